@@ -0,0 +1,41 @@
+package app
+
+import "strings"
+
+// AccessToken represents a long-lived personal access token used by scripts.
+// Only the SHA-256 hash of the token is stored.
+type AccessToken struct {
+	ID        int64  `json:"id" db:"id"`
+	UserID    int64  `json:"user_id" db:"user_id"`
+	Name      string `json:"name" db:"name"`
+	TokenHash string `json:"-" db:"token_hash"`
+	// Scopes is a space separated list, e.g. "posts:read posts:write".
+	Scopes     string `json:"scopes" db:"scopes"`
+	ExpiresAt  int64  `json:"expires_at" db:"expires_at"`
+	LastUsedAt int64  `json:"last_used_at" db:"last_used_at"`
+	CreatedAt  int64  `json:"created_at" db:"created_at"`
+}
+
+// AccessTokenService defines the basic service of access token
+type AccessTokenService interface {
+	CreateAccessToken(*AccessToken) error
+	AccessToken(tokenHash string) (*AccessToken, error)
+	AccessTokens(userID int64) ([]*AccessToken, error)
+	TouchAccessToken(id, lastUsedAt int64) error
+	DeleteAccessToken(id, userID int64) error
+}
+
+// HasScope reports whether the token was granted the scope.
+func (t *AccessToken) HasScope(scope string) bool {
+	for _, s := range strings.Fields(t.Scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// TableName represents the table name of access token
+func (AccessToken) TableName() string {
+	return "access_tokens"
+}
@@ -0,0 +1,237 @@
+// Package accesstoken issues personal access tokens and
+// accepts them next to the JWTs in the Authorization header.
+package accesstoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/jwtauth"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/response"
+)
+
+// Prefix starts every personal access token, so that they are easy
+// to tell apart from JWTs and to spot in leaked files.
+const Prefix = "wit_"
+
+// The scopes that can be granted to a personal access token. ScopeUsersWrite
+// covers the profile and the avatar, never the email address or the password.
+const (
+	ScopePostsRead  = "posts:read"
+	ScopePostsWrite = "posts:write"
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+)
+
+// Scopes lists every grantable scope.
+var Scopes = []string{ScopePostsRead, ScopePostsWrite, ScopeUsersRead, ScopeUsersWrite}
+
+// ScopeClaim holds the space separated scopes of delegated tokens.
+// Tokens without it are interactive logins and have every scope.
+const ScopeClaim = "scope"
+
+// lastUsedResolution limits how often the last use of a token is written.
+const lastUsedResolution = time.Minute
+
+var (
+	errNameRequired   = app.NewValidationError("Token name is required", map[string]string{"name": "Token name is required"})
+	errScopesRequired = app.NewValidationError("At least one scope is required", map[string]string{"scopes": "At least one scope is required"})
+	errExpiresAt      = app.NewValidationError("Token expiry must be in the future", map[string]string{"expires_at": "Token expiry must be in the future"})
+	errInvalidToken   = app.NewError(app.Unauthorized, "Access token is invalid or expired")
+	errTokenGenerate  = app.NewError(app.Internal, "Failed to generate the access token")
+)
+
+const (
+	errUnknownScope = "Unknown scope: "
+	errMissingScope = "Token is missing the required scope: "
+	errInteractive  = "This resource cannot be accessed with a delegated token"
+)
+
+// Service creates and checks the personal access tokens.
+type Service struct {
+	accessTokenService app.AccessTokenService
+}
+
+// New returns an access token Service.
+func New(accessTokenService app.AccessTokenService) *Service {
+	return &Service{
+		accessTokenService: accessTokenService,
+	}
+}
+
+// Create issues a new token for the user and returns its plaintext,
+// which cannot be retrieved afterwards. A zero expiresAt never expires.
+func (s *Service) Create(userID int64, name string, scopes []string, expiresAt int64) (*app.AccessToken, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, "", errNameRequired
+	}
+
+	if len(scopes) == 0 {
+		return nil, "", errScopesRequired
+	}
+
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return nil, "", app.NewValidationError(errUnknownScope+scope, map[string]string{"scopes": errUnknownScope + scope})
+		}
+	}
+
+	if expiresAt != 0 && expiresAt <= time.Now().Unix() {
+		return nil, "", errExpiresAt
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", errTokenGenerate.Wrap(err)
+	}
+	plaintext := Prefix + base64.RawURLEncoding.EncodeToString(b)
+
+	token := &app.AccessToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: HashToken(plaintext),
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+	}
+
+	if err := s.accessTokenService.CreateAccessToken(token); err != nil {
+		return nil, "", err
+	}
+
+	return token, plaintext, nil
+}
+
+// List returns the tokens of the user.
+func (s *Service) List(userID int64) ([]*app.AccessToken, error) {
+	return s.accessTokenService.AccessTokens(userID)
+}
+
+// Revoke deletes a token of the user.
+func (s *Service) Revoke(id, userID int64) error {
+	return s.accessTokenService.DeleteAccessToken(id, userID)
+}
+
+// Authenticate returns the unexpired token matching the plaintext
+// and records its use.
+func (s *Service) Authenticate(plaintext string) (*app.AccessToken, error) {
+	token, err := s.accessTokenService.AccessToken(HashToken(plaintext))
+	if err != nil {
+		return nil, errInvalidToken.Wrap(err)
+	}
+
+	now := time.Now()
+	if token.ExpiresAt != 0 && now.Unix() >= token.ExpiresAt {
+		return nil, errInvalidToken
+	}
+
+	if now.Unix()-token.LastUsedAt >= int64(lastUsedResolution.Seconds()) {
+		token.LastUsedAt = now.Unix()
+		s.accessTokenService.TouchAccessToken(token.ID, token.LastUsedAt)
+	}
+
+	return token, nil
+}
+
+// Authenticator is a middleware that accepts personal access tokens in the
+// Authorization header. It runs after the JWT verifier and before
+// jwtauth.Authenticator, and stores an equivalent token in the context so that
+// the handlers read the same claims whatever the kind of token.
+func (s *Service) Authenticator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plaintext := jwtauth.TokenFromHeader(r)
+		if !strings.HasPrefix(plaintext, Prefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		accessToken, err := s.Authenticate(plaintext)
+		if err != nil {
+			ctx := jwtauth.NewContext(r.Context(), nil, err)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		token := &jwt.Token{
+			Valid: true,
+			Claims: jwt.MapClaims{
+				"user_id":         float64(accessToken.UserID),
+				"iat":             float64(accessToken.CreatedAt),
+				"access_token_id": float64(accessToken.ID),
+				ScopeClaim:        accessToken.Scopes,
+			},
+		}
+
+		ctx := jwtauth.NewContext(r.Context(), token, nil)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireScope is a middleware that refuses delegated tokens missing the scope.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes, delegated := tokenScopes(r)
+			if delegated && !contains(scopes, scope) {
+				config := response.Configure(errMissingScope+scope, http.StatusForbidden, nil)
+				response.JSONError(w, r, config)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// InteractiveOnly is a middleware that refuses every delegated token,
+// for the routes that manage the account itself.
+func InteractiveOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, delegated := tokenScopes(r); delegated {
+			config := response.Configure(errInteractive, http.StatusForbidden, nil)
+			response.JSONError(w, r, config)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HashToken returns the hex encoded SHA-256 hash of the token, which is what we store.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenScopes returns the scopes of the token in the context,
+// and whether the token is a delegated one.
+func tokenScopes(r *http.Request) ([]string, bool) {
+	_, claims, _ := jwtauth.FromContext(r.Context())
+
+	scopes, ok := claims[ScopeClaim].(string)
+	if !ok {
+		return nil, false
+	}
+
+	return strings.Fields(scopes), true
+}
+
+func validScope(scope string) bool {
+	return contains(Scopes, scope)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
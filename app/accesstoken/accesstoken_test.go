@@ -0,0 +1,91 @@
+package accesstoken_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/jwtauth"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/accesstoken"
+)
+
+// errNotFound is what the stores return when nothing matches.
+var errNotFound = app.NewError(app.NotFound, "Not found")
+
+type accessTokenStore struct {
+	tokens map[string]*app.AccessToken
+}
+
+func (s *accessTokenStore) CreateAccessToken(token *app.AccessToken) error {
+	token.ID = int64(len(s.tokens) + 1)
+	s.tokens[token.TokenHash] = token
+	return nil
+}
+
+func (s *accessTokenStore) AccessToken(tokenHash string) (*app.AccessToken, error) {
+	token, ok := s.tokens[tokenHash]
+	if !ok {
+		return nil, errNotFound
+	}
+	return token, nil
+}
+
+func (s *accessTokenStore) AccessTokens(userID int64) ([]*app.AccessToken, error) {
+	return nil, nil
+}
+
+func (s *accessTokenStore) TouchAccessToken(id, lastUsedAt int64) error {
+	return nil
+}
+
+func (s *accessTokenStore) DeleteAccessToken(id, userID int64) error {
+	return nil
+}
+
+func TestAccessToken(t *testing.T) {
+	service := accesstoken.New(&accessTokenStore{tokens: map[string]*app.AccessToken{}})
+
+	_, plaintext, err := service.Create(1, "publishing script", []string{accesstoken.ScopePostsRead}, 0)
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	// request reports whether the token reached the handler guarded by the scope.
+	request := func(token, scope string) bool {
+		reached := false
+		ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true })
+
+		handler := service.Authenticator(jwtauth.Authenticator(accesstoken.RequireScope(scope)(ok)))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		return reached
+	}
+
+	t.Run("TestGrantedScope", func(t *testing.T) {
+		if !request(plaintext, accesstoken.ScopePostsRead) {
+			t.Error("Expecting the token to be accepted")
+		}
+	})
+
+	t.Run("TestMissingScope", func(t *testing.T) {
+		if request(plaintext, accesstoken.ScopePostsWrite) {
+			t.Error("Expecting the token to be refused without the scope")
+		}
+	})
+
+	t.Run("TestUnknownToken", func(t *testing.T) {
+		if request(accesstoken.Prefix+"unknown", accesstoken.ScopePostsRead) {
+			t.Error("Expecting an unknown token to be refused")
+		}
+	})
+
+	t.Run("TestUnknownScope", func(t *testing.T) {
+		if _, _, err := service.Create(1, "script", []string{"admin"}, 0); err == nil {
+			t.Error("Expecting an error when granting an unknown scope")
+		}
+	})
+}
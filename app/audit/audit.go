@@ -0,0 +1,257 @@
+// Package audit records the security-relevant and content events in an
+// append-only log, whose hash chain reveals any change to a past entry.
+package audit
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mw "github.com/go-chi/chi/middleware"
+	"github.com/go-chi/jwtauth"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/response"
+)
+
+// The audited actions.
+const (
+	ActionLogin          = "user.login"
+	ActionLoginFailed    = "user.login_failed"
+	ActionUserUpdate     = "user.update"
+	ActionRoleChange     = "user.role_change"
+	ActionErasureRequest = "user.erasure_request"
+	ActionPostCreate     = "post.create"
+	ActionPostUpdate     = "post.update"
+	ActionPostDelete     = "post.delete"
+	ActionAdminTwoFactor = "admin.role_two_factor"
+)
+
+// pageSize is the number of entries read at once when
+// verifying the chain or exporting the entries.
+const pageSize = 500
+
+type contextKey struct{}
+
+// Event is an action of the request to record.
+type Event struct {
+	Action     string
+	TargetType string
+	TargetID   string
+	// ActorID defaults to the authenticated user of the request.
+	ActorID int64
+	// Before and After are the target around the action, the changed
+	// fields of their admin representation are recorded.
+	Before interface{}
+	After  interface{}
+}
+
+// Verification is the result of checking the hash chain.
+type Verification struct {
+	Valid   bool `json:"valid"`
+	Entries int  `json:"entries"`
+	// BrokenAt is the id of the first entry that does not match the chain.
+	BrokenAt int64 `json:"broken_at,omitempty"`
+	// Head is the hash of the last entry. Keeping it elsewhere reveals
+	// the removal of the latest entries too.
+	Head string `json:"head"`
+}
+
+// Service appends the entries to the log and reads them back.
+type Service struct {
+	auditService app.AuditService
+
+	// mu serialises the appends, the chain has a single head
+	mu sync.Mutex
+}
+
+// New returns an audit Service.
+func New(auditService app.AuditService) *Service {
+	return &Service{
+		auditService: auditService,
+	}
+}
+
+// Middleware lets the handlers Record their events.
+func (s *Service) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, s)))
+	})
+}
+
+// Record appends the event of the request to the log of the Middleware.
+// Without the Middleware nothing is recorded. Failures are only logged,
+// they never fail the request.
+func Record(r *http.Request, event Event) {
+	s, ok := r.Context().Value(contextKey{}).(*Service)
+	if !ok {
+		return
+	}
+
+	if err := s.Append(r, event); err != nil {
+		log.Printf("Error recording the audit event %s: %v", event.Action, err)
+	}
+}
+
+// Append records the event of the request.
+func (s *Service) Append(r *http.Request, event Event) error {
+	entry := &app.AuditEntry{
+		ActorID:    event.ActorID,
+		Action:     event.Action,
+		TargetType: event.TargetType,
+		TargetID:   event.TargetID,
+		Diff:       Diff(event.Before, event.After),
+		IP:         remoteIP(r),
+		RequestID:  mw.GetReqID(r.Context()),
+		CreatedAt:  time.Now().Unix(),
+	}
+
+	if entry.ActorID == 0 {
+		if _, claims, err := jwtauth.FromContext(r.Context()); err == nil {
+			if userID, ok := claims["user_id"].(float64); ok {
+				entry.ActorID = int64(userID)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.auditService.AppendAuditEntry(entry)
+}
+
+// Entries returns the entries matching the filter.
+func (s *Service) Entries(filter app.AuditFilter) ([]*app.AuditEntry, error) {
+	return s.auditService.AuditEntries(filter)
+}
+
+// WriteCSV writes every entry matching the filter as CSV, whatever its limit.
+func (s *Service) WriteCSV(w io.Writer, filter app.AuditFilter) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "created_at", "actor_id", "action", "target_type", "target_id", "diff", "ip", "request_id", "prev_hash", "hash"})
+
+	filter.Limit = pageSize
+
+	for {
+		entries, err := s.auditService.AuditEntries(filter)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			diff, _ := e.Diff.Value()
+
+			out.Write([]string{
+				strconv.FormatInt(e.ID, 10),
+				time.Unix(e.CreatedAt, 0).UTC().Format(time.RFC3339),
+				strconv.FormatInt(e.ActorID, 10),
+				e.Action,
+				e.TargetType,
+				e.TargetID,
+				diff.(string),
+				e.IP,
+				e.RequestID,
+				e.PrevHash,
+				e.Hash,
+			})
+			filter.AfterID = e.ID
+		}
+
+		out.Flush()
+		if err = out.Error(); err != nil {
+			return err
+		}
+
+		if len(entries) < pageSize {
+			return nil
+		}
+	}
+}
+
+// Verify walks the whole chain, checking that every entry
+// follows the previous one and still has its hash.
+func (s *Service) Verify() (*Verification, error) {
+	v := &Verification{Valid: true}
+
+	var afterID int64
+
+	for {
+		entries, err := s.auditService.AuditEntries(app.AuditFilter{AfterID: afterID, Limit: pageSize})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.PrevHash != v.Head || entry.ComputeHash() != entry.Hash {
+				v.Valid = false
+				v.BrokenAt = entry.ID
+				return v, nil
+			}
+
+			v.Entries++
+			v.Head = entry.Hash
+			afterID = entry.ID
+		}
+
+		if len(entries) < pageSize {
+			return v, nil
+		}
+	}
+}
+
+// Diff returns the fields whose values differ between before and after,
+// either of which can be nil. The fields are compared in the admin
+// representation, which never holds a password hash.
+func Diff(before, after interface{}) app.AuditDiff {
+	b, a := fields(before), fields(after)
+	diff := app.AuditDiff{}
+
+	for name, value := range a {
+		if old, ok := b[name]; !ok || !reflect.DeepEqual(old, value) {
+			diff[name] = app.AuditChange{Before: b[name], After: value}
+		}
+	}
+
+	for name, value := range b {
+		if _, ok := a[name]; !ok {
+			diff[name] = app.AuditChange{Before: value}
+		}
+	}
+
+	return diff
+}
+
+func fields(v interface{}) map[string]interface{} {
+	m := map[string]interface{}{}
+	if v == nil {
+		return m
+	}
+
+	data, err := json.Marshal(response.Redact(v, response.ViewAdmin))
+	if err != nil {
+		return m
+	}
+
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	dec.Decode(&m)
+
+	return m
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
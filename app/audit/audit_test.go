@@ -0,0 +1,134 @@
+package audit_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/jwtauth"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/audit"
+)
+
+type auditStore struct {
+	entries []*app.AuditEntry
+}
+
+func (s *auditStore) AppendAuditEntry(e *app.AuditEntry) error {
+	if len(s.entries) > 0 {
+		e.PrevHash = s.entries[len(s.entries)-1].Hash
+	}
+	e.Hash = e.ComputeHash()
+	e.ID = int64(len(s.entries) + 1)
+
+	saved := *e
+	s.entries = append(s.entries, &saved)
+	return nil
+}
+
+func (s *auditStore) AuditEntries(filter app.AuditFilter) ([]*app.AuditEntry, error) {
+	entries := []*app.AuditEntry{}
+	for _, e := range s.entries {
+		if e.ID > filter.AfterID && (filter.Action == "" || e.Action == filter.Action) && len(entries) < filter.Limit {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func TestAudit(t *testing.T) {
+	store := &auditStore{}
+	service := audit.New(store)
+	tokenAuth := jwtauth.New("HS256", []byte("secret"), nil)
+
+	// record answers the request after recording the event
+	record := func(event audit.Event) {
+		handler := service.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			audit.Record(r, event)
+		}))
+
+		_, token, _ := tokenAuth.Encode(jwt.MapClaims{"user_id": 7})
+		req := httptest.NewRequest("PUT", "/api/v1/posts/1", nil)
+		req.RemoteAddr = "203.0.113.9:5555"
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		jwtauth.Verifier(tokenAuth)(handler).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	record(audit.Event{
+		Action:     audit.ActionRoleChange,
+		TargetType: "user",
+		TargetID:   "7",
+		Before:     &app.User{ID: 7, Username: "writer", Password: "old-hash", UserType: "reader"},
+		After:      &app.User{ID: 7, Username: "writer", Password: "new-hash", UserType: "admin"},
+	})
+	record(audit.Event{Action: audit.ActionPostCreate, TargetType: "post", TargetID: "1", After: &app.Post{ID: 1, PostTitle: "Hello"}})
+	record(audit.Event{Action: audit.ActionPostDelete, TargetType: "post", TargetID: "1", Before: &app.Post{ID: 1, PostTitle: "Hello"}})
+
+	t.Run("Entries", func(t *testing.T) {
+		if len(store.entries) != 3 {
+			t.Fatalf("Expecting: %v, but got: %v instead", 3, len(store.entries))
+		}
+
+		role := store.entries[0]
+		if role.ActorID != 7 || role.IP != "203.0.113.9" || role.Diff["user_type"].After != "admin" {
+			t.Errorf("Expecting: %v, but got: %v instead", "the role change of the user 7", role)
+		}
+
+		if _, ok := role.Diff["username"]; ok || len(role.Diff) != 1 {
+			t.Errorf("Expecting: %v, but got: %v instead", "only the changed fields without password", role.Diff)
+		}
+	})
+
+	t.Run("Verify", func(t *testing.T) {
+		v, err := service.Verify()
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if !v.Valid || v.Entries != 3 || v.Head != store.entries[2].Hash {
+			t.Errorf("Expecting: %v, but got: %v instead", "an intact chain", v)
+		}
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := service.WriteCSV(&buf, app.AuditFilter{Action: audit.ActionPostCreate}); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		rows, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if len(rows) != 2 || rows[1][3] != audit.ActionPostCreate || !strings.Contains(rows[1][6], "Hello") {
+			t.Errorf("Expecting: %v, but got: %v instead", "the header and the post creation", rows)
+		}
+	})
+
+	t.Run("Tampering", func(t *testing.T) {
+		store.entries[1].ActorID = 1
+
+		v, err := service.Verify()
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if v.Valid || v.BrokenAt != 2 {
+			t.Errorf("Expecting: %v, but got: %v instead", "the chain broken at 2", v)
+		}
+
+		// Rehashing the edited entry does not help, the next one still points to the old hash
+		store.entries[1].Hash = store.entries[1].ComputeHash()
+
+		if v, _ = service.Verify(); v.Valid || v.BrokenAt != 3 {
+			t.Errorf("Expecting: %v, but got: %v instead", "the chain broken at 3", v)
+		}
+	})
+}
@@ -0,0 +1,34 @@
+package app
+
+// Erasure represents the request of a user to erase their account.
+// It is carried out once confirmed and after the cooling-off period,
+// the erased row is then kept as a tombstone without personal data.
+type Erasure struct {
+	ID          int64 `json:"id" db:"id"`
+	UserID      int64 `json:"user_id" db:"user_id"`
+	KeepPosts   bool  `json:"keep_posts" db:"keep_posts"`
+	RequestedAt int64 `json:"requested_at" db:"requested_at"`
+	ConfirmedAt int64 `json:"confirmed_at" db:"confirmed_at"`
+	EraseAfter  int64 `json:"erase_after" db:"erase_after"`
+	ErasedAt    int64 `json:"erased_at" db:"erased_at"`
+}
+
+// ErasureService defines the basic service of account erasure
+type ErasureService interface {
+	// PendingErasure returns the erasure of the user that is not carried out yet.
+	PendingErasure(userID int64) (*Erasure, error)
+	// SaveErasure creates the erasure, or updates its confirmation once it has an ID.
+	SaveErasure(*Erasure) error
+	// CancelErasure removes the pending erasure of the user.
+	CancelErasure(userID int64) error
+	// DueErasures returns the confirmed erasures whose cooling-off period ended before now.
+	DueErasures(now int64) ([]*Erasure, error)
+	// EraseUser removes the user together with their personal data. The posts
+	// are either deleted or attributed to the former member account.
+	EraseUser(*Erasure) error
+}
+
+// TableName represents the table name of erasure
+func (Erasure) TableName() string {
+	return "erasures"
+}
@@ -0,0 +1,241 @@
+// Package erasure erases the accounts of the users who ask for it, once they
+// confirmed it by email and a cooling-off period went by.
+package erasure
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/jwtservice"
+	"github.com/rbo13/write-it/app/mailer"
+	"github.com/rbo13/write-it/app/profile"
+)
+
+const purpose = "account_erasure"
+
+// confirmTTL is how long the confirmation link stays valid.
+const confirmTTL = 24 * time.Hour
+
+var (
+	errInvalidToken     = app.NewError(app.Validation, "Erasure confirmation link is invalid or expired")
+	errAlreadyScheduled = app.NewError(app.Conflict, "The erasure of this account is already scheduled, cancel it first")
+	errNoErasure        = app.NewError(app.NotFound, "No erasure is pending for this account")
+)
+
+// Purger removes the cached copies of an erased user and of their posts.
+type Purger interface {
+	Purge(userID int64, postIDs []int64)
+}
+
+// Service schedules the erasures and carries them out.
+type Service struct {
+	erasureService app.ErasureService
+	userService    app.UserService
+	postService    app.PostService
+	profiles       *profile.Service
+	jwtService     *jwtservice.JWT
+	mailer         mailer.Mailer
+	purger         Purger
+	baseURL        string
+	coolingOff     time.Duration
+}
+
+// New returns an erasure Service. The confirmation links point to baseURL,
+// the accounts are erased coolingOff after their confirmation.
+func New(erasureService app.ErasureService, userService app.UserService, postService app.PostService, profiles *profile.Service, jwtService *jwtservice.JWT, m mailer.Mailer, purger Purger, baseURL string, coolingOff time.Duration) *Service {
+	return &Service{
+		erasureService: erasureService,
+		userService:    userService,
+		postService:    postService,
+		profiles:       profiles,
+		jwtService:     jwtService,
+		mailer:         m,
+		purger:         purger,
+		baseURL:        baseURL,
+		coolingOff:     coolingOff,
+	}
+}
+
+// Request records the wish of the user to be erased, keeping or deleting
+// their posts, and emails them the confirmation link. An unconfirmed request
+// is replaced by the new one.
+func (s *Service) Request(userID int64, keepPosts bool) (*app.Erasure, error) {
+	user, err := s.userService.User(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := s.Pending(userID)
+	if err == nil && pending.ConfirmedAt > 0 {
+		return nil, errAlreadyScheduled
+	}
+
+	if err = s.erasureService.CancelErasure(userID); err != nil {
+		return nil, err
+	}
+
+	erasure := &app.Erasure{UserID: userID, KeepPosts: keepPosts}
+	if err = s.erasureService.SaveErasure(erasure); err != nil {
+		return nil, err
+	}
+
+	token, err := s.jwtService.EncodePurpose(purpose, jwt.MapClaims{
+		"user_id":    userID,
+		"erasure_id": erasure.ID,
+	}, confirmTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	link := s.baseURL + "/erasure/confirm?token=" + url.QueryEscape(token)
+
+	err = s.mailer.Send(mailer.Message{
+		To:      user.EmailAddress,
+		Subject: "Confirm the erasure of your account",
+		Body:    fmt.Sprintf("Hi %s,\n\nSomeone asked to erase your account, your posts would be %s. If it was you, open the link below to confirm it:\n\n%s\n\nYour account is then erased after %s, until then you can cancel it. If it was not you, you can ignore this email.\n", user.Username, postsFate(keepPosts), link, s.coolingOff),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return erasure, nil
+}
+
+// Confirm starts the cooling-off period of the erasure of a confirmation link.
+func (s *Service) Confirm(token string) (*app.Erasure, error) {
+	claims, err := s.jwtService.DecodePurpose(purpose, token)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	userID, _ := claims["user_id"].(float64)
+	erasureID, _ := claims["erasure_id"].(float64)
+
+	erasure, err := s.Pending(int64(userID))
+	if err != nil || erasure.ID != int64(erasureID) {
+		return nil, errInvalidToken
+	}
+
+	// Following the link twice keeps the first schedule
+	if erasure.ConfirmedAt > 0 {
+		return erasure, nil
+	}
+
+	now := time.Now()
+	erasure.ConfirmedAt = now.Unix()
+	erasure.EraseAfter = now.Add(s.coolingOff).Unix()
+
+	if err = s.erasureService.SaveErasure(erasure); err != nil {
+		return nil, err
+	}
+
+	return erasure, nil
+}
+
+// Pending returns the erasure of the user that is not carried out yet.
+func (s *Service) Pending(userID int64) (*app.Erasure, error) {
+	erasure, err := s.erasureService.PendingErasure(userID)
+	if app.KindOf(err) == app.NotFound {
+		return nil, errNoErasure.Wrap(err)
+	}
+
+	return erasure, err
+}
+
+// Cancel cancels the pending erasure of the user.
+func (s *Service) Cancel(userID int64) error {
+	if _, err := s.Pending(userID); err != nil {
+		return err
+	}
+
+	return s.erasureService.CancelErasure(userID)
+}
+
+// EraseDue erases the accounts whose cooling-off period is over.
+func (s *Service) EraseDue() error {
+	erasures, err := s.erasureService.DueErasures(time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	for _, erasure := range erasures {
+		if err = s.erase(erasure); err != nil {
+			log.Printf("Error erasing the account %d: %v", erasure.UserID, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) erase(erasure *app.Erasure) error {
+	postIDs, err := s.postIDs(erasure.UserID)
+	if err != nil {
+		return err
+	}
+
+	if err = s.profiles.DeleteAvatar(erasure.UserID); err != nil {
+		return err
+	}
+
+	if err = s.erasureService.EraseUser(erasure); err != nil {
+		return err
+	}
+
+	s.purger.Purge(erasure.UserID, postIDs)
+	log.Printf("Erased the account %d, its %d posts were %s", erasure.UserID, len(postIDs), postsFate(erasure.KeepPosts))
+
+	return nil
+}
+
+// postIDs returns the ids of the posts of the user,
+// whose cached copies are stale once the user is erased.
+func (s *Service) postIDs(userID int64) ([]int64, error) {
+	var ids []int64
+	var afterID int64
+
+	for {
+		posts, err := s.postService.PostsByCreator(userID, afterID, 100)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(posts) == 0 {
+			return ids, nil
+		}
+
+		for _, post := range posts {
+			ids = append(ids, post.ID)
+		}
+		afterID = posts[len(posts)-1].ID
+	}
+}
+
+// StartWorker runs EraseDue every hour until done is closed.
+func (s *Service) StartWorker(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := s.EraseDue(); err != nil {
+				log.Printf("Error erasing the due accounts: %v", err)
+			}
+		}
+	}
+}
+
+func postsFate(keepPosts bool) string {
+	if keepPosts {
+		return "kept under a former member account"
+	}
+
+	return "deleted"
+}
@@ -0,0 +1,262 @@
+package erasure_test
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/erasure"
+	"github.com/rbo13/write-it/app/jwtservice"
+	"github.com/rbo13/write-it/app/mailer"
+	"github.com/rbo13/write-it/app/persistence/inmemory"
+	"github.com/rbo13/write-it/app/profile"
+)
+
+// errNotFound is what the stores return when nothing matches.
+var errNotFound = app.NewError(app.NotFound, "Not found")
+
+type userStore struct {
+	app.UserService
+	users map[int64]*app.User
+}
+
+func (s *userStore) User(id int64) (*app.User, error) {
+	user, ok := s.users[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	return user, nil
+}
+
+type profileStore struct{}
+
+func (s *profileStore) Profile(userID int64) (*app.Profile, error) {
+	return nil, errNotFound
+}
+
+func (s *profileStore) SaveProfile(p *app.Profile) error {
+	return nil
+}
+
+// erasureStore erases the users from userStore and keeps
+// or deletes their posts like the SQL service.
+type erasureStore struct {
+	erasures []*app.Erasure
+	users    *userStore
+	posts    app.PostService
+	// kept are the posts moved to the former member
+	kept map[int64]bool
+}
+
+func (s *erasureStore) PendingErasure(userID int64) (*app.Erasure, error) {
+	for _, e := range s.erasures {
+		if e.UserID == userID && e.ErasedAt == 0 {
+			found := *e
+			return &found, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (s *erasureStore) SaveErasure(e *app.Erasure) error {
+	if e.ID == 0 {
+		e.ID = int64(len(s.erasures) + 1)
+		saved := *e
+		s.erasures = append(s.erasures, &saved)
+		return nil
+	}
+
+	for _, saved := range s.erasures {
+		if saved.ID == e.ID {
+			*saved = *e
+		}
+	}
+	return nil
+}
+
+func (s *erasureStore) CancelErasure(userID int64) error {
+	kept := []*app.Erasure{}
+	for _, e := range s.erasures {
+		if e.UserID != userID || e.ErasedAt > 0 {
+			kept = append(kept, e)
+		}
+	}
+	s.erasures = kept
+	return nil
+}
+
+func (s *erasureStore) DueErasures(now int64) ([]*app.Erasure, error) {
+	due := []*app.Erasure{}
+	for _, e := range s.erasures {
+		if e.ErasedAt == 0 && e.ConfirmedAt > 0 && e.EraseAfter <= now {
+			due = append(due, e)
+		}
+	}
+	return due, nil
+}
+
+func (s *erasureStore) EraseUser(e *app.Erasure) error {
+	posts, _ := s.posts.PostsByCreator(e.UserID, 0, 1000)
+	for _, post := range posts {
+		if e.KeepPosts {
+			s.kept[post.ID] = true
+		} else {
+			s.posts.DeletePost(post.ID)
+		}
+	}
+
+	delete(s.users.users, e.UserID)
+	e.ErasedAt = time.Now().Unix()
+	return nil
+}
+
+type outbox struct {
+	messages []mailer.Message
+}
+
+func (o *outbox) Send(m mailer.Message) error {
+	o.messages = append(o.messages, m)
+	return nil
+}
+
+type purger struct {
+	purged map[int64][]int64
+}
+
+func (p *purger) Purge(userID int64, postIDs []int64) {
+	p.purged[userID] = postIDs
+}
+
+var linkPattern = regexp.MustCompile(`https://write-it\.test/erasure/confirm\?token=\S+`)
+
+func TestErasure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avatars")
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	jwtService, err := jwtservice.New(jwtservice.Config{Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	users := &userStore{users: map[int64]*app.User{
+		1: {ID: 1, Username: "writer", EmailAddress: "writer@example.com"},
+		2: {ID: 2, Username: "reader", EmailAddress: "reader@example.com"},
+	}}
+
+	posts := inmemory.NewInMemoryPostService()
+	posts.CreatePost(&app.Post{ID: 1, CreatorID: 1, PostTitle: "First"})
+	posts.CreatePost(&app.Post{ID: 2, CreatorID: 1, PostTitle: "Second"})
+	posts.CreatePost(&app.Post{ID: 3, CreatorID: 2, PostTitle: "Third"})
+
+	store := &erasureStore{users: users, posts: posts, kept: map[int64]bool{}}
+	mail := &outbox{}
+	cache := &purger{purged: map[int64][]int64{}}
+	profiles := profile.New(&profileStore{}, users, "https://write-it.test", dir)
+
+	// confirm follows the link of the last email
+	confirm := func(service *erasure.Service) (*app.Erasure, error) {
+		link, err := url.Parse(linkPattern.FindString(mail.messages[len(mail.messages)-1].Body))
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+		return service.Confirm(link.Query().Get("token"))
+	}
+
+	t.Run("CoolingOff", func(t *testing.T) {
+		service := erasure.New(store, users, posts, profiles, jwtService, mail, cache, "https://write-it.test", time.Hour)
+
+		if _, err := service.Request(2, false); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if mail.messages[0].To != "reader@example.com" {
+			t.Errorf("Expecting: %v, but got: %v instead", "reader@example.com", mail.messages[0].To)
+		}
+
+		if err := service.EraseDue(); err != nil || users.users[2] == nil {
+			t.Fatalf("Expecting: %v, but got: %v instead", "no erasure before the confirmation", err)
+		}
+
+		scheduled, err := confirm(service)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if scheduled.EraseAfter <= time.Now().Unix() {
+			t.Errorf("Expecting: %v, but got: %v instead", "an erasure in an hour", scheduled.EraseAfter)
+		}
+
+		if _, err := service.Request(2, true); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+
+		service.EraseDue()
+		if users.users[2] == nil {
+			t.Fatalf("Expecting: %v, but got: %v instead", "no erasure during the cooling-off period", users.users)
+		}
+
+		if err := service.Cancel(2); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if _, err := service.Pending(2); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+
+		if _, err := confirm(service); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "the link of a cancelled erasure to be invalid", err)
+		}
+	})
+
+	t.Run("Erase", func(t *testing.T) {
+		service := erasure.New(store, users, posts, profiles, jwtService, mail, cache, "https://write-it.test", 0)
+
+		if _, err := service.Request(1, false); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		// The new request replaces the unconfirmed one
+		if _, err := service.Request(1, true); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if _, err := confirm(service); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if err := service.EraseDue(); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if users.users[1] != nil || users.users[2] == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "only the writer to be erased", users.users)
+		}
+
+		if kept, _ := posts.Post(1); kept == nil || !store.kept[1] {
+			t.Errorf("Expecting: %v, but got: %v instead", "the post to be kept without its creator", kept)
+		}
+
+		if len(cache.purged[1]) != 2 {
+			t.Errorf("Expecting: %v, but got: %v instead", []int64{1, 2}, cache.purged[1])
+		}
+
+		if len(store.erasures) != 1 || store.erasures[0].ErasedAt == 0 {
+			t.Errorf("Expecting: %v, but got: %v instead", "a tombstone", store.erasures)
+		}
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		service := erasure.New(store, users, posts, profiles, jwtService, mail, cache, "https://write-it.test", 0)
+
+		if _, err := service.Confirm("invalid"); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package app
+
+// ErrorKind tells what went wrong, whatever the service or the
+// backend, so that clients are answered the same way for the same kind.
+type ErrorKind int
+
+const (
+	// Internal is a failure of the server, e.g. of the database. Its cause is
+	// only logged, and it is the kind of the errors that are not an *Error.
+	Internal ErrorKind = iota
+	// NotFound is returned when what was asked for does not exist.
+	NotFound
+	// Conflict is returned when the request clashes with the current state,
+	// e.g. an email address already taken. Repeating it may succeed later.
+	Conflict
+	// Validation is returned when the request is invalid, with the
+	// invalid fields, if known, in Fields.
+	Validation
+	// Unauthorized is returned when the client could not be authenticated.
+	Unauthorized
+	// Forbidden is returned when the client is not allowed to do it.
+	Forbidden
+	// RateLimited is returned when the client must slow down.
+	RateLimited
+	// PreconditionFailed is returned when what the client updates
+	// was changed since they read it, as its version tells.
+	PreconditionFailed
+)
+
+// Error is the error returned by the services. Its Message is shown to
+// clients, while the error causing it, e.g. of a database driver, is not.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	// Fields maps the invalid fields of a Validation error to what is wrong with them.
+	Fields map[string]string
+	Err    error
+}
+
+// NewError returns an error of the given kind, shown to clients as message.
+func NewError(kind ErrorKind, message string) *Error {
+	return &Error{
+		Kind:    kind,
+		Message: message,
+	}
+}
+
+// NewValidationError returns a Validation error for the given invalid fields.
+func NewValidationError(message string, fields map[string]string) *Error {
+	return &Error{
+		Kind:    Validation,
+		Message: message,
+		Fields:  fields,
+	}
+}
+
+// WrapError returns an error of the given kind caused by err.
+func WrapError(kind ErrorKind, message string, err error) error {
+	return &Error{
+		Kind:    kind,
+		Message: message,
+		Err:     err,
+	}
+}
+
+// Error returns the message, followed by its cause if any.
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+
+	return e.Message
+}
+
+// Unwrap returns the cause of the error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wrap returns a copy of the error caused by err, so that a shared
+// error like errUserNotInserted keeps the cause for the logs.
+func (e *Error) Wrap(err error) error {
+	wrapped := *e
+	wrapped.Err = err
+	return &wrapped
+}
+
+// Is tells whether target is the same error, caused by anything.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Kind == e.Kind && t.Message == e.Message
+}
+
+// ErrorOf returns the first *Error of the chain of err,
+// following Unwrap, or nil when there is none.
+func ErrorOf(err error) *Error {
+	for err != nil {
+		if e, ok := err.(*Error); ok {
+			return e
+		}
+
+		u, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return nil
+		}
+
+		err = u.Unwrap()
+	}
+
+	return nil
+}
+
+// KindOf returns the kind of err, Internal when it is not an *Error.
+func KindOf(err error) ErrorKind {
+	if e := ErrorOf(err); e != nil {
+		return e.Kind
+	}
+
+	return Internal
+}
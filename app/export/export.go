@@ -0,0 +1,368 @@
+// Package export builds an archive of the data of a user, which
+// they can download through a signed link once it is ready.
+package export
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	jwt "github.com/dgrijalva/jwt-go"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/jwtservice"
+	"github.com/rbo13/write-it/app/profile"
+	"github.com/rbo13/write-it/app/response"
+)
+
+const downloadPurpose = "export_download"
+
+// pageSize is the number of posts read at once,
+// so large accounts are streamed into the archive.
+const pageSize = 100
+
+// The status of an export job.
+const (
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+var (
+	errExportRunning = app.NewError(app.Conflict, "An export of this account is already running")
+	errInvalidLink   = app.NewError(app.NotFound, "Export link is invalid or expired")
+	errArchive       = app.NewError(app.Internal, "Failed to open the archive")
+)
+
+// Progress is sent to the user while the archive is built.
+type Progress struct {
+	Kind        string `json:"kind"`
+	JobID       string `json:"job_id"`
+	Status      string `json:"status"`
+	Done        int    `json:"done"`
+	Total       int    `json:"total"`
+	DownloadURL string `json:"download_url,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Notifier sends a message to every connection of a user, e.g. the websocket hub.
+type Notifier interface {
+	Notify(userID int64, message interface{})
+}
+
+// Service runs the export jobs and serves their archives.
+type Service struct {
+	userService app.UserService
+	profiles    *profile.Service
+	postService app.PostService
+	jwtService  *jwtservice.JWT
+	notifier    Notifier
+	baseURL     string
+	dir         string
+	ttl         time.Duration
+
+	mu      sync.Mutex
+	running map[int64]string
+}
+
+// New returns an export Service writing the archives inside dir.
+// The download links point to baseURL and expire after ttl,
+// when their archive is removed by Cleanup.
+func New(userService app.UserService, profiles *profile.Service, postService app.PostService, jwtService *jwtservice.JWT, notifier Notifier, baseURL, dir string, ttl time.Duration) *Service {
+	return &Service{
+		userService: userService,
+		profiles:    profiles,
+		postService: postService,
+		jwtService:  jwtService,
+		notifier:    notifier,
+		baseURL:     baseURL,
+		dir:         dir,
+		ttl:         ttl,
+		running:     make(map[int64]string),
+	}
+}
+
+// Start builds the archive of the user in the background and returns the id of the job.
+// Its Progress is notified until the download link is ready.
+func (s *Service) Start(userID int64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.running[userID]; ok {
+		return "", errExportRunning
+	}
+
+	jobID, err := newJobID()
+	if err != nil {
+		return "", err
+	}
+
+	s.running[userID] = jobID
+	go s.run(userID, jobID)
+
+	return jobID, nil
+}
+
+func (s *Service) run(userID int64, jobID string) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, userID)
+		s.mu.Unlock()
+	}()
+
+	progress := Progress{Kind: "export", JobID: jobID, Status: StatusRunning}
+
+	err := s.build(userID, jobID, &progress)
+	if err != nil {
+		log.Printf("Error exporting the account %d: %v", userID, err)
+		progress.Status = StatusFailed
+		progress.Error = "The export could not be completed"
+		s.notifier.Notify(userID, progress)
+		return
+	}
+
+	token, err := s.jwtService.EncodePurpose(downloadPurpose, jwt.MapClaims{
+		"job": jobID,
+	}, s.ttl)
+	if err != nil {
+		log.Printf("Error signing the export link: %v", err)
+		progress.Status = StatusFailed
+		progress.Error = "The export could not be completed"
+		s.notifier.Notify(userID, progress)
+		return
+	}
+
+	progress.Status = StatusDone
+	progress.DownloadURL = s.baseURL + "/exports/download?token=" + url.QueryEscape(token)
+	s.notifier.Notify(userID, progress)
+}
+
+// build writes the archive next to its final path, and only
+// moves it there once complete.
+func (s *Service) build(userID int64, jobID string, progress *Progress) error {
+	total, err := s.postService.CountPostsByCreator(userID)
+	if err != nil {
+		return err
+	}
+	progress.Total = total
+
+	if err = os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, jobID+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	archive := zip.NewWriter(tmp)
+
+	if err = s.writeProfile(archive, userID); err != nil {
+		return err
+	}
+
+	if err = s.writePosts(archive, userID, progress); err != nil {
+		return err
+	}
+
+	if err = archive.Close(); err != nil {
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(jobID))
+}
+
+func (s *Service) writeProfile(archive *zip.Writer, userID int64) error {
+	user, err := s.userService.User(userID)
+	if err != nil {
+		return err
+	}
+
+	p, err := s.profiles.Profile(userID)
+	if err != nil {
+		return err
+	}
+
+	w, err := archive.Create("profile.json")
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(map[string]interface{}{
+		"user":    response.Redact(user, response.ViewSelf),
+		"profile": p,
+	})
+}
+
+func (s *Service) writePosts(archive *zip.Writer, userID int64, progress *Progress) error {
+	var afterID int64
+
+	for {
+		posts, err := s.postService.PostsByCreator(userID, afterID, pageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, post := range posts {
+			w, err := archive.Create(fmt.Sprintf("posts/%d-%s.md", post.ID, slug(post.PostTitle)))
+			if err != nil {
+				return err
+			}
+
+			if err = writeMarkdown(w, post); err != nil {
+				return err
+			}
+
+			afterID = post.ID
+		}
+
+		progress.Done += len(posts)
+		s.notifier.Notify(userID, *progress)
+
+		if len(posts) < pageSize {
+			return nil
+		}
+	}
+}
+
+// writeMarkdown writes the post with its metadata as YAML front matter.
+// The title is quoted as JSON, which is valid YAML.
+func writeMarkdown(w io.Writer, post *app.Post) error {
+	title, err := json.Marshal(post.PostTitle)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "---\nid: %d\ntitle: %s\ncreated_at: %s\nupdated_at: %s\n---\n\n%s\n",
+		post.ID,
+		title,
+		time.Unix(post.CreatedAt, 0).UTC().Format(time.RFC3339),
+		time.Unix(post.UpdatedAt, 0).UTC().Format(time.RFC3339),
+		post.PostBody,
+	)
+
+	return err
+}
+
+// Open returns the archive of a download link.
+func (s *Service) Open(token string) (*os.File, error) {
+	claims, err := s.jwtService.DecodePurpose(downloadPurpose, token)
+	if err != nil {
+		return nil, errInvalidLink
+	}
+
+	jobID, _ := claims["job"].(string)
+	if _, err = hex.DecodeString(jobID); err != nil || jobID == "" {
+		return nil, errInvalidLink
+	}
+
+	file, err := os.Open(s.path(jobID))
+	if os.IsNotExist(err) {
+		return nil, errInvalidLink
+	}
+
+	if err != nil {
+		return nil, errArchive.Wrap(err)
+	}
+
+	return file, nil
+}
+
+// Cleanup removes the archives whose links have expired.
+func (s *Service) Cleanup() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if !file.IsDir() && time.Since(file.ModTime()) > s.ttl {
+			os.Remove(filepath.Join(s.dir, file.Name()))
+		}
+	}
+
+	return nil
+}
+
+// StartCleanup runs Cleanup every hour until done is closed.
+func (s *Service) StartCleanup(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := s.Cleanup(); err != nil {
+				log.Printf("Error removing the expired exports: %v", err)
+			}
+		}
+	}
+}
+
+func (s *Service) path(jobID string) string {
+	return filepath.Join(s.dir, jobID+".zip")
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// slug turns a title into a file name, e.g. "Hello, World!" into "hello-world".
+func slug(title string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(title) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+
+		if b.Len() >= 50 {
+			break
+		}
+	}
+
+	if b.Len() == 0 {
+		return "untitled"
+	}
+
+	return b.String()
+}
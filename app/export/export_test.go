@@ -0,0 +1,152 @@
+package export_test
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/export"
+	"github.com/rbo13/write-it/app/jwtservice"
+	"github.com/rbo13/write-it/app/persistence/inmemory"
+	"github.com/rbo13/write-it/app/profile"
+)
+
+// errNotFound is what the stores return when nothing matches.
+var errNotFound = app.NewError(app.NotFound, "Not found")
+
+type userStore struct {
+	app.UserService
+}
+
+func (s *userStore) User(id int64) (*app.User, error) {
+	return &app.User{ID: id, Username: "writer", EmailAddress: "writer@example.com", Password: "$argon2id$hash"}, nil
+}
+
+type profileStore struct{}
+
+func (s *profileStore) Profile(userID int64) (*app.Profile, error) {
+	return nil, errNotFound
+}
+
+func (s *profileStore) SaveProfile(p *app.Profile) error {
+	return nil
+}
+
+type notifier struct {
+	progress chan export.Progress
+}
+
+func (n *notifier) Notify(userID int64, message interface{}) {
+	n.progress <- message.(export.Progress)
+}
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exports")
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	jwtService, err := jwtservice.New(jwtservice.Config{Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	posts := inmemory.NewInMemoryPostService()
+	for id := int64(1); id <= 260; id++ {
+		creator := int64(1)
+		if id%26 == 0 {
+			creator = 2
+		}
+
+		posts.CreatePost(&app.Post{ID: id, CreatorID: creator, PostTitle: fmt.Sprintf("Post #%d: \"Hello\"", id), PostBody: "Body"})
+	}
+
+	users := &userStore{}
+	profiles := profile.New(&profileStore{}, users, "https://write-it.test", dir)
+	n := &notifier{progress: make(chan export.Progress, 10)}
+	service := export.New(users, profiles, posts, jwtService, n, "https://write-it.test", dir, time.Hour)
+
+	jobID, err := service.Start(1)
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	var last export.Progress
+	for last.Status != export.StatusDone {
+		select {
+		case last = <-n.progress:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expecting: %v, but got: %v instead", export.StatusDone, last)
+		}
+
+		if last.JobID != jobID || last.Status == export.StatusFailed {
+			t.Fatalf("Expecting: %v, but got: %v instead", jobID, last)
+		}
+	}
+
+	if last.Done != 250 || last.Total != 250 {
+		t.Errorf("Expecting: %v, but got: %v instead", 250, last)
+	}
+
+	link, err := url.Parse(last.DownloadURL)
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	t.Run("Archive", func(t *testing.T) {
+		file, err := service.Open(link.Query().Get("token"))
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+		defer file.Close()
+
+		info, _ := file.Stat()
+		archive, err := zip.NewReader(file, info.Size())
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		count := 0
+		for _, f := range archive.File {
+			rc, _ := f.Open()
+			content, _ := ioutil.ReadAll(rc)
+			rc.Close()
+
+			switch {
+			case f.Name == "profile.json":
+				if strings.Contains(string(content), "argon2id") || !strings.Contains(string(content), "writer@example.com") {
+					t.Errorf("Expecting: %v, but got: %v instead", "the user without password", string(content))
+				}
+			case f.Name == "posts/1-post-1-hello.md":
+				if !strings.HasPrefix(string(content), "---\nid: 1\ntitle: \"Post #1: \\\"Hello\\\"\"\n") {
+					t.Errorf("Expecting: %v, but got: %v instead", "the front matter", string(content))
+				}
+				count++
+			case strings.HasPrefix(f.Name, "posts/"):
+				count++
+			}
+		}
+
+		if count != 250 {
+			t.Errorf("Expecting: %v, but got: %v instead", 250, count)
+		}
+	})
+
+	t.Run("InvalidLink", func(t *testing.T) {
+		if _, err := service.Open("invalid"); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+
+		token, _ := jwtService.EncodePurpose("email_verification", map[string]interface{}{"job": jobID}, time.Hour)
+		if _, err := service.Open(token); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+	})
+}
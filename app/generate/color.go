@@ -2,18 +2,166 @@
 package generate
 
 import (
+	"hash/fnv"
+	"math"
 	"math/rand"
 	"time"
 
 	colorful "github.com/lucasb-eyer/go-colorful"
 )
 
+// DefaultContrast is the WCAG 2 contrast ratio of normal text at level AA.
+const DefaultContrast = 4.5
+
+// preferredLightness is the HCL lightness of the colors when the backgrounds allow it.
+const preferredLightness = 0.6
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
-// Color generates a color.
+// Scheme derives the hues of a palette from a base hue,
+// as offsets in degrees. Its first hue is the base one.
+type Scheme []float64
+
+// The harmonious schemes of the palettes.
+var (
+	Analogous     = Scheme{0, 30, -30}
+	Triadic       = Scheme{0, 120, 240}
+	Complementary = Scheme{0, 180}
+)
+
+// Colors generates the colors of a seed, e.g. a user id, which are always
+// the same for the seed and readable on every background.
+type Colors struct {
+	backgrounds []colorful.Color
+	minContrast float64
+}
+
+// DefaultColors generates colors readable on white.
+var DefaultColors, _ = NewColors(DefaultContrast, "#ffffff")
+
+// NewColors returns the Colors keeping at least minContrast against the given
+// hex backgrounds. When the backgrounds make it impossible, e.g. black and white
+// with a contrast above 4.58, the colors get as close as they can.
+func NewColors(minContrast float64, backgrounds ...string) (Colors, error) {
+	c := Colors{minContrast: minContrast}
+
+	for _, background := range backgrounds {
+		color, err := colorful.Hex(background)
+		if err != nil {
+			return Colors{}, err
+		}
+
+		c.backgrounds = append(c.backgrounds, color)
+	}
+
+	return c, nil
+}
+
+// Color returns the color of the seed.
+func (c Colors) Color(seed int64) string {
+	return c.Palette(seed, Scheme{0})[0]
+}
+
+// Palette returns the colors of the scheme around the hue of the seed,
+// the first one being the Color of the seed.
+func (c Colors) Palette(seed int64, scheme Scheme) []string {
+	r := rand.New(rand.NewSource(seed))
+	base := r.Float64() * 360.0
+	chroma := 0.45 + r.Float64()*0.2
+
+	colors := make([]string, len(scheme))
+	for i, offset := range scheme {
+		colors[i] = c.readable(math.Mod(base+offset+360.0, 360.0), chroma)
+	}
+
+	return colors
+}
+
+// readable returns the color of the hue and chroma whose lightness is the
+// closest to preferredLightness while keeping the contrast, or else the
+// color with the best contrast.
+func (c Colors) readable(hue, chroma float64) string {
+	best, bestContrast := "", -1.0
+	found, distance := "", math.MaxFloat64
+
+	for step := 0; step <= 100; step++ {
+		l := float64(step) / 100
+
+		// The contrast is measured on the color as it is written
+		hex := colorful.Hcl(hue, chroma, l).Clamped().Hex()
+		color, _ := colorful.Hex(hex)
+
+		contrast := math.MaxFloat64
+		for _, background := range c.backgrounds {
+			contrast = math.Min(contrast, contrastRatio(color, background))
+		}
+
+		if contrast >= c.minContrast && math.Abs(l-preferredLightness) < distance {
+			found, distance = hex, math.Abs(l-preferredLightness)
+		}
+
+		if contrast > bestContrast {
+			best, bestContrast = hex, contrast
+		}
+	}
+
+	if found != "" {
+		return found
+	}
+
+	return best
+}
+
+// Color generates a random color readable on white.
 func Color() string {
-	c := colorful.Hsv(rand.Float64()*360.0, 0.8, 0.8)
-	return c.Hex()
+	return DefaultColors.Color(rand.Int63())
+}
+
+// ColorFor generates the color of the seed, e.g. a user id, readable on white.
+func ColorFor(seed int64) string {
+	return DefaultColors.Color(seed)
+}
+
+// ColorForKey generates the color of a key, e.g. a tag name, readable on white.
+func ColorForKey(key string) string {
+	return DefaultColors.Color(Seed(key))
+}
+
+// Seed returns a seed that is always the same for the key.
+func Seed(key string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int64(h.Sum64())
+}
+
+// Contrast returns the WCAG 2 contrast ratio of two hex colors, from 1 to 21.
+func Contrast(a, b string) (float64, error) {
+	colorA, err := colorful.Hex(a)
+	if err != nil {
+		return 0, err
+	}
+
+	colorB, err := colorful.Hex(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return contrastRatio(colorA, colorB), nil
+}
+
+func contrastRatio(a, b colorful.Color) float64 {
+	la, lb := luminance(a), luminance(b)
+	if la < lb {
+		la, lb = lb, la
+	}
+
+	return (la + 0.05) / (lb + 0.05)
+}
+
+// luminance is the relative luminance of WCAG 2.
+func luminance(c colorful.Color) float64 {
+	r, g, b := c.LinearRgb()
+	return 0.2126*r + 0.7152*g + 0.0722*b
 }
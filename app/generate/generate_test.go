@@ -0,0 +1,118 @@
+package generate_test
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+
+	"github.com/rbo13/write-it/app/generate"
+)
+
+func TestContrast(t *testing.T) {
+	ratios := []struct {
+		a, b     string
+		expected float64
+	}{
+		{"#000000", "#ffffff", 21},
+		{"#ffffff", "#ffffff", 1},
+		{"#777777", "#ffffff", 4.48},
+	}
+
+	for _, r := range ratios {
+		contrast, err := generate.Contrast(r.a, r.b)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if contrast < r.expected-0.01 || contrast > r.expected+0.01 {
+			t.Errorf("Expecting: %v, but got: %v instead", r.expected, contrast)
+		}
+	}
+
+	if _, err := generate.Contrast("white", "#ffffff"); err == nil {
+		t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+	}
+}
+
+func TestColors(t *testing.T) {
+	t.Run("Deterministic", func(t *testing.T) {
+		if generate.ColorFor(42) != generate.ColorFor(42) || generate.ColorFor(42) == generate.ColorFor(43) {
+			t.Errorf("Expecting: %v, but got: %v instead", "the same color per seed", generate.ColorFor(42))
+		}
+
+		if generate.ColorForKey("golang") != generate.ColorFor(generate.Seed("golang")) {
+			t.Errorf("Expecting: %v, but got: %v instead", generate.ColorFor(generate.Seed("golang")), generate.ColorForKey("golang"))
+		}
+	})
+
+	t.Run("ReadableOnWhite", func(t *testing.T) {
+		for seed := int64(0); seed < 200; seed++ {
+			color := generate.ColorFor(seed)
+			if contrast, _ := generate.Contrast(color, "#ffffff"); contrast < generate.DefaultContrast {
+				t.Errorf("Expecting: %v, but got: %v instead", generate.DefaultContrast, contrast)
+			}
+		}
+	})
+
+	t.Run("ReadableOnDark", func(t *testing.T) {
+		colors, err := generate.NewColors(7, "#1e1e1e")
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		for seed := int64(0); seed < 50; seed++ {
+			for _, color := range colors.Palette(seed, generate.Triadic) {
+				if contrast, _ := generate.Contrast(color, "#1e1e1e"); contrast < 7 {
+					t.Errorf("Expecting: %v, but got: %v instead", 7, contrast)
+				}
+			}
+		}
+	})
+
+	t.Run("Palette", func(t *testing.T) {
+		palette := generate.DefaultColors.Palette(7, generate.Analogous)
+
+		if len(palette) != 3 || palette[0] != generate.ColorFor(7) || palette[1] == palette[2] {
+			t.Errorf("Expecting: %v, but got: %v instead", "3 colors starting with ColorFor(7)", palette)
+		}
+	})
+
+	t.Run("InvalidBackground", func(t *testing.T) {
+		if _, err := generate.NewColors(4.5, "white"); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+	})
+}
+
+func TestIdenticon(t *testing.T) {
+	if !bytes.Equal(generate.Identicon(1, 64), generate.Identicon(1, 64)) || bytes.Equal(generate.Identicon(1, 64), generate.Identicon(2, 64)) {
+		t.Errorf("Expecting: %v, but got: %v instead", "one identicon per seed", string(generate.Identicon(1, 64)))
+	}
+
+	data, err := generate.IdenticonPNG(1, 70)
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	if img.Bounds().Dx() != 70 || img.Bounds().Dy() != 70 {
+		t.Errorf("Expecting: %v, but got: %v instead", "70x70", img.Bounds())
+	}
+
+	// The pattern is symmetric, the margin is the background
+	for y := 0; y < 70; y++ {
+		for x := 0; x < 35; x++ {
+			if img.At(x, y) != img.At(69-x, y) {
+				t.Fatalf("Expecting: %v, but got: %v instead", img.At(x, y), img.At(69-x, y))
+			}
+		}
+	}
+
+	if img.At(5, 5) != img.At(0, 0) || img.At(64, 64) != img.At(0, 0) {
+		t.Errorf("Expecting: %v, but got: %v instead", "the background in the margin", img.At(5, 5))
+	}
+}
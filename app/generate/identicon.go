@@ -0,0 +1,107 @@
+package generate
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+
+	colorful "github.com/lucasb-eyer/go-colorful"
+)
+
+// identiconGrid is the number of cells on each side of an identicon,
+// which has a cell of margin around them.
+const identiconGrid = 5
+
+// identiconBackground is the background of the identicons.
+const identiconBackground = "#f0f0f0"
+
+// identiconColors keeps the identicons visible on their background and on white.
+var identiconColors, _ = NewColors(3, identiconBackground, "#ffffff")
+
+// identicon is the symmetric pattern of a seed.
+type identicon struct {
+	color string
+	cells [identiconGrid][identiconGrid]bool
+}
+
+func newIdenticon(seed int64) *identicon {
+	r := rand.New(rand.NewSource(seed))
+	icon := &identicon{color: identiconColors.Color(seed)}
+
+	// The left columns are random, the right ones mirror them
+	for y := 0; y < identiconGrid; y++ {
+		for x := 0; x < (identiconGrid+1)/2; x++ {
+			filled := r.Intn(2) == 1
+			icon.cells[y][x] = filled
+			icon.cells[y][identiconGrid-1-x] = filled
+		}
+	}
+
+	return icon
+}
+
+// Identicon generates a symmetric SVG pattern of size×size pixels that is
+// always the same for the given seed, e.g. a user id. Its color is readable
+// on white, next to the other colors generated for the seed.
+func Identicon(seed int64, size int) []byte {
+	icon := newIdenticon(seed)
+	side := identiconGrid + 2
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, side, side)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="%s"/>`, side, side, identiconBackground)
+
+	for y, row := range icon.cells {
+		for x, filled := range row {
+			if filled {
+				fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="1" height="1" fill="%s"/>`, x+1, y+1, icon.color)
+			}
+		}
+	}
+
+	buf.WriteString(`</svg>`)
+
+	return buf.Bytes()
+}
+
+// IdenticonPNG renders the Identicon of the seed as a PNG of size×size pixels.
+func IdenticonPNG(seed int64, size int) ([]byte, error) {
+	icon := newIdenticon(seed)
+	side := identiconGrid + 2
+
+	background, err := colorful.Hex(identiconBackground)
+	if err != nil {
+		return nil, err
+	}
+
+	foreground, err := colorful.Hex(icon.color)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{background, foreground})
+
+	// Each pixel takes the color of the cell under its center
+	for py := 0; py < size; py++ {
+		y := (2*py+1)*side/(2*size) - 1
+
+		for px := 0; px < size; px++ {
+			x := (2*px+1)*side/(2*size) - 1
+
+			if x >= 0 && x < identiconGrid && y >= 0 && y < identiconGrid && icon.cells[y][x] {
+				img.SetColorIndex(px, py, 1)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
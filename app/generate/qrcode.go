@@ -0,0 +1,555 @@
+package generate
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+var errQRCodeTooLong = errors.New("generate: text is too long for a QR code")
+
+// qrBlocks describes the error correction blocks of a version at level M:
+// the number of ecc codewords per block, then the (count, data codewords)
+// of the two block groups.
+type qrBlocks struct {
+	ecc           int
+	count1, data1 int
+	count2, data2 int
+}
+
+// qrVersions lists the versions 1 to 20 at error correction level M,
+// which is enough for about 330 bytes.
+var qrVersions = []qrBlocks{
+	{10, 1, 16, 0, 0},
+	{16, 1, 28, 0, 0},
+	{26, 1, 44, 0, 0},
+	{18, 2, 32, 0, 0},
+	{24, 2, 43, 0, 0},
+	{16, 4, 27, 0, 0},
+	{18, 4, 31, 0, 0},
+	{22, 2, 38, 2, 39},
+	{22, 3, 36, 2, 37},
+	{26, 4, 43, 1, 44},
+	{30, 1, 50, 4, 51},
+	{22, 6, 36, 2, 37},
+	{22, 8, 37, 1, 38},
+	{24, 4, 40, 5, 41},
+	{24, 5, 41, 5, 42},
+	{28, 7, 45, 3, 46},
+	{28, 10, 46, 1, 47},
+	{26, 9, 43, 4, 44},
+	{26, 3, 44, 11, 45},
+	{26, 3, 41, 13, 42},
+}
+
+func (b qrBlocks) dataCodewords() int {
+	return b.count1*b.data1 + b.count2*b.data2
+}
+
+// QRCode is a QR code encoded in byte mode at error correction level M.
+type QRCode struct {
+	// Size is the number of modules on each side.
+	Size int
+
+	version    int
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// NewQRCode encodes text as a QR code, picking the smallest version that fits.
+func NewQRCode(text string) (*QRCode, error) {
+	data := []byte(text)
+
+	for i, blocks := range qrVersions {
+		version := i + 1
+		countBits := 8
+		if version >= 10 {
+			countBits = 16
+		}
+
+		if 4+countBits+len(data)*8 > blocks.dataCodewords()*8 {
+			continue
+		}
+
+		q := newQRCode(version)
+		q.drawFunctionPatterns()
+		q.drawCodewords(q.addECC(q.encodeData(data, countBits), blocks))
+		q.applyBestMask()
+
+		return q, nil
+	}
+
+	return nil, errQRCodeTooLong
+}
+
+func newQRCode(version int) *QRCode {
+	size := version*4 + 17
+	q := &QRCode{
+		Size:       size,
+		version:    version,
+		modules:    make([][]bool, size),
+		isFunction: make([][]bool, size),
+	}
+
+	for y := range q.modules {
+		q.modules[y] = make([]bool, size)
+		q.isFunction[y] = make([]bool, size)
+	}
+
+	return q
+}
+
+// Black reports whether the module at column x and row y is dark.
+func (q *QRCode) Black(x, y int) bool {
+	return x >= 0 && y >= 0 && x < q.Size && y < q.Size && q.modules[y][x]
+}
+
+// Image renders the QR code with scale pixels per module
+// and the four modules wide quiet zone around it.
+func (q *QRCode) Image(scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+
+	const quiet = 4
+	side := (q.Size + 2*quiet) * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			if q.Black(x/scale-quiet, y/scale-quiet) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+
+	return img
+}
+
+// PNG renders the QR code as a PNG image, see Image.
+func (q *QRCode) PNG(scale int) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, q.Image(scale)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (q *QRCode) setFunction(x, y int, black bool) {
+	q.modules[y][x] = black
+	q.isFunction[y][x] = true
+}
+
+func (q *QRCode) drawFunctionPatterns() {
+	for i := 0; i < q.Size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+
+	q.drawFinderPattern(3, 3)
+	q.drawFinderPattern(q.Size-4, 3)
+	q.drawFinderPattern(3, q.Size-4)
+
+	positions := q.alignmentPositions()
+	n := len(positions)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			// Skip the three corners occupied by the finder patterns.
+			if (i == 0 && j == 0) || (i == 0 && j == n-1) || (i == n-1 && j == 0) {
+				continue
+			}
+			q.drawAlignmentPattern(positions[i], positions[j])
+		}
+	}
+
+	// Reserve the format areas, they are drawn once the mask is known.
+	q.drawFormatBits(0)
+	q.drawVersion()
+}
+
+// drawFinderPattern draws a finder pattern and its separator centered at (x, y).
+func (q *QRCode) drawFinderPattern(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= q.Size || yy >= q.Size {
+				continue
+			}
+
+			dist := abs(dx)
+			if abs(dy) > dist {
+				dist = abs(dy)
+			}
+			q.setFunction(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (q *QRCode) drawAlignmentPattern(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			dist := abs(dx)
+			if abs(dy) > dist {
+				dist = abs(dy)
+			}
+			q.setFunction(x+dx, y+dy, dist != 1)
+		}
+	}
+}
+
+func (q *QRCode) alignmentPositions() []int {
+	if q.version == 1 {
+		return nil
+	}
+
+	n := q.version/7 + 2
+	step := (q.version*4 + n*2 + 1) / (n*2 - 2) * 2
+
+	positions := make([]int, n)
+	positions[0] = 6
+	for i, pos := n-1, q.Size-7; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+
+	return positions
+}
+
+// drawFormatBits draws the error correction level M and the mask.
+func (q *QRCode) drawFormatBits(mask int) {
+	// Level M is encoded as 00.
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, bit(bits, i))
+	}
+	q.setFunction(8, 7, bit(bits, 6))
+	q.setFunction(8, 8, bit(bits, 7))
+	q.setFunction(7, 8, bit(bits, 8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(bits, i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.setFunction(q.Size-1-i, 8, bit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.Size-15+i, bit(bits, i))
+	}
+	q.setFunction(8, q.Size-8, true)
+}
+
+func (q *QRCode) drawVersion() {
+	if q.version < 7 {
+		return
+	}
+
+	rem := q.version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := q.version<<12 | rem
+
+	for i := 0; i < 18; i++ {
+		a, b := q.Size-11+i%3, i/3
+		q.setFunction(a, b, bit(bits, i))
+		q.setFunction(b, a, bit(bits, i))
+	}
+}
+
+// encodeData returns the data codewords: mode, length, data, terminator and padding.
+func (q *QRCode) encodeData(data []byte, countBits int) []byte {
+	capacity := qrVersions[q.version-1].dataCodewords() * 8
+
+	var bits []bool
+	appendBits := func(val, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, bit(val, i))
+		}
+	}
+
+	appendBits(0x4, 4)
+	appendBits(len(data), countBits)
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+
+	for i := 0; i < 4 && len(bits) < capacity; i++ {
+		bits = append(bits, false)
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		appendBits(pad, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i, b := range bits {
+		if b {
+			codewords[i/8] |= 1 << uint(7-i%8)
+		}
+	}
+
+	return codewords
+}
+
+// addECC splits the data into blocks, computes their Reed-Solomon
+// error correction codewords and interleaves everything.
+func (q *QRCode) addECC(data []byte, blocks qrBlocks) []byte {
+	divisor := rsDivisor(blocks.ecc)
+
+	var dataBlocks, eccBlocks [][]byte
+	for i := 0; i < blocks.count1+blocks.count2; i++ {
+		n := blocks.data1
+		if i >= blocks.count1 {
+			n = blocks.data2
+		}
+
+		dataBlocks = append(dataBlocks, data[:n])
+		eccBlocks = append(eccBlocks, rsRemainder(data[:n], divisor))
+		data = data[n:]
+	}
+
+	var result []byte
+	for i := 0; i < blocks.data1 || i < blocks.data2; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < blocks.ecc; i++ {
+		for _, block := range eccBlocks {
+			result = append(result, block[i])
+		}
+	}
+
+	return result
+}
+
+// drawCodewords places the codewords in the zigzag order, two columns at a time.
+func (q *QRCode) drawCodewords(codewords []byte) {
+	i := 0
+	for right := q.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+
+		for vert := 0; vert < q.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.Size - 1 - vert
+				}
+
+				if !q.isFunction[y][x] && i < len(codewords)*8 {
+					q.modules[y][x] = bit(int(codewords[i/8]), 7-i%8)
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (q *QRCode) applyMask(mask int) {
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if q.isFunction[y][x] {
+				continue
+			}
+
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+
+			q.modules[y][x] = q.modules[y][x] != invert
+		}
+	}
+}
+
+// applyBestMask tries the eight masks and keeps the one with the lowest penalty.
+func (q *QRCode) applyBestMask() {
+	best, bestPenalty := 0, -1
+
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+
+		if penalty := q.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+
+		// Masks are xor'ed, applying it again undoes it.
+		q.applyMask(mask)
+	}
+
+	q.applyMask(best)
+	q.drawFormatBits(best)
+}
+
+// penalty scores the symbol with the four rules of the specification.
+func (q *QRCode) penalty() int {
+	result := 0
+	finder := []bool{true, false, true, true, true, false, true}
+
+	for i := 0; i < q.Size; i++ {
+		row := make([]bool, q.Size)
+		col := make([]bool, q.Size)
+		for j := 0; j < q.Size; j++ {
+			row[j] = q.modules[i][j]
+			col[j] = q.modules[j][i]
+		}
+
+		for _, line := range [][]bool{row, col} {
+			// Rule 1: runs of five or more modules of the same color.
+			run := 1
+			for j := 1; j <= len(line); j++ {
+				if j < len(line) && line[j] == line[j-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					result += run - 2
+				}
+				run = 1
+			}
+
+			// Rule 3: finder-like patterns preceded or followed by four light modules.
+			for j := 0; j+len(finder) <= len(line); j++ {
+				if !matches(line[j:j+len(finder)], finder) {
+					continue
+				}
+				if lightRun(line, j-4, j) || lightRun(line, j+len(finder), j+len(finder)+4) {
+					result += 40
+				}
+			}
+		}
+	}
+
+	// Rule 2: 2x2 blocks of the same color.
+	dark := 0
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x+1 < q.Size && y+1 < q.Size {
+				c := q.modules[y][x]
+				if c == q.modules[y][x+1] && c == q.modules[y+1][x] && c == q.modules[y+1][x+1] {
+					result += 3
+				}
+			}
+		}
+	}
+
+	// Rule 4: balance of dark and light modules.
+	total := q.Size * q.Size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	if k > 0 {
+		result += k * 10
+	}
+
+	return result
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of the given degree.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+
+	return result
+}
+
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+
+	return byte(z)
+}
+
+func matches(line, pattern []bool) bool {
+	for i := range pattern {
+		if line[i] != pattern[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// lightRun reports whether every module in [from, to) is light,
+// treating the modules outside the symbol as light.
+func lightRun(line []bool, from, to int) bool {
+	for i := from; i < to; i++ {
+		if i >= 0 && i < len(line) && line[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func bit(val, i int) bool {
+	return (val>>uint(i))&1 != 0
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -1,22 +1,93 @@
 package app
 
 import (
-  "net/http"
+	"net/http"
 )
 
 // Handler is an interface that defines the basic operations of every http request.
 type Handler interface {
-  Create(w http.ResponseWriter, r *http.Request)
-  Get(w http.ResponseWriter, r *http.Request)
-  GetByID(w http.ResponseWriter, r *http.Request)
-  Update(w http.ResponseWriter, r *http.Request)
-  Delete(w http.ResponseWriter, r *http.Request)
+	Create(w http.ResponseWriter, r *http.Request)
+	Get(w http.ResponseWriter, r *http.Request)
+	GetByID(w http.ResponseWriter, r *http.Request)
+	Update(w http.ResponseWriter, r *http.Request)
+	Delete(w http.ResponseWriter, r *http.Request)
 }
 
 // UserHandler implements the Handler interface with some user related methods.
 type UserHandler interface {
-  Handler
+	Handler
 
-  Login(w http.ResponseWriter, r *http.Request)
-  GetUserPosts(w http.ResponseWriter, r *http.Request)
+	Login(w http.ResponseWriter, r *http.Request)
+	GetUserPosts(w http.ResponseWriter, r *http.Request)
+	VerifyEmail(w http.ResponseWriter, r *http.Request)
+	SetUserType(w http.ResponseWriter, r *http.Request)
+}
+
+// ProfileHandler handles the user profile and avatar requests.
+type ProfileHandler interface {
+	Get(w http.ResponseWriter, r *http.Request)
+	Update(w http.ResponseWriter, r *http.Request)
+	Avatar(w http.ResponseWriter, r *http.Request)
+	UploadAvatar(w http.ResponseWriter, r *http.Request)
+	DeleteAvatar(w http.ResponseWriter, r *http.Request)
+}
+
+// PasswordHandler handles the password reset requests.
+type PasswordHandler interface {
+	Forgot(w http.ResponseWriter, r *http.Request)
+	Reset(w http.ResponseWriter, r *http.Request)
+}
+
+// OIDCHandler handles the sign in with the OpenID Connect providers.
+type OIDCHandler interface {
+	Providers(w http.ResponseWriter, r *http.Request)
+	Begin(w http.ResponseWriter, r *http.Request)
+	Callback(w http.ResponseWriter, r *http.Request)
+}
+
+// OAuthHandler handles the OAuth2 authorization server requests.
+type OAuthHandler interface {
+	RegisterClient(w http.ResponseWriter, r *http.Request)
+	Clients(w http.ResponseWriter, r *http.Request)
+	DeleteClient(w http.ResponseWriter, r *http.Request)
+	Consent(w http.ResponseWriter, r *http.Request)
+	Authorize(w http.ResponseWriter, r *http.Request)
+	Token(w http.ResponseWriter, r *http.Request)
+	Introspect(w http.ResponseWriter, r *http.Request)
+}
+
+// TwoFactorHandler handles the two-factor authentication requests.
+type TwoFactorHandler interface {
+	Enrol(w http.ResponseWriter, r *http.Request)
+	QRCode(w http.ResponseWriter, r *http.Request)
+	Confirm(w http.ResponseWriter, r *http.Request)
+	Disable(w http.ResponseWriter, r *http.Request)
+	Login(w http.ResponseWriter, r *http.Request)
+	SetRoleRequirement(w http.ResponseWriter, r *http.Request)
+}
+
+// AccessTokenHandler handles the personal access token requests.
+type AccessTokenHandler interface {
+	Create(w http.ResponseWriter, r *http.Request)
+	Get(w http.ResponseWriter, r *http.Request)
+	Delete(w http.ResponseWriter, r *http.Request)
+}
+
+// ExportHandler handles the account data exports.
+type ExportHandler interface {
+	Start(w http.ResponseWriter, r *http.Request)
+	Download(w http.ResponseWriter, r *http.Request)
+}
+
+// ErasureHandler handles the account erasure requests.
+type ErasureHandler interface {
+	Status(w http.ResponseWriter, r *http.Request)
+	Cancel(w http.ResponseWriter, r *http.Request)
+	Confirm(w http.ResponseWriter, r *http.Request)
+}
+
+// AuditHandler handles the audit log requests of the admins.
+type AuditHandler interface {
+	Get(w http.ResponseWriter, r *http.Request)
+	Verify(w http.ResponseWriter, r *http.Request)
 }
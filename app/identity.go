@@ -0,0 +1,24 @@
+package app
+
+// Identity links a user to an account of an external OpenID Connect provider.
+type Identity struct {
+	ID       int64  `json:"id" db:"id"`
+	UserID   int64  `json:"user_id" db:"user_id"`
+	Provider string `json:"provider" db:"provider"`
+	// Subject is the stable identifier of the account at the provider.
+	Subject   string `json:"-" db:"subject"`
+	Email     string `json:"email" db:"email"`
+	CreatedAt int64  `json:"created_at" db:"created_at"`
+}
+
+// IdentityService defines the basic service of identity
+type IdentityService interface {
+	CreateIdentity(*Identity) error
+	Identity(provider, subject string) (*Identity, error)
+	Identities(userID int64) ([]*Identity, error)
+}
+
+// TableName represents the table name of identity
+func (Identity) TableName() string {
+	return "identities"
+}
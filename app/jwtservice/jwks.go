@@ -0,0 +1,165 @@
+package jwtservice
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/render"
+)
+
+// JSONWebKey is the public part of a signing key as described by RFC 7517.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+var errUnsupportedJWK = errors.New("jwtservice: unsupported JSON Web Key")
+
+// JSONWebKeySet is the document served on /.well-known/jwks.json.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// KeySet returns the public keys that can currently verify our tokens.
+// Symmetric keys are never published.
+func (j *JWT) KeySet() JSONWebKeySet {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	now := time.Now()
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+
+	for _, key := range j.keys {
+		if key.retired(now) {
+			continue
+		}
+
+		if jwk, ok := NewJSONWebKey(key.ID, key.verifyKey); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	sort.Slice(set.Keys, func(a, b int) bool {
+		return set.Keys[a].KeyID < set.Keys[b].KeyID
+	})
+
+	return set
+}
+
+// JWKS serves the public key set so that other services can verify our tokens.
+func (j *JWT) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	render.JSON(w, r, j.KeySet())
+}
+
+// NewJSONWebKey converts an RSA or ECDSA public key into its JWK representation.
+func NewJSONWebKey(id string, publicKey interface{}) (JSONWebKey, bool) {
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		return JSONWebKey{
+			KeyType:   "RSA",
+			KeyID:     id,
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         encodeBigInt(k.N, 0),
+			E:         encodeBigInt(big.NewInt(int64(k.E)), 0),
+		}, true
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return JSONWebKey{
+			KeyType:   "EC",
+			KeyID:     id,
+			Use:       "sig",
+			Algorithm: "ES256",
+			Curve:     k.Curve.Params().Name,
+			X:         encodeBigInt(k.X, size),
+			Y:         encodeBigInt(k.Y, size),
+		}, true
+	}
+
+	return JSONWebKey{}, false
+}
+
+// PublicKey converts the JWK back into an *rsa.PublicKey or an *ecdsa.PublicKey,
+// e.g. to verify the tokens of another issuer.
+func (k JSONWebKey) PublicKey() (interface{}, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errUnsupportedJWK
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errUnsupportedJWK
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, errUnsupportedJWK
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, errUnsupportedJWK
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errUnsupportedJWK
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+func encodeBigInt(n *big.Int, size int) string {
+	b := n.Bytes()
+	if len(b) < size {
+		padded := make([]byte, size)
+		copy(padded[size-len(b):], b)
+		b = padded
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -1,17 +1,259 @@
 package jwtservice
 
-import "github.com/go-chi/jwtauth"
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
 
-// JWT ...
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/jwtauth"
+)
+
+var (
+	errMissingSecret = errors.New("jwtservice: an HS256 secret or a key directory is required")
+	errUnknownKey    = errors.New("jwtservice: unknown key id")
+	errAlgoMismatch  = errors.New("jwtservice: token algorithm does not match its key")
+)
+
+// hmacKeyID is the `kid` used for the single HS256 development key.
+const hmacKeyID = "hs256"
+
+// Config sets how tokens are signed.
+//
+// When KeyDir is set, every *.pem private key inside it (RSA or P-256 EC)
+// becomes a signing key named after its file, and the most recently
+// modified one signs.
+// Otherwise tokens are signed with HS256 using Secret, which is meant for
+// local development only.
+type Config struct {
+	Secret string
+	KeyDir string
+	// RotationInterval is the maximum age of the active key. When it is
+	// exceeded, a new key is generated inside KeyDir. Zero disables rotation.
+	RotationInterval time.Duration
+	// GracePeriod is how long a superseded key keeps verifying tokens.
+	GracePeriod time.Duration
+}
+
+// JWT signs and verifies the tokens of our application.
 type JWT struct {
-	TokenAuth *jwtauth.JWTAuth
+	config Config
+	parser *jwt.Parser
+
+	mu     sync.RWMutex
+	active *Key
+	keys   map[string]*Key
+}
+
+// New returns a JWT service configured by the given Config.
+func New(config Config) (*JWT, error) {
+	j := &JWT{
+		config: config,
+		parser: &jwt.Parser{},
+		keys:   map[string]*Key{},
+	}
+
+	if config.KeyDir == "" {
+		if config.Secret == "" {
+			return nil, errMissingSecret
+		}
+
+		key := newHMACKey(hmacKeyID, []byte(config.Secret))
+		j.active = key
+		j.keys[key.ID] = key
+
+		return j, nil
+	}
+
+	if err := j.Reload(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Reload re-reads the key directory. The most recently modified key becomes
+// the active one. Every other key on disk was superseded when the key after it
+// was written, so it keeps verifying for the grace period from that moment.
+// Deriving the retirement from the modification times keeps retired keys
+// retired across restarts, without removing the operator's files.
+func (j *JWT) Reload() error {
+	if j.config.KeyDir == "" {
+		return nil
+	}
+
+	keys, err := loadKeyDir(j.config.KeyDir)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	onDisk := map[string]bool{}
+	loaded := make(map[string]*Key, len(keys))
+
+	for i, key := range keys {
+		onDisk[key.ID] = true
+		if i+1 < len(keys) {
+			key.RetiresAt = keys[i+1].CreatedAt.Add(j.config.GracePeriod)
+		}
+
+		if !key.retired(now) {
+			loaded[key.ID] = key
+		}
+	}
+
+	// Keys removed from disk keep verifying for the grace period.
+	for id, key := range j.keys {
+		if onDisk[id] {
+			continue
+		}
+
+		if key.RetiresAt.IsZero() {
+			key.RetiresAt = now.Add(j.config.GracePeriod)
+		}
+
+		if !key.retired(now) {
+			loaded[id] = key
+		}
+	}
+
+	j.keys = loaded
+	j.active = keys[len(keys)-1]
+
+	return nil
 }
 
-const jwtSecret = "5f7532af1ee4524945250f694b5bd06f44f9127bfc35924c457dfa7f68356798319d2d2c4bdce5aaee390cdc731585285e1e374fc1a88dcdbe3f21320b602aba"
+// Rotate generates a new key of the same algorithm inside the key directory
+// and makes it the active one.
+func (j *JWT) Rotate() error {
+	if j.config.KeyDir == "" {
+		return errUnsupportedAlgo
+	}
 
-// New ...
-func New() *JWT {
-	return &JWT{
-		TokenAuth: jwtauth.New("HS256", []byte(jwtSecret), nil),
+	_, err := generateKeyFile(j.config.KeyDir, j.activeKey().Algorithm)
+	if err != nil {
+		return err
 	}
+
+	return j.Reload()
+}
+
+// StartRotation reloads the key directory every minute and rotates the active
+// key once it is older than the configured RotationInterval. It blocks until
+// done is closed, so it should run inside its own goroutine.
+func (j *JWT) StartRotation(done <-chan struct{}) {
+	if j.config.KeyDir == "" {
+		return
+	}
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := j.Reload(); err != nil {
+				log.Printf("Error reloading signing keys: %v", err)
+				continue
+			}
+
+			active := j.activeKey()
+			if j.config.RotationInterval > 0 && time.Since(active.CreatedAt) > j.config.RotationInterval {
+				if err := j.Rotate(); err != nil {
+					log.Printf("Error rotating signing key: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// Encode signs the claims with the active key.
+func (j *JWT) Encode(claims jwt.MapClaims) (string, error) {
+	key := j.activeKey()
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.signKey)
+}
+
+// Decode parses and verifies the token string, picking the key by its `kid` header.
+func (j *JWT) Decode(tokenString string) (*jwt.Token, error) {
+	return j.parser.Parse(tokenString, j.keyFunc)
+}
+
+func (j *JWT) keyFunc(t *jwt.Token) (interface{}, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	// Tokens issued before key ids existed are verified with the active key.
+	key := j.active
+	if kid, ok := t.Header["kid"].(string); ok {
+		key, ok = j.keys[kid]
+		if !ok || key.retired(time.Now()) {
+			return nil, errUnknownKey
+		}
+	}
+
+	if t.Method.Alg() != key.method.Alg() {
+		return nil, errAlgoMismatch
+	}
+
+	return key.verifyKey, nil
+}
+
+// Verifier is a middleware that verifies the JWT found in the request,
+// in the same order as jwtauth.Verifier, and stores the result in the
+// context so that jwtauth.Authenticator and jwtauth.FromContext keep working.
+func (j *JWT) Verifier(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := j.verifyRequest(r)
+		ctx := jwtauth.NewContext(r.Context(), token, err)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (j *JWT) verifyRequest(r *http.Request) (*jwt.Token, error) {
+	var tokenString string
+	for _, find := range []func(*http.Request) string{jwtauth.TokenFromQuery, jwtauth.TokenFromHeader, jwtauth.TokenFromCookie} {
+		if tokenString = find(r); tokenString != "" {
+			break
+		}
+	}
+
+	if tokenString == "" {
+		return nil, jwtauth.ErrNoTokenFound
+	}
+
+	token, err := j.Decode(tokenString)
+	if err != nil {
+		if verr, ok := err.(*jwt.ValidationError); ok && verr.Errors&jwt.ValidationErrorExpired > 0 {
+			return token, jwtauth.ErrExpired
+		}
+		return token, err
+	}
+
+	if !token.Valid {
+		return token, jwtauth.ErrUnauthorized
+	}
+
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && claims[purposeClaim] != nil {
+		return token, jwtauth.ErrUnauthorized
+	}
+
+	return token, nil
+}
+
+func (j *JWT) activeKey() *Key {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return j.active
 }
@@ -0,0 +1,279 @@
+package jwtservice_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/jwtauth"
+	"github.com/rbo13/write-it/app/jwtservice"
+)
+
+func writeECKey(t *testing.T, dir, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".pem"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func touchKey(t *testing.T, dir, name string, modTime time.Time) {
+	if err := os.Chtimes(filepath.Join(dir, name+".pem"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJWT(t *testing.T) {
+	claims := jwt.MapClaims{"user_id": 1}
+
+	t.Run("TestHS256RoundTrip", func(t *testing.T) {
+		jwtService, err := jwtservice.New(jwtservice.Config{Secret: "secret"})
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		token, err := jwtService.Encode(claims)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if _, err := jwtService.Decode(token); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+
+		if keys := jwtService.KeySet().Keys; len(keys) != 0 {
+			t.Errorf("Expecting no published keys, but got: %v instead", keys)
+		}
+	})
+
+	t.Run("TestRotationGracePeriod", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "jwtservice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		writeECKey(t, dir, "0001")
+
+		jwtService, err := jwtservice.New(jwtservice.Config{KeyDir: dir, GracePeriod: time.Hour})
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		oldToken, err := jwtService.Encode(claims)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		writeECKey(t, dir, "0002")
+		if err := jwtService.Reload(); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		newToken, err := jwtService.Encode(claims)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		parsed, err := jwtService.Decode(newToken)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if parsed.Header["kid"] != "0002" {
+			t.Errorf("Expecting: %v, but got: %v instead", "0002", parsed.Header["kid"])
+		}
+
+		if _, err := jwtService.Decode(oldToken); err != nil {
+			t.Errorf("Expecting the old key to verify during its grace period, but got: %v", err)
+		}
+
+		if keys := jwtService.KeySet().Keys; len(keys) != 2 {
+			t.Errorf("Expecting: %d published keys, but got: %d instead", 2, len(keys))
+		}
+	})
+
+	t.Run("TestRotationsWithinASecond", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "jwtservice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		writeECKey(t, dir, "0001")
+
+		jwtService, err := jwtservice.New(jwtservice.Config{KeyDir: dir, GracePeriod: time.Hour})
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		var tokens []string
+		for i := 0; i < 3; i++ {
+			if i > 0 {
+				if err := jwtService.Rotate(); err != nil {
+					t.Fatalf("Error occurred due to: %v", err)
+				}
+			}
+
+			token, err := jwtService.Encode(claims)
+			if err != nil {
+				t.Fatalf("Error occurred due to: %v", err)
+			}
+			tokens = append(tokens, token)
+		}
+
+		// Every key signing a token is kept, none is overwritten
+		for _, token := range tokens {
+			if _, err := jwtService.Decode(token); err != nil {
+				t.Errorf("Error occurred due to: %v", err)
+			}
+		}
+
+		if paths, _ := filepath.Glob(filepath.Join(dir, "*.pem")); len(paths) != 3 {
+			t.Errorf("Expecting: %d key files, but got: %d instead", 3, len(paths))
+		}
+	})
+
+	t.Run("TestNewestKeyByModificationTime", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "jwtservice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		now := time.Now()
+		writeECKey(t, dir, "b")
+		writeECKey(t, dir, "a")
+		touchKey(t, dir, "b", now.Add(-time.Hour))
+		touchKey(t, dir, "a", now)
+
+		jwtService, err := jwtservice.New(jwtservice.Config{KeyDir: dir, GracePeriod: time.Hour})
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		token, err := jwtService.Encode(claims)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		parsed, err := jwtService.Decode(token)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if parsed.Header["kid"] != "a" {
+			t.Errorf("Expecting: %v, but got: %v instead", "a", parsed.Header["kid"])
+		}
+	})
+
+	t.Run("TestRetirementSurvivesRestart", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "jwtservice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		now := time.Now()
+		writeECKey(t, dir, "0001")
+		touchKey(t, dir, "0001", now.Add(-3*time.Hour))
+
+		jwtService, err := jwtservice.New(jwtservice.Config{KeyDir: dir, GracePeriod: time.Hour})
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		oldToken, err := jwtService.Encode(claims)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		// The newer key was written two hours ago, so the grace period
+		// of the old key ended an hour ago.
+		writeECKey(t, dir, "0002")
+		touchKey(t, dir, "0002", now.Add(-2*time.Hour))
+
+		restarted, err := jwtservice.New(jwtservice.Config{KeyDir: dir, GracePeriod: time.Hour})
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if _, err := restarted.Decode(oldToken); err == nil {
+			t.Error("Expecting the retired key to be rejected after a restart")
+		}
+
+		if keys := restarted.KeySet().Keys; len(keys) != 1 {
+			t.Errorf("Expecting: %d published keys, but got: %d instead", 1, len(keys))
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, "0001.pem")); err != nil {
+			t.Errorf("Expecting the retired key file to be kept, but got: %v", err)
+		}
+	})
+
+	t.Run("TestPurposeToken", func(t *testing.T) {
+		jwtService, err := jwtservice.New(jwtservice.Config{Secret: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := jwtService.EncodePurpose("email_verification", jwt.MapClaims{"sub": 1}, time.Hour)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if _, err := jwtService.DecodePurpose("password_reset", token); err == nil {
+			t.Error("Expecting an error when decoding a token made for another purpose")
+		}
+
+		handler := jwtService.Verifier(jwtauth.Authenticator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "BEARER "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expecting: %d, but got: %d instead", http.StatusUnauthorized, rec.Code)
+		}
+	})
+
+	t.Run("TestRejectUnknownKey", func(t *testing.T) {
+		signer, err := jwtservice.New(jwtservice.Config{Secret: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		verifier, err := jwtservice.New(jwtservice.Config{Secret: "another secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := signer.Encode(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := verifier.Decode(token); err == nil {
+			t.Error("Expecting an error when verifying with the wrong secret")
+		}
+	})
+}
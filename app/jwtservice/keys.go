@@ -0,0 +1,221 @@
+package jwtservice
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+var (
+	errNoKeys          = errors.New("jwtservice: no signing keys found")
+	errInvalidPEM      = errors.New("jwtservice: key must be PEM encoded")
+	errUnsupportedKey  = errors.New("jwtservice: unsupported key type, expecting an RSA or P-256 EC private key")
+	errUnsupportedAlgo = errors.New("jwtservice: unsupported signing algorithm")
+	errKeyFileExists   = errors.New("jwtservice: too many keys generated within the same second")
+)
+
+// maxKeyFileTries is how many names generateKeyFile tries within a second.
+const maxKeyFileTries = 100
+
+// Key is a single signing key identified by its `kid`.
+type Key struct {
+	ID        string
+	Algorithm string
+	CreatedAt time.Time
+	// RetiresAt is zero for the active key. For a superseded key it is
+	// the end of its grace period, after which it no longer verifies tokens.
+	RetiresAt time.Time
+
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// newHMACKey returns a symmetric HS256 key.
+func newHMACKey(id string, secret []byte) *Key {
+	return &Key{
+		ID:        id,
+		Algorithm: jwt.SigningMethodHS256.Alg(),
+		CreatedAt: time.Now(),
+		method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+// newPrivateKey returns an RS256 or ES256 key depending on the type of the private key.
+func newPrivateKey(id string, privateKey interface{}, createdAt time.Time) (*Key, error) {
+	switch k := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return &Key{
+			ID:        id,
+			Algorithm: jwt.SigningMethodRS256.Alg(),
+			CreatedAt: createdAt,
+			method:    jwt.SigningMethodRS256,
+			signKey:   k,
+			verifyKey: &k.PublicKey,
+		}, nil
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errUnsupportedKey
+		}
+		return &Key{
+			ID:        id,
+			Algorithm: jwt.SigningMethodES256.Alg(),
+			CreatedAt: createdAt,
+			method:    jwt.SigningMethodES256,
+			signKey:   k,
+			verifyKey: &k.PublicKey,
+		}, nil
+	}
+
+	return nil, errUnsupportedKey
+}
+
+// retired reports whether the key has outlived its grace period.
+func (k *Key) retired(now time.Time) bool {
+	return !k.RetiresAt.IsZero() && now.After(k.RetiresAt)
+}
+
+// parsePrivateKeyPEM parses a PKCS1, PKCS8 or SEC1 encoded private key.
+func parsePrivateKeyPEM(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errInvalidPEM
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errUnsupportedKey
+	}
+
+	return key, nil
+}
+
+// loadKeyDir reads every *.pem file inside dir. The file name without
+// the extension becomes the `kid`, and the keys are returned sorted by their
+// modification time, so the last one is the newest.
+func loadKeyDir(dir string) ([]*Key, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(paths) == 0 {
+		return nil, errNoKeys
+	}
+
+	keys := make([]*Key, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		privateKey, err := parsePrivateKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		key, err := newPrivateKey(id, privateKey, info.ModTime())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(a, b int) bool {
+		if keys[a].CreatedAt.Equal(keys[b].CreatedAt) {
+			return keys[a].ID < keys[b].ID
+		}
+		return keys[a].CreatedAt.Before(keys[b].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+// generateKeyFile creates a new private key with the same algorithm as the
+// given one and writes it inside dir, where it becomes the newest key.
+func generateKeyFile(dir, algorithm string) (string, error) {
+	var block *pem.Block
+
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return "", err
+		}
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	case jwt.SigningMethodES256.Alg():
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return "", err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	default:
+		return "", errUnsupportedAlgo
+	}
+
+	// A key rotated within the same second gets a numbered name, sorted
+	// after the first one, instead of replacing a key still in use.
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+
+	for try := 0; try < maxKeyFileTries; try++ {
+		name := stamp
+		if try > 0 {
+			name = fmt.Sprintf("%s-%d", stamp, try)
+		}
+
+		file, err := os.OpenFile(filepath.Join(dir, name+".pem"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		_, err = file.Write(pem.EncodeToMemory(block))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return name, nil
+	}
+
+	return "", errKeyFileExists
+}
@@ -0,0 +1,39 @@
+package jwtservice
+
+import (
+	"errors"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/jwtauth"
+)
+
+// purposeClaim marks single-purpose tokens, e.g. email verification links.
+// Tokens carrying it are never accepted as authentication tokens.
+const purposeClaim = "purpose"
+
+var errWrongPurpose = errors.New("jwtservice: token is not valid for this purpose")
+
+// EncodePurpose signs a token that expires after ttl and is only valid for the given purpose.
+func (j *JWT) EncodePurpose(purpose string, claims jwt.MapClaims, ttl time.Duration) (string, error) {
+	claims[purposeClaim] = purpose
+	jwtauth.SetExpiryIn(claims, ttl)
+	jwtauth.SetIssuedNow(claims)
+
+	return j.Encode(claims)
+}
+
+// DecodePurpose verifies a token created by EncodePurpose and returns its claims.
+func (j *JWT) DecodePurpose(purpose, tokenString string) (jwt.MapClaims, error) {
+	token, err := j.Decode(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims[purposeClaim] != purpose {
+		return nil, errWrongPurpose
+	}
+
+	return claims, nil
+}
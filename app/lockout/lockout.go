@@ -0,0 +1,347 @@
+// Package lockout slows down and locks the login attempts of
+// an account or an IP address after repeated failures.
+package lockout
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/mailer"
+	"github.com/rbo13/write-it/app/persistence/cache"
+	"github.com/rbo13/write-it/app/persistence/cache/memory"
+)
+
+// localItems is how many accounts and IP addresses the
+// local store of the failures holds at most.
+const localItems = 100000
+
+// pendingTimeout is how long an attempt let through by Check is reserved
+// when neither its failure nor its success is reported.
+const pendingTimeout = time.Minute
+
+// Config sets the thresholds of the Guard.
+type Config struct {
+	// BaseDelay is the wait after the first failure of an account,
+	// doubled after each following one and capped to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxAccountFailures locks the account for LockDuration.
+	MaxAccountFailures int
+	LockDuration       time.Duration
+	// MaxIPFailures locks the IP address for LockDuration, whatever the account.
+	MaxIPFailures int
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+// DefaultConfig is a sensible Config for an interactive login.
+var DefaultConfig = Config{
+	BaseDelay:          time.Second,
+	MaxDelay:           5 * time.Minute,
+	MaxAccountFailures: 10,
+	LockDuration:       15 * time.Minute,
+	MaxIPFailures:      50,
+	Window:             time.Hour,
+}
+
+// LockedError is returned while the attempts are refused.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("Too many failed login attempts, retry in %d seconds", int(e.RetryAfter.Seconds()+0.5))
+}
+
+// attempts is what we keep inside the cache for an account or an IP address.
+type attempts struct {
+	Failures    int   `json:"failures"`
+	LastFailure int64 `json:"last_failure"`
+	LockedUntil int64 `json:"locked_until"`
+	// Pending are the attempts let through by Check
+	// whose failure or success is not reported yet.
+	Pending     int   `json:"pending"`
+	LastAttempt int64 `json:"last_attempt"`
+}
+
+// Guard records the failed logins through the cache, which is shared by
+// the instances when it updates the failures atomically, as memcached does.
+// The failures are counted by the instance alone, in its local store, while
+// the cache fails or when it cannot update them atomically, so that an
+// unreachable cache never lets unlimited attempts through.
+type Guard struct {
+	config      Config
+	cache       cache.Cacher
+	local       *memory.Cache
+	mailer      mailer.Mailer
+	userService app.UserService
+	now         func() time.Time
+	// scope prefixes the cache keys, so that the guards of
+	// different steps of the login count their failures apart.
+	scope string
+	// silent guards do not notify the owner of a locked account.
+	silent bool
+}
+
+// New returns a Guard. The owner of an account is notified through
+// the mailer when the account gets locked.
+func New(config Config, c cache.Cacher, m mailer.Mailer, userService app.UserService) *Guard {
+	return &Guard{
+		config:      config,
+		cache:       c,
+		local:       memory.New(localItems),
+		mailer:      m,
+		userService: userService,
+		now:         time.Now,
+		scope:       "login",
+	}
+}
+
+// Scoped returns a Guard sharing the cache and the thresholds of g, which
+// counts its failures apart from g, e.g. for the second step of the login,
+// so that succeeding at the first step does not forget them.
+func (g *Guard) Scoped(scope string) *Guard {
+	scoped := *g
+	scoped.scope = scope
+	return &scoped
+}
+
+// Silent returns a Guard like g which does not notify the owner when the
+// account gets locked, for the guards that count something else than failed
+// logins, e.g. the password reset emails sent to the account.
+func (g *Guard) Silent() *Guard {
+	silent := *g
+	silent.silent = true
+	return &silent
+}
+
+// Check returns a *LockedError when the next attempt for the email or
+// the IP address must wait. It must be called before checking the password,
+// as it reserves the attempt until Fail, Succeed or Release reports it.
+func (g *Guard) Check(email, ip string) error {
+	now := g.now()
+	retryAfter := g.wait(g.load(g.accountKey(email), now), now)
+
+	// An IP address can be shared by many users, so it is only locked,
+	// without the backoff slowing everyone down after a single typo.
+	if ip := g.load(g.ipKey(ip), now); ip.LockedUntil > now.Unix() {
+		if wait := time.Unix(ip.LockedUntil, 0).Sub(now); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	if retryAfter > 0 {
+		return &LockedError{RetryAfter: retryAfter}
+	}
+
+	// Concurrent attempts cannot get more guesses than the failures left,
+	// as each one counts against them until its outcome is known.
+	var reserved bool
+
+	g.update(g.accountKey(email), now, func(a *attempts) {
+		retryAfter = g.wait(*a, now)
+		reserved = retryAfter <= 0 && a.Failures+a.Pending < g.config.MaxAccountFailures
+
+		if reserved {
+			a.Pending++
+			a.LastAttempt = now.Unix()
+		}
+	})
+
+	if !reserved {
+		if retryAfter <= 0 {
+			retryAfter = g.config.BaseDelay
+		}
+
+		return &LockedError{RetryAfter: retryAfter}
+	}
+
+	return nil
+}
+
+// Release gives back the attempt reserved by Check when it was neither
+// a failure nor a success, e.g. when the store could not be reached.
+func (g *Guard) Release(email string) {
+	g.update(g.accountKey(email), g.now(), func(a *attempts) {
+		if a.Pending > 0 {
+			a.Pending--
+		}
+	})
+}
+
+// Fail records a failed attempt, locking the account or
+// the IP address once their threshold is reached.
+func (g *Guard) Fail(email, ip string) {
+	now := g.now()
+
+	if locked := g.fail(g.accountKey(email), g.config.MaxAccountFailures, now); locked && !g.silent {
+		go g.notify(email)
+	}
+
+	g.fail(g.ipKey(ip), g.config.MaxIPFailures, now)
+}
+
+// Succeed forgets the failures and the reserved attempts of the account.
+// The failures of the IP address are kept, otherwise logging into one's
+// own account would reset them.
+func (g *Guard) Succeed(email string) {
+	g.local.Delete(g.accountKey(email))
+
+	if _, err := cache.Delete(g.cache, g.accountKey(email)); err != nil {
+		log.Printf("Error resetting the login attempts: %v", err)
+	}
+}
+
+// fail counts a failure under key, in place of the attempt it reserved.
+func (g *Guard) fail(key string, max int, now time.Time) (locked bool) {
+	g.update(key, now, func(a *attempts) {
+		if a.Pending > 0 {
+			a.Pending--
+		}
+
+		a.Failures++
+		a.LastFailure = now.Unix()
+
+		locked = a.Failures >= max && a.LockedUntil <= now.Unix()
+		if locked {
+			a.LockedUntil = now.Add(g.config.LockDuration).Unix()
+		}
+	})
+
+	return locked
+}
+
+// update changes the attempts under key atomically, in the cache when it
+// can, otherwise in the local store. change may run more than once.
+func (g *Guard) update(key string, now time.Time, change func(a *attempts)) {
+	update := func(val string, found bool) (string, error) {
+		var a attempts
+		if found {
+			json.Unmarshal([]byte(val), &a)
+		}
+
+		a = g.current(a, now)
+		change(&a)
+
+		data, err := json.Marshal(a)
+		return string(data), err
+	}
+
+	if shared, ok := g.cache.(cache.Updater); ok {
+		err := shared.Update(key, update)
+		if err == nil {
+			return
+		}
+
+		log.Printf("Error saving the login attempts, counting them locally: %v", err)
+	}
+
+	g.local.Update(key, update)
+}
+
+// load returns the attempts saved under key, in the cache or in the local
+// store, keeping the most of both, or none when they are older than the window.
+func (g *Guard) load(key string, now time.Time) attempts {
+	var a attempts
+
+	for _, c := range []cache.Cacher{g.cache, g.local} {
+		var saved attempts
+		if err := cache.Get(c, key, &saved); err != nil {
+			continue
+		}
+
+		if saved.Failures > a.Failures {
+			a.Failures = saved.Failures
+		}
+		if saved.LastFailure > a.LastFailure {
+			a.LastFailure = saved.LastFailure
+		}
+		if saved.LockedUntil > a.LockedUntil {
+			a.LockedUntil = saved.LockedUntil
+		}
+		if saved.Pending > a.Pending {
+			a.Pending = saved.Pending
+		}
+		if saved.LastAttempt > a.LastAttempt {
+			a.LastAttempt = saved.LastAttempt
+		}
+	}
+
+	return g.current(a, now)
+}
+
+// current returns the attempts without the failures older than the window,
+// and without the reserved attempts older than pendingTimeout.
+func (g *Guard) current(a attempts, now time.Time) attempts {
+	if now.Unix()-a.LastAttempt > int64(pendingTimeout.Seconds()) {
+		a.Pending = 0
+		a.LastAttempt = 0
+	}
+
+	if now.Unix()-a.LastFailure > int64(g.config.Window.Seconds()) && a.LockedUntil <= now.Unix() {
+		a.Failures = 0
+		a.LastFailure = 0
+		a.LockedUntil = 0
+	}
+
+	return a
+}
+
+// wait returns how long the next attempt must wait, using an exponential backoff.
+func (g *Guard) wait(a attempts, now time.Time) time.Duration {
+	if a.LockedUntil > now.Unix() {
+		return time.Unix(a.LockedUntil, 0).Sub(now)
+	}
+
+	if a.Failures == 0 {
+		return 0
+	}
+
+	delay := g.config.BaseDelay
+	for i := 1; i < a.Failures && delay < g.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > g.config.MaxDelay {
+		delay = g.config.MaxDelay
+	}
+
+	return time.Unix(a.LastFailure, 0).Add(delay).Sub(now)
+}
+
+// notify tells the owner of the account, if any, that it got locked.
+func (g *Guard) notify(email string) {
+	user, err := g.userService.UserByEmail(email)
+	if err != nil {
+		return
+	}
+
+	err = g.mailer.Send(mailer.Message{
+		To:      user.EmailAddress,
+		Subject: "Your account has been temporarily locked",
+		Body:    fmt.Sprintf("Hi %s,\n\nWe noticed %d failed login attempts on your account, so we locked it for %s.\n\nIf it was not you, we recommend resetting your password and enabling two-factor authentication.\n", user.Username, g.config.MaxAccountFailures, g.config.LockDuration),
+	})
+	if err != nil {
+		log.Printf("Error sending the lockout email: %v", err)
+	}
+}
+
+// The cache keys are hashed as emails can hold characters
+// that memcached does not accept in a key.
+func (g *Guard) accountKey(email string) string {
+	return g.scope + ".account." + hash(strings.ToLower(strings.TrimSpace(email)))
+}
+
+func (g *Guard) ipKey(ip string) string {
+	return g.scope + ".ip." + hash(ip)
+}
+
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,226 @@
+package lockout_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/lockout"
+	"github.com/rbo13/write-it/app/mailer"
+	"github.com/rbo13/write-it/app/persistence/cache/memory"
+)
+
+type memoryCache map[string]string
+
+func (c memoryCache) Set(key, val string) (bool, error) {
+	c[key] = val
+	return true, nil
+}
+
+func (c memoryCache) Get(key string) (string, error) {
+	val, ok := c[key]
+	if !ok {
+		return "", errors.New("cache miss")
+	}
+	return val, nil
+}
+
+func (c memoryCache) Delete(key string) (bool, error) {
+	delete(c, key)
+	return true, nil
+}
+
+// downCache fails like memcached when it cannot be reached.
+type downCache struct{}
+
+func (downCache) Set(key, val string) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func (downCache) Get(key string) (string, error) {
+	return "", errors.New("connection refused")
+}
+
+func (downCache) Delete(key string) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func (downCache) Update(key string, fn func(string, bool) (string, error)) error {
+	return errors.New("connection refused")
+}
+
+type userStore struct {
+	app.UserService
+}
+
+func (s *userStore) UserByEmail(email string) (*app.User, error) {
+	return &app.User{Username: "owner", EmailAddress: email}, nil
+}
+
+func TestGuard(t *testing.T) {
+	config := lockout.Config{
+		BaseDelay:          time.Hour,
+		MaxDelay:           2 * time.Hour,
+		MaxAccountFailures: 3,
+		LockDuration:       24 * time.Hour,
+		MaxIPFailures:      5,
+		Window:             48 * time.Hour,
+	}
+
+	t.Run("Backoff", func(t *testing.T) {
+		guard := lockout.New(config, memoryCache{}, mailer.NewLog(ioutil.Discard), &userStore{})
+
+		if err := guard.Check("owner@example.com", "10.0.0.1"); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+
+		guard.Fail("owner@example.com", "10.0.0.1")
+
+		err, ok := guard.Check("owner@example.com", "10.0.0.2").(*lockout.LockedError)
+		if !ok || err.RetryAfter <= 0 || err.RetryAfter > config.BaseDelay {
+			t.Errorf("Expecting: %v, but got: %v instead", config.BaseDelay, err)
+		}
+
+		// The IP address alone is not slowed down
+		if err := guard.Check("other@example.com", "10.0.0.1"); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+
+		guard.Succeed("Owner@Example.com")
+
+		if err := guard.Check("owner@example.com", "10.0.0.1"); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+	})
+
+	t.Run("LockAccount", func(t *testing.T) {
+		mail := mailer.NewLog(ioutil.Discard)
+		guard := lockout.New(config, memoryCache{}, mail, &userStore{})
+
+		for i := 0; i < config.MaxAccountFailures; i++ {
+			guard.Fail("owner@example.com", "10.0.0.1")
+		}
+
+		err, ok := guard.Check("owner@example.com", "10.0.0.1").(*lockout.LockedError)
+		if !ok || err.RetryAfter <= config.MaxDelay {
+			t.Errorf("Expecting: %v, but got: %v instead", config.LockDuration, err)
+		}
+
+		deadline := time.Now().Add(time.Second)
+		for len(mail.Messages()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if messages := mail.Messages(); len(messages) != 1 || messages[0].To != "owner@example.com" {
+			t.Errorf("Expecting: %v, but got: %v instead", 1, len(messages))
+		}
+	})
+
+	t.Run("LockIP", func(t *testing.T) {
+		guard := lockout.New(config, memoryCache{}, mailer.NewLog(ioutil.Discard), &userStore{})
+
+		for i := 0; i < config.MaxIPFailures; i++ {
+			guard.Fail("user"+strconv.Itoa(i)+"@example.com", "10.0.0.1")
+		}
+
+		if _, ok := guard.Check("new@example.com", "10.0.0.1").(*lockout.LockedError); !ok {
+			t.Errorf("Expecting: %v, but got: %v instead", "locked", "not locked")
+		}
+
+		if err := guard.Check("new@example.com", "10.0.0.2"); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+	})
+	t.Run("Scoped", func(t *testing.T) {
+		guard := lockout.New(config, memoryCache{}, mailer.NewLog(ioutil.Discard), &userStore{})
+		scoped := guard.Scoped("two_factor")
+
+		scoped.Fail("owner@example.com", "10.0.0.1")
+		guard.Succeed("owner@example.com")
+
+		if _, ok := scoped.Check("owner@example.com", "10.0.0.1").(*lockout.LockedError); !ok {
+			t.Errorf("Expecting: %v, but got: %v instead", "locked", "not locked")
+		}
+
+		if err := guard.Check("owner@example.com", "10.0.0.1"); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+	})
+	t.Run("ConcurrentFailures", func(t *testing.T) {
+		concurrent := config
+		concurrent.MaxAccountFailures = 50
+		concurrent.MaxIPFailures = 1000
+		guard := lockout.New(concurrent, memory.New(100), mailer.NewLog(ioutil.Discard), &userStore{})
+
+		// Every failure is counted, so the last one locks the account
+		var wg sync.WaitGroup
+		for i := 0; i < concurrent.MaxAccountFailures; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				guard.Fail("owner@example.com", "10.0.0."+strconv.Itoa(i))
+			}(i)
+		}
+		wg.Wait()
+
+		err, ok := guard.Check("owner@example.com", "10.0.1.1").(*lockout.LockedError)
+		if !ok || err.RetryAfter <= concurrent.MaxDelay {
+			t.Errorf("Expecting: %v, but got: %v instead", "locked", err)
+		}
+	})
+
+	t.Run("ConcurrentChecks", func(t *testing.T) {
+		guard := lockout.New(config, memory.New(100), mailer.NewLog(ioutil.Discard), &userStore{})
+
+		// A burst of attempts gets no more guesses than the failures allowed
+		var passed int
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if guard.Check("owner@example.com", "10.0.0."+strconv.Itoa(i)) == nil {
+					mu.Lock()
+					passed++
+					mu.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		if passed != config.MaxAccountFailures {
+			t.Errorf("Expecting: %v, but got: %v instead", config.MaxAccountFailures, passed)
+		}
+
+		// A released attempt can be made again
+		guard.Release("owner@example.com")
+
+		if err := guard.Check("owner@example.com", "10.0.0.1"); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+
+		guard.Succeed("owner@example.com")
+
+		if err := guard.Check("owner@example.com", "10.0.0.1"); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+	})
+
+	t.Run("CacheDown", func(t *testing.T) {
+		guard := lockout.New(config, downCache{}, mailer.NewLog(ioutil.Discard), &userStore{})
+
+		for i := 0; i < config.MaxAccountFailures; i++ {
+			guard.Fail("owner@example.com", "10.0.0.1")
+		}
+
+		err, ok := guard.Check("owner@example.com", "10.0.0.2").(*lockout.LockedError)
+		if !ok || err.RetryAfter <= config.MaxDelay {
+			t.Errorf("Expecting: %v, but got: %v instead", "locked", err)
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Log writes the emails to an io.Writer instead of sending them,
+// and keeps them in memory so that tests can inspect them.
+type Log struct {
+	mu       sync.Mutex
+	w        io.Writer
+	messages []Message
+}
+
+// NewLog returns a Log mailer that writes to w.
+func NewLog(w io.Writer) *Log {
+	return &Log{
+		w: w,
+	}
+}
+
+// NewFile returns a Log mailer that appends to the file at path.
+func NewFile(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLog(f), nil
+}
+
+// Send writes the message.
+func (l *Log) Send(msg Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.messages = append(l.messages, msg)
+
+	_, err := fmt.Fprintf(l.w, "To: %s\nSubject: %s\n\n%s\n\n", msg.To, msg.Subject, msg.Body)
+	return err
+}
+
+// Messages returns every message sent so far.
+func (l *Log) Messages() []Message {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]Message(nil), l.messages...)
+}
@@ -0,0 +1,14 @@
+// Package mailer sends the emails of our application.
+package mailer
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends a Message.
+type Mailer interface {
+	Send(Message) error
+}
@@ -0,0 +1,72 @@
+package app
+
+import "strings"
+
+// OAuthClient represents a third-party app registered by one of our users.
+// Only the SHA-256 hash of the client secret is stored, and public
+// clients, e.g. mobile apps, have none.
+type OAuthClient struct {
+	ID         int64  `json:"-" db:"id"`
+	ClientID   string `json:"client_id" db:"client_id"`
+	SecretHash string `json:"-" db:"secret_hash"`
+	Name       string `json:"name" db:"name"`
+	OwnerID    int64  `json:"owner_id" db:"owner_id"`
+	// RedirectURIs and Scopes are space separated lists.
+	RedirectURIs string `json:"redirect_uris" db:"redirect_uris"`
+	Scopes       string `json:"scopes" db:"scopes"`
+	CreatedAt    int64  `json:"created_at" db:"created_at"`
+}
+
+// OAuthCode represents a single-use authorization code.
+// Only the SHA-256 hash of the code is stored, and the redirect URI
+// only when it was in the authorization request.
+type OAuthCode struct {
+	ID            int64  `json:"id" db:"id"`
+	CodeHash      string `json:"-" db:"code_hash"`
+	ClientID      string `json:"client_id" db:"client_id"`
+	UserID        int64  `json:"user_id" db:"user_id"`
+	RedirectURI   string `json:"redirect_uri" db:"redirect_uri"`
+	Scopes        string `json:"scopes" db:"scopes"`
+	CodeChallenge string `json:"-" db:"code_challenge"`
+	ExpiresAt     int64  `json:"expires_at" db:"expires_at"`
+	UsedAt        int64  `json:"used_at" db:"used_at"`
+	CreatedAt     int64  `json:"created_at" db:"created_at"`
+}
+
+// OAuthService defines the basic service of the OAuth2 clients and codes
+type OAuthService interface {
+	CreateOAuthClient(*OAuthClient) error
+	OAuthClient(clientID string) (*OAuthClient, error)
+	OAuthClients(ownerID int64) ([]*OAuthClient, error)
+	DeleteOAuthClient(clientID string, ownerID int64) error
+	CreateOAuthCode(*OAuthCode) error
+	// OAuthCode returns the unexpired and unused code with the given hash.
+	OAuthCode(codeHash string) (*OAuthCode, error)
+	// UseOAuthCode consumes the unexpired code with the given hash.
+	UseOAuthCode(codeHash string) (*OAuthCode, error)
+}
+
+// Public reports whether the client cannot keep a secret.
+func (c *OAuthClient) Public() bool {
+	return c.SecretHash == ""
+}
+
+// AllowsRedirectURI reports whether the redirect URI was registered, exactly.
+func (c *OAuthClient) AllowsRedirectURI(uri string) bool {
+	for _, registered := range strings.Fields(c.RedirectURIs) {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// TableName represents the table name of OAuth client
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
+
+// TableName represents the table name of OAuth code
+func (OAuthCode) TableName() string {
+	return "oauth_codes"
+}
@@ -0,0 +1,39 @@
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/rbo13/write-it/app"
+)
+
+// Error is an OAuth2 error response as described by RFC 6749, section 5.2.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	Status      int    `json:"-"`
+}
+
+func (e *Error) Error() string {
+	return e.Description
+}
+
+// AccessDenied is reported to the app when the user refuses the consent.
+var AccessDenied = &Error{Code: "access_denied", Description: "The user denied the request", Status: http.StatusForbidden}
+
+func invalidRequest(description string) *Error {
+	return &Error{Code: "invalid_request", Description: description, Status: http.StatusBadRequest}
+}
+
+func invalidGrant(description string) *Error {
+	return &Error{Code: "invalid_grant", Description: description, Status: http.StatusBadRequest}
+}
+
+func invalidClient() *Error {
+	return &Error{Code: "invalid_client", Description: "Client authentication failed", Status: http.StatusUnauthorized}
+}
+
+// invalidClientMetadata is returned by RegisterClient. Unlike the other errors it
+// is answered by our own API rather than the OAuth endpoints, as an app.Validation error.
+func invalidClientMetadata(field, description string) error {
+	return app.NewValidationError(description, map[string]string{field: description})
+}
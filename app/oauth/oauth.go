@@ -0,0 +1,462 @@
+// Package oauth makes write-it an OAuth2 authorization server, so that
+// third-party apps can use the API on behalf of our users.
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/jwtauth"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/accesstoken"
+	"github.com/rbo13/write-it/app/jwtservice"
+	"github.com/rbo13/write-it/app/response"
+)
+
+// ClientIDClaim holds the client an access token was issued to.
+const ClientIDClaim = "client_id"
+
+const errClientRevoked = "The app this token was issued to has been removed"
+
+// ScopeDescriptions explain the scopes on the consent screen.
+var ScopeDescriptions = map[string]string{
+	accesstoken.ScopePostsRead:  "Read your posts",
+	accesstoken.ScopePostsWrite: "Create, update and delete posts on your behalf",
+	accesstoken.ScopeUsersRead:  "Read your profile",
+	accesstoken.ScopeUsersWrite: "Update your profile",
+}
+
+// Token is the successful response of the token endpoint.
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// Introspection is the response of the introspection endpoint, RFC 7662.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// AuthorizationRequest is a valid request of an app for an authorization
+// code, shown to the user on the consent screen.
+type AuthorizationRequest struct {
+	Client        *app.OAuthClient `json:"client"`
+	RedirectURI   string           `json:"redirect_uri"`
+	Scopes        []string         `json:"scopes"`
+	State         string           `json:"state"`
+	CodeChallenge string           `json:"-"`
+
+	// redirectURIGiven is false when the registered URI was used by default
+	redirectURIGiven bool
+}
+
+// Service registers the clients and issues their codes and tokens.
+type Service struct {
+	oauthService app.OAuthService
+	jwtService   *jwtservice.JWT
+	userService  app.UserService
+	codeTTL      time.Duration
+	tokenTTL     time.Duration
+}
+
+// New returns an OAuth2 Service. Authorization codes expire
+// after codeTTL and access tokens after tokenTTL.
+func New(oauthService app.OAuthService, jwtService *jwtservice.JWT, userService app.UserService, codeTTL, tokenTTL time.Duration) *Service {
+	return &Service{
+		oauthService: oauthService,
+		jwtService:   jwtService,
+		userService:  userService,
+		codeTTL:      codeTTL,
+		tokenTTL:     tokenTTL,
+	}
+}
+
+// RegisterClient registers an app of the user and returns its secret,
+// which cannot be retrieved afterwards. Public clients get no secret
+// and must use PKCE.
+func (s *Service) RegisterClient(ownerID int64, name string, redirectURIs, scopes []string, public bool) (*app.OAuthClient, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, "", invalidClientMetadata("name", "Client name is required")
+	}
+
+	if len(redirectURIs) == 0 {
+		return nil, "", invalidClientMetadata("redirect_uris", "At least one redirect URI is required")
+	}
+
+	for _, uri := range redirectURIs {
+		if !validRedirectURI(uri) {
+			return nil, "", invalidClientMetadata("redirect_uris", "Redirect URI must be absolute, without fragment, and use HTTPS unless on the loopback: "+uri)
+		}
+	}
+
+	if len(scopes) == 0 {
+		return nil, "", invalidClientMetadata("scopes", "At least one scope is required")
+	}
+
+	for _, scope := range scopes {
+		if !contains(accesstoken.Scopes, scope) {
+			return nil, "", invalidClientMetadata("scopes", "Unknown scope: "+scope)
+		}
+	}
+
+	clientID, err := randomString(16)
+	if err != nil {
+		return nil, "", err
+	}
+
+	client := &app.OAuthClient{
+		ClientID:     clientID,
+		Name:         name,
+		OwnerID:      ownerID,
+		RedirectURIs: strings.Join(redirectURIs, " "),
+		Scopes:       strings.Join(scopes, " "),
+	}
+
+	secret := ""
+	if !public {
+		if secret, err = randomString(32); err != nil {
+			return nil, "", err
+		}
+		client.SecretHash = hash(secret)
+	}
+
+	if err = s.oauthService.CreateOAuthClient(client); err != nil {
+		return nil, "", err
+	}
+
+	return client, secret, nil
+}
+
+// Clients returns the apps registered by the user.
+func (s *Service) Clients(ownerID int64) ([]*app.OAuthClient, error) {
+	return s.oauthService.OAuthClients(ownerID)
+}
+
+// DeleteClient removes an app of the user, which invalidates its tokens.
+func (s *Service) DeleteClient(clientID string, ownerID int64) error {
+	return s.oauthService.DeleteOAuthClient(clientID, ownerID)
+}
+
+// ParseAuthorization validates the query of the authorization endpoint.
+// When the client or its redirect URI are invalid, the request is nil and the
+// user must not be redirected. Otherwise the error can be sent back to the app
+// through ErrorRedirect.
+func (s *Service) ParseAuthorization(query url.Values) (*AuthorizationRequest, error) {
+	client, err := s.oauthService.OAuthClient(query.Get("client_id"))
+	if err != nil {
+		return nil, &Error{Code: "invalid_client", Description: "Unknown client", Status: http.StatusBadRequest}
+	}
+
+	redirectURI := query.Get("redirect_uri")
+	given := redirectURI != ""
+	if !given {
+		// Only unambiguous when the client registered a single one
+		if uris := strings.Fields(client.RedirectURIs); len(uris) == 1 {
+			redirectURI = uris[0]
+		}
+	}
+
+	if !client.AllowsRedirectURI(redirectURI) {
+		return nil, invalidRequest("Redirect URI is not registered for this client")
+	}
+
+	req := &AuthorizationRequest{
+		Client:           client,
+		RedirectURI:      redirectURI,
+		State:            query.Get("state"),
+		redirectURIGiven: given,
+	}
+
+	if query.Get("response_type") != "code" {
+		return req, &Error{Code: "unsupported_response_type", Description: "Only the authorization code flow is supported", Status: http.StatusBadRequest}
+	}
+
+	req.Scopes = strings.Fields(query.Get("scope"))
+	if len(req.Scopes) == 0 {
+		req.Scopes = strings.Fields(client.Scopes)
+	}
+
+	for _, scope := range req.Scopes {
+		if !contains(strings.Fields(client.Scopes), scope) {
+			return req, &Error{Code: "invalid_scope", Description: "Scope is not allowed for this client: " + scope, Status: http.StatusBadRequest}
+		}
+	}
+
+	req.CodeChallenge = query.Get("code_challenge")
+	method := query.Get("code_challenge_method")
+
+	if req.CodeChallenge == "" && client.Public() {
+		return req, invalidRequest("PKCE is required for public clients")
+	}
+
+	if req.CodeChallenge != "" && method != "S256" {
+		return req, invalidRequest("Only the S256 code challenge method is supported")
+	}
+
+	return req, nil
+}
+
+// Approve issues an authorization code for the user and returns
+// where to redirect the user agent.
+func (s *Service) Approve(req *AuthorizationRequest, userID int64) (string, error) {
+	code, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	// Only a redirect URI sent by the app must be repeated when exchanging the code
+	redirectURI := ""
+	if req.redirectURIGiven {
+		redirectURI = req.RedirectURI
+	}
+
+	err = s.oauthService.CreateOAuthCode(&app.OAuthCode{
+		CodeHash:      hash(code),
+		ClientID:      req.Client.ClientID,
+		UserID:        userID,
+		RedirectURI:   redirectURI,
+		Scopes:        strings.Join(req.Scopes, " "),
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().Add(s.codeTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return req.redirect(url.Values{"code": {code}}), nil
+}
+
+// ErrorRedirect returns where to redirect the user agent to report the error to the app,
+// e.g. an *Error with the access_denied code when the user refuses.
+func (req *AuthorizationRequest) ErrorRedirect(err error) string {
+	query := url.Values{"error": {"server_error"}}
+
+	if e, ok := err.(*Error); ok {
+		query.Set("error", e.Code)
+		query.Set("error_description", e.Description)
+	}
+
+	return req.redirect(query)
+}
+
+func (req *AuthorizationRequest) redirect(query url.Values) string {
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+
+	separator := "?"
+	if strings.Contains(req.RedirectURI, "?") {
+		separator = "&"
+	}
+
+	return req.RedirectURI + separator + query.Encode()
+}
+
+// Exchange trades an authorization code for an access token.
+func (s *Service) Exchange(clientID, clientSecret, code, redirectURI, codeVerifier string) (*Token, error) {
+	client, err := s.authenticateClient(clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	// The code is only consumed once the request is checked, so that a
+	// wrong client, redirect URI or verifier cannot use up the code
+	grant, err := s.oauthService.OAuthCode(hash(code))
+	if err != nil {
+		return nil, invalidGrant("Authorization code is invalid, expired or already used")
+	}
+
+	// The redirect URI of the authorization request must be sent again, identical (RFC 6749 4.1.3)
+	if grant.ClientID != client.ClientID || (grant.RedirectURI != "" && grant.RedirectURI != redirectURI) {
+		return nil, invalidGrant("Authorization code was issued to another client or redirect URI")
+	}
+
+	if grant.CodeChallenge != "" && !equal(codeChallenge(codeVerifier), grant.CodeChallenge) {
+		return nil, invalidGrant("Code verifier does not match the code challenge")
+	}
+
+	// Using the code fails when a concurrent exchange used it first
+	grant, err = s.oauthService.UseOAuthCode(grant.CodeHash)
+	if err != nil {
+		return nil, invalidGrant("Authorization code is invalid, expired or already used")
+	}
+
+	claims := jwt.MapClaims{
+		"user_id":              grant.UserID,
+		ClientIDClaim:          client.ClientID,
+		accesstoken.ScopeClaim: grant.Scopes,
+	}
+	jwtauth.SetExpiryIn(claims, s.tokenTTL)
+	jwtauth.SetIssuedNow(claims)
+
+	accessToken, err := s.jwtService.Encode(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Token{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.tokenTTL.Seconds()),
+		Scope:       grant.Scopes,
+	}, nil
+}
+
+// Introspect describes an access token to the client it was issued to.
+// Every other token, or one that is no longer valid, is inactive.
+func (s *Service) Introspect(ctx context.Context, clientID, clientSecret, token string) (*Introspection, error) {
+	client, err := s.authenticateClient(clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Public() {
+		return nil, &Error{Code: "invalid_client", Description: "Public clients cannot introspect tokens", Status: http.StatusUnauthorized}
+	}
+
+	inactive := &Introspection{Active: false}
+
+	parsed, err := s.jwtService.Decode(token)
+	if err != nil || !parsed.Valid {
+		return inactive, nil
+	}
+
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || claims[ClientIDClaim] != client.ClientID {
+		return inactive, nil
+	}
+
+	userID, _ := claims["user_id"].(float64)
+	issuedAt, _ := claims["iat"].(float64)
+	expiresAt, _ := claims["exp"].(float64)
+	scopes, _ := claims[accesstoken.ScopeClaim].(string)
+
+	user, err := s.userService.UserContext(ctx, int64(userID))
+	if err != nil || int64(issuedAt) <= user.SessionsRevokedAt {
+		return inactive, nil
+	}
+
+	return &Introspection{
+		Active:    true,
+		Scope:     scopes,
+		ClientID:  client.ClientID,
+		Subject:   strconv.FormatInt(user.ID, 10),
+		TokenType: "Bearer",
+		ExpiresAt: int64(expiresAt),
+		IssuedAt:  int64(issuedAt),
+	}, nil
+}
+
+// ActiveClient is a middleware that refuses the tokens of deleted clients.
+// It runs after jwtauth.Authenticator.
+func (s *Service) ActiveClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, claims, _ := jwtauth.FromContext(r.Context())
+
+		if clientID, ok := claims[ClientIDClaim].(string); ok {
+			if _, err := s.oauthService.OAuthClient(clientID); err != nil {
+				config := response.Configure(errClientRevoked, http.StatusUnauthorized, nil)
+				response.JSONError(w, r, config)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateClient checks the secret of confidential clients.
+func (s *Service) authenticateClient(clientID, clientSecret string) (*app.OAuthClient, error) {
+	client, err := s.oauthService.OAuthClient(clientID)
+	if err != nil {
+		return nil, invalidClient()
+	}
+
+	if client.Public() {
+		if clientSecret != "" {
+			return nil, invalidClient()
+		}
+		return client, nil
+	}
+
+	if !equal(hash(clientSecret), client.SecretHash) {
+		return nil, invalidClient()
+	}
+
+	return client, nil
+}
+
+// validRedirectURI accepts HTTPS, HTTP on the loopback for development,
+// and the private schemes of native apps, e.g. com.example.app:/callback.
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		ip := net.ParseIP(host)
+		return host == "localhost" || (ip != nil && ip.IsLoopback())
+	case "javascript", "data", "file":
+		return false
+	}
+
+	return true
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hash returns the hex encoded SHA-256 hash of the secret or code, which is what we store.
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func equal(a, b string) bool {
+	return a != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,303 @@
+package oauth_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/jwtauth"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/accesstoken"
+	"github.com/rbo13/write-it/app/jwtservice"
+	"github.com/rbo13/write-it/app/oauth"
+)
+
+// errNotFound is what the stores return when nothing matches.
+var errNotFound = app.NewError(app.NotFound, "Not found")
+
+type oauthStore struct {
+	clients map[string]*app.OAuthClient
+	codes   map[string]*app.OAuthCode
+}
+
+func (s *oauthStore) CreateOAuthClient(client *app.OAuthClient) error {
+	s.clients[client.ClientID] = client
+	return nil
+}
+
+func (s *oauthStore) OAuthClient(clientID string) (*app.OAuthClient, error) {
+	client, ok := s.clients[clientID]
+	if !ok {
+		return nil, errNotFound
+	}
+	return client, nil
+}
+
+func (s *oauthStore) OAuthClients(ownerID int64) ([]*app.OAuthClient, error) {
+	return nil, nil
+}
+
+func (s *oauthStore) DeleteOAuthClient(clientID string, ownerID int64) error {
+	delete(s.clients, clientID)
+	return nil
+}
+
+func (s *oauthStore) CreateOAuthCode(code *app.OAuthCode) error {
+	s.codes[code.CodeHash] = code
+	return nil
+}
+
+func (s *oauthStore) OAuthCode(codeHash string) (*app.OAuthCode, error) {
+	code, ok := s.codes[codeHash]
+	if !ok || code.UsedAt != 0 || code.ExpiresAt <= time.Now().Unix() {
+		return nil, errNotFound
+	}
+	found := *code
+	return &found, nil
+}
+
+func (s *oauthStore) UseOAuthCode(codeHash string) (*app.OAuthCode, error) {
+	code, err := s.OAuthCode(codeHash)
+	if err != nil {
+		return nil, err
+	}
+	s.codes[codeHash].UsedAt = time.Now().Unix()
+	return code, nil
+}
+
+type userStore struct {
+	app.UserService
+}
+
+func (s *userStore) UserContext(ctx context.Context, id int64) (*app.User, error) {
+	return &app.User{ID: id}, nil
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	jwtService, err := jwtservice.New(jwtservice.Config{Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	store := &oauthStore{clients: map[string]*app.OAuthClient{}, codes: map[string]*app.OAuthCode{}}
+	service := oauth.New(store, jwtService, &userStore{}, time.Minute, time.Hour)
+
+	client, secret, err := service.RegisterClient(1, "Partner", []string{"https://partner.example/callback"}, []string{accesstoken.ScopePostsRead, accesstoken.ScopePostsWrite}, false)
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	verifier := "a-very-long-and-random-code-verifier-for-this-test"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	authorize := func(scope string) string {
+		req, err := service.ParseAuthorization(url.Values{
+			"response_type":         {"code"},
+			"client_id":             {client.ClientID},
+			"redirect_uri":          {"https://partner.example/callback"},
+			"scope":                 {scope},
+			"state":                 {"xyz"},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		})
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		redirectTo, err := service.Approve(req, 42)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		u, _ := url.Parse(redirectTo)
+		if u.Host != "partner.example" || u.Query().Get("state") != "xyz" {
+			t.Errorf("Expecting: %v, but got: %v instead", "https://partner.example/callback?code=...&state=xyz", redirectTo)
+		}
+
+		return u.Query().Get("code")
+	}
+
+	t.Run("Exchange", func(t *testing.T) {
+		code := authorize(accesstoken.ScopePostsRead)
+
+		token, err := service.Exchange(client.ClientID, secret, code, "https://partner.example/callback", verifier)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		parsed, err := jwtService.Decode(token.AccessToken)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		claims := parsed.Claims.(jwt.MapClaims)
+		if claims["client_id"] != client.ClientID || claims["scope"] != accesstoken.ScopePostsRead || claims["user_id"] != float64(42) {
+			t.Errorf("Expecting: %v, but got: %v instead", "client_id, scope and user_id claims", claims)
+		}
+
+		// The token only reaches the routes of its scopes
+		reached := func(scope string) bool {
+			reached := false
+			ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true })
+
+			handler := jwtService.Verifier(jwtauth.Authenticator(service.ActiveClient(accesstoken.RequireScope(scope)(ok))))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			return reached
+		}
+
+		if !reached(accesstoken.ScopePostsRead) || reached(accesstoken.ScopePostsWrite) {
+			t.Errorf("Expecting: %v, but got: %v instead", "only posts:read", token.Scope)
+		}
+
+		introspection, err := service.Introspect(context.Background(), client.ClientID, secret, token.AccessToken)
+		if err != nil || !introspection.Active || introspection.Subject != "42" {
+			t.Errorf("Expecting: %v, but got: %v instead", "an active token", introspection)
+		}
+
+		// The code is single use
+		if _, err := service.Exchange(client.ClientID, secret, code, "https://partner.example/callback", verifier); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "invalid_grant", err)
+		}
+	})
+
+	t.Run("WrongVerifier", func(t *testing.T) {
+		code := authorize(accesstoken.ScopePostsRead)
+
+		if _, err := service.Exchange(client.ClientID, secret, code, "https://partner.example/callback", "another-verifier"); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "invalid_grant", err)
+		}
+	})
+
+	t.Run("RejectedExchangeKeepsCode", func(t *testing.T) {
+		code := authorize(accesstoken.ScopePostsRead)
+
+		if _, err := service.Exchange(client.ClientID, secret, code, "https://attacker.example/callback", "another-verifier"); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "invalid_grant", err)
+		}
+
+		if _, err := service.Exchange(client.ClientID, secret, code, "https://partner.example/callback", verifier); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+	})
+
+	t.Run("WrongSecret", func(t *testing.T) {
+		code := authorize(accesstoken.ScopePostsRead)
+
+		_, err := service.Exchange(client.ClientID, "wrong", code, "https://partner.example/callback", verifier)
+		if e, ok := err.(*oauth.Error); !ok || e.Code != "invalid_client" {
+			t.Errorf("Expecting: %v, but got: %v instead", "invalid_client", err)
+		}
+	})
+
+	t.Run("RedirectURIMustMatch", func(t *testing.T) {
+		for _, redirectURI := range []string{"", "https://partner.example/callback/"} {
+			code := authorize(accesstoken.ScopePostsRead)
+
+			_, err := service.Exchange(client.ClientID, secret, code, redirectURI, verifier)
+			if e, ok := err.(*oauth.Error); !ok || e.Code != "invalid_grant" {
+				t.Errorf("Expecting: %v, but got: %v instead", "invalid_grant", err)
+			}
+		}
+	})
+
+	t.Run("DefaultRedirectURI", func(t *testing.T) {
+		req, err := service.ParseAuthorization(url.Values{
+			"response_type":         {"code"},
+			"client_id":             {client.ClientID},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		})
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		redirectTo, err := service.Approve(req, 42)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		u, _ := url.Parse(redirectTo)
+		if _, err := service.Exchange(client.ClientID, secret, u.Query().Get("code"), "", verifier); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+	})
+
+	t.Run("UnregisteredRedirectURI", func(t *testing.T) {
+		req, err := service.ParseAuthorization(url.Values{
+			"response_type": {"code"},
+			"client_id":     {client.ClientID},
+			"redirect_uri":  {"https://attacker.example/callback"},
+		})
+
+		// The user must not be redirected to an unregistered URI
+		if req != nil || err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "no redirect", req)
+		}
+	})
+
+	t.Run("ScopeNotAllowed", func(t *testing.T) {
+		req, err := service.ParseAuthorization(url.Values{
+			"response_type": {"code"},
+			"client_id":     {client.ClientID},
+			"scope":         {accesstoken.ScopeUsersWrite},
+			"state":         {"xyz"},
+		})
+
+		if req == nil || err == nil {
+			t.Fatalf("Expecting: %v, but got: %v instead", "invalid_scope", err)
+		}
+
+		u, _ := url.Parse(req.ErrorRedirect(err))
+		if u.Query().Get("error") != "invalid_scope" || u.Query().Get("state") != "xyz" {
+			t.Errorf("Expecting: %v, but got: %v instead", "invalid_scope", u)
+		}
+	})
+
+	t.Run("PublicClientRequiresPKCE", func(t *testing.T) {
+		public, _, err := service.RegisterClient(1, "Mobile", []string{"com.partner.app:/callback"}, []string{accesstoken.ScopePostsRead}, true)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		_, err = service.ParseAuthorization(url.Values{
+			"response_type": {"code"},
+			"client_id":     {public.ClientID},
+		})
+		if err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "invalid_request", err)
+		}
+	})
+
+	t.Run("DeletedClient", func(t *testing.T) {
+		code := authorize(accesstoken.ScopePostsRead)
+
+		token, err := service.Exchange(client.ClientID, secret, code, "https://partner.example/callback", verifier)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		service.DeleteClient(client.ClientID, 1)
+
+		reached := false
+		ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true })
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		jwtService.Verifier(service.ActiveClient(ok)).ServeHTTP(httptest.NewRecorder(), req)
+
+		if reached {
+			t.Errorf("Expecting: %v, but got: %v instead", false, reached)
+		}
+	})
+}
@@ -0,0 +1,297 @@
+// Package oidc lets users sign in with an external OpenID Connect provider,
+// through the authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/jwtservice"
+)
+
+const (
+	loginPurpose = "oidc_login"
+
+	// maxUsername is the length of the username column, see validation.User
+	maxUsername = 16
+	// usernameTries is how many names are tried before giving up on a taken one
+	usernameTries = 10
+)
+
+var (
+	errUnknownProvider  = app.NewError(app.NotFound, "Unknown identity provider")
+	errInvalidState     = app.NewError(app.Unauthorized, "Sign in request is invalid or expired, please try again")
+	errSignInFailed     = app.NewError(app.Unauthorized, "The identity provider could not sign you in")
+	errEmailNotVerified = app.NewError(app.Forbidden, "The identity provider did not verify your email address")
+	errAccountNotLinked = app.NewError(app.Conflict, "An account with this email address exists but is not verified, login with your password and verify it first")
+	errUsernameTaken    = app.NewError(app.Conflict, "No username is available for your account, please register with your email address instead")
+)
+
+// Service runs the sign in with the providers and links
+// their accounts to our users.
+type Service struct {
+	providers       map[string]*Provider
+	jwtService      *jwtservice.JWT
+	identityService app.IdentityService
+	userService     app.UserService
+	ttl             time.Duration
+}
+
+// New returns an OIDC Service. A sign in must complete within ttl.
+func New(jwtService *jwtservice.JWT, identityService app.IdentityService, userService app.UserService, ttl time.Duration, providers ...*Provider) *Service {
+	s := &Service{
+		providers:       map[string]*Provider{},
+		jwtService:      jwtService,
+		identityService: identityService,
+		userService:     userService,
+		ttl:             ttl,
+	}
+
+	for _, p := range providers {
+		s.providers[p.Name] = p
+	}
+
+	return s
+}
+
+// Providers returns the names of the configured providers.
+func (s *Service) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Begin returns the authorization URL of the provider, and the signed
+// session to keep in the user agent until the callback. The session holds
+// the state, the nonce and the PKCE verifier of this sign in.
+func (s *Service) Begin(providerName string) (authURL, session string, err error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", "", errUnknownProvider
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	verifier, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	session, err = s.jwtService.EncodePurpose(loginPurpose, jwt.MapClaims{
+		"provider": providerName,
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+	}, s.ttl)
+	if err != nil {
+		return "", "", err
+	}
+
+	return provider.AuthCodeURL(state, nonce, codeChallenge(verifier)), session, nil
+}
+
+// Finish checks the callback against the session created by Begin,
+// exchanges the code and returns the user of the verified ID token.
+func (s *Service) Finish(ctx context.Context, providerName, session, state, code string) (*app.User, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, errUnknownProvider
+	}
+
+	claims, err := s.jwtService.DecodePurpose(loginPurpose, session)
+	if err != nil || claims["provider"] != providerName {
+		return nil, errInvalidState
+	}
+
+	expected, _ := claims["state"].(string)
+	nonce, _ := claims["nonce"].(string)
+	verifier, _ := claims["verifier"].(string)
+
+	if !equal(state, expected) || code == "" {
+		return nil, errInvalidState
+	}
+
+	rawIDToken, err := provider.Exchange(code, verifier)
+	if err != nil {
+		return nil, errSignInFailed.Wrap(err)
+	}
+
+	idToken, err := provider.Verify(rawIDToken, nonce)
+	if err != nil {
+		return nil, errSignInFailed.Wrap(err)
+	}
+
+	return s.link(ctx, provider, idToken)
+}
+
+// link returns the user already linked to the account of the provider.
+// Otherwise the account is linked by its verified email address to an
+// existing user with the same verified address, or to a new user.
+func (s *Service) link(ctx context.Context, provider *Provider, idToken jwt.MapClaims) (*app.User, error) {
+	subject, _ := idToken["sub"].(string)
+
+	identity, err := s.identityService.Identity(provider.Name, subject)
+	if err == nil {
+		return s.userService.UserContext(ctx, identity.UserID)
+	}
+
+	if app.KindOf(err) != app.NotFound {
+		return nil, err
+	}
+
+	email, _ := idToken["email"].(string)
+	if email == "" || !emailVerified(idToken) {
+		return nil, errEmailNotVerified
+	}
+
+	user, err := s.userService.UserByEmailContext(ctx, email)
+
+	switch {
+	case app.KindOf(err) == app.NotFound:
+		if user, err = s.createUser(ctx, email, idToken); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case !user.EmailVerified():
+		// Whoever registered the address without verifying
+		// it must not gain access to the provider's account.
+		return nil, errAccountNotLinked
+	}
+
+	err = s.identityService.CreateIdentity(&app.Identity{
+		UserID:   user.ID,
+		Provider: provider.Name,
+		Subject:  subject,
+		Email:    email,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// createUser registers a user for the email address verified by the
+// provider. Its random password is never shown, the user can choose one
+// through the password reset.
+func (s *Service) createUser(ctx context.Context, email string, idToken jwt.MapClaims) (*app.User, error) {
+	password, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	wanted, _ := idToken["preferred_username"].(string)
+	if wanted == "" {
+		wanted = email
+		if at := strings.LastIndex(email, "@"); at > 0 {
+			wanted = email[:at]
+		}
+	}
+
+	username, err := s.freeUsername(ctx, wanted)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &app.User{
+		Username:     username,
+		EmailAddress: email,
+		Password:     password,
+	}
+
+	if err = s.userService.CreateUserContext(ctx, user); err != nil {
+		return nil, err
+	}
+
+	if err = s.userService.VerifyEmailContext(ctx, user.ID, email); err != nil {
+		return nil, err
+	}
+
+	return s.userService.UserContext(ctx, user.ID)
+}
+
+// freeUsername returns the wanted username, cut to fit the column, or when
+// another user has it, the same followed by random digits.
+func (s *Service) freeUsername(ctx context.Context, wanted string) (string, error) {
+	suffix := ""
+
+	for try := 0; try < usernameTries; try++ {
+		username := []rune(wanted)
+		if max := maxUsername - len(suffix); len(username) > max {
+			username = username[:max]
+		}
+
+		_, err := s.userService.UserByUsernameContext(ctx, string(username)+suffix)
+		if app.KindOf(err) == app.NotFound {
+			return string(username) + suffix, nil
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		n, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return "", err
+		}
+
+		suffix = fmt.Sprintf("%04d", n)
+	}
+
+	return "", errUsernameTaken
+}
+
+// emailVerified reads the `email_verified` claim, which some
+// providers send as a string.
+func emailVerified(idToken jwt.MapClaims) bool {
+	switch verified := idToken["email_verified"].(type) {
+	case bool:
+		return verified
+	case string:
+		return verified == "true"
+	}
+
+	return false
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func equal(a, b string) bool {
+	return a != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
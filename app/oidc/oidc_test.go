@@ -0,0 +1,440 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/jwtservice"
+	"github.com/rbo13/write-it/app/oidc"
+	"github.com/rbo13/write-it/app/twofactor"
+	"github.com/rbo13/write-it/app/usecase"
+)
+
+// errNotFound is what the stores return when nothing matches.
+var errNotFound = app.NewError(app.NotFound, "Not found")
+
+// fakeProvider is an in-process OpenID Connect provider that
+// authenticates everyone as its current account.
+type fakeProvider struct {
+	server   *httptest.Server
+	keys     *jwtservice.JWT
+	account  jwt.MapClaims
+	codes    map[string]url.Values
+	badNonce bool
+	dir      string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	dir, err := ioutil.TempDir("", "oidc")
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	if err = ioutil.WriteFile(filepath.Join(dir, "provider.pem"), data, 0600); err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	keys, err := jwtservice.New(jwtservice.Config{KeyDir: dir})
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	p := &fakeProvider{keys: keys, codes: map[string]url.Values{}, dir: dir}
+
+	r := chi.NewRouter()
+	r.Get("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, r, map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	r.Get("/authorize", p.authorize)
+	r.Post("/token", p.token)
+	r.Get("/jwks", keys.JWKS)
+
+	p.server = httptest.NewServer(r)
+
+	return p
+}
+
+func (p *fakeProvider) Close() {
+	p.server.Close()
+	os.RemoveAll(p.dir)
+}
+
+func (p *fakeProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	code := base64.RawURLEncoding.EncodeToString([]byte(query.Get("state")))
+	p.codes[code] = query
+
+	http.Redirect(w, r, query.Get("redirect_uri")+"?"+url.Values{
+		"code":  {code},
+		"state": {query.Get("state")},
+	}.Encode(), http.StatusFound)
+}
+
+func (p *fakeProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	authorize, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	if !ok || clientID != "write-it" || clientSecret != "secret" || challenge != authorize.Get("code_challenge") {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   p.server.URL,
+		"aud":   clientID,
+		"nonce": authorize.Get("nonce"),
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+	if p.badNonce {
+		claims["nonce"] = "replayed"
+	}
+	for k, v := range p.account {
+		claims[k] = v
+	}
+
+	idToken, err := p.keys.Encode(claims)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	render.JSON(w, r, map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+type userStore struct {
+	app.UserService
+	users []*app.User
+}
+
+func (s *userStore) CreateUserContext(ctx context.Context, user *app.User) error {
+	user.ID = int64(len(s.users) + 1)
+	s.users = append(s.users, user)
+	return nil
+}
+
+func (s *userStore) UserContext(ctx context.Context, id int64) (*app.User, error) {
+	for _, user := range s.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (s *userStore) UserByEmailContext(ctx context.Context, email string) (*app.User, error) {
+	for _, user := range s.users {
+		if user.EmailAddress == email {
+			return user, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (s *userStore) UserByUsernameContext(ctx context.Context, username string) (*app.User, error) {
+	for _, user := range s.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (s *userStore) VerifyEmailContext(ctx context.Context, id int64, email string) error {
+	user, err := s.UserContext(ctx, id)
+	if err != nil {
+		return err
+	}
+	user.EmailVerifiedAt = time.Now().Unix()
+	return nil
+}
+
+func (s *userStore) GenerateAuthToken(user *app.User) (string, error) {
+	return "auth-token", nil
+}
+
+type twoFactorStore struct {
+	app.TwoFactorService
+}
+
+func (s *twoFactorStore) TwoFactor(userID int64) (*app.TwoFactor, error) {
+	return nil, errNotFound
+}
+
+type identityStore struct {
+	identities []*app.Identity
+}
+
+func (s *identityStore) CreateIdentity(identity *app.Identity) error {
+	s.identities = append(s.identities, identity)
+	return nil
+}
+
+func (s *identityStore) Identity(provider, subject string) (*app.Identity, error) {
+	for _, identity := range s.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (s *identityStore) Identities(userID int64) ([]*app.Identity, error) {
+	return s.identities, nil
+}
+
+func TestSignIn(t *testing.T) {
+	fake := newFakeProvider(t)
+	defer fake.Close()
+
+	client := fake.server.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	provider, err := oidc.NewProvider(oidc.Config{
+		Name:         "fake",
+		Issuer:       fake.server.URL,
+		ClientID:     "write-it",
+		ClientSecret: "secret",
+		RedirectURL:  "https://write-it.test/login/oidc/fake/callback",
+	}, client)
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	sessions, err := jwtservice.New(jwtservice.Config{Secret: "sessions"})
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	users := &userStore{}
+	service := oidc.New(sessions, &identityStore{}, users, time.Minute, provider)
+
+	// signIn follows the flow until the callback and returns its parameters,
+	// with the session that would be in the cookie.
+	signIn := func() (session, state, code string) {
+		authURL, session, err := service.Begin("fake")
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		res, err := client.Get(authURL)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+		res.Body.Close()
+
+		callback, err := url.Parse(res.Header.Get("Location"))
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		return session, callback.Query().Get("state"), callback.Query().Get("code")
+	}
+
+	finish := func() (*app.User, error) {
+		session, state, code := signIn()
+		return service.Finish(context.Background(), "fake", session, state, code)
+	}
+
+	t.Run("NewUser", func(t *testing.T) {
+		fake.account = jwt.MapClaims{"sub": "1", "email": "writer@example.com", "email_verified": true, "preferred_username": "writer"}
+
+		user, err := finish()
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if user.EmailAddress != "writer@example.com" || user.Username != "writer" || !user.EmailVerified() {
+			t.Errorf("Expecting: %v, but got: %v instead", "a verified writer", user)
+		}
+	})
+
+	t.Run("LinkedIdentity", func(t *testing.T) {
+		// The email changed at the provider, the subject did not
+		fake.account = jwt.MapClaims{"sub": "1", "email": "new@example.com", "email_verified": true}
+
+		user, err := finish()
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if user.ID != 1 || len(users.users) != 1 {
+			t.Errorf("Expecting: %v, but got: %v instead", 1, user.ID)
+		}
+	})
+
+	t.Run("ExistingVerifiedUser", func(t *testing.T) {
+		users.CreateUserContext(context.Background(), &app.User{Username: "reader", EmailAddress: "reader@example.com", EmailVerifiedAt: 1})
+		fake.account = jwt.MapClaims{"sub": "2", "email": "reader@example.com", "email_verified": "true"}
+
+		user, err := finish()
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if user.Username != "reader" {
+			t.Errorf("Expecting: %v, but got: %v instead", "reader", user.Username)
+		}
+	})
+
+	t.Run("ExistingUnverifiedUser", func(t *testing.T) {
+		users.CreateUserContext(context.Background(), &app.User{Username: "squatter", EmailAddress: "victim@example.com"})
+		fake.account = jwt.MapClaims{"sub": "3", "email": "victim@example.com", "email_verified": true}
+
+		if _, err := finish(); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+	})
+
+	t.Run("TakenUsername", func(t *testing.T) {
+		fake.account = jwt.MapClaims{"sub": "5", "email": "other.writer@example.com", "email_verified": true, "preferred_username": "writer"}
+
+		user, err := finish()
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if user.Username == "writer" || !strings.HasPrefix(user.Username, "writer") {
+			t.Errorf("Expecting: %v, but got: %v instead", "writer followed by digits", user.Username)
+		}
+	})
+
+	t.Run("LongUsername", func(t *testing.T) {
+		fake.account = jwt.MapClaims{"sub": "6", "email": "a.very.long.local.part@example.com", "email_verified": true}
+
+		user, err := finish()
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if user.Username != "a.very.long.loca" {
+			t.Errorf("Expecting: %v, but got: %v instead", "a.very.long.loca", user.Username)
+		}
+	})
+
+	t.Run("Callback", func(t *testing.T) {
+		fake.account = jwt.MapClaims{"sub": "1", "email": "writer@example.com", "email_verified": true}
+
+		handler := usecase.NewOIDC(service, users, twofactor.New(&twoFactorStore{}, sessions, "write-it", time.Minute), false)
+		router := chi.NewRouter()
+		router.Get("/login/oidc/{provider}", handler.Begin)
+		router.Get("/login/oidc/{provider}/callback", handler.Callback)
+
+		begin := httptest.NewRecorder()
+		router.ServeHTTP(begin, httptest.NewRequest(http.MethodGet, "/login/oidc/fake", nil))
+
+		res, err := client.Get(begin.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+		res.Body.Close()
+
+		callback, err := url.Parse(res.Header.Get("Location"))
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+		for _, cookie := range begin.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		// The signed in user is sent without their password
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK || !strings.Contains(body, "auth-token") {
+			t.Fatalf("Expecting: %v, but got: %v instead", "an auth token", body)
+		}
+
+		if strings.Contains(body, `"password":`) || strings.Contains(body, users.users[0].Password) {
+			t.Errorf("Expecting: %v, but got: %v instead", "no password", body)
+		}
+	})
+
+	t.Run("UnverifiedEmail", func(t *testing.T) {
+		fake.account = jwt.MapClaims{"sub": "4", "email": "someone@example.com", "email_verified": false}
+
+		if _, err := finish(); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+	})
+
+	t.Run("WrongState", func(t *testing.T) {
+		fake.account = jwt.MapClaims{"sub": "1", "email": "writer@example.com", "email_verified": true}
+
+		session, _, code := signIn()
+		otherSession, state, _ := signIn()
+
+		if _, err := service.Finish(context.Background(), "fake", session, state, code); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+
+		if _, err := service.Finish(context.Background(), "fake", otherSession, "", code); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+	})
+
+	t.Run("WrongNonce", func(t *testing.T) {
+		fake.account = jwt.MapClaims{"sub": "1", "email": "writer@example.com", "email_verified": true}
+		fake.badNonce = true
+		defer func() { fake.badNonce = false }()
+
+		if _, err := finish(); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+	})
+
+	t.Run("ForeignSignature", func(t *testing.T) {
+		// A token signed by another issuer's key must not verify
+		other, err := jwtservice.New(jwtservice.Config{Secret: "attacker"})
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		idToken, _ := other.Encode(jwt.MapClaims{"iss": fake.server.URL, "aud": "write-it", "sub": "1", "exp": time.Now().Add(time.Minute).Unix()})
+
+		if _, err := provider.Verify(idToken, ""); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+	})
+}
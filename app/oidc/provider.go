@@ -0,0 +1,291 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+
+	"github.com/rbo13/write-it/app/jwtservice"
+)
+
+// keyRefreshInterval limits how often an unknown `kid` makes us fetch the key set again.
+const keyRefreshInterval = time.Minute
+
+var (
+	errIssuerMismatch = errors.New("oidc: discovery document is for another issuer")
+	errUnknownKey     = errors.New("oidc: ID token is signed with an unknown key")
+	errInvalidIDToken = errors.New("oidc: ID token is invalid")
+	errNonceMismatch  = errors.New("oidc: ID token nonce does not match")
+	errTokenExchange  = errors.New("oidc: authorization code exchange failed")
+)
+
+// Config is what we register at the provider.
+type Config struct {
+	// Name identifies the provider inside our URLs, e.g. "google".
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Provider is an OpenID Connect provider found through its discovery document.
+type Provider struct {
+	Config
+
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	JWKSURI               string
+
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+// NewProvider reads the discovery document of the issuer.
+func NewProvider(config Config, client *http.Client) (*Provider, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	res, err := client.Get(strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: discovery of %s answered %s", config.Issuer, res.Status)
+	}
+
+	var document struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+
+	if err = json.NewDecoder(res.Body).Decode(&document); err != nil {
+		return nil, err
+	}
+
+	if document.Issuer != config.Issuer {
+		return nil, errIssuerMismatch
+	}
+
+	if document.AuthorizationEndpoint == "" || document.TokenEndpoint == "" || document.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: discovery of %s is missing endpoints", config.Issuer)
+	}
+
+	return &Provider{
+		Config:                config,
+		AuthorizationEndpoint: document.AuthorizationEndpoint,
+		TokenEndpoint:         document.TokenEndpoint,
+		JWKSURI:               document.JWKSURI,
+		client:                client,
+		keys:                  map[string]interface{}{},
+	}, nil
+}
+
+// AuthCodeURL returns where the user agent is sent to authenticate.
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return p.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange trades the authorization code and the PKCE verifier for the raw ID token.
+func (p *Provider) Exchange(code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", errTokenExchange
+	}
+
+	if err = json.NewDecoder(res.Body).Decode(&token); err != nil || token.IDToken == "" {
+		return "", errTokenExchange
+	}
+
+	return token.IDToken, nil
+}
+
+// Verify checks the signature and the claims of the ID token, which
+// must have been issued to us for the given nonce, and returns its claims.
+func (p *Provider) Verify(rawIDToken, nonce string) (jwt.MapClaims, error) {
+	parser := &jwt.Parser{ValidMethods: []string{"RS256", "ES256"}}
+
+	token, err := parser.Parse(rawIDToken, p.keyFunc)
+	if err != nil || !token.Valid {
+		return nil, errInvalidIDToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errInvalidIDToken
+	}
+
+	now := time.Now().Unix()
+
+	if !claims.VerifyIssuer(p.Issuer, true) || !claims.VerifyExpiresAt(now, true) {
+		return nil, errInvalidIDToken
+	}
+
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errInvalidIDToken
+	}
+
+	audiences := audience(claims)
+	if !contains(audiences, p.ClientID) {
+		return nil, errInvalidIDToken
+	}
+
+	// With several audiences, the token must have been issued to us.
+	if azp, ok := claims["azp"].(string); (ok || len(audiences) > 1) && azp != p.ClientID {
+		return nil, errInvalidIDToken
+	}
+
+	if claimNonce, _ := claims["nonce"].(string); !equal(claimNonce, nonce) {
+		return nil, errNonceMismatch
+	}
+
+	return claims, nil
+}
+
+func (p *Provider) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	// The provider may have rotated its keys since our last fetch
+	if time.Since(p.fetchedAt) < keyRefreshInterval {
+		return nil, errUnknownKey
+	}
+
+	if err := p.fetchKeys(); err != nil {
+		return nil, err
+	}
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	return nil, errUnknownKey
+}
+
+// fetchKeys replaces the cached keys by the key set of the provider. It must be called with mu held.
+func (p *Provider) fetchKeys() error {
+	p.fetchedAt = time.Now()
+
+	res, err := p.client.Get(p.JWKSURI)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var set jwtservice.JSONWebKeySet
+	if err = json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+
+		keys[jwk.KeyID] = key
+	}
+
+	p.keys = keys
+
+	return nil
+}
+
+// audience returns the `aud` claim, which is either a string or an array.
+func audience(claims jwt.MapClaims) []string {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		audiences := make([]string, 0, len(aud))
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+		return audiences
+	}
+
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
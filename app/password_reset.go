@@ -0,0 +1,35 @@
+package app
+
+import (
+	"context"
+)
+
+// PasswordReset represents a single-use password reset token.
+// Only the SHA-256 hash of the token is stored.
+type PasswordReset struct {
+	ID        int64  `json:"id" db:"id"`
+	UserID    int64  `json:"user_id" db:"user_id"`
+	TokenHash string `json:"-" db:"token_hash"`
+	ExpiresAt int64  `json:"expires_at" db:"expires_at"`
+	UsedAt    int64  `json:"used_at" db:"used_at"`
+	CreatedAt int64  `json:"created_at" db:"created_at"`
+}
+
+// PasswordResetService defines the basic service of password reset
+type PasswordResetService interface {
+	CreatePasswordReset(*PasswordReset) error
+	// PasswordReset returns the unexpired and unused token with the given hash.
+	PasswordReset(tokenHash string) (*PasswordReset, error)
+	// UsePasswordReset consumes the unexpired token with the given hash,
+	// together with every other pending token of the same user.
+	UsePasswordReset(tokenHash string) (*PasswordReset, error)
+
+	// UsePasswordResetContext joins the unit of work carried by ctx, so that
+	// the token is only used together with the new password being saved.
+	UsePasswordResetContext(ctx context.Context, tokenHash string) (*PasswordReset, error)
+}
+
+// TableName represents the table name of password reset
+func (PasswordReset) TableName() string {
+	return "password_resets"
+}
@@ -0,0 +1,141 @@
+// Package passwordreset lets users choose a new password through a link sent by email.
+package passwordreset
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/mailer"
+	"github.com/rbo13/write-it/app/passwords"
+)
+
+var (
+	errInvalidToken    = app.NewError(app.Validation, "Password reset token is invalid or expired")
+	errPasswordMissing = app.NewValidationError("Password is required", map[string]string{"password": "Password is required"})
+)
+
+// Service creates and consumes the password reset tokens.
+type Service struct {
+	userService  app.UserService
+	resetService app.PasswordResetService
+	unitOfWork   app.UnitOfWork
+	policy       *passwords.Policy
+	mailer       mailer.Mailer
+	baseURL      string
+	ttl          time.Duration
+}
+
+// New returns a password reset Service. The links inside
+// the emails point to baseURL and expire after ttl.
+func New(userService app.UserService, resetService app.PasswordResetService, unitOfWork app.UnitOfWork, policy *passwords.Policy, m mailer.Mailer, baseURL string, ttl time.Duration) *Service {
+	return &Service{
+		userService:  userService,
+		resetService: resetService,
+		unitOfWork:   unitOfWork,
+		policy:       policy,
+		mailer:       m,
+		baseURL:      baseURL,
+		ttl:          ttl,
+	}
+}
+
+// Forgot emails a reset link when a user owns the email address.
+// It never reports whether the address exists, errors are only logged.
+func (s *Service) Forgot(email string) {
+	user, err := s.userService.UserByEmail(email)
+	if err != nil {
+		return
+	}
+
+	token, err := newToken()
+	if err != nil {
+		log.Printf("Error generating a password reset token: %v", err)
+		return
+	}
+
+	err = s.resetService.CreatePasswordReset(&app.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: HashToken(token),
+		ExpiresAt: time.Now().Add(s.ttl).Unix(),
+	})
+	if err != nil {
+		log.Printf("Error saving the password reset token: %v", err)
+		return
+	}
+
+	link := s.baseURL + "/password/reset?token=" + url.QueryEscape(token)
+
+	err = s.mailer.Send(mailer.Message{
+		To:      user.EmailAddress,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. If it was you, open the link below to choose a new one:\n\n%s\n\nThe link expires in %s and can only be used once. If it was not you, you can ignore this email.\n", user.Username, link, s.ttl),
+	})
+	if err != nil {
+		log.Printf("Error sending the password reset email: %v", err)
+	}
+}
+
+// Reset consumes the token and sets the new password of its user.
+func (s *Service) Reset(ctx context.Context, token, password string) error {
+	if password == "" {
+		return errPasswordMissing
+	}
+
+	// The token is only consumed once the new password is accepted
+	reset, err := s.resetService.PasswordReset(HashToken(token))
+	if err != nil {
+		return invalidToken(err)
+	}
+
+	user, err := s.userService.UserContext(ctx, reset.UserID)
+	if err != nil {
+		return invalidToken(err)
+	}
+
+	if err = s.policy.Validate(password, user); err != nil {
+		return err
+	}
+
+	// Either both the token is used and the password saved, or neither
+	return s.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		reset, err := s.resetService.UsePasswordResetContext(ctx, HashToken(token))
+		if err != nil {
+			return invalidToken(err)
+		}
+
+		return s.userService.ResetPasswordContext(ctx, reset.UserID, password)
+	})
+}
+
+// invalidToken returns errInvalidToken when err is not the failure of a store,
+// e.g. an unknown token or a deleted user.
+func invalidToken(err error) error {
+	if app.KindOf(err) == app.Internal {
+		return err
+	}
+
+	return errInvalidToken.Wrap(err)
+}
+
+// HashToken returns the hex encoded SHA-256 hash of the token, which is what we store.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,339 @@
+package passwordreset_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/jwtauth"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/jwtservice"
+	"github.com/rbo13/write-it/app/lockout"
+	"github.com/rbo13/write-it/app/mailer"
+	"github.com/rbo13/write-it/app/passwordreset"
+	"github.com/rbo13/write-it/app/passwords"
+	"github.com/rbo13/write-it/app/persistence/cache/memory"
+	"github.com/rbo13/write-it/app/persistence/inmemory"
+	"github.com/rbo13/write-it/app/usecase"
+)
+
+var errResetInvalid = app.NewError(app.Validation, "Password reset token is invalid or expired")
+
+// resetStore keeps the tokens like the SQL service: a token is only
+// found while unused and unexpired, and using it uses every pending
+// token of the same user.
+type resetStore struct {
+	mu     sync.Mutex
+	resets []*app.PasswordReset
+}
+
+func (s *resetStore) CreatePasswordReset(reset *app.PasswordReset) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reset.ID = int64(len(s.resets) + 1)
+	saved := *reset
+	s.resets = append(s.resets, &saved)
+	return nil
+}
+
+func (s *resetStore) PasswordReset(tokenHash string) (*app.PasswordReset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, reset := range s.resets {
+		if reset.TokenHash == tokenHash && reset.UsedAt == 0 && reset.ExpiresAt > time.Now().Unix() {
+			found := *reset
+			return &found, nil
+		}
+	}
+	return nil, errResetInvalid
+}
+
+func (s *resetStore) UsePasswordReset(tokenHash string) (*app.PasswordReset, error) {
+	reset, err := s.PasswordReset(tokenHash)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, pending := range s.resets {
+		if pending.UserID == reset.UserID && pending.UsedAt == 0 {
+			pending.UsedAt = time.Now().Unix()
+		}
+	}
+	return reset, nil
+}
+
+func (s *resetStore) UsePasswordResetContext(ctx context.Context, tokenHash string) (*app.PasswordReset, error) {
+	return s.UsePasswordReset(tokenHash)
+}
+
+// unitOfWork rolls back the tokens used by fn when it fails,
+// the users of the inmemory service are left as they are.
+type unitOfWork struct {
+	resets *resetStore
+}
+
+func (u unitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	u.resets.mu.Lock()
+	usedAt := make([]int64, len(u.resets.resets))
+	for i, reset := range u.resets.resets {
+		usedAt[i] = reset.UsedAt
+	}
+	u.resets.mu.Unlock()
+
+	err := fn(ctx)
+	if err != nil {
+		u.resets.mu.Lock()
+		for i := range usedAt {
+			u.resets.resets[i].UsedAt = usedAt[i]
+		}
+		u.resets.mu.Unlock()
+	}
+	return err
+}
+
+// downUsers fails to save the passwords.
+type downUsers struct {
+	app.UserService
+}
+
+func (downUsers) ResetPasswordContext(ctx context.Context, id int64, password string) error {
+	return app.NewError(app.Internal, "Failed to update the user").Wrap(errors.New("connection refused"))
+}
+
+type outbox struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (o *outbox) Send(m mailer.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.messages = append(o.messages, m)
+	return nil
+}
+
+var linkPattern = regexp.MustCompile(`https://write-it\.test/password/reset\?token=\S+`)
+
+// tokenOf returns the token of the reset link inside the last email.
+func tokenOf(t *testing.T, mail *outbox) string {
+	if len(mail.messages) == 0 {
+		t.Fatal("Expecting a password reset email, but got none")
+	}
+
+	link, err := url.Parse(linkPattern.FindString(mail.messages[len(mail.messages)-1].Body))
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+	return link.Query().Get("token")
+}
+
+func TestPasswordReset(t *testing.T) {
+	jwtService, err := jwtservice.New(jwtservice.Config{Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	hasher := passwords.New(passwords.Bcrypt{Cost: 4})
+	policy := passwords.NewPolicy(8, 64, nil)
+
+	users := inmemory.NewInMemoryUserService(inmemory.NewInMemoryPostService(), jwtService, hasher)
+	user := &app.User{Username: "writer", EmailAddress: "writer@example.com", Password: "correct horse"}
+	if err := users.CreateUser(user); err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	resets := &resetStore{}
+	mail := &outbox{}
+	service := passwordreset.New(users, resets, unitOfWork{resets}, policy, mail, "https://write-it.test", time.Hour)
+	guard := lockout.New(lockout.DefaultConfig, memory.New(100), mail, users)
+
+	t.Run("SingleUse", func(t *testing.T) {
+		service.Forgot("writer@example.com")
+		token := tokenOf(t, mail)
+
+		if err := service.Reset(context.Background(), token, "battery staple"); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if _, err := users.Login("writer@example.com", "battery staple"); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+
+		err := service.Reset(context.Background(), token, "another staple")
+		if app.KindOf(err) != app.Validation {
+			t.Errorf("Expecting: %v, but got: %v instead", "an invalid token", err)
+		}
+
+		if _, err := users.Login("writer@example.com", "another staple"); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "the password to be unchanged", err)
+		}
+	})
+
+	t.Run("PendingTokensAreUsedTogether", func(t *testing.T) {
+		service.Forgot("writer@example.com")
+		first := tokenOf(t, mail)
+		service.Forgot("writer@example.com")
+		second := tokenOf(t, mail)
+
+		if err := service.Reset(context.Background(), second, "horse battery"); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if err := service.Reset(context.Background(), first, "staple horse"); app.KindOf(err) != app.Validation {
+			t.Errorf("Expecting: %v, but got: %v instead", "an invalid token", err)
+		}
+	})
+
+	t.Run("FailedPasswordKeepsToken", func(t *testing.T) {
+		service.Forgot("writer@example.com")
+		token := tokenOf(t, mail)
+
+		down := passwordreset.New(downUsers{users}, resets, unitOfWork{resets}, policy, mail, "https://write-it.test", time.Hour)
+		if err := down.Reset(context.Background(), token, "unsaved horse"); app.KindOf(err) != app.Internal {
+			t.Errorf("Expecting: %v, but got: %v instead", app.Internal, err)
+		}
+
+		if err := service.Reset(context.Background(), token, "saved horse"); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		token := "expired-token"
+		resets.CreatePasswordReset(&app.PasswordReset{
+			UserID:    user.ID,
+			TokenHash: passwordreset.HashToken(token),
+			ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+		})
+
+		if err := service.Reset(context.Background(), token, "expired horse"); app.KindOf(err) != app.Validation {
+			t.Errorf("Expecting: %v, but got: %v instead", "an invalid token", err)
+		}
+	})
+
+	t.Run("WeakPasswordKeepsToken", func(t *testing.T) {
+		service.Forgot("writer@example.com")
+		token := tokenOf(t, mail)
+
+		if err := service.Reset(context.Background(), token, "short"); app.KindOf(err) != app.Validation {
+			t.Errorf("Expecting: %v, but got: %v instead", "a rejected password", err)
+		}
+
+		if err := service.Reset(context.Background(), token, "a better horse"); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+	})
+
+	t.Run("RevokesSessions", func(t *testing.T) {
+		// The sessions of the writer were revoked by the resets above
+		reader := &app.User{Username: "reader", EmailAddress: "reader@example.com", Password: "correct horse"}
+		if err := users.CreateUser(reader); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		// A token issued within the same second as the reset is revoked too
+		authToken, err := jwtService.Encode(jwt.MapClaims{"user_id": reader.ID, "iat": time.Now().Unix()})
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		handler := jwtService.Verifier(jwtauth.Authenticator(usecase.ActiveSession(users)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))))
+		serve := func() int {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "BEARER "+authToken)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		if code := serve(); code != http.StatusOK {
+			t.Fatalf("Expecting: %d, but got: %d instead", http.StatusOK, code)
+		}
+
+		service.Forgot("reader@example.com")
+		if err := service.Reset(context.Background(), tokenOf(t, mail), "revoking horse"); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if code := serve(); code != http.StatusUnauthorized {
+			t.Errorf("Expecting: %d, but got: %d instead", http.StatusUnauthorized, code)
+		}
+	})
+
+	t.Run("SameReplyForUnknownEmail", func(t *testing.T) {
+		handler := usecase.NewPassword(passwordreset.New(users, resets, unitOfWork{resets}, policy, &outbox{}, "https://write-it.test", time.Hour), guard)
+
+		forgot := func(email string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/password/forgot", strings.NewReader(`{"email_address": "`+email+`"}`))
+			rec := httptest.NewRecorder()
+			handler.Forgot(rec, req)
+			return rec
+		}
+
+		known := forgot("writer@example.com")
+		unknown := forgot("nobody@example.com")
+
+		if known.Code != http.StatusOK || unknown.Code != known.Code {
+			t.Errorf("Expecting: %d, but got: %d and %d instead", http.StatusOK, known.Code, unknown.Code)
+		}
+
+		if unknown.Body.String() != known.Body.String() {
+			t.Errorf("Expecting: %v, but got: %v instead", known.Body.String(), unknown.Body.String())
+		}
+	})
+
+	t.Run("ForgotIsThrottled", func(t *testing.T) {
+		handler := usecase.NewPassword(passwordreset.New(users, resets, unitOfWork{resets}, policy, &outbox{}, "https://write-it.test", time.Hour), guard)
+
+		for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+			req := httptest.NewRequest(http.MethodPost, "/password/forgot", strings.NewReader(`{"email_address": "throttled@example.com"}`))
+			rec := httptest.NewRecorder()
+			handler.Forgot(rec, req)
+
+			if rec.Code != want {
+				t.Errorf("Expecting: %d, but got: %d instead", want, rec.Code)
+			}
+		}
+	})
+
+	t.Run("ResetHandler", func(t *testing.T) {
+		handler := usecase.NewPassword(service, guard)
+
+		service.Forgot("writer@example.com")
+		body := `{"token": "` + tokenOf(t, mail) + `", "password": "handled horse"}`
+
+		for _, want := range []int{http.StatusOK, http.StatusUnprocessableEntity} {
+			req := httptest.NewRequest(http.MethodPost, "/password/reset", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			handler.Reset(rec, req)
+
+			if rec.Code != want {
+				t.Errorf("Expecting: %d, but got: %d instead", want, rec.Code)
+			}
+		}
+	})
+
+	t.Run("UnknownEmailSendsNothing", func(t *testing.T) {
+		sent := len(mail.messages)
+		service.Forgot("nobody@example.com")
+
+		if len(mail.messages) != sent {
+			t.Errorf("Expecting: %d, but got: %d instead", sent, len(mail.messages))
+		}
+	})
+}
@@ -0,0 +1,230 @@
+// Package passwords hashes the passwords of the users and
+// checks that new passwords are strong enough.
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	errUnknownHash = errors.New("Password hash format is unknown")
+	errInvalidHash = errors.New("Password hash is malformed")
+)
+
+// Hasher hashes passwords into self-describing encoded strings.
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the encoded hash.
+	Verify(encoded, password string) (bool, error)
+	// NeedsRehash reports whether the encoded hash should be
+	// replaced by a new one, e.g. after the parameters changed.
+	NeedsRehash(encoded string) bool
+}
+
+// Scheme is a Hasher for a single algorithm.
+type Scheme interface {
+	Hasher
+	// Identifies reports whether the encoded hash belongs to the scheme.
+	Identifies(encoded string) bool
+}
+
+type multiHasher struct {
+	current Scheme
+	legacy  []Scheme
+}
+
+// New returns a Hasher that hashes with the current scheme, and
+// still verifies the hashes of the legacy schemes so that they
+// can be replaced on the next successful login.
+func New(current Scheme, legacy ...Scheme) Hasher {
+	return &multiHasher{
+		current: current,
+		legacy:  legacy,
+	}
+}
+
+func (m *multiHasher) Hash(password string) (string, error) {
+	return m.current.Hash(password)
+}
+
+func (m *multiHasher) Verify(encoded, password string) (bool, error) {
+	scheme := m.scheme(encoded)
+	if scheme == nil {
+		return false, errUnknownHash
+	}
+
+	return scheme.Verify(encoded, password)
+}
+
+func (m *multiHasher) NeedsRehash(encoded string) bool {
+	if !m.current.Identifies(encoded) {
+		return true
+	}
+
+	return m.current.NeedsRehash(encoded)
+}
+
+func (m *multiHasher) scheme(encoded string) Scheme {
+	if m.current.Identifies(encoded) {
+		return m.current
+	}
+
+	for _, scheme := range m.legacy {
+		if scheme.Identifies(encoded) {
+			return scheme
+		}
+	}
+
+	return nil
+}
+
+// Argon2id hashes with argon2id, encoding the hashes
+// in the PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+type Argon2id struct {
+	Time       uint32
+	Memory     uint32 // in KiB
+	Threads    uint8
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+// DefaultArgon2id follows the recommendations of RFC 9106 for constrained memory.
+var DefaultArgon2id = Argon2id{
+	Time:       3,
+	Memory:     64 * 1024,
+	Threads:    4,
+	SaltLength: 16,
+	KeyLength:  32,
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// Identifies ...
+func (a Argon2id) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+// Hash ...
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		a.Memory,
+		a.Time,
+		a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify ...
+func (a Argon2id) Verify(encoded, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// NeedsRehash ...
+func (a Argon2id) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return params.Time != a.Time ||
+		params.Memory != a.Memory ||
+		params.Threads != a.Threads ||
+		uint32(len(salt)) != a.SaltLength ||
+		uint32(len(key)) != a.KeyLength
+}
+
+func decodeArgon2id(encoded string) (params Argon2id, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=4", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errInvalidHash
+	}
+
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errInvalidHash
+	}
+
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, errInvalidHash
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, errInvalidHash
+	}
+
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return params, nil, nil, errInvalidHash
+	}
+
+	return params, salt, key, nil
+}
+
+// Bcrypt hashes with bcrypt. It is kept to verify the hashes
+// stored before argon2id became the default.
+type Bcrypt struct {
+	Cost int
+}
+
+// Identifies ...
+func (b Bcrypt) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+// Hash ...
+func (b Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// Verify ...
+func (b Bcrypt) Verify(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// NeedsRehash ...
+func (b Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < b.Cost
+}
@@ -0,0 +1,88 @@
+package passwords_test
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/passwords"
+)
+
+// cheap keeps the tests fast, the parameters do not change the format.
+var cheap = passwords.Argon2id{Time: 1, Memory: 1024, Threads: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHasher(t *testing.T) {
+	hasher := passwords.New(cheap, passwords.Bcrypt{Cost: bcrypt.MinCost})
+
+	t.Run("Argon2id", func(t *testing.T) {
+		hash, err := hasher.Hash("correct horse battery staple")
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+			t.Errorf("Expecting: %v, but got: %v instead", "$argon2id$v=19$m=1024,t=1,p=1$", hash)
+		}
+
+		if ok, err := hasher.Verify(hash, "correct horse battery staple"); !ok || err != nil {
+			t.Errorf("Expecting: %v, but got: %v instead", true, err)
+		}
+
+		if ok, _ := hasher.Verify(hash, "wrong horse battery staple"); ok {
+			t.Errorf("Expecting: %v, but got: %v instead", false, ok)
+		}
+
+		if hasher.NeedsRehash(hash) {
+			t.Errorf("Expecting: %v, but got: %v instead", false, true)
+		}
+
+		stronger := cheap
+		stronger.Time = 2
+		if !passwords.New(stronger).NeedsRehash(hash) {
+			t.Errorf("Expecting: %v, but got: %v instead", true, false)
+		}
+	})
+
+	t.Run("LegacyBcrypt", func(t *testing.T) {
+		legacy, _ := bcrypt.GenerateFromPassword([]byte("correct horse battery staple"), bcrypt.MinCost)
+
+		if ok, err := hasher.Verify(string(legacy), "correct horse battery staple"); !ok || err != nil {
+			t.Errorf("Expecting: %v, but got: %v instead", true, err)
+		}
+
+		if !hasher.NeedsRehash(string(legacy)) {
+			t.Errorf("Expecting: %v, but got: %v instead", true, false)
+		}
+	})
+
+	t.Run("UnknownHash", func(t *testing.T) {
+		if ok, err := hasher.Verify("", "password"); ok || err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "error", err)
+		}
+	})
+}
+
+func TestPolicy(t *testing.T) {
+	policy := passwords.NewPolicy(10, 64, []string{"Tr0ub4dor&3"})
+	user := &app.User{Username: "richard", EmailAddress: "rich@example.com"}
+
+	tests := map[string]bool{
+		"correct horse battery staple": true,
+		"short":                        false,
+		"ñandú-ñandú":                  true,
+		strings.Repeat("a", 65):        false,
+		"tr0ub4dor&3":                  false,
+		"password123":                  false,
+		"my name is richard!":          false,
+		"rich@example.com1":            false,
+	}
+
+	for password, valid := range tests {
+		err := policy.Validate(password, user)
+		if (err == nil) != valid {
+			t.Errorf("Expecting: %v, but got: %v instead", valid, err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package passwords
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rbo13/write-it/app"
+)
+
+var (
+	errBreached        = invalid("Password is too common, it appears in known data breaches")
+	errContainsAccount = invalid("Password must not contain your email address or username")
+)
+
+// CommonPasswords are refused even without a breached password list.
+var CommonPasswords = []string{
+	"123456", "123456789", "12345678", "1234567890", "12345", "1234567",
+	"password", "password1", "password123", "passw0rd", "qwerty", "qwerty123",
+	"qwertyuiop", "abc123", "111111", "000000", "123123", "iloveyou",
+	"letmein", "welcome", "monkey", "dragon", "football", "baseball",
+	"sunshine", "princess", "admin", "admin123", "master", "shadow",
+	"superman", "trustno1", "changeme", "secret", "write-it",
+}
+
+// Policy checks the passwords chosen by the users.
+type Policy struct {
+	MinLength int
+	MaxLength int
+	breached  map[string]struct{}
+}
+
+// NewPolicy returns a Policy refusing the breached passwords
+// on top of the CommonPasswords. Lengths are counted in characters.
+func NewPolicy(minLength, maxLength int, breached []string) *Policy {
+	p := &Policy{
+		MinLength: minLength,
+		MaxLength: maxLength,
+		breached:  make(map[string]struct{}, len(breached)+len(CommonPasswords)),
+	}
+
+	for _, list := range [][]string{CommonPasswords, breached} {
+		for _, password := range list {
+			p.breached[strings.ToLower(password)] = struct{}{}
+		}
+	}
+
+	return p
+}
+
+// LoadBreached reads a breached password list, one password per line.
+func LoadBreached(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var list []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			list = append(list, line)
+		}
+	}
+
+	return list, scanner.Err()
+}
+
+// Validate returns why the password cannot be used by the user, if anything.
+func (p *Policy) Validate(password string, user *app.User) error {
+	length := utf8.RuneCountInString(password)
+
+	if length < p.MinLength {
+		return invalid(fmt.Sprintf("Password must be at least %d characters", p.MinLength))
+	}
+
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return invalid(fmt.Sprintf("Password must be at most %d characters", p.MaxLength))
+	}
+
+	lower := strings.ToLower(password)
+
+	if _, ok := p.breached[lower]; ok {
+		return errBreached
+	}
+
+	if user != nil {
+		for _, part := range accountParts(user) {
+			if strings.Contains(lower, part) {
+				return errContainsAccount
+			}
+		}
+	}
+
+	return nil
+}
+
+// accountParts returns the parts of the account that cannot appear inside
+// the password. Parts shorter than 3 characters would refuse too much.
+func accountParts(user *app.User) []string {
+	var parts []string
+
+	email := strings.ToLower(strings.TrimSpace(user.EmailAddress))
+	candidates := []string{strings.ToLower(strings.TrimSpace(user.Username)), email}
+	if at := strings.LastIndex(email, "@"); at > 0 {
+		candidates = append(candidates, email[:at])
+	}
+
+	for _, part := range candidates {
+		if utf8.RuneCountInString(part) >= 3 {
+			parts = append(parts, part)
+		}
+	}
+
+	return parts
+}
+
+// invalid returns the app.Validation error of the password field.
+func invalid(message string) *app.Error {
+	return app.NewValidationError(message, map[string]string{"password": message})
+}
@@ -17,6 +17,15 @@ type Cacher interface {
 	Delete(string) (bool, error)
 }
 
+// Updater is implemented by the caches that change a value atomically,
+// for the values that concurrent requests change, e.g. counters.
+type Updater interface {
+	// Update replaces the value under key by the one fn returns, given
+	// the current value if found. fn runs again when the value changed
+	// in the meantime, so it must not have other effects.
+	Update(key string, fn func(val string, found bool) (string, error)) error
+}
+
 // Set sets data to the cache
 // and returns a boolean value
 // data is saved successfully,
@@ -4,12 +4,19 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"errors"
 
 	"github.com/bradfitz/gomemcache/memcache"
 )
 
 var prefix = "mycache."
 
+// maxUpdateTries is how many times Update reads the value again
+// when other clients keep changing it.
+const maxUpdateTries = 10
+
+var errContention = errors.New("memcached: the value kept changing during the update")
+
 // Memcached struct for our concrete
 // implemenation of memcached
 type Memcached struct {
@@ -100,6 +107,61 @@ func (m *Memcached) Delete(suffix string) (bool, error) {
 	return true, nil
 }
 
+// Update replaces the value under `key` by the one returned by fn,
+// using compare-and-swap so that concurrent updates are not lost.
+func (m *Memcached) Update(suffix string, fn func(val string, found bool) (string, error)) error {
+	key := prefix + suffix
+	if m.isCompressed {
+		key = prefix + ".c." + suffix
+	}
+
+	for tries := 0; tries < maxUpdateTries; tries++ {
+		var val string
+
+		it, err := m.client.Get(key)
+		if err != nil && err != memcache.ErrCacheMiss {
+			return err
+		}
+
+		found := err == nil
+		if found {
+			val = string(it.Value)
+			if m.isCompressed {
+				if val, err = gzuncompress(it.Value); err != nil {
+					return err
+				}
+			}
+		}
+
+		updated, err := fn(val, found)
+		if err != nil {
+			return err
+		}
+
+		value := []byte(updated)
+		if m.isCompressed {
+			value = gzcompress(updated)
+		}
+
+		// Add fails when another client created the value first,
+		// and CompareAndSwap when it was changed or deleted since.
+		if found {
+			it.Value = value
+			err = m.client.CompareAndSwap(it)
+		} else {
+			err = m.client.Add(&memcache.Item{Key: key, Value: value})
+		}
+
+		if err == memcache.ErrCASConflict || err == memcache.ErrNotStored {
+			continue
+		}
+
+		return err
+	}
+
+	return errContention
+}
+
 func gzcompress(val string) []byte {
 	var b bytes.Buffer
 
@@ -0,0 +1,87 @@
+// Package memory is a cache held by the process itself, for the values
+// that must be kept when memcached cannot be reached.
+package memory
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrCacheMiss is returned by Get when nothing is saved under the key.
+var ErrCacheMiss = errors.New("memory: cache miss")
+
+// Cache is a Cacher and an Updater safe for concurrent use. Once it holds
+// its maximum number of values, saving another one evicts any of them.
+type Cache struct {
+	mu       sync.Mutex
+	values   map[string]string
+	maxItems int
+}
+
+// New returns an empty Cache holding at most maxItems values.
+func New(maxItems int) *Cache {
+	return &Cache{
+		values:   map[string]string{},
+		maxItems: maxItems,
+	}
+}
+
+// Set saves the value under key.
+func (c *Cache) Set(key, val string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, val)
+	return true, nil
+}
+
+// Get returns the value saved under key.
+func (c *Cache) Get(key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	val, ok := c.values[key]
+	if !ok {
+		return "", ErrCacheMiss
+	}
+
+	return val, nil
+}
+
+// Delete removes the value saved under key.
+func (c *Cache) Delete(key string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.values[key]
+	delete(c.values, key)
+	return ok, nil
+}
+
+// Update replaces the value under key by the one returned by fn,
+// while holding the lock so that concurrent updates are not lost.
+func (c *Cache) Update(key string, fn func(val string, found bool) (string, error)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	val, found := c.values[key]
+
+	updated, err := fn(val, found)
+	if err != nil {
+		return err
+	}
+
+	c.set(key, updated)
+	return nil
+}
+
+func (c *Cache) set(key, val string) {
+	if _, ok := c.values[key]; !ok && len(c.values) >= c.maxItems {
+		for evicted := range c.values {
+			delete(c.values, evicted)
+			break
+		}
+	}
+
+	c.values[key] = val
+}
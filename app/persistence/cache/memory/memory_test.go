@@ -0,0 +1,76 @@
+package memory_test
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/rbo13/write-it/app/persistence/cache"
+	"github.com/rbo13/write-it/app/persistence/cache/memory"
+)
+
+func TestMemory(t *testing.T) {
+	t.Run("SetGetDelete", func(t *testing.T) {
+		mem := memory.New(10)
+
+		if _, err := cache.Set(mem, "key", map[string]string{"val": "Hello World"}); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+
+		var d map[string]string
+		if err := cache.Get(mem, "key", &d); err != nil || d["val"] != "Hello World" {
+			t.Errorf("Expecting: %v, but got: %v instead", "Hello World", d)
+		}
+
+		cache.Delete(mem, "key")
+
+		if _, err := mem.Get("key"); err != memory.ErrCacheMiss {
+			t.Errorf("Expecting: %v, but got: %v instead", memory.ErrCacheMiss, err)
+		}
+	})
+
+	t.Run("ConcurrentUpdates", func(t *testing.T) {
+		mem := memory.New(10)
+		increment := func(val string, found bool) (string, error) {
+			n, _ := strconv.Atoi(val)
+			return strconv.Itoa(n + 1), nil
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				mem.Update("counter", increment)
+			}()
+		}
+		wg.Wait()
+
+		if val, _ := mem.Get("counter"); val != "100" {
+			t.Errorf("Expecting: %v, but got: %v instead", "100", val)
+		}
+	})
+
+	t.Run("MaxItems", func(t *testing.T) {
+		mem := memory.New(2)
+
+		for _, key := range []string{"a", "b", "c"} {
+			mem.Set(key, key)
+		}
+
+		found := 0
+		for _, key := range []string{"a", "b", "c"} {
+			if _, err := mem.Get(key); err == nil {
+				found++
+			}
+		}
+
+		if found != 2 {
+			t.Errorf("Expecting: %v, but got: %v instead", 2, found)
+		}
+
+		if val, _ := mem.Get("c"); val != "c" {
+			t.Errorf("Expecting: %v, but got: %v instead", "the last value to be kept", val)
+		}
+	})
+}
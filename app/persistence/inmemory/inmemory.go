@@ -1,21 +1,30 @@
 package inmemory
 
 import (
-	"errors"
+	"context"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/rbo13/write-it/app"
 )
 
-var errIDRequired = errors.New("ID is required")
+var (
+	errIDRequired   = app.NewError(app.Validation, "ID is required")
+	errEmpty        = app.NewError(app.Validation, "error: Post is required")
+	errPostNotFound = app.NewError(app.NotFound, "error: Post not found")
+	errPostChanged  = app.NewError(app.PreconditionFailed, "error: Post was changed since it was read")
+)
 
 type postService struct {
-	mu    *sync.RWMutex
-	posts map[int64]*app.Post
+	mu     *sync.RWMutex
+	posts  map[int64]*app.Post
+	lastID int64
 }
 
-// NewInMemoryPostService ...
+// NewInMemoryPostService returns an app.PostService keeping the posts in memory.
+// It behaves like the SQL services: the ids are assigned in order, and the
+// posts are copied in and out, so changing one does not change the store.
 func NewInMemoryPostService() app.PostService {
 	return &postService{
 		mu:    &sync.RWMutex{},
@@ -24,67 +33,196 @@ func NewInMemoryPostService() app.PostService {
 }
 
 func (ps *postService) CreatePost(post *app.Post) error {
-	ps.mu.RLock()
-	defer ps.mu.RUnlock()
-
-	ps.posts[post.ID] = &app.Post{
-		ID:        post.ID,
-		CreatorID: post.CreatorID,
-		PostTitle: post.PostTitle,
-		PostBody:  post.PostBody,
-		CreatedAt: time.Now().Unix(),
-		DeletedAt: int64(0),
+	if post == nil {
+		return errEmpty
 	}
 
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.lastID++
+
+	post.ID = ps.lastID
+	post.CreatedAt = time.Now().Unix()
+	post.Version = 1
+
+	saved := *post
+	ps.posts[post.ID] = &saved
+
 	return nil
 }
 
 func (ps *postService) Post(id int64) (*app.Post, error) {
+	if id <= 0 {
+		return nil, errIDRequired
+	}
+
 	ps.mu.RLock()
 	defer ps.mu.RUnlock()
 
-	if id <= 0 {
-		return nil, errIDRequired
+	post, ok := ps.posts[id]
+	if !ok {
+		return nil, errPostNotFound
 	}
 
-	return ps.posts[id], nil
+	found := *post
+	return &found, nil
 }
 
 func (ps *postService) Posts() ([]*app.Post, error) {
 	ps.mu.RLock()
 	defer ps.mu.RUnlock()
 
-	var posts []*app.Post
+	posts := ps.filter(func(*app.Post) bool { return true })
+	sort.Slice(posts, func(i, j int) bool { return posts[i].ID > posts[j].ID })
 
-	for _, post := range ps.posts {
-		posts = append(posts, post)
+	return posts, nil
+}
+
+func (ps *postService) PostsByCreator(creatorID, afterID int64, limit int) ([]*app.Post, error) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	posts := ps.filter(func(post *app.Post) bool {
+		return post.CreatorID == creatorID && post.ID > afterID
+	})
+	sort.Slice(posts, func(i, j int) bool { return posts[i].ID < posts[j].ID })
+
+	if len(posts) > limit {
+		posts = posts[:limit]
 	}
 
 	return posts, nil
 }
 
-func (ps *postService) UpdatePost(post *app.Post) error {
+func (ps *postService) CountPostsByCreator(creatorID int64) (int, error) {
 	ps.mu.RLock()
 	defer ps.mu.RUnlock()
 
+	count := 0
+
+	for _, post := range ps.posts {
+		if post.CreatorID == creatorID {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// UpdatePost changes the title and body of the post, as long
+// as it belongs to the creator of the given one.
+func (ps *postService) UpdatePost(post *app.Post) error {
 	if post.ID <= 0 {
 		return errIDRequired
 	}
 
-	ps.posts[post.ID] = post
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	post.UpdatedAt = time.Now().Unix()
+
+	saved, ok := ps.posts[post.ID]
+	if !ok || saved.CreatorID != post.CreatorID {
+		return errPostNotFound
+	}
+
+	if saved.Version != post.Version {
+		return errPostChanged
+	}
+
+	saved.PostTitle = post.PostTitle
+	saved.PostBody = post.PostBody
+	saved.CreatedAt = post.CreatedAt
+	saved.UpdatedAt = post.UpdatedAt
+	saved.Version++
+	post.Version = saved.Version
 
 	return nil
 }
 
 func (ps *postService) DeletePost(id int64) error {
-	ps.mu.RLock()
-	defer ps.mu.RUnlock()
-
 	if id <= 0 {
 		return errIDRequired
 	}
 
-	ps.posts[id] = nil
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	delete(ps.posts, id)
 
 	return nil
 }
+
+// filter returns a copy of the posts matching keep. The caller holds the lock.
+func (ps *postService) filter(keep func(*app.Post) bool) []*app.Post {
+	posts := []*app.Post{}
+
+	for _, post := range ps.posts {
+		if keep(post) {
+			found := *post
+			posts = append(posts, &found)
+		}
+	}
+
+	return posts
+}
+
+// The store answers right away, so the Context variants only
+// give up when the context is done before they start.
+
+func (ps *postService) CreatePostContext(ctx context.Context, post *app.Post) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return ps.CreatePost(post)
+}
+
+func (ps *postService) PostContext(ctx context.Context, id int64) (*app.Post, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return ps.Post(id)
+}
+
+func (ps *postService) PostsContext(ctx context.Context) ([]*app.Post, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return ps.Posts()
+}
+
+func (ps *postService) PostsByCreatorContext(ctx context.Context, creatorID, afterID int64, limit int) ([]*app.Post, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return ps.PostsByCreator(creatorID, afterID, limit)
+}
+
+func (ps *postService) CountPostsByCreatorContext(ctx context.Context, creatorID int64) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return ps.CountPostsByCreator(creatorID)
+}
+
+func (ps *postService) UpdatePostContext(ctx context.Context, post *app.Post) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return ps.UpdatePost(post)
+}
+
+func (ps *postService) DeletePostContext(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return ps.DeletePost(id)
+}
@@ -4,7 +4,9 @@ import (
 	"testing"
 
 	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/passwords"
 	"github.com/rbo13/write-it/app/persistence/inmemory"
+	"github.com/rbo13/write-it/app/persistence/storetest"
 )
 
 func TestInMemoryStore(t *testing.T) {
@@ -57,6 +59,7 @@ func TestInMemoryStore(t *testing.T) {
 			CreatorID: int64(1),
 			PostTitle: "Test Update Post Title",
 			PostBody:  "Test Update Post Body",
+			Version:   1,
 		}
 
 		err := postInmemory.UpdatePost(post)
@@ -90,3 +93,12 @@ func TestInMemoryStore(t *testing.T) {
 		t.Log(gotPosts)
 	})
 }
+
+func TestConformance(t *testing.T) {
+	hasher := passwords.New(passwords.Bcrypt{Cost: 4})
+
+	storetest.Run(t, func(t *testing.T) (app.UserService, app.PostService) {
+		posts := inmemory.NewInMemoryPostService()
+		return inmemory.NewInMemoryUserService(posts, nil, hasher), posts
+	})
+}
@@ -0,0 +1,465 @@
+package inmemory
+
+import (
+	"context"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/jwtauth"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/jwtservice"
+	"github.com/rbo13/write-it/app/passwords"
+)
+
+var (
+	errUserDelete           = app.NewError(app.Internal, "Failed to delete the user")
+	errUserNotFound         = app.NewError(app.NotFound, "User not found")
+	errUserChanged          = app.NewError(app.PreconditionFailed, "User was changed since it was read")
+	errEmailAlreadyTaken    = app.NewError(app.Conflict, "Email Address is already taken")
+	errUsernameAlreadyTaken = app.NewError(app.Conflict, "Username is already taken")
+	errEmailRequired        = app.NewValidationError("Email is required", map[string]string{"email": "Email is required"})
+	errUsernameRequired     = app.NewValidationError("Username is required", map[string]string{"username": "Username is required"})
+	errMissingCredentials   = app.NewError(app.Validation, "Email or Password is missing")
+	errCredentialsIncorrect = app.NewError(app.Unauthorized, "Email or Password is invalid")
+	errEmailNotVerified     = app.NewError(app.Conflict, "Email Address cannot be verified")
+	errPasswordNotHashed    = app.NewError(app.Internal, "Failed to hash the password")
+	errAuthToken            = app.NewError(app.Internal, "Failed to generate the auth token")
+)
+
+type userService struct {
+	mu     *sync.RWMutex
+	users  map[int64]*app.User
+	lastID int64
+
+	posts      app.PostService
+	jwtService *jwtservice.JWT
+	hasher     passwords.Hasher
+
+	// dummyHash is verified against when the email is unknown,
+	// so that it takes as long to answer as a wrong password.
+	dummyHash string
+}
+
+// NewInMemoryUserService returns an app.UserService keeping the users in
+// memory, whose posts are those of postService. Like the SQL services, it
+// hashes the passwords with hasher and deletes the posts of a deleted user.
+func NewInMemoryUserService(postService app.PostService, jwtService *jwtservice.JWT, hasher passwords.Hasher) app.UserService {
+	dummyHash, err := hasher.Hash("write-it")
+	if err != nil {
+		log.Printf("Error hashing the dummy password: %v", err)
+	}
+
+	return &userService{
+		mu:         &sync.RWMutex{},
+		users:      map[int64]*app.User{},
+		posts:      postService,
+		jwtService: jwtService,
+		hasher:     hasher,
+		dummyHash:  dummyHash,
+	}
+}
+
+func (us *userService) CreateUser(user *app.User) error {
+	if user.EmailAddress == "" {
+		return errEmailRequired
+	}
+
+	// Hashing takes a while, it is done before locking the store
+	hash, err := us.hasher.Hash(user.Password)
+	if err != nil {
+		return errPasswordNotHashed.Wrap(err)
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if us.find(func(u *app.User) bool { return u.EmailAddress == user.EmailAddress }) != nil {
+		return errEmailAlreadyTaken
+	}
+
+	if us.find(func(u *app.User) bool { return u.Username == user.Username }) != nil {
+		return errUsernameAlreadyTaken
+	}
+
+	us.lastID++
+
+	user.ID = us.lastID
+	user.CreatedAt = time.Now().Unix()
+	user.Password = hash
+	user.Version = 1
+
+	if user.UserType == "" {
+		user.UserType = "reader"
+	}
+
+	saved := *user
+	us.users[user.ID] = &saved
+
+	return nil
+}
+
+func (us *userService) User(id int64) (*app.User, error) {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	user, ok := us.users[id]
+	if !ok {
+		return nil, errUserNotFound
+	}
+
+	found := *user
+	return &found, nil
+}
+
+func (us *userService) UserByEmail(email string) (*app.User, error) {
+	if email == "" {
+		return nil, errEmailRequired
+	}
+
+	return us.findCopy(func(u *app.User) bool { return u.EmailAddress == email })
+}
+
+func (us *userService) UserByUsername(username string) (*app.User, error) {
+	if username == "" {
+		return nil, errUsernameRequired
+	}
+
+	return us.findCopy(func(u *app.User) bool { return u.Username == username })
+}
+
+func (us *userService) Login(email, password string) (*app.User, error) {
+	if email == "" || password == "" {
+		return nil, errMissingCredentials
+	}
+
+	user, err := us.UserByEmail(email)
+	if app.KindOf(err) == app.NotFound {
+		us.hasher.Verify(us.dummyHash, password)
+		return nil, errCredentialsIncorrect
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := us.hasher.Verify(user.Password, password)
+	if err != nil {
+		log.Printf("Error verifying the password of user %d: %v", user.ID, err)
+	}
+
+	if !ok {
+		return nil, errCredentialsIncorrect
+	}
+
+	if us.hasher.NeedsRehash(user.Password) {
+		us.rehashPassword(user, password)
+	}
+
+	return user, nil
+}
+
+func (us *userService) GenerateAuthToken(user *app.User) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id":       user.ID,
+		"email":         user.EmailAddress,
+		"authenticated": true,
+		"created_at":    user.CreatedAt,
+	}
+
+	jwtauth.SetExpiryIn(claims, 1*time.Hour)
+	jwtauth.SetIssuedNow(claims)
+
+	authToken, err := us.jwtService.Encode(claims)
+	if err != nil {
+		return "", errAuthToken.Wrap(err)
+	}
+
+	return authToken, nil
+}
+
+func (us *userService) Users() ([]*app.User, error) {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	users := []*app.User{}
+
+	for _, user := range us.users {
+		found := *user
+		users = append(users, &found)
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].ID > users[j].ID })
+
+	return users, nil
+}
+
+// GetUserPosts joins the posts of the user with the user.
+func (us *userService) GetUserPosts(userID int64) ([]*app.UserPosts, error) {
+	user, err := us.User(userID)
+	if app.KindOf(err) == app.NotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	posts, err := us.posts.PostsByCreator(userID, 0, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+
+	var userPosts []*app.UserPosts
+
+	for _, post := range posts {
+		userPosts = append(userPosts, &app.UserPosts{
+			PostTitle: post.PostTitle,
+			PostBody:  post.PostBody,
+			UserType:  user.UserType,
+			Email:     user.EmailAddress,
+			Username:  user.Username,
+			CreatedAt: post.CreatedAt,
+			UpdatedAt: post.UpdatedAt,
+		})
+	}
+
+	return userPosts, nil
+}
+
+func (us *userService) UpdateUser(user *app.User) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	user.UpdatedAt = time.Now().Unix()
+
+	saved, ok := us.users[user.ID]
+	if !ok {
+		return errUserNotFound
+	}
+
+	if saved.Version != user.Version {
+		return errUserChanged
+	}
+
+	if us.find(func(u *app.User) bool { return u.Username == user.Username && u.ID != user.ID }) != nil {
+		return errUsernameAlreadyTaken
+	}
+
+	saved.Username = user.Username
+	saved.EmailAddress = user.EmailAddress
+	saved.Password = user.Password
+	saved.UserType = user.UserType
+	saved.EmailVerifiedAt = user.EmailVerifiedAt
+	saved.UpdatedAt = user.UpdatedAt
+	saved.Version++
+	user.Version = saved.Version
+
+	return nil
+}
+
+func (us *userService) VerifyEmail(id int64, email string) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	user, ok := us.users[id]
+	if !ok || user.EmailAddress != email {
+		return errEmailNotVerified
+	}
+
+	user.EmailVerifiedAt = time.Now().Unix()
+	user.Version++
+
+	return nil
+}
+
+func (us *userService) ResetPassword(id int64, password string) error {
+	hash, err := us.hasher.Hash(password)
+	if err != nil {
+		return errPasswordNotHashed.Wrap(err)
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if user, ok := us.users[id]; ok {
+		now := time.Now().Unix()
+
+		user.Password = hash
+		user.SessionsRevokedAt = now
+		user.UpdatedAt = now
+		user.Version++
+	}
+
+	return nil
+}
+
+// DeleteUser deletes the user together with their posts.
+func (us *userService) DeleteUser(id int64) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if _, ok := us.users[id]; !ok {
+		return errUserNotFound
+	}
+
+	for {
+		posts, err := us.posts.PostsByCreator(id, 0, 100)
+		if err != nil {
+			return errUserDelete.Wrap(err)
+		}
+
+		if len(posts) == 0 {
+			break
+		}
+
+		for _, post := range posts {
+			if err = us.posts.DeletePost(post.ID); err != nil {
+				return errUserDelete.Wrap(err)
+			}
+		}
+	}
+
+	delete(us.users, id)
+
+	return nil
+}
+
+// rehashPassword replaces the hash of the user, unless the
+// password changed since we read it.
+func (us *userService) rehashPassword(user *app.User, password string) {
+	hash, err := us.hasher.Hash(password)
+	if err != nil {
+		log.Printf("Error rehashing the password of user %d: %v", user.ID, err)
+		return
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if saved, ok := us.users[user.ID]; ok && saved.Password == user.Password {
+		saved.Password = hash
+		user.Password = hash
+	}
+}
+
+// find returns the user with the lowest id matching keep. The caller holds the lock.
+func (us *userService) find(keep func(*app.User) bool) *app.User {
+	var found *app.User
+
+	for _, user := range us.users {
+		if keep(user) && (found == nil || user.ID < found.ID) {
+			found = user
+		}
+	}
+
+	return found
+}
+
+func (us *userService) findCopy(keep func(*app.User) bool) (*app.User, error) {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	user := us.find(keep)
+	if user == nil {
+		return nil, errUserNotFound
+	}
+
+	found := *user
+	return &found, nil
+}
+
+// Like for the posts, the Context variants only give
+// up when the context is done before they start.
+
+func (us *userService) CreateUserContext(ctx context.Context, user *app.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return us.CreateUser(user)
+}
+
+func (us *userService) UserContext(ctx context.Context, id int64) (*app.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return us.User(id)
+}
+
+func (us *userService) UserByEmailContext(ctx context.Context, email string) (*app.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return us.UserByEmail(email)
+}
+
+func (us *userService) UserByUsernameContext(ctx context.Context, username string) (*app.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return us.UserByUsername(username)
+}
+
+func (us *userService) LoginContext(ctx context.Context, email, password string) (*app.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return us.Login(email, password)
+}
+
+func (us *userService) UsersContext(ctx context.Context) ([]*app.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return us.Users()
+}
+
+func (us *userService) UpdateUserContext(ctx context.Context, user *app.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return us.UpdateUser(user)
+}
+
+func (us *userService) DeleteUserContext(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return us.DeleteUser(id)
+}
+
+func (us *userService) GetUserPostsContext(ctx context.Context, userID int64) ([]*app.UserPosts, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return us.GetUserPosts(userID)
+}
+
+func (us *userService) VerifyEmailContext(ctx context.Context, id int64, email string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return us.VerifyEmail(id, email)
+}
+
+func (us *userService) ResetPasswordContext(ctx context.Context, id int64, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return us.ResetPassword(id, password)
+}
@@ -0,0 +1,92 @@
+package postgres_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/passwords"
+	"github.com/rbo13/write-it/app/persistence/postgres"
+	"github.com/rbo13/write-it/app/persistence/sql"
+	"github.com/rbo13/write-it/app/persistence/storetest"
+)
+
+// TestStores runs against the scratch database named in POSTGRES_DSN,
+// e.g. postgres://localhost/writeit_test?sslmode=disable, which it empties.
+func TestStores(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_DSN is not set")
+	}
+
+	db, err := sql.New(dsn)
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+	defer db.Sqlx.Close()
+
+	if err = db.Migrate(postgres.Migrations); err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	hasher := passwords.New(passwords.Bcrypt{Cost: 4})
+
+	storetest.Run(t, func(t *testing.T) (app.UserService, app.PostService) {
+		if _, err := db.Sqlx.Exec("TRUNCATE users, posts RESTART IDENTITY CASCADE;"); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		return postgres.NewUserPostgresService(db.Sqlx, nil, hasher), postgres.NewPostPostgresService(db.Sqlx)
+	})
+}
+
+func TestAuxiliaryStores(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_DSN is not set")
+	}
+
+	db, err := sql.New(dsn)
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+	defer db.Sqlx.Close()
+
+	if err = db.Migrate(postgres.Migrations); err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	hasher := passwords.New(passwords.Bcrypt{Cost: 4})
+
+	storetest.RunAuxiliary(t, func(t *testing.T) storetest.Auxiliary {
+		if _, err := db.Sqlx.Exec("TRUNCATE users, posts, role_policies, audit_log RESTART IDENTITY CASCADE;"); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		return storetest.Auxiliary{
+			Users:          postgres.NewUserPostgresService(db.Sqlx, nil, hasher),
+			Posts:          postgres.NewPostPostgresService(db.Sqlx),
+			PasswordResets: sql.NewPasswordResetSQLService(db.Sqlx),
+			TwoFactor:      sql.NewTwoFactorSQLService(db.Sqlx),
+			AccessTokens:   sql.NewAccessTokenSQLService(db.Sqlx),
+			Identities:     sql.NewIdentitySQLService(db.Sqlx),
+			OAuth:          sql.NewOAuthSQLService(db.Sqlx),
+			Profiles:       sql.NewProfileSQLService(db.Sqlx),
+			Erasures:       sql.NewErasureSQLService(db.Sqlx),
+			Audit:          sql.NewAuditSQLService(db.Sqlx),
+		}
+	})
+}
+
+func TestMigrations(t *testing.T) {
+	// Both schemas move together, version by version
+	if len(postgres.Migrations) != len(sql.Migrations) {
+		t.Errorf("Expecting: %v, but got: %v instead", len(sql.Migrations), len(postgres.Migrations))
+	}
+
+	for i, m := range postgres.Migrations {
+		if m.Version != i+1 || len(m.Up) == 0 || len(m.Down) == 0 {
+			t.Errorf("Expecting: %v, but got: %v instead", "a numbered and reversible migration", m.Version)
+		}
+	}
+}
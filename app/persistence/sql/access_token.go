@@ -0,0 +1,96 @@
+package sql
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rbo13/write-it/app"
+)
+
+var (
+	errAccessTokenNotInserted = app.NewError(app.Internal, "Failed to insert the access token")
+	errAccessTokenDelete      = app.NewError(app.Internal, "Failed to delete the access token")
+	errAccessTokenUpdate      = app.NewError(app.Internal, "Failed to update the access token")
+	errAccessTokenNotFound    = app.NewError(app.NotFound, "Access token not found")
+)
+
+// AccessTokenService implements the app.AccessTokenService
+type AccessTokenService interface {
+	app.AccessTokenService
+}
+
+// AccessToken implements the AccessTokenService interface
+type AccessToken struct {
+	DB *sqlx.DB
+}
+
+// NewAccessTokenSQLService returns the interface that implements the app.AccessTokenService
+func NewAccessTokenSQLService(db *sqlx.DB) AccessTokenService {
+	return &AccessToken{
+		DB: db,
+	}
+}
+
+// CreateAccessToken ...
+func (a *AccessToken) CreateAccessToken(token *app.AccessToken) error {
+	token.CreatedAt = time.Now().Unix()
+
+	id, err := insert(a.DB, "INSERT INTO access_tokens (user_id, name, token_hash, scopes, expires_at, last_used_at, created_at) VALUES(:user_id, :name, :token_hash, :scopes, :expires_at, :last_used_at, :created_at)", token)
+	if err != nil {
+		return errAccessTokenNotInserted.Wrap(err)
+	}
+
+	token.ID = id
+
+	return nil
+}
+
+// AccessToken ...
+func (a *AccessToken) AccessToken(tokenHash string) (*app.AccessToken, error) {
+	token := new(app.AccessToken)
+
+	err := a.DB.Get(token, a.DB.Rebind("SELECT * FROM access_tokens WHERE token_hash = ? LIMIT 1;"), tokenHash)
+
+	if err != nil {
+		return nil, QueryError(err, errAccessTokenNotFound)
+	}
+
+	return token, nil
+}
+
+// AccessTokens ...
+func (a *AccessToken) AccessTokens(userID int64) ([]*app.AccessToken, error) {
+	tokens := []*app.AccessToken{}
+
+	err := a.DB.Select(&tokens, a.DB.Rebind("SELECT * FROM access_tokens WHERE user_id = ? ORDER BY id DESC;"), userID)
+
+	if err != nil {
+		return nil, QueryError(err, errAccessTokenNotFound)
+	}
+
+	return tokens, nil
+}
+
+// TouchAccessToken ...
+func (a *AccessToken) TouchAccessToken(id, lastUsedAt int64) error {
+	_, err := a.DB.Exec(a.DB.Rebind("UPDATE access_tokens SET last_used_at = ? WHERE id = ?;"), lastUsedAt, id)
+	if err != nil {
+		return errAccessTokenUpdate.Wrap(err)
+	}
+
+	return nil
+}
+
+// DeleteAccessToken ...
+func (a *AccessToken) DeleteAccessToken(id, userID int64) error {
+	res, err := a.DB.Exec(a.DB.Rebind("DELETE FROM access_tokens WHERE id = ? AND user_id = ?;"), id, userID)
+	if err != nil {
+		return errAccessTokenDelete.Wrap(err)
+	}
+
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		return errAccessTokenNotFound.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rbo13/write-it/app"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+var (
+	errAuditNotInserted = app.NewError(app.Internal, "Failed to insert the audit entry")
+	errAuditNotFound    = app.NewError(app.NotFound, "Audit entry not found")
+)
+
+// AuditService implements the app.AuditService
+type AuditService interface {
+	app.AuditService
+}
+
+// Audit implements the AuditService interface
+type Audit struct {
+	DB *sqlx.DB
+}
+
+// NewAuditSQLService returns the interface that implements the app.AuditService
+func NewAuditSQLService(db *sqlx.DB) AuditService {
+	return &Audit{
+		DB: db,
+	}
+}
+
+// AppendAuditEntry ...
+func (a *Audit) AppendAuditEntry(entry *app.AuditEntry) error {
+	err := Transact(context.Background(), a.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		// Lock the last entry so that two entries cannot follow the same one.
+		var prevHash string
+
+		err := tx.Get(&prevHash, "SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1"+forUpdate(tx))
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		entry.PrevHash = prevHash
+		entry.Hash = entry.ComputeHash()
+
+		entry.ID, err = insert(tx, "INSERT INTO audit_log (actor_id, action, target_type, target_id, diff, ip, request_id, created_at, prev_hash, hash) VALUES(:actor_id, :action, :target_type, :target_id, :diff, :ip, :request_id, :created_at, :prev_hash, :hash)", entry)
+		return err
+	})
+
+	if err != nil {
+		return TxError(err, errAuditNotInserted)
+	}
+
+	return nil
+}
+
+// AuditEntries ...
+func (a *Audit) AuditEntries(filter app.AuditFilter) ([]*app.AuditEntry, error) {
+	conditions := []string{"id > ?"}
+	args := []interface{}{filter.AfterID}
+
+	if filter.ActorID > 0 {
+		conditions = append(conditions, "actor_id = ?")
+		args = append(args, filter.ActorID)
+	}
+
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+
+	if filter.TargetType != "" {
+		conditions = append(conditions, "target_type = ?")
+		args = append(args, filter.TargetType)
+	}
+
+	if filter.TargetID != "" {
+		conditions = append(conditions, "target_id = ?")
+		args = append(args, filter.TargetID)
+	}
+
+	if filter.From > 0 {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.From)
+	}
+
+	if filter.To > 0 {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.To)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+	args = append(args, limit)
+
+	entries := []*app.AuditEntry{}
+
+	err := a.DB.Select(&entries, a.DB.Rebind("SELECT * FROM audit_log WHERE "+strings.Join(conditions, " AND ")+" ORDER BY id LIMIT ?;"), args...)
+	if err != nil {
+		return nil, QueryError(err, errAuditNotFound)
+	}
+
+	return entries, nil
+}
@@ -1,23 +1,77 @@
 package sql
 
 import (
+	"context"
+	"errors"
 	"log"
+	"strings"
+	"time"
 
 	// Import mysql driver
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	// Import postgres driver
+	_ "github.com/lib/pq"
+	// Import sqlite driver, which needs cgo
+	_ "github.com/mattn/go-sqlite3"
 )
 
+// sqliteOptions turn on the write-ahead log, so that reads do not block the
+// writer, wait up to 5s for a busy database rather than failing right away,
+// and take the write lock when a transaction begins, as a transaction that
+// reads first could not wait for it later on.
+const sqliteOptions = "_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=1&_txlock=immediate"
+
 // const dbName = "writeit"
 
+// ErrSQLiteUnsupported is returned by New for a sqlite:// URL in a binary built without cgo.
+var ErrSQLiteUnsupported = errors.New("SQLite needs a binary built with CGO_ENABLED=1, rebuild it or set DATABASE_URL to a MySQL or PostgreSQL database")
+
+// QueryTimeout bounds every query of the services, even
+// when its context has a later deadline or none.
+var QueryTimeout = 5 * time.Second
+
+// WithQueryTimeout returns the context of a query, done after QueryTimeout at the latest.
+func WithQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, QueryTimeout)
+}
+
 // DB uses the sqlx library to interact with our sql database.
 type DB struct {
 	Sqlx *sqlx.DB
+
+	// name is the database selected by Use
+	name string
+}
+
+// Driver returns the driver of the data source: postgres for the postgres://
+// and postgresql:// URLs, sqlite3 for the sqlite:// ones, mysql otherwise.
+func Driver(dataSourceName string) string {
+	if strings.HasPrefix(dataSourceName, "postgres://") || strings.HasPrefix(dataSourceName, "postgresql://") {
+		return "postgres"
+	}
+
+	if strings.HasPrefix(dataSourceName, "sqlite://") {
+		return "sqlite3"
+	}
+
+	return "mysql"
 }
 
-// New returns the pointer to sqlx.DB struct.
+// New returns the pointer to sqlx.DB struct, connected with the Driver of the data source.
+// The path of a sqlite:// URL is relative unless it starts with a third slash.
 func New(dataSourceName string) (*DB, error) {
-	sqlDB, dbErr := sqlx.Connect("mysql", dataSourceName)
+	driver := Driver(dataSourceName)
+
+	if driver == "sqlite3" {
+		if !sqliteSupported {
+			return nil, ErrSQLiteUnsupported
+		}
+
+		dataSourceName = sqliteDSN(dataSourceName)
+	}
+
+	sqlDB, dbErr := sqlx.Connect(driver, dataSourceName)
 
 	if dbErr != nil {
 		return nil, dbErr
@@ -28,20 +82,52 @@ func New(dataSourceName string) (*DB, error) {
 	}, nil
 }
 
-// Create creates the database if not exists.
-func (db *DB) Create(dbName string) {
-	db.Sqlx.MustExec("CREATE DATABASE IF NOT EXISTS `" + dbName + "` DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci;")
+// Postgres returns true when connected to PostgreSQL.
+func (db *DB) Postgres() bool {
+	return db.Sqlx.DriverName() == "postgres"
+}
+
+// SQLite returns true when connected to a SQLite file.
+func (db *DB) SQLite() bool {
+	return db.Sqlx.DriverName() == "sqlite3"
+}
+
+// Create creates the database if not exists. PostgreSQL databases are
+// created beforehand and named in the URL, SQLite files once opened.
+func (db *DB) Create(dbName string) error {
+	_, err := db.Sqlx.Exec("CREATE DATABASE IF NOT EXISTS `" + dbName + "` DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci;")
+	return err
 }
 
 // Use selects the given database to operate with.
-func (db *DB) Use(dbName string) {
-	db.Sqlx.MustExec("USE " + dbName)
+func (db *DB) Use(dbName string) error {
+	if _, err := db.Sqlx.Exec("USE " + dbName); err != nil {
+		return err
+	}
+
+	db.name = dbName
+	return nil
 }
 
-// Migrate migrates a table.
-func (db *DB) Migrate() {
-	for _, schema := range Schemas() {
-		db.Sqlx.MustExec(schema)
+// Migrate applies the pending migrations. It refuses to run
+// when the applied migrations differ from this binary.
+func (db *DB) Migrate(migrations []Migration) error {
+	done, err := NewMigrator(db, migrations).Up()
+	if err != nil {
+		return err
 	}
-	log.Println("DB Migrated Successfully")
+
+	log.Printf("DB Migrated Successfully, %d migrations applied", len(done))
+	return nil
+}
+
+// sqliteDSN returns the file name of the sqlite:// URL with the sqliteOptions.
+func sqliteDSN(url string) string {
+	path := strings.TrimPrefix(url, "sqlite://")
+
+	if strings.Contains(path, "?") {
+		return "file:" + path + "&" + sqliteOptions
+	}
+
+	return "file:" + path + "?" + sqliteOptions
 }
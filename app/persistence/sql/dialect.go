@@ -0,0 +1,64 @@
+package sql
+
+import (
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// The services of the tables beside the users and posts serve every backend.
+// Their queries are written with ? placeholders, rebound for the driver of
+// the connection, and the few clauses that differ are built below.
+
+// insert runs the INSERT statement of named parameters and returns the id of
+// the new row, which PostgreSQL returns as its driver cannot tell the last one.
+func insert(db sqlx.Ext, query string, arg interface{}) (int64, error) {
+	query, args, err := db.BindNamed(query, arg)
+	if err != nil {
+		return 0, err
+	}
+
+	if db.DriverName() == "postgres" {
+		var id int64
+		err = sqlx.Get(db, &id, query+" RETURNING id", args...)
+		return id, err
+	}
+
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// upsert returns the clause of an INSERT replacing the columns of
+// the row whose key is taken, instead of failing.
+func upsert(db sqlx.Ext, key string, columns ...string) string {
+	set := make([]string, len(columns))
+
+	if db.DriverName() == "mysql" {
+		for i, column := range columns {
+			set[i] = column + " = VALUES(" + column + ")"
+		}
+
+		return " ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+	}
+
+	for i, column := range columns {
+		set[i] = column + " = excluded." + column
+	}
+
+	return " ON CONFLICT (" + key + ") DO UPDATE SET " + strings.Join(set, ", ")
+}
+
+// forUpdate returns the clause locking the selected rows until the end of the
+// transaction. SQLite has none, as its transactions take the write lock when
+// they begin.
+func forUpdate(db sqlx.Ext) string {
+	if db.DriverName() == "sqlite3" {
+		return ""
+	}
+
+	return " FOR UPDATE"
+}
@@ -0,0 +1,206 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rbo13/write-it/app"
+)
+
+// formerMemberType is the user type of the account
+// keeping the posts of the erased users.
+const formerMemberType = "former_member"
+
+var (
+	errErasureNotInserted = app.NewError(app.Internal, "Failed to insert the erasure")
+	errErasureUpdate      = app.NewError(app.Internal, "Failed to update the erasure")
+	errErasureNotFound    = app.NewError(app.NotFound, "Erasure not found")
+	errErasureFailed      = app.NewError(app.Internal, "Failed to erase the user")
+)
+
+// personalTables hold rows that only make sense for their user,
+// they are deleted together with the user.
+var personalTables = []string{
+	"password_resets",
+	"two_factor",
+	"recovery_codes",
+	"access_tokens",
+	"identities",
+	"profiles",
+}
+
+// ErasureService implements the app.ErasureService
+type ErasureService interface {
+	app.ErasureService
+}
+
+// Erasure implements the ErasureService interface
+type Erasure struct {
+	DB *sqlx.DB
+}
+
+// NewErasureSQLService returns the interface that implements the app.ErasureService
+func NewErasureSQLService(db *sqlx.DB) ErasureService {
+	return &Erasure{
+		DB: db,
+	}
+}
+
+// PendingErasure ...
+func (e *Erasure) PendingErasure(userID int64) (*app.Erasure, error) {
+	erasure := new(app.Erasure)
+
+	err := e.DB.Get(erasure, e.DB.Rebind("SELECT * FROM erasures WHERE user_id = ? AND erased_at = 0 ORDER BY id DESC LIMIT 1;"), userID)
+	if err != nil {
+		return nil, QueryError(err, errErasureNotFound)
+	}
+
+	return erasure, nil
+}
+
+// SaveErasure ...
+func (e *Erasure) SaveErasure(erasure *app.Erasure) error {
+	if erasure.ID > 0 {
+		_, err := e.DB.NamedExec("UPDATE erasures SET keep_posts = :keep_posts, confirmed_at = :confirmed_at, erase_after = :erase_after WHERE id = :id AND erased_at = 0", erasure)
+		if err != nil {
+			return errErasureUpdate.Wrap(err)
+		}
+
+		return nil
+	}
+
+	erasure.RequestedAt = time.Now().Unix()
+
+	id, err := insert(e.DB, "INSERT INTO erasures (user_id, keep_posts, requested_at, confirmed_at, erase_after, erased_at) VALUES(:user_id, :keep_posts, :requested_at, :confirmed_at, :erase_after, :erased_at)", erasure)
+	if err != nil {
+		return errErasureNotInserted.Wrap(err)
+	}
+
+	erasure.ID = id
+
+	return nil
+}
+
+// CancelErasure ...
+func (e *Erasure) CancelErasure(userID int64) error {
+	_, err := e.DB.Exec(e.DB.Rebind("DELETE FROM erasures WHERE user_id = ? AND erased_at = 0;"), userID)
+	if err != nil {
+		return errErasureUpdate.Wrap(err)
+	}
+
+	return nil
+}
+
+// DueErasures ...
+func (e *Erasure) DueErasures(now int64) ([]*app.Erasure, error) {
+	erasures := []*app.Erasure{}
+
+	err := e.DB.Select(&erasures, e.DB.Rebind("SELECT * FROM erasures WHERE erased_at = 0 AND confirmed_at > 0 AND erase_after <= ? ORDER BY id;"), now)
+	if err != nil {
+		return nil, QueryError(err, errErasureNotFound)
+	}
+
+	return erasures, nil
+}
+
+// EraseUser ...
+func (e *Erasure) EraseUser(erasure *app.Erasure) error {
+	err := Transact(context.Background(), e.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		var formerMemberID int64
+		var err error
+
+		if erasure.KeepPosts {
+			formerMemberID, err = formerMember(tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err = eraseUser(tx, erasure.UserID, formerMemberID); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(tx.Rebind("UPDATE erasures SET erased_at = ? WHERE id = ?;"), time.Now().Unix(), erasure.ID)
+		return err
+	})
+
+	if err != nil {
+		return TxError(err, errErasureFailed)
+	}
+
+	return nil
+}
+
+// eraseUser deletes the user and every row referencing them. The posts are
+// attributed to formerMemberID, or deleted when it is zero.
+func eraseUser(tx *sqlx.Tx, userID, formerMemberID int64) error {
+	var err error
+
+	if formerMemberID > 0 {
+		_, err = tx.Exec(tx.Rebind("UPDATE posts SET creator_id = ?, version = version + 1 WHERE creator_id = ?;"), formerMemberID, userID)
+	} else {
+		_, err = tx.Exec(tx.Rebind("DELETE FROM posts WHERE creator_id = ?;"), userID)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// The codes issued to the apps of the user go together with the apps
+	_, err = tx.Exec(tx.Rebind("DELETE FROM oauth_codes WHERE user_id = ? OR client_id IN (SELECT client_id FROM oauth_clients WHERE owner_id = ?);"), userID, userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(tx.Rebind("DELETE FROM oauth_clients WHERE owner_id = ?;"), userID); err != nil {
+		return err
+	}
+
+	for _, table := range personalTables {
+		if _, err = tx.Exec(tx.Rebind("DELETE FROM "+table+" WHERE user_id = ?;"), userID); err != nil {
+			return err
+		}
+	}
+
+	res, err := tx.Exec(tx.Rebind("DELETE FROM users WHERE id = ?;"), userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return errUserNotFound
+	}
+
+	return nil
+}
+
+// formerMember returns the id of the account keeping the posts of the erased
+// users, creating it the first time. It has neither email nor password.
+func formerMember(tx *sqlx.Tx) (int64, error) {
+	var id int64
+
+	err := tx.Get(&id, tx.Rebind("SELECT id FROM users WHERE user_type = ? ORDER BY id LIMIT 1"+forUpdate(tx)), formerMemberType)
+	if err == nil {
+		return id, nil
+	}
+
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+
+	return insert(tx, "INSERT INTO users (username, password, user_type, created_at, updated_at, deleted_at) VALUES(:username, '', :user_type, :created_at, :updated_at, 0)", map[string]interface{}{
+		"username":   "former-member",
+		"user_type":  formerMemberType,
+		"created_at": now,
+		"updated_at": now,
+	})
+}
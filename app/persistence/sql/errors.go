@@ -0,0 +1,52 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/rbo13/write-it/app"
+)
+
+// errQuery is returned when the database failed to answer a query.
+var errQuery = app.NewError(app.Internal, "Failed to query the database")
+
+// QueryError returns the error of a service whose query failed: notFound
+// when no row matched, err itself when it is an *app.Error, and otherwise
+// an app.Internal error caused by err, so that the messages of the driver
+// are not shown to clients.
+func QueryError(err error, notFound *app.Error) error {
+	switch {
+	case err == nil:
+		return nil
+	case err == sql.ErrNoRows:
+		return notFound.Wrap(err)
+	case app.ErrorOf(err) != nil:
+		return err
+	}
+
+	return errQuery.Wrap(err)
+}
+
+// CheckVersion returns the error of an update made at the version held by
+// the client, whose result is res: nil when it changed the row, and otherwise
+// notFound when count, a query counting the rows it was meant for, finds
+// none, or changed when the row is at another version.
+func CheckVersion(ctx context.Context, tx *sqlx.Tx, res sql.Result, notFound, changed *app.Error, count string, args ...interface{}) error {
+	affected, err := res.RowsAffected()
+	if err != nil || affected > 0 {
+		return err
+	}
+
+	var n int
+	if err = tx.GetContext(ctx, &n, count, args...); err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return notFound
+	}
+
+	return changed
+}
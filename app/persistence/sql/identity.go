@@ -0,0 +1,70 @@
+package sql
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rbo13/write-it/app"
+)
+
+var (
+	errIdentityNotInserted = app.NewError(app.Internal, "Failed to link the identity")
+	errIdentityNotFound    = app.NewError(app.NotFound, "Identity not found")
+)
+
+// IdentityService implements the app.IdentityService
+type IdentityService interface {
+	app.IdentityService
+}
+
+// Identity implements the IdentityService interface
+type Identity struct {
+	DB *sqlx.DB
+}
+
+// NewIdentitySQLService returns the interface that implements the app.IdentityService
+func NewIdentitySQLService(db *sqlx.DB) IdentityService {
+	return &Identity{
+		DB: db,
+	}
+}
+
+// CreateIdentity ...
+func (i *Identity) CreateIdentity(identity *app.Identity) error {
+	identity.CreatedAt = time.Now().Unix()
+
+	id, err := insert(i.DB, "INSERT INTO identities (user_id, provider, subject, email, created_at) VALUES(:user_id, :provider, :subject, :email, :created_at)", identity)
+	if err != nil {
+		return errIdentityNotInserted.Wrap(err)
+	}
+
+	identity.ID = id
+
+	return nil
+}
+
+// Identity ...
+func (i *Identity) Identity(provider, subject string) (*app.Identity, error) {
+	identity := new(app.Identity)
+
+	err := i.DB.Get(identity, i.DB.Rebind("SELECT * FROM identities WHERE provider = ? AND subject = ? LIMIT 1;"), provider, subject)
+
+	if err != nil {
+		return nil, QueryError(err, errIdentityNotFound)
+	}
+
+	return identity, nil
+}
+
+// Identities ...
+func (i *Identity) Identities(userID int64) ([]*app.Identity, error) {
+	identities := []*app.Identity{}
+
+	err := i.DB.Select(&identities, i.DB.Rebind("SELECT * FROM identities WHERE user_id = ? ORDER BY id;"), userID)
+
+	if err != nil {
+		return nil, QueryError(err, errIdentityNotFound)
+	}
+
+	return identities, nil
+}
@@ -0,0 +1,413 @@
+package sql
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// migrationLock is the name of the lock held while migrating, so
+// that the instances starting together migrate one after the other.
+const migrationLock = "write-it.schema_migrations"
+
+// migrationLockTimeout is how long an instance waits for the lock, in seconds.
+const migrationLockTimeout = 60
+
+// The state of a migration.
+const (
+	MigrationApplied = "applied"
+	MigrationPending = "pending"
+	// MigrationChanged is an applied migration whose statements were edited since.
+	MigrationChanged = "changed"
+	// MigrationUnknown is an applied migration that is not part of this binary.
+	MigrationUnknown = "unknown"
+)
+
+var (
+	errMigrationLock = errors.New("Cannot acquire the migration lock, another instance is migrating")
+	errNothingToUndo = errors.New("No migration to revert")
+)
+
+// Migration changes the schema from the previous version to Version,
+// Down reverts it. Each statement is executed on its own.
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// Checksum returns the SHA-256 of the statements of the migration.
+func (m Migration) Checksum() string {
+	h := sha256.New()
+	for _, stmt := range append(append([]string{}, m.Up...), m.Down...) {
+		h.Write([]byte(strings.TrimSpace(stmt)))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// MigrationStatus compares an applied migration to the one of this binary.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	State     string
+	AppliedAt int64
+}
+
+// DriftError lists the applied migrations that differ from this binary.
+type DriftError struct {
+	Drifted []MigrationStatus
+}
+
+func (e *DriftError) Error() string {
+	versions := make([]string, len(e.Drifted))
+	for i, s := range e.Drifted {
+		versions[i] = fmt.Sprintf("%d (%s)", s.Version, s.State)
+	}
+
+	return "Applied migrations differ from this binary: " + strings.Join(versions, ", ")
+}
+
+// appliedMigration is a row of schema_migrations.
+type appliedMigration struct {
+	Version   int
+	Name      string
+	Checksum  string
+	AppliedAt int64
+}
+
+// Migrator applies and reverts the migrations, recording them in schema_migrations.
+type Migrator struct {
+	db         *DB
+	migrations []Migration
+}
+
+// NewMigrator returns a Migrator of the migrations, sorted by version.
+func NewMigrator(db *DB, migrations []Migration) *Migrator {
+	sorted := append([]Migration{}, migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	return &Migrator{
+		db:         db,
+		migrations: sorted,
+	}
+}
+
+// Status returns the state of every migration, applied or not.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	conn, err := m.conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied, err := m.applied(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	return statuses(applied, m.migrations), nil
+}
+
+// Check returns a *DriftError when the applied migrations differ from this binary.
+func (m *Migrator) Check() error {
+	all, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	return drift(all)
+}
+
+// Up applies the pending migrations in order and returns them.
+// It refuses to run when the applied migrations drifted.
+func (m *Migrator) Up() ([]Migration, error) {
+	var done []Migration
+
+	err := m.locked(func(conn *sql.Conn, all []MigrationStatus) error {
+		for i, s := range all {
+			if s.State != MigrationPending {
+				continue
+			}
+
+			migration := m.migrations[i]
+			if err := m.apply(conn, migration, migration.Up); err != nil {
+				return err
+			}
+
+			_, err := conn.ExecContext(context.Background(), m.db.Sqlx.Rebind("INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES(?, ?, ?, ?);"), migration.Version, migration.Name, migration.Checksum(), time.Now().Unix())
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Migrated up to %d_%s", migration.Version, migration.Name)
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Down reverts the last applied migration and returns it.
+func (m *Migrator) Down() (*Migration, error) {
+	var undone *Migration
+
+	err := m.locked(func(conn *sql.Conn, all []MigrationStatus) error {
+		for i := len(all) - 1; i >= 0; i-- {
+			if all[i].State != MigrationApplied {
+				continue
+			}
+
+			migration := m.migrations[i]
+			if err := m.apply(conn, migration, migration.Down); err != nil {
+				return err
+			}
+
+			if _, err := conn.ExecContext(context.Background(), m.db.Sqlx.Rebind("DELETE FROM schema_migrations WHERE version = ?;"), migration.Version); err != nil {
+				return err
+			}
+
+			log.Printf("Migrated down from %d_%s", migration.Version, migration.Name)
+			undone = &migration
+			return nil
+		}
+
+		return errNothingToUndo
+	})
+
+	return undone, err
+}
+
+// Redo reverts the last applied migration and applies it again.
+func (m *Migrator) Redo() (*Migration, error) {
+	undone, err := m.Down()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = m.Up(); err != nil {
+		return nil, err
+	}
+
+	return undone, nil
+}
+
+// locked runs fn on a connection holding the migration lock, once the
+// schema_migrations table exists and the applied migrations did not drift.
+func (m *Migrator) locked(fn func(*sql.Conn, []MigrationStatus) error) error {
+	ctx := context.Background()
+
+	conn, err := m.conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The lock belongs to the connection, which releases it when closed
+	if err = m.lock(conn); err != nil {
+		return err
+	}
+	defer m.unlock(conn)
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version int NOT NULL,
+			name varchar(255) NOT NULL,
+			checksum char(64) NOT NULL,
+			applied_at bigint,
+			PRIMARY KEY (version)
+		);`)
+	if err != nil {
+		return err
+	}
+
+	applied, err := m.applied(conn)
+	if err != nil {
+		return err
+	}
+
+	all := statuses(applied, m.migrations)
+	if err = drift(all); err != nil {
+		return err
+	}
+
+	return fn(conn, all[:len(m.migrations)])
+}
+
+// lock waits up to migrationLockTimeout for the migration lock.
+// A SQLite file belongs to a single instance, which needs none.
+func (m *Migrator) lock(conn *sql.Conn) error {
+	ctx := context.Background()
+
+	if m.db.SQLite() {
+		return nil
+	}
+
+	if !m.db.Postgres() {
+		var acquired sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?);", migrationLock, migrationLockTimeout).Scan(&acquired); err != nil {
+			return err
+		}
+
+		if acquired.Int64 != 1 {
+			return errMigrationLock
+		}
+
+		return nil
+	}
+
+	// PostgreSQL advisory locks either wait forever or not at all
+	for tries := 0; tries < migrationLockTimeout; tries++ {
+		var acquired bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1));", migrationLock).Scan(&acquired); err != nil {
+			return err
+		}
+
+		if acquired {
+			return nil
+		}
+
+		time.Sleep(time.Second)
+	}
+
+	return errMigrationLock
+}
+
+func (m *Migrator) unlock(conn *sql.Conn) {
+	if m.db.SQLite() {
+		return
+	}
+
+	if m.db.Postgres() {
+		conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1));", migrationLock)
+		return
+	}
+
+	conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?);", migrationLock)
+}
+
+// apply executes the statements of the migration one by one.
+// MySQL commits every schema change, so a failure is not rolled back.
+func (m *Migrator) apply(conn *sql.Conn, migration Migration, statements []string) error {
+	for _, stmt := range statements {
+		if _, err := conn.ExecContext(context.Background(), stmt); err != nil {
+			return fmt.Errorf("migration %d_%s: %v", migration.Version, migration.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// conn returns a connection of its own, using the database of DB.Use.
+func (m *Migrator) conn() (*sql.Conn, error) {
+	conn, err := m.db.Sqlx.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	if m.db.name != "" {
+		if _, err = conn.ExecContext(context.Background(), "USE `"+m.db.name+"`"); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// applied returns the rows of schema_migrations, none before its creation.
+func (m *Migrator) applied(conn *sql.Conn) ([]appliedMigration, error) {
+	ctx := context.Background()
+
+	query := "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'schema_migrations';"
+	switch {
+	case m.db.Postgres():
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schema_migrations';"
+	case m.db.SQLite():
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations';"
+	}
+
+	var exists int
+	err := conn.QueryRowContext(ctx, query).Scan(&exists)
+	if err != nil || exists == 0 {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applied []appliedMigration
+	for rows.Next() {
+		var a appliedMigration
+		if err = rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied = append(applied, a)
+	}
+
+	return applied, rows.Err()
+}
+
+// statuses returns the status of every migration, followed by the
+// applied migrations that this binary does not know.
+func statuses(applied []appliedMigration, migrations []Migration) []MigrationStatus {
+	byVersion := map[int]appliedMigration{}
+	for _, a := range applied {
+		byVersion[a.Version] = a
+	}
+
+	all := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		s := MigrationStatus{Version: migration.Version, Name: migration.Name, State: MigrationPending}
+
+		if a, ok := byVersion[migration.Version]; ok {
+			s.State = MigrationApplied
+			s.AppliedAt = a.AppliedAt
+
+			if a.Checksum != migration.Checksum() {
+				s.State = MigrationChanged
+			}
+
+			delete(byVersion, migration.Version)
+		}
+
+		all = append(all, s)
+	}
+
+	for _, a := range applied {
+		if _, ok := byVersion[a.Version]; ok {
+			all = append(all, MigrationStatus{Version: a.Version, Name: a.Name, State: MigrationUnknown, AppliedAt: a.AppliedAt})
+		}
+	}
+
+	return all
+}
+
+func drift(all []MigrationStatus) error {
+	var drifted []MigrationStatus
+	for _, s := range all {
+		if s.State == MigrationChanged || s.State == MigrationUnknown {
+			drifted = append(drifted, s)
+		}
+	}
+
+	if len(drifted) > 0 {
+		return &DriftError{Drifted: drifted}
+	}
+
+	return nil
+}
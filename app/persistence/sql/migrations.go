@@ -0,0 +1,307 @@
+package sql
+
+// Migrations are the versions of the schema, in order. A released migration
+// is never edited, as its checksum would no longer match the applied one:
+// the schema is changed by appending a new migration.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		// The tables may already exist, they were created at every start before
+		Up: []string{
+			`
+			CREATE TABLE IF NOT EXISTS users (
+				id bigint NOT NULL AUTO_INCREMENT,
+				username varchar(16),
+				email varchar(151),
+				password varchar(255),
+				user_type varchar(255),
+				created_at bigint,
+				updated_at bigint,
+				deleted_at bigint,
+				PRIMARY KEY (id)
+			);`,
+
+			`
+			CREATE TABLE IF NOT EXISTS posts (
+				id bigint NOT NULL AUTO_INCREMENT,
+				creator_id bigint,
+				post_title text,
+				post_body text,
+				created_at bigint,
+				updated_at bigint,
+				deleted_at bigint,
+				PRIMARY KEY (id),
+				FOREIGN KEY (creator_id) REFERENCES users(id)
+			);`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS posts;",
+			"DROP TABLE IF EXISTS users;",
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_email_verified_at",
+		// The users created before email verification are left unverified
+		Up: []string{
+			"ALTER TABLE users ADD email_verified_at bigint DEFAULT 0;",
+		},
+		Down: []string{
+			"ALTER TABLE users DROP email_verified_at;",
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add_sessions_revoked_at",
+		Up: []string{
+			"ALTER TABLE users ADD sessions_revoked_at bigint DEFAULT 0;",
+		},
+		Down: []string{
+			"ALTER TABLE users DROP sessions_revoked_at;",
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_versions",
+		// Sent as the ETag of the users and posts, so that concurrent updates do not clobber each other
+		Up: []string{
+			"ALTER TABLE users ADD version bigint NOT NULL DEFAULT 1;",
+			"ALTER TABLE posts ADD version bigint NOT NULL DEFAULT 1;",
+		},
+		Down: []string{
+			"ALTER TABLE posts DROP version;",
+			"ALTER TABLE users DROP version;",
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_unique_usernames",
+		// The public profiles are found by username. Of the users sharing one,
+		// the first to register keeps it and the others are renamed by their id.
+		Up: []string{
+			"UPDATE users SET username = CONCAT('user', id) WHERE id NOT IN (SELECT id FROM (SELECT MIN(id) AS id FROM users GROUP BY username) AS kept);",
+			"CREATE UNIQUE INDEX users_username ON users (username);",
+		},
+		Down: []string{
+			"DROP INDEX users_username ON users;",
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_password_resets",
+		// Like the users and posts, this and the tables of the next
+		// migrations may already exist
+		Up: []string{
+			`
+			CREATE TABLE IF NOT EXISTS password_resets (
+				id bigint NOT NULL AUTO_INCREMENT,
+				user_id bigint NOT NULL,
+				token_hash char(64) NOT NULL,
+				expires_at bigint,
+				used_at bigint DEFAULT 0,
+				created_at bigint,
+				PRIMARY KEY (id),
+				UNIQUE KEY (token_hash),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS password_resets;",
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_two_factor",
+		Up: []string{
+			`
+			CREATE TABLE IF NOT EXISTS two_factor (
+				user_id bigint NOT NULL,
+				secret varchar(64) NOT NULL,
+				enabled_at bigint DEFAULT 0,
+				last_step bigint DEFAULT 0,
+				created_at bigint,
+				PRIMARY KEY (user_id),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);`,
+
+			`
+			CREATE TABLE IF NOT EXISTS recovery_codes (
+				id bigint NOT NULL AUTO_INCREMENT,
+				user_id bigint NOT NULL,
+				code_hash char(64) NOT NULL,
+				used_at bigint DEFAULT 0,
+				PRIMARY KEY (id),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);`,
+
+			`
+			CREATE TABLE IF NOT EXISTS role_policies (
+				user_type varchar(255) NOT NULL,
+				require_two_factor boolean DEFAULT false,
+				PRIMARY KEY (user_type)
+			);`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS role_policies;",
+			"DROP TABLE IF EXISTS recovery_codes;",
+			"DROP TABLE IF EXISTS two_factor;",
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add_access_tokens",
+		Up: []string{
+			`
+			CREATE TABLE IF NOT EXISTS access_tokens (
+				id bigint NOT NULL AUTO_INCREMENT,
+				user_id bigint NOT NULL,
+				name varchar(255),
+				token_hash char(64) NOT NULL,
+				scopes varchar(255),
+				expires_at bigint DEFAULT 0,
+				last_used_at bigint DEFAULT 0,
+				created_at bigint,
+				PRIMARY KEY (id),
+				UNIQUE KEY (token_hash),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS access_tokens;",
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add_identities",
+		Up: []string{
+			`
+			CREATE TABLE IF NOT EXISTS identities (
+				id bigint NOT NULL AUTO_INCREMENT,
+				user_id bigint NOT NULL,
+				provider varchar(64) NOT NULL,
+				subject varchar(255) NOT NULL,
+				email varchar(255),
+				created_at bigint,
+				PRIMARY KEY (id),
+				UNIQUE KEY (provider, subject),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS identities;",
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add_oauth",
+		Up: []string{
+			`
+			CREATE TABLE IF NOT EXISTS oauth_clients (
+				id bigint NOT NULL AUTO_INCREMENT,
+				client_id varchar(64) NOT NULL,
+				secret_hash char(64),
+				name varchar(255),
+				owner_id bigint NOT NULL,
+				redirect_uris text,
+				scopes varchar(255),
+				created_at bigint,
+				PRIMARY KEY (id),
+				UNIQUE KEY (client_id),
+				FOREIGN KEY (owner_id) REFERENCES users(id)
+			);`,
+
+			`
+			CREATE TABLE IF NOT EXISTS oauth_codes (
+				id bigint NOT NULL AUTO_INCREMENT,
+				code_hash char(64) NOT NULL,
+				client_id varchar(64) NOT NULL,
+				user_id bigint NOT NULL,
+				redirect_uri text,
+				scopes varchar(255),
+				code_challenge varchar(128),
+				expires_at bigint NOT NULL,
+				used_at bigint DEFAULT 0,
+				created_at bigint,
+				PRIMARY KEY (id),
+				UNIQUE KEY (code_hash),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS oauth_codes;",
+			"DROP TABLE IF EXISTS oauth_clients;",
+		},
+	},
+	{
+		Version: 11,
+		Name:    "add_profiles",
+		Up: []string{
+			`
+			CREATE TABLE IF NOT EXISTS profiles (
+				user_id bigint NOT NULL,
+				display_name varchar(64),
+				bio text,
+				website varchar(255),
+				location varchar(64),
+				social_links text,
+				avatar_updated_at bigint DEFAULT 0,
+				updated_at bigint,
+				PRIMARY KEY (user_id),
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS profiles;",
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add_erasures",
+		Up: []string{
+			`
+			CREATE TABLE IF NOT EXISTS erasures (
+				id bigint NOT NULL AUTO_INCREMENT,
+				user_id bigint NOT NULL,
+				keep_posts boolean DEFAULT false,
+				requested_at bigint,
+				confirmed_at bigint DEFAULT 0,
+				erase_after bigint DEFAULT 0,
+				erased_at bigint DEFAULT 0,
+				PRIMARY KEY (id),
+				KEY (user_id)
+			);`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS erasures;",
+		},
+	},
+	{
+		Version: 13,
+		Name:    "add_audit_log",
+		Up: []string{
+			`
+			CREATE TABLE IF NOT EXISTS audit_log (
+				id bigint NOT NULL AUTO_INCREMENT,
+				actor_id bigint DEFAULT 0,
+				action varchar(64) NOT NULL,
+				target_type varchar(32),
+				target_id varchar(64),
+				diff text,
+				ip varchar(45),
+				request_id varchar(64),
+				created_at bigint,
+				prev_hash char(64),
+				hash char(64) NOT NULL,
+				PRIMARY KEY (id),
+				KEY (actor_id),
+				KEY (action),
+				KEY (target_type, target_id),
+				KEY (created_at)
+			);`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS audit_log;",
+		},
+	},
+}
@@ -0,0 +1,38 @@
+package sql_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/rbo13/write-it/app/persistence/sql"
+)
+
+var migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+func TestMigrations(t *testing.T) {
+	t.Run("Versions", func(t *testing.T) {
+		for i, m := range sql.Migrations {
+			if m.Version != i+1 {
+				t.Errorf("Expecting: %v, but got: %v instead", i+1, m.Version)
+			}
+
+			if !migrationName.MatchString(m.Name) || len(m.Up) == 0 || len(m.Down) == 0 {
+				t.Errorf("Expecting: %v, but got: %v instead", "a named and reversible migration", m.Version)
+			}
+		}
+	})
+
+	t.Run("Checksum", func(t *testing.T) {
+		m := sql.Migration{Version: 1, Name: "add_column", Up: []string{"ALTER TABLE users ADD bio text;"}, Down: []string{"ALTER TABLE users DROP bio;"}}
+		reformatted := sql.Migration{Version: 1, Name: "add_column", Up: []string{"\n\tALTER TABLE users ADD bio text;\n"}, Down: m.Down}
+		edited := sql.Migration{Version: 1, Name: "add_column", Up: []string{"ALTER TABLE users ADD bio varchar(255);"}, Down: m.Down}
+
+		if m.Checksum() != reformatted.Checksum() {
+			t.Errorf("Expecting: %v, but got: %v instead", m.Checksum(), reformatted.Checksum())
+		}
+
+		if m.Checksum() == edited.Checksum() {
+			t.Errorf("Expecting: %v, but got: %v instead", "another checksum", edited.Checksum())
+		}
+	})
+}
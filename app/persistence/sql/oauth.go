@@ -0,0 +1,154 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rbo13/write-it/app"
+)
+
+var (
+	errOAuthClientNotInserted = app.NewError(app.Internal, "Failed to register the OAuth client")
+	errOAuthClientDelete      = app.NewError(app.Internal, "Failed to delete the OAuth client")
+	errOAuthClientNotFound    = app.NewError(app.NotFound, "OAuth client not found")
+	errOAuthCodeNotInserted   = app.NewError(app.Internal, "Failed to insert the authorization code")
+	errOAuthCodeInvalid       = app.NewError(app.Validation, "Authorization code is invalid or expired")
+	errOAuthCodeUpdate        = app.NewError(app.Internal, "Failed to use the authorization code")
+)
+
+// OAuthService implements the app.OAuthService
+type OAuthService interface {
+	app.OAuthService
+}
+
+// OAuth implements the OAuthService interface
+type OAuth struct {
+	DB *sqlx.DB
+}
+
+// NewOAuthSQLService returns the interface that implements the app.OAuthService
+func NewOAuthSQLService(db *sqlx.DB) OAuthService {
+	return &OAuth{
+		DB: db,
+	}
+}
+
+// CreateOAuthClient ...
+func (o *OAuth) CreateOAuthClient(client *app.OAuthClient) error {
+	client.CreatedAt = time.Now().Unix()
+
+	id, err := insert(o.DB, "INSERT INTO oauth_clients (client_id, secret_hash, name, owner_id, redirect_uris, scopes, created_at) VALUES(:client_id, :secret_hash, :name, :owner_id, :redirect_uris, :scopes, :created_at)", client)
+	if err != nil {
+		return errOAuthClientNotInserted.Wrap(err)
+	}
+
+	client.ID = id
+
+	return nil
+}
+
+// OAuthClient ...
+func (o *OAuth) OAuthClient(clientID string) (*app.OAuthClient, error) {
+	client := new(app.OAuthClient)
+
+	err := o.DB.Get(client, o.DB.Rebind("SELECT * FROM oauth_clients WHERE client_id = ? LIMIT 1;"), clientID)
+
+	if err != nil {
+		return nil, QueryError(err, errOAuthClientNotFound)
+	}
+
+	return client, nil
+}
+
+// OAuthClients ...
+func (o *OAuth) OAuthClients(ownerID int64) ([]*app.OAuthClient, error) {
+	clients := []*app.OAuthClient{}
+
+	err := o.DB.Select(&clients, o.DB.Rebind("SELECT * FROM oauth_clients WHERE owner_id = ? ORDER BY id DESC;"), ownerID)
+
+	if err != nil {
+		return nil, QueryError(err, errOAuthClientNotFound)
+	}
+
+	return clients, nil
+}
+
+// DeleteOAuthClient ...
+func (o *OAuth) DeleteOAuthClient(clientID string, ownerID int64) error {
+	err := Transact(context.Background(), o.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		res, err := tx.Exec(tx.Rebind("DELETE FROM oauth_clients WHERE client_id = ? AND owner_id = ?;"), clientID, ownerID)
+		if err != nil {
+			return err
+		}
+
+		if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+			return errOAuthClientNotFound.Wrap(err)
+		}
+
+		_, err = tx.Exec(tx.Rebind("DELETE FROM oauth_codes WHERE client_id = ?;"), clientID)
+		return err
+	})
+
+	if err != nil {
+		return TxError(err, errOAuthClientDelete)
+	}
+
+	return nil
+}
+
+// CreateOAuthCode ...
+func (o *OAuth) CreateOAuthCode(code *app.OAuthCode) error {
+	code.CreatedAt = time.Now().Unix()
+
+	id, err := insert(o.DB, "INSERT INTO oauth_codes (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at, used_at, created_at) VALUES(:code_hash, :client_id, :user_id, :redirect_uri, :scopes, :code_challenge, :expires_at, :used_at, :created_at)", code)
+	if err != nil {
+		return errOAuthCodeNotInserted.Wrap(err)
+	}
+
+	code.ID = id
+
+	return nil
+}
+
+// OAuthCode ...
+func (o *OAuth) OAuthCode(codeHash string) (*app.OAuthCode, error) {
+	code := new(app.OAuthCode)
+
+	err := o.DB.Get(code, o.DB.Rebind("SELECT * FROM oauth_codes WHERE code_hash = ? AND used_at = 0 AND expires_at > ? LIMIT 1;"), codeHash, time.Now().Unix())
+	if err != nil {
+		return nil, QueryError(err, errOAuthCodeInvalid)
+	}
+
+	return code, nil
+}
+
+// UseOAuthCode ...
+func (o *OAuth) UseOAuthCode(codeHash string) (*app.OAuthCode, error) {
+	now := time.Now().Unix()
+
+	code := new(app.OAuthCode)
+
+	err := Transact(context.Background(), o.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		// Lock the row so that the same code cannot be exchanged twice concurrently.
+		err := tx.Get(code, tx.Rebind("SELECT * FROM oauth_codes WHERE code_hash = ? AND used_at = 0 AND expires_at > ? LIMIT 1"+forUpdate(tx)), codeHash, now)
+		if err == sql.ErrNoRows {
+			return errOAuthCodeInvalid
+		}
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(tx.Rebind("UPDATE oauth_codes SET used_at = ? WHERE id = ?;"), now, code.ID)
+		return err
+	})
+
+	if err != nil {
+		return nil, TxError(err, errOAuthCodeUpdate)
+	}
+
+	code.UsedAt = now
+	return code, nil
+}
@@ -0,0 +1,96 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rbo13/write-it/app"
+)
+
+var (
+	errPasswordResetNotInserted = app.NewError(app.Internal, "Failed to insert the password reset")
+	errPasswordResetInvalid     = app.NewError(app.Validation, "Password reset token is invalid or expired")
+	errPasswordResetUpdate      = app.NewError(app.Internal, "Failed to use the password reset")
+)
+
+// PasswordResetService implements the app.PasswordResetService
+type PasswordResetService interface {
+	app.PasswordResetService
+}
+
+// PasswordReset implements the PasswordResetService interface
+type PasswordReset struct {
+	DB *sqlx.DB
+}
+
+// NewPasswordResetSQLService returns the interface that implements the app.PasswordResetService
+func NewPasswordResetSQLService(db *sqlx.DB) PasswordResetService {
+	return &PasswordReset{
+		DB: db,
+	}
+}
+
+// CreatePasswordReset ...
+func (p *PasswordReset) CreatePasswordReset(reset *app.PasswordReset) error {
+	reset.CreatedAt = time.Now().Unix()
+
+	id, err := insert(p.DB, "INSERT INTO password_resets (user_id, token_hash, expires_at, used_at, created_at) VALUES(:user_id, :token_hash, :expires_at, :used_at, :created_at)", reset)
+	if err != nil {
+		return errPasswordResetNotInserted.Wrap(err)
+	}
+
+	reset.ID = id
+
+	return nil
+}
+
+// PasswordReset ...
+func (p *PasswordReset) PasswordReset(tokenHash string) (*app.PasswordReset, error) {
+	reset := new(app.PasswordReset)
+
+	err := p.DB.Get(reset, p.DB.Rebind("SELECT * FROM password_resets WHERE token_hash = ? AND used_at = 0 AND expires_at > ? LIMIT 1;"), tokenHash, time.Now().Unix())
+	if err != nil {
+		return nil, QueryError(err, errPasswordResetInvalid)
+	}
+
+	return reset, nil
+}
+
+// UsePasswordReset ...
+func (p *PasswordReset) UsePasswordReset(tokenHash string) (*app.PasswordReset, error) {
+	return p.UsePasswordResetContext(context.Background(), tokenHash)
+}
+
+// UsePasswordResetContext ...
+func (p *PasswordReset) UsePasswordResetContext(ctx context.Context, tokenHash string) (*app.PasswordReset, error) {
+	now := time.Now().Unix()
+
+	reset := new(app.PasswordReset)
+
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := Transact(ctx, p.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		// Lock the row so that the same token cannot be used twice concurrently.
+		err := tx.GetContext(ctx, reset, tx.Rebind("SELECT * FROM password_resets WHERE token_hash = ? AND used_at = 0 AND expires_at > ? LIMIT 1"+forUpdate(tx)), tokenHash, now)
+		if err == sql.ErrNoRows {
+			return errPasswordResetInvalid
+		}
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, tx.Rebind("UPDATE password_resets SET used_at = ? WHERE user_id = ? AND used_at = 0;"), now, reset.UserID)
+		return err
+	})
+
+	if err != nil {
+		return nil, TxError(err, errPasswordResetUpdate)
+	}
+
+	reset.UsedAt = now
+	return reset, nil
+}
@@ -1,7 +1,7 @@
 package sql
 
 import (
-	"errors"
+	"context"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -9,11 +9,13 @@ import (
 )
 
 var (
-	errEmpty       = errors.New("error: Post is required")
-	errNotInserted = errors.New("error: Not inserted")
-	errNoID        = errors.New("error: ID is required")
-	errPostDelete  = errors.New("error: Post deletion")
-	errPostUpdate  = errors.New("error: Post update")
+	errEmpty        = app.NewError(app.Validation, "error: Post is required")
+	errNotInserted  = app.NewError(app.Internal, "error: Not inserted")
+	errNoID         = app.NewError(app.Validation, "error: ID is required")
+	errPostDelete   = app.NewError(app.Internal, "error: Post deletion")
+	errPostUpdate   = app.NewError(app.Internal, "error: Post update")
+	errPostNotFound = app.NewError(app.NotFound, "error: Post not found")
+	errPostChanged  = app.NewError(app.PreconditionFailed, "error: Post was changed since it was read")
 )
 
 // PostService implements the app.UserService
@@ -37,36 +39,58 @@ func NewPostSQLService(db *sqlx.DB) PostService {
 
 // CreatePost ...
 func (p *Post) CreatePost(post *app.Post) error {
+	return p.CreatePostContext(context.Background(), post)
+}
+
+// CreatePostContext ...
+func (p *Post) CreatePostContext(ctx context.Context, post *app.Post) error {
 	if post == nil {
 		return errEmpty
 	}
 
-	tx := p.DB.MustBegin()
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
 
 	post.CreatedAt = time.Now().Unix()
+	post.Version = 1
+
+	err := Transact(ctx, p.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		res, err := tx.NamedExecContext(ctx, "INSERT INTO posts (creator_id, post_title, post_body, created_at, deleted_at, updated_at) VALUES(:creator_id, :post_title, :post_body, :created_at, :deleted_at, :updated_at)", post)
+		if err != nil {
+			return err
+		}
 
-	res, err := tx.NamedExec("INSERT INTO posts (creator_id, post_title, post_body, created_at, deleted_at, updated_at) VALUES(:creator_id, :post_title, :post_body, :created_at, :deleted_at, :updated_at)", &post)
+		post.ID, err = res.LastInsertId()
+		return err
+	})
 
-	if err != nil && res == nil {
-		tx.Rollback()
-		return errNotInserted
+	if err != nil {
+		return TxError(err, errNotInserted)
 	}
-	tx.Commit()
+
 	return nil
 }
 
 // Post ...
 func (p *Post) Post(id int64) (*app.Post, error) {
+	return p.PostContext(context.Background(), id)
+}
+
+// PostContext ...
+func (p *Post) PostContext(ctx context.Context, id int64) (*app.Post, error) {
 	if id <= 0 {
 		return nil, errNoID
 	}
 
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
 	post := new(app.Post)
 
-	err := p.DB.Get(post, "SELECT * FROM posts WHERE id = ? LIMIT 1;", id)
+	err := Conn(ctx, p.DB).GetContext(ctx, post, "SELECT * FROM posts WHERE id = ? LIMIT 1;", id)
 
 	if err != nil {
-		return nil, err
+		return nil, QueryError(err, errPostNotFound)
 	}
 
 	return post, nil
@@ -74,43 +98,112 @@ func (p *Post) Post(id int64) (*app.Post, error) {
 
 // Posts ...
 func (p *Post) Posts() ([]*app.Post, error) {
+	return p.PostsContext(context.Background())
+}
+
+// PostsContext ...
+func (p *Post) PostsContext(ctx context.Context) ([]*app.Post, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
 	posts := []*app.Post{}
 
-	err := p.DB.Select(&posts, "SELECT * FROM posts ORDER BY id DESC;")
+	err := Conn(ctx, p.DB).SelectContext(ctx, &posts, "SELECT * FROM posts ORDER BY id DESC;")
 
 	if err != nil {
-		return nil, err
+		return nil, QueryError(err, errPostNotFound)
 	}
 	return posts, nil
 }
 
+// PostsByCreator ...
+func (p *Post) PostsByCreator(creatorID, afterID int64, limit int) ([]*app.Post, error) {
+	return p.PostsByCreatorContext(context.Background(), creatorID, afterID, limit)
+}
+
+// PostsByCreatorContext ...
+func (p *Post) PostsByCreatorContext(ctx context.Context, creatorID, afterID int64, limit int) ([]*app.Post, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	posts := []*app.Post{}
+
+	err := Conn(ctx, p.DB).SelectContext(ctx, &posts, "SELECT * FROM posts WHERE creator_id = ? AND id > ? ORDER BY id LIMIT ?;", creatorID, afterID, limit)
+
+	if err != nil {
+		return nil, QueryError(err, errPostNotFound)
+	}
+	return posts, nil
+}
+
+// CountPostsByCreator ...
+func (p *Post) CountPostsByCreator(creatorID int64) (int, error) {
+	return p.CountPostsByCreatorContext(context.Background(), creatorID)
+}
+
+// CountPostsByCreatorContext ...
+func (p *Post) CountPostsByCreatorContext(ctx context.Context, creatorID int64) (int, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	var count int
+
+	err := Conn(ctx, p.DB).GetContext(ctx, &count, "SELECT COUNT(*) FROM posts WHERE creator_id = ?;", creatorID)
+
+	if err != nil {
+		return 0, QueryError(err, errPostNotFound)
+	}
+	return count, nil
+}
+
 // UpdatePost ...
 func (p *Post) UpdatePost(post *app.Post) error {
+	return p.UpdatePostContext(context.Background(), post)
+}
+
+// UpdatePostContext ...
+func (p *Post) UpdatePostContext(ctx context.Context, post *app.Post) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
 	post.UpdatedAt = time.Now().Unix()
 
-	tx := p.DB.MustBegin()
-	res := tx.MustExec("UPDATE posts SET post_title = ?, post_body = ?, created_at = ?, updated_at = ? WHERE id = ? AND creator_id = ? LIMIT 1;", post.PostTitle, post.PostBody, post.CreatedAt, post.UpdatedAt, post.ID, post.CreatorID)
+	err := Transact(ctx, p.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE posts SET post_title = ?, post_body = ?, created_at = ?, updated_at = ?, version = version + 1 WHERE id = ? AND creator_id = ? AND version = ? LIMIT 1;", post.PostTitle, post.PostBody, post.CreatedAt, post.UpdatedAt, post.ID, post.CreatorID, post.Version)
+		if err != nil {
+			return err
+		}
+
+		// A post of another creator is not found, as for them it does not exist
+		return CheckVersion(ctx, tx, res, errPostNotFound, errPostChanged, "SELECT COUNT(*) FROM posts WHERE id = ? AND creator_id = ?;", post.ID, post.CreatorID)
+	})
 
-	if res == nil {
-		tx.Rollback()
-		return errPostUpdate
+	if err != nil {
+		return TxError(err, errPostUpdate)
 	}
 
-	tx.Commit()
+	post.Version++
 	return nil
 }
 
 // DeletePost ...
 func (p *Post) DeletePost(id int64) error {
-	tx := p.DB.MustBegin()
+	return p.DeletePostContext(context.Background(), id)
+}
 
-	res := tx.MustExec("DELETE FROM posts WHERE id = ?;", id)
+// DeletePostContext ...
+func (p *Post) DeletePostContext(ctx context.Context, id int64) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
 
-	if res == nil {
-		tx.Rollback()
-		return errPostDelete
+	err := Transact(ctx, p.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM posts WHERE id = ?;", id)
+		return err
+	})
+
+	if err != nil {
+		return TxError(err, errPostDelete)
 	}
 
-	tx.Commit()
 	return nil
 }
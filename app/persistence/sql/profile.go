@@ -0,0 +1,57 @@
+package sql
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rbo13/write-it/app"
+)
+
+var (
+	errProfileNotSaved = app.NewError(app.Internal, "Failed to save the profile")
+	errProfileNotFound = app.NewError(app.NotFound, "Profile not found")
+)
+
+// ProfileService implements the app.ProfileService
+type ProfileService interface {
+	app.ProfileService
+}
+
+// Profile implements the ProfileService interface
+type Profile struct {
+	DB *sqlx.DB
+}
+
+// NewProfileSQLService returns the interface that implements the app.ProfileService
+func NewProfileSQLService(db *sqlx.DB) ProfileService {
+	return &Profile{
+		DB: db,
+	}
+}
+
+// Profile ...
+func (p *Profile) Profile(userID int64) (*app.Profile, error) {
+	profile := new(app.Profile)
+
+	err := p.DB.Get(profile, p.DB.Rebind("SELECT * FROM profiles WHERE user_id = ? LIMIT 1;"), userID)
+
+	if err != nil {
+		return nil, QueryError(err, errProfileNotFound)
+	}
+
+	return profile, nil
+}
+
+// SaveProfile creates the profile of the user or replaces it.
+func (p *Profile) SaveProfile(profile *app.Profile) error {
+	profile.UpdatedAt = time.Now().Unix()
+
+	_, err := p.DB.NamedExec(`INSERT INTO profiles (user_id, display_name, bio, website, location, social_links, avatar_updated_at, updated_at)
+		VALUES(:user_id, :display_name, :bio, :website, :location, :social_links, :avatar_updated_at, :updated_at)`+
+		upsert(p.DB, "user_id", "display_name", "bio", "website", "location", "social_links", "avatar_updated_at", "updated_at"), profile)
+	if err != nil {
+		return errProfileNotSaved.Wrap(err)
+	}
+
+	return nil
+}
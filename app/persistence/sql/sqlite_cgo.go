@@ -0,0 +1,7 @@
+//go:build cgo
+// +build cgo
+
+package sql
+
+// sqliteSupported tells whether the SQLite driver works, which needs cgo.
+const sqliteSupported = true
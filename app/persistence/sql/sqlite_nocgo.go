@@ -0,0 +1,8 @@
+//go:build !cgo
+// +build !cgo
+
+package sql
+
+// sqliteSupported tells whether the SQLite driver works, which needs cgo.
+// Without it, the driver is a stub failing every connection.
+const sqliteSupported = false
@@ -0,0 +1,88 @@
+package sql_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/passwords"
+	"github.com/rbo13/write-it/app/persistence/sql"
+	"github.com/rbo13/write-it/app/persistence/storetest"
+)
+
+// auxiliaryTables are emptied in this order, the referencing rows first.
+var auxiliaryTables = []string{
+	"oauth_codes", "oauth_clients", "password_resets", "recovery_codes", "two_factor", "role_policies",
+	"access_tokens", "identities", "profiles", "erasures", "audit_log", "posts", "users",
+}
+
+// TestStores runs against the scratch database named in MYSQL_DSN,
+// e.g. root:@tcp(127.0.0.1:3306)/writeit_test, whose rows it deletes.
+func TestStores(t *testing.T) {
+	dsn := os.Getenv("MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("MYSQL_DSN is not set")
+	}
+
+	db, err := sql.New(dsn)
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+	defer db.Sqlx.Close()
+
+	if err = db.Migrate(sql.Migrations); err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	hasher := passwords.New(passwords.Bcrypt{Cost: 4})
+
+	storetest.Run(t, func(t *testing.T) (app.UserService, app.PostService) {
+		for _, table := range []string{"posts", "users"} {
+			if _, err := db.Sqlx.Exec("DELETE FROM " + table + ";"); err != nil {
+				t.Fatalf("Error occurred due to: %v", err)
+			}
+		}
+
+		return sql.NewUserSQLService(db.Sqlx, nil, hasher), sql.NewPostSQLService(db.Sqlx)
+	})
+}
+
+func TestAuxiliaryStores(t *testing.T) {
+	dsn := os.Getenv("MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("MYSQL_DSN is not set")
+	}
+
+	db, err := sql.New(dsn)
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+	defer db.Sqlx.Close()
+
+	if err = db.Migrate(sql.Migrations); err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	hasher := passwords.New(passwords.Bcrypt{Cost: 4})
+
+	storetest.RunAuxiliary(t, func(t *testing.T) storetest.Auxiliary {
+		for _, table := range auxiliaryTables {
+			if _, err := db.Sqlx.Exec("DELETE FROM " + table + ";"); err != nil {
+				t.Fatalf("Error occurred due to: %v", err)
+			}
+		}
+
+		return storetest.Auxiliary{
+			Users:          sql.NewUserSQLService(db.Sqlx, nil, hasher),
+			Posts:          sql.NewPostSQLService(db.Sqlx),
+			PasswordResets: sql.NewPasswordResetSQLService(db.Sqlx),
+			TwoFactor:      sql.NewTwoFactorSQLService(db.Sqlx),
+			AccessTokens:   sql.NewAccessTokenSQLService(db.Sqlx),
+			Identities:     sql.NewIdentitySQLService(db.Sqlx),
+			OAuth:          sql.NewOAuthSQLService(db.Sqlx),
+			Profiles:       sql.NewProfileSQLService(db.Sqlx),
+			Erasures:       sql.NewErasureSQLService(db.Sqlx),
+			Audit:          sql.NewAuditSQLService(db.Sqlx),
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rbo13/write-it/app"
+)
+
+var (
+	errTwoFactorNotSaved  = app.NewError(app.Internal, "Failed to save the two-factor authentication")
+	errTwoFactorNotFound  = app.NewError(app.NotFound, "Two-factor authentication is not enrolled")
+	errStepAlreadyUsed    = app.NewError(app.Unauthorized, "Authentication code was already used")
+	errRecoveryCodeUnused = app.NewError(app.Unauthorized, "Recovery code is invalid or already used")
+	errRolePolicyNotSaved = app.NewError(app.Internal, "Failed to save the role policy")
+)
+
+// TwoFactorService implements the app.TwoFactorService
+type TwoFactorService interface {
+	app.TwoFactorService
+}
+
+// TwoFactor implements the TwoFactorService interface
+type TwoFactor struct {
+	DB *sqlx.DB
+}
+
+// NewTwoFactorSQLService returns the interface that implements the app.TwoFactorService
+func NewTwoFactorSQLService(db *sqlx.DB) TwoFactorService {
+	return &TwoFactor{
+		DB: db,
+	}
+}
+
+// TwoFactor ...
+func (t *TwoFactor) TwoFactor(userID int64) (*app.TwoFactor, error) {
+	twoFactor := new(app.TwoFactor)
+
+	err := t.DB.Get(twoFactor, t.DB.Rebind("SELECT * FROM two_factor WHERE user_id = ? LIMIT 1;"), userID)
+
+	if err != nil {
+		return nil, QueryError(err, errTwoFactorNotFound)
+	}
+
+	return twoFactor, nil
+}
+
+// SaveTwoFactor ...
+func (t *TwoFactor) SaveTwoFactor(twoFactor *app.TwoFactor) error {
+	if twoFactor.CreatedAt == 0 {
+		twoFactor.CreatedAt = time.Now().Unix()
+	}
+
+	_, err := t.DB.NamedExec("INSERT INTO two_factor (user_id, secret, enabled_at, last_step, created_at) VALUES(:user_id, :secret, :enabled_at, :last_step, :created_at)"+
+		upsert(t.DB, "user_id", "secret", "enabled_at", "last_step", "created_at"), twoFactor)
+
+	if err != nil {
+		return errTwoFactorNotSaved.Wrap(err)
+	}
+
+	return nil
+}
+
+// DeleteTwoFactor ...
+func (t *TwoFactor) DeleteTwoFactor(userID int64) error {
+	err := Transact(context.Background(), t.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		if _, err := tx.Exec(tx.Rebind("DELETE FROM recovery_codes WHERE user_id = ?;"), userID); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(tx.Rebind("DELETE FROM two_factor WHERE user_id = ?;"), userID)
+		return err
+	})
+
+	if err != nil {
+		return TxError(err, errTwoFactorNotSaved)
+	}
+
+	return nil
+}
+
+// UseStep ...
+func (t *TwoFactor) UseStep(userID, step int64) error {
+	res, err := t.DB.Exec(t.DB.Rebind("UPDATE two_factor SET last_step = ? WHERE user_id = ? AND last_step < ?;"), step, userID, step)
+	if err != nil {
+		return errTwoFactorNotSaved.Wrap(err)
+	}
+
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		return errStepAlreadyUsed
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes ...
+func (t *TwoFactor) ReplaceRecoveryCodes(userID int64, codeHashes []string) error {
+	err := Transact(context.Background(), t.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		if _, err := tx.Exec(tx.Rebind("DELETE FROM recovery_codes WHERE user_id = ?;"), userID); err != nil {
+			return err
+		}
+
+		for _, hash := range codeHashes {
+			if _, err := tx.Exec(tx.Rebind("INSERT INTO recovery_codes (user_id, code_hash, used_at) VALUES(?, ?, 0);"), userID, hash); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return TxError(err, errTwoFactorNotSaved)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode ...
+func (t *TwoFactor) UseRecoveryCode(userID int64, codeHash string) error {
+	res, err := t.DB.Exec(t.DB.Rebind("UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at = 0;"), time.Now().Unix(), userID, codeHash)
+	if err != nil {
+		return errTwoFactorNotSaved.Wrap(err)
+	}
+
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		return errRecoveryCodeUnused
+	}
+
+	return nil
+}
+
+// RoleRequiresTwoFactor ...
+func (t *TwoFactor) RoleRequiresTwoFactor(userType string) (bool, error) {
+	var required bool
+
+	err := t.DB.Get(&required, t.DB.Rebind("SELECT require_two_factor FROM role_policies WHERE user_type = ? LIMIT 1;"), userType)
+
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, QueryError(err, errTwoFactorNotFound)
+	}
+
+	return required, nil
+}
+
+// SetRoleRequiresTwoFactor ...
+func (t *TwoFactor) SetRoleRequiresTwoFactor(userType string, required bool) error {
+	_, err := t.DB.Exec(t.DB.Rebind("INSERT INTO role_policies (user_type, require_two_factor) VALUES(?, ?)"+
+		upsert(t.DB, "user_type", "require_two_factor")), userType, required)
+	if err != nil {
+		return errRolePolicyNotSaved.Wrap(err)
+	}
+
+	return nil
+}
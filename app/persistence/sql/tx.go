@@ -0,0 +1,168 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/rbo13/write-it/app"
+)
+
+// maxAttempts is how many times a transaction runs
+// before giving up on deadlocks and serialization failures.
+const maxAttempts = 3
+
+// retryDelay is waited before the second attempt, and doubled before the next ones.
+const retryDelay = 20 * time.Millisecond
+
+// ErrTxAborted is returned once a transaction was aborted by
+// concurrent ones on every attempt. Running it later may succeed.
+var ErrTxAborted = app.NewError(app.Conflict, "Transaction aborted by concurrent ones, try again")
+
+// Queryer runs the queries of the services, on the
+// database or inside the transaction of a unit of work.
+type Queryer interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+}
+
+type unitKey struct{}
+
+// unit is the transaction of a unit of work, carried by its context.
+type unit struct {
+	tx *sqlx.Tx
+
+	// retry is set when a statement was aborted by a concurrent
+	// transaction, so that the whole unit runs again.
+	retry bool
+}
+
+// Conn returns the transaction of the unit of work ctx belongs to, or db outside of one.
+func Conn(ctx context.Context, db *sqlx.DB) Queryer {
+	if u, ok := ctx.Value(unitKey{}).(*unit); ok {
+		return u.tx
+	}
+
+	return db
+}
+
+// Transact runs fn in a transaction of db, committed when fn returns nil and
+// rolled back otherwise. The context given to fn carries the transaction, so
+// that the services it calls with that context join it.
+//
+// When the database aborts the transaction on a deadlock or a serialization
+// failure, fn runs again in a new one, and ErrTxAborted is returned after
+// the last attempt. When ctx already belongs to a unit of work, fn joins it
+// and the outermost Transact commits or runs it again.
+func Transact(ctx context.Context, db *sqlx.DB, fn func(ctx context.Context, tx *sqlx.Tx) error) error {
+	if u, ok := ctx.Value(unitKey{}).(*unit); ok {
+		err := fn(ctx, u.tx)
+		if retryable(err) {
+			u.retry = true
+		}
+
+		return err
+	}
+
+	delay := retryDelay
+
+	for attempt := 1; ; attempt++ {
+		u := new(unit)
+
+		err := transact(ctx, db, u, fn)
+		if err == nil || !u.retry && !retryable(err) {
+			return err
+		}
+
+		if attempt == maxAttempts {
+			return ErrTxAborted
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+			delay *= 2
+		}
+	}
+}
+
+// transact runs a single attempt of Transact.
+func transact(ctx context.Context, db *sqlx.DB, u *unit, fn func(ctx context.Context, tx *sqlx.Tx) error) (err error) {
+	u.tx, err = db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			u.tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	err = fn(context.WithValue(ctx, unitKey{}, u), u.tx)
+	if err == nil && u.retry {
+		// fn went on after a statement of the unit was aborted
+		err = ErrTxAborted
+	}
+
+	if err != nil {
+		u.tx.Rollback()
+		return err
+	}
+
+	return u.tx.Commit()
+}
+
+// retryable tells whether err aborted the transaction because of concurrent
+// ones. SQLite does not need it, its writers queue for the database lock.
+func retryable(err error) bool {
+	switch e := err.(type) {
+	case *mysql.MySQLError:
+		// ER_LOCK_DEADLOCK and ER_LOCK_WAIT_TIMEOUT
+		return e.Number == 1213 || e.Number == 1205
+	case *pq.Error:
+		// serialization_failure and deadlock_detected
+		return e.Code == "40001" || e.Code == "40P01"
+	}
+
+	return false
+}
+
+// TxError returns the error of a service whose transaction failed: err
+// itself when it is an *app.Error, like ErrTxAborted, and otherwise
+// fallback caused by err.
+func TxError(err error, fallback *app.Error) error {
+	if app.ErrorOf(err) != nil {
+		return err
+	}
+
+	return fallback.Wrap(err)
+}
+
+// UnitOfWork implements the app.UnitOfWork
+type UnitOfWork struct {
+	DB *sqlx.DB
+}
+
+// NewUnitOfWork returns the app.UnitOfWork running in transactions of db.
+// It serves every backend, as their services query through Conn and Transact.
+func NewUnitOfWork(db *sqlx.DB) app.UnitOfWork {
+	return &UnitOfWork{
+		DB: db,
+	}
+}
+
+// Do ...
+func (u *UnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	return Transact(ctx, u.DB, func(ctx context.Context, _ *sqlx.Tx) error {
+		return fn(ctx)
+	})
+}
@@ -0,0 +1,160 @@
+//go:build cgo
+// +build cgo
+
+package sql_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/passwords"
+	"github.com/rbo13/write-it/app/persistence/sql"
+	"github.com/rbo13/write-it/app/persistence/sqlite"
+)
+
+func TestTransact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "write-it")
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := sql.New("sqlite://" + filepath.Join(dir, "write-it.db"))
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+	defer db.Sqlx.Close()
+
+	if err = db.Migrate(sqlite.Migrations); err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	users := sqlite.NewUserSQLiteService(db.Sqlx, nil, passwords.New(passwords.Bcrypt{Cost: 4}))
+	posts := sqlite.NewPostSQLiteService(db.Sqlx)
+	unitOfWork := sql.NewUnitOfWork(db.Sqlx)
+
+	count := func(t *testing.T) int {
+		var n int
+		if err := db.Sqlx.Get(&n, "SELECT COUNT(*) FROM users;"); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+		return n
+	}
+
+	t.Run("Commit", func(t *testing.T) {
+		before := count(t)
+
+		err := unitOfWork.Do(context.Background(), func(ctx context.Context) error {
+			writer := &app.User{Username: "writer", EmailAddress: "writer@example.com", Password: "correct horse"}
+			if err := users.CreateUserContext(ctx, writer); err != nil {
+				return err
+			}
+
+			// The services read what the unit of work wrote so far
+			if _, err := users.UserContext(ctx, writer.ID); err != nil {
+				return err
+			}
+
+			return posts.CreatePostContext(ctx, &app.Post{CreatorID: writer.ID, PostTitle: "First"})
+		})
+
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if count(t) != before+1 {
+			t.Errorf("Expecting: %v, but got: %v instead", before+1, count(t))
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		before := count(t)
+		errFailed := errors.New("failed")
+
+		err := unitOfWork.Do(context.Background(), func(ctx context.Context) error {
+			reader := &app.User{Username: "reader", EmailAddress: "reader@example.com", Password: "correct horse"}
+			if err := users.CreateUserContext(ctx, reader); err != nil {
+				return err
+			}
+
+			return errFailed
+		})
+
+		if err != errFailed {
+			t.Errorf("Expecting: %v, but got: %v instead", errFailed, err)
+		}
+
+		if count(t) != before {
+			t.Errorf("Expecting: %v, but got: %v instead", before, count(t))
+		}
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		before := count(t)
+
+		func() {
+			defer func() {
+				if p := recover(); p == nil {
+					t.Errorf("Expecting: %v, but got: %v instead", "a panic", p)
+				}
+			}()
+
+			unitOfWork.Do(context.Background(), func(ctx context.Context) error {
+				users.CreateUserContext(ctx, &app.User{Username: "panic", EmailAddress: "panic@example.com", Password: "correct horse"})
+				panic("failed")
+			})
+		}()
+
+		if count(t) != before {
+			t.Errorf("Expecting: %v, but got: %v instead", before, count(t))
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		attempts := 0
+
+		err := sql.Transact(context.Background(), db.Sqlx, func(ctx context.Context, tx *sqlx.Tx) error {
+			attempts++
+			if attempts < 2 {
+				return &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+			}
+			return nil
+		})
+
+		if err != nil || attempts != 2 {
+			t.Errorf("Expecting: %v, but got: %v instead", 2, attempts)
+		}
+	})
+
+	t.Run("Aborted", func(t *testing.T) {
+		attempts := 0
+
+		err := unitOfWork.Do(context.Background(), func(ctx context.Context) error {
+			attempts++
+
+			// A deadlock of a joined transaction aborts the whole unit of work,
+			// even when its error is not returned
+			sql.Transact(ctx, db.Sqlx, func(ctx context.Context, tx *sqlx.Tx) error {
+				return &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+			})
+
+			return nil
+		})
+
+		if err != sql.ErrTxAborted {
+			t.Errorf("Expecting: %v, but got: %v instead", sql.ErrTxAborted, err)
+		}
+
+		if attempts != 3 {
+			t.Errorf("Expecting: %v, but got: %v instead", 3, attempts)
+		}
+	})
+}
@@ -1,8 +1,8 @@
 package sql
 
 import (
-	"errors"
-	"fmt"
+	"context"
+	"database/sql"
 	"log"
 	"time"
 
@@ -11,18 +11,24 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/rbo13/write-it/app"
 	"github.com/rbo13/write-it/app/jwtservice"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/rbo13/write-it/app/passwords"
 )
 
 var (
-	errUserNotInserted      = errors.New("Failed to insert the user")
-	errUserUpdate           = errors.New("Failed to updated the user")
-	errUserDelete           = errors.New("Failed to delete the user")
-	errEmailAlreadyTaken    = errors.New("Email Address is already taken")
-	errNoResultSet          = errors.New("sql: no rows in result set")
-	errEmailRequired        = errors.New("Email is required")
-	errMissingCredentials   = errors.New("Email or Password is missing")
-	errCredentialsIncorrect = errors.New("Email or Password is invalid")
+	errUserNotInserted      = app.NewError(app.Internal, "Failed to insert the user")
+	errUserUpdate           = app.NewError(app.Internal, "Failed to updated the user")
+	errUserDelete           = app.NewError(app.Internal, "Failed to delete the user")
+	errUserNotFound         = app.NewError(app.NotFound, "User not found")
+	errUserChanged          = app.NewError(app.PreconditionFailed, "User was changed since it was read")
+	errEmailAlreadyTaken    = app.NewError(app.Conflict, "Email Address is already taken")
+	errUsernameAlreadyTaken = app.NewError(app.Conflict, "Username is already taken")
+	errEmailRequired        = app.NewValidationError("Email is required", map[string]string{"email": "Email is required"})
+	errUsernameRequired     = app.NewValidationError("Username is required", map[string]string{"username": "Username is required"})
+	errMissingCredentials   = app.NewError(app.Validation, "Email or Password is missing")
+	errCredentialsIncorrect = app.NewError(app.Unauthorized, "Email or Password is invalid")
+	errEmailNotVerified     = app.NewError(app.Conflict, "Email Address cannot be verified")
+	errPasswordNotHashed    = app.NewError(app.Internal, "Failed to hash the password")
+	errAuthToken            = app.NewError(app.Internal, "Failed to generate the auth token")
 )
 
 // UserService implements the app.UserService
@@ -32,49 +38,87 @@ type UserService interface {
 
 // User implements the UserService interface
 type User struct {
-	DB        *sqlx.DB
-	UserSrvc  *app.User
-	TokenAuth *jwtauth.JWTAuth
+	DB         *sqlx.DB
+	UserSrvc   *app.User
+	JWTService *jwtservice.JWT
+	Hasher     passwords.Hasher
+
+	// dummyHash is verified against when the email is unknown,
+	// so that it takes as long to answer as a wrong password.
+	dummyHash string
 }
 
 // NewUserSQLService returns the interface that implements the app.UserService
-func NewUserSQLService(db *sqlx.DB, jwtService *jwtservice.JWT) UserService {
+func NewUserSQLService(db *sqlx.DB, jwtService *jwtservice.JWT, hasher passwords.Hasher) UserService {
+	dummyHash, err := hasher.Hash("write-it")
+	if err != nil {
+		log.Printf("Error hashing the dummy password: %v", err)
+	}
+
 	return &User{
-		DB:        db,
-		UserSrvc:  new(app.User),
-		TokenAuth: jwtService.TokenAuth,
+		DB:         db,
+		UserSrvc:   new(app.User),
+		JWTService: jwtService,
+		Hasher:     hasher,
+		dummyHash:  dummyHash,
 	}
 }
 
 // CreateUser ...
 func (u *User) CreateUser(user *app.User) error {
-	userRes, err := u.UserByEmail(user.EmailAddress)
+	return u.CreateUserContext(context.Background(), user)
+}
 
-	if err != nil && err.Error() != errNoResultSet.Error() {
-		return errUserNotInserted
+// CreateUserContext ...
+func (u *User) CreateUserContext(ctx context.Context, user *app.User) error {
+	userRes, err := u.UserByEmailContext(ctx, user.EmailAddress)
+
+	if err != nil && app.KindOf(err) != app.NotFound {
+		return errUserNotInserted.Wrap(err)
 	}
 
 	if userRes != nil {
 		return errEmailAlreadyTaken
 	}
 
-	tx := u.DB.MustBegin()
+	userRes, err = u.UserByUsernameContext(ctx, user.Username)
 
-	if userRes == nil {
-		user.CreatedAt = time.Now().Unix()
-		user.Password = hashPassword(user.Password)
+	if err != nil && app.KindOf(err) != app.NotFound {
+		return errUserNotInserted.Wrap(err)
+	}
 
-		if user.UserType == "" {
-			user.UserType = "reader"
-		}
+	if userRes != nil {
+		return errUsernameAlreadyTaken
+	}
+
+	hash, err := u.Hasher.Hash(user.Password)
+	if err != nil {
+		return errPasswordNotHashed.Wrap(err)
+	}
+
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
 
-		res, err := tx.NamedExec("INSERT INTO users (username, email, password, user_type, created_at, deleted_at, updated_at) VALUES(:username, :email, :password, :user_type, :created_at, :deleted_at, :updated_at)", &user)
+	user.CreatedAt = time.Now().Unix()
+	user.Password = hash
+	user.Version = 1
 
-		if err != nil && res == nil {
-			tx.Rollback()
-			return errUserNotInserted
+	if user.UserType == "" {
+		user.UserType = "reader"
+	}
+
+	err = Transact(ctx, u.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		res, err := tx.NamedExecContext(ctx, "INSERT INTO users (username, email, password, user_type, created_at, deleted_at, updated_at) VALUES(:username, :email, :password, :user_type, :created_at, :deleted_at, :updated_at)", user)
+		if err != nil {
+			return err
 		}
-		tx.Commit()
+
+		user.ID, err = res.LastInsertId()
+		return err
+	})
+
+	if err != nil {
+		return TxError(err, errUserNotInserted)
 	}
 
 	return nil
@@ -82,12 +126,20 @@ func (u *User) CreateUser(user *app.User) error {
 
 // User ...
 func (u *User) User(id int64) (*app.User, error) {
+	return u.UserContext(context.Background(), id)
+}
+
+// UserContext ...
+func (u *User) UserContext(ctx context.Context, id int64) (*app.User, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
 	user := new(app.User)
 
-	err := u.DB.Get(user, "SELECT * FROM users WHERE id = ? LIMIT 1;", id)
+	err := Conn(ctx, u.DB).GetContext(ctx, user, "SELECT * FROM users WHERE id = ? LIMIT 1;", id)
 
 	if err != nil {
-		return nil, err
+		return nil, QueryError(err, errUserNotFound)
 	}
 
 	return user, nil
@@ -95,17 +147,51 @@ func (u *User) User(id int64) (*app.User, error) {
 
 // UserByEmail ...
 func (u *User) UserByEmail(email string) (*app.User, error) {
+	return u.UserByEmailContext(context.Background(), email)
+}
+
+// UserByEmailContext ...
+func (u *User) UserByEmailContext(ctx context.Context, email string) (*app.User, error) {
 
 	if email == "" {
 		return nil, errEmailRequired
 	}
 
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	user := app.User{}
+
+	err := Conn(ctx, u.DB).GetContext(ctx, &user, "SELECT * FROM users WHERE email = ? LIMIT 1;", email)
+
+	if err != nil {
+		return nil, QueryError(err, errUserNotFound)
+	}
+
+	return &user, nil
+}
+
+// UserByUsername ...
+func (u *User) UserByUsername(username string) (*app.User, error) {
+	return u.UserByUsernameContext(context.Background(), username)
+}
+
+// UserByUsernameContext ...
+func (u *User) UserByUsernameContext(ctx context.Context, username string) (*app.User, error) {
+
+	if username == "" {
+		return nil, errUsernameRequired
+	}
+
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
 	user := app.User{}
 
-	err := u.DB.Get(&user, "SELECT * FROM users WHERE email = ? LIMIT 1;", email)
+	err := Conn(ctx, u.DB).GetContext(ctx, &user, "SELECT * FROM users WHERE username = ? LIMIT 1;", username)
 
 	if err != nil {
-		return nil, err
+		return nil, QueryError(err, errUserNotFound)
 	}
 
 	return &user, nil
@@ -113,27 +199,44 @@ func (u *User) UserByEmail(email string) (*app.User, error) {
 
 // Login ...
 func (u *User) Login(email, password string) (*app.User, error) {
+	return u.LoginContext(context.Background(), email, password)
+}
+
+// LoginContext ...
+func (u *User) LoginContext(ctx context.Context, email, password string) (*app.User, error) {
 	if email == "" || password == "" {
 		return nil, errMissingCredentials
 	}
 
 	user := app.User{}
 
-	// We get a user using the email
-	err := u.DB.Get(&user, "SELECT password FROM users WHERE email = ? LIMIT 1;", email)
+	// We get a user using the email, the deadline does not cover the hashing
+	queryCtx, cancel := WithQueryTimeout(ctx)
+	err := Conn(ctx, u.DB).GetContext(queryCtx, &user, "SELECT * FROM users WHERE email = ? LIMIT 1;", email)
+	cancel()
+
+	if err == sql.ErrNoRows {
+		u.Hasher.Verify(u.dummyHash, password)
+		return nil, errCredentialsIncorrect
+	}
 
 	if err != nil {
-		return nil, err
+		return nil, QueryError(err, errUserNotFound)
 	}
 
-	passwordsEqual := comparePasswords(user.Password, []byte(password))
+	ok, err := u.Hasher.Verify(user.Password, password)
+	if err != nil {
+		log.Printf("Error verifying the password of user %d: %v", user.ID, err)
+	}
 
-	if passwordsEqual {
-		err = u.DB.Get(&user, "SELECT * FROM users WHERE email = ? AND password = ? LIMIT 1;", email, user.Password)
+	if !ok {
+		return nil, errCredentialsIncorrect
+	}
 
-		if err != nil {
-			return nil, err
-		}
+	// Hashes from a previous algorithm or parameters are upgraded
+	// now that we know the password. Failing to do so can wait.
+	if u.Hasher.NeedsRehash(user.Password) {
+		u.rehashPassword(ctx, &user, password)
 	}
 
 	return &user, nil
@@ -151,9 +254,9 @@ func (u *User) GenerateAuthToken(user *app.User) (string, error) {
 	jwtauth.SetExpiryIn(claims, 1*time.Hour)
 	jwtauth.SetIssuedNow(claims)
 
-	_, authToken, err := u.TokenAuth.Encode(claims)
+	authToken, err := u.JWTService.Encode(claims)
 	if err != nil {
-		return "", err
+		return "", errAuthToken.Wrap(err)
 	}
 
 	return authToken, nil
@@ -161,25 +264,41 @@ func (u *User) GenerateAuthToken(user *app.User) (string, error) {
 
 // Users ...
 func (u *User) Users() ([]*app.User, error) {
+	return u.UsersContext(context.Background())
+}
+
+// UsersContext ...
+func (u *User) UsersContext(ctx context.Context) ([]*app.User, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
 	users := []*app.User{}
 
-	err := u.DB.Select(&users, "SELECT * FROM users ORDER BY id DESC;")
+	err := Conn(ctx, u.DB).SelectContext(ctx, &users, "SELECT * FROM users ORDER BY id DESC;")
 
 	if err != nil {
-		return nil, err
+		return nil, QueryError(err, errUserNotFound)
 	}
 	return users, nil
 }
 
 // GetUserPosts returns a slice to pointer of UserPosts.
 func (u *User) GetUserPosts(userID int64) ([]*app.UserPosts, error) {
+	return u.GetUserPostsContext(context.Background(), userID)
+}
+
+// GetUserPostsContext ...
+func (u *User) GetUserPostsContext(ctx context.Context, userID int64) ([]*app.UserPosts, error) {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
 	var userPosts []*app.UserPosts
 
 	query := "SELECT po.`post_title`, po.`post_body`, po.`created_at`, po.`updated_at`, u.`user_type`, u.`email`, u.`username` FROM posts as po, users as u WHERE po.`creator_id` = u.`id` AND u.`id` = ?;"
-	err := u.DB.Select(&userPosts, query, userID)
+	err := Conn(ctx, u.DB).SelectContext(ctx, &userPosts, query, userID)
 
 	if err != nil {
-		return nil, err
+		return nil, QueryError(err, errUserNotFound)
 	}
 
 	return userPosts, nil
@@ -187,58 +306,150 @@ func (u *User) GetUserPosts(userID int64) ([]*app.UserPosts, error) {
 
 // UpdateUser ...
 func (u *User) UpdateUser(user *app.User) error {
+	return u.UpdateUserContext(context.Background(), user)
+}
+
+// UpdateUserContext ...
+func (u *User) UpdateUserContext(ctx context.Context, user *app.User) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
 	user.UpdatedAt = time.Now().Unix()
 
-	query := fmt.Sprintf("UPDATE users SET username = '%s', email = '%s', password = '%s', user_type = '%s', updated_at = '%d' WHERE id = %d;", user.Username, user.EmailAddress, user.Password, user.UserType, user.UpdatedAt, user.ID)
+	err := Transact(ctx, u.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		var taken int
+		err := tx.GetContext(ctx, &taken, "SELECT COUNT(*) FROM users WHERE username = ? AND id <> ?;", user.Username, user.ID)
+		if err != nil {
+			return err
+		}
 
-	log.Println(query)
+		if taken > 0 {
+			return errUsernameAlreadyTaken
+		}
 
-	tx := u.DB.MustBegin()
-	res := tx.MustExec(query)
+		res, err := tx.ExecContext(ctx, "UPDATE users SET username = ?, email = ?, password = ?, user_type = ?, email_verified_at = ?, updated_at = ?, version = version + 1 WHERE id = ? AND version = ? LIMIT 1;", user.Username, user.EmailAddress, user.Password, user.UserType, user.EmailVerifiedAt, user.UpdatedAt, user.ID, user.Version)
+		if err != nil {
+			return err
+		}
 
-	if res == nil {
-		tx.Rollback()
-		return errUserUpdate
+		return CheckVersion(ctx, tx, res, errUserNotFound, errUserChanged, "SELECT COUNT(*) FROM users WHERE id = ?;", user.ID)
+	})
+
+	if err != nil {
+		return TxError(err, errUserUpdate)
 	}
 
-	tx.Commit()
+	user.Version++
 	return nil
 }
 
-// DeleteUser ...
-func (u *User) DeleteUser(id int64) error {
-	tx := u.DB.MustBegin()
+// VerifyEmail marks the email address of the user as verified,
+// as long as the user did not change it in the meantime.
+func (u *User) VerifyEmail(id int64, email string) error {
+	return u.VerifyEmailContext(context.Background(), id, email)
+}
+
+// VerifyEmailContext ...
+func (u *User) VerifyEmailContext(ctx context.Context, id int64, email string) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	var affected int64
 
-	res := tx.MustExec("DELETE FROM users WHERE id = ?;", id)
+	err := Transact(ctx, u.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE users SET email_verified_at = ?, version = version + 1 WHERE id = ? AND email = ? LIMIT 1;", time.Now().Unix(), id, email)
+		if err != nil {
+			return err
+		}
+
+		affected, err = res.RowsAffected()
+		return err
+	})
+
+	if err != nil {
+		return TxError(err, errUserUpdate)
+	}
 
-	if res == nil {
-		tx.Rollback()
-		return errUserDelete
+	if affected == 0 {
+		return errEmailNotVerified
 	}
 
-	tx.Commit()
 	return nil
 }
 
-func hashPassword(rawPassword string) (hashedPassword string) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(rawPassword), bcrypt.MinCost)
+// ResetPassword hashes the new password and revokes
+// every auth token issued until now.
+func (u *User) ResetPassword(id int64, password string) error {
+	return u.ResetPasswordContext(context.Background(), id, password)
+}
+
+// ResetPasswordContext ...
+func (u *User) ResetPasswordContext(ctx context.Context, id int64, password string) error {
+	now := time.Now().Unix()
+
+	hash, err := u.Hasher.Hash(password)
+	if err != nil {
+		return errPasswordNotHashed.Wrap(err)
+	}
+
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	err = Transact(ctx, u.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE users SET password = ?, sessions_revoked_at = ?, updated_at = ?, version = version + 1 WHERE id = ? LIMIT 1;", hash, now, now, id)
+		return err
+	})
+
+	if err != nil {
+		return TxError(err, errUserUpdate)
+	}
+
+	return nil
+}
+
+// DeleteUser deletes the user right away, together with their posts
+// and personal data. Users erase their own account through an Erasure.
+func (u *User) DeleteUser(id int64) error {
+	return u.DeleteUserContext(context.Background(), id)
+}
+
+// DeleteUserContext ...
+func (u *User) DeleteUserContext(ctx context.Context, id int64) error {
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := Transact(ctx, u.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		return eraseUser(tx, id, 0)
+	})
+
 	if err != nil {
-		log.Println(err)
+		return TxError(err, errUserDelete)
 	}
 
-	hashedPassword = string(hash)
-	return hashedPassword
+	return nil
 }
 
-// ComparePasswords compares the hashed and raw password. Returns boolean if equal.
-func comparePasswords(hashedPassword string, rawPassword []byte) bool {
-	byteHash := []byte(hashedPassword)
+// rehashPassword replaces the hash of the user, unless the
+// password changed since we read it.
+func (u *User) rehashPassword(ctx context.Context, user *app.User, password string) {
+	hash, err := u.Hasher.Hash(password)
+	if err != nil {
+		log.Printf("Error rehashing the password of user %d: %v", user.ID, err)
+		return
+	}
+
+	ctx, cancel := WithQueryTimeout(ctx)
+	defer cancel()
+
+	err = Transact(ctx, u.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ? AND password = ? LIMIT 1;", hash, user.ID, user.Password)
+		return err
+	})
 
-	err := bcrypt.CompareHashAndPassword(byteHash, rawPassword)
 	if err != nil {
-		log.Println(err)
-		return false
+		log.Printf("Error rehashing the password of user %d: %v", user.ID, err)
+		return
 	}
 
-	return true
+	user.Password = hash
 }
@@ -0,0 +1,363 @@
+// Package sqlite stores the users and posts in a SQLite file,
+// for the deployments without a database server.
+package sqlite
+
+import (
+	"github.com/rbo13/write-it/app/persistence/sql"
+)
+
+// Migrations are the versions of the SQLite schema, in order. Like
+// sql.Migrations, a released migration is never edited.
+var Migrations = []sql.Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		// Deleting a user deletes the rows referencing them, but for the
+		// posts, which an erasure may keep under another account.
+		Up: []string{
+			`
+			CREATE TABLE users (
+				id integer PRIMARY KEY AUTOINCREMENT,
+				username varchar(16),
+				email varchar(151),
+				password varchar(255),
+				user_type varchar(255),
+				created_at bigint,
+				updated_at bigint,
+				deleted_at bigint
+			);`,
+
+			`
+			CREATE TABLE posts (
+				id integer PRIMARY KEY AUTOINCREMENT,
+				creator_id bigint REFERENCES users (id),
+				post_title text,
+				post_body text,
+				created_at bigint,
+				updated_at bigint,
+				deleted_at bigint
+			);`,
+
+			"CREATE INDEX posts_creator_id ON posts (creator_id);",
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS posts;",
+			"DROP TABLE IF EXISTS users;",
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_email_verified_at",
+		Up: []string{
+			"ALTER TABLE users ADD COLUMN email_verified_at bigint DEFAULT 0;",
+		},
+		// SQLite only drops columns since 3.35, the table is copied without
+		// it instead. The foreign keys are off meanwhile, so that dropping the
+		// users does not delete the rows referencing them.
+		Down: []string{
+			"PRAGMA foreign_keys = OFF;",
+
+			`
+			CREATE TABLE users_unverified (
+				id integer PRIMARY KEY AUTOINCREMENT,
+				username varchar(16),
+				email varchar(151),
+				password varchar(255),
+				user_type varchar(255),
+				created_at bigint,
+				updated_at bigint,
+				deleted_at bigint
+			);`,
+
+			"INSERT INTO users_unverified SELECT id, username, email, password, user_type, created_at, updated_at, deleted_at FROM users;",
+			"DROP TABLE users;",
+			"ALTER TABLE users_unverified RENAME TO users;",
+
+			"PRAGMA foreign_keys = ON;",
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add_sessions_revoked_at",
+		Up: []string{
+			"ALTER TABLE users ADD COLUMN sessions_revoked_at bigint DEFAULT 0;",
+		},
+		Down: []string{
+			"PRAGMA foreign_keys = OFF;",
+
+			`
+			CREATE TABLE users_unrevoked (
+				id integer PRIMARY KEY AUTOINCREMENT,
+				username varchar(16),
+				email varchar(151),
+				password varchar(255),
+				user_type varchar(255),
+				email_verified_at bigint DEFAULT 0,
+				created_at bigint,
+				updated_at bigint,
+				deleted_at bigint
+			);`,
+
+			"INSERT INTO users_unrevoked SELECT id, username, email, password, user_type, email_verified_at, created_at, updated_at, deleted_at FROM users;",
+			"DROP TABLE users;",
+			"ALTER TABLE users_unrevoked RENAME TO users;",
+
+			"PRAGMA foreign_keys = ON;",
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_versions",
+		Up: []string{
+			"ALTER TABLE users ADD COLUMN version bigint NOT NULL DEFAULT 1;",
+			"ALTER TABLE posts ADD COLUMN version bigint NOT NULL DEFAULT 1;",
+		},
+		// Like above, the tables are copied without the column.
+		Down: []string{
+			"PRAGMA foreign_keys = OFF;",
+
+			`
+			CREATE TABLE users_unversioned (
+				id integer PRIMARY KEY AUTOINCREMENT,
+				username varchar(16),
+				email varchar(151),
+				password varchar(255),
+				user_type varchar(255),
+				email_verified_at bigint DEFAULT 0,
+				sessions_revoked_at bigint DEFAULT 0,
+				created_at bigint,
+				updated_at bigint,
+				deleted_at bigint
+			);`,
+
+			"INSERT INTO users_unversioned SELECT id, username, email, password, user_type, email_verified_at, sessions_revoked_at, created_at, updated_at, deleted_at FROM users;",
+			"DROP TABLE users;",
+			"ALTER TABLE users_unversioned RENAME TO users;",
+
+			`
+			CREATE TABLE posts_unversioned (
+				id integer PRIMARY KEY AUTOINCREMENT,
+				creator_id bigint REFERENCES users (id),
+				post_title text,
+				post_body text,
+				created_at bigint,
+				updated_at bigint,
+				deleted_at bigint
+			);`,
+
+			"INSERT INTO posts_unversioned SELECT id, creator_id, post_title, post_body, created_at, updated_at, deleted_at FROM posts;",
+			"DROP TABLE posts;",
+			"ALTER TABLE posts_unversioned RENAME TO posts;",
+			"CREATE INDEX posts_creator_id ON posts (creator_id);",
+
+			"PRAGMA foreign_keys = ON;",
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_unique_usernames",
+		// The public profiles are found by username. Of the users sharing one,
+		// the first to register keeps it and the others are renamed by their id.
+		Up: []string{
+			"UPDATE users SET username = 'user' || id WHERE id NOT IN (SELECT MIN(id) FROM users GROUP BY username);",
+			"CREATE UNIQUE INDEX users_username ON users (username);",
+		},
+		Down: []string{
+			"DROP INDEX IF EXISTS users_username;",
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_password_resets",
+		Up: []string{
+			`
+			CREATE TABLE password_resets (
+				id integer PRIMARY KEY AUTOINCREMENT,
+				user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				token_hash char(64) NOT NULL UNIQUE,
+				expires_at bigint,
+				used_at bigint DEFAULT 0,
+				created_at bigint
+			);`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS password_resets;",
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_two_factor",
+		Up: []string{
+			`
+			CREATE TABLE two_factor (
+				user_id bigint PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+				secret varchar(64) NOT NULL,
+				enabled_at bigint DEFAULT 0,
+				last_step bigint DEFAULT 0,
+				created_at bigint
+			);`,
+
+			`
+			CREATE TABLE recovery_codes (
+				id integer PRIMARY KEY AUTOINCREMENT,
+				user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				code_hash char(64) NOT NULL,
+				used_at bigint DEFAULT 0
+			);`,
+
+			`
+			CREATE TABLE role_policies (
+				user_type varchar(255) PRIMARY KEY,
+				require_two_factor boolean DEFAULT false
+			);`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS role_policies;",
+			"DROP TABLE IF EXISTS recovery_codes;",
+			"DROP TABLE IF EXISTS two_factor;",
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add_access_tokens",
+		Up: []string{
+			`
+			CREATE TABLE access_tokens (
+				id integer PRIMARY KEY AUTOINCREMENT,
+				user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				name varchar(255),
+				token_hash char(64) NOT NULL UNIQUE,
+				scopes varchar(255),
+				expires_at bigint DEFAULT 0,
+				last_used_at bigint DEFAULT 0,
+				created_at bigint
+			);`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS access_tokens;",
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add_identities",
+		Up: []string{
+			`
+			CREATE TABLE identities (
+				id integer PRIMARY KEY AUTOINCREMENT,
+				user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				provider varchar(64) NOT NULL,
+				subject varchar(255) NOT NULL,
+				email varchar(255),
+				created_at bigint,
+				UNIQUE (provider, subject)
+			);`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS identities;",
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add_oauth",
+		Up: []string{
+			`
+			CREATE TABLE oauth_clients (
+				id integer PRIMARY KEY AUTOINCREMENT,
+				client_id varchar(64) NOT NULL UNIQUE,
+				secret_hash char(64),
+				name varchar(255),
+				owner_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				redirect_uris text,
+				scopes varchar(255),
+				created_at bigint
+			);`,
+
+			`
+			CREATE TABLE oauth_codes (
+				id integer PRIMARY KEY AUTOINCREMENT,
+				code_hash char(64) NOT NULL UNIQUE,
+				client_id varchar(64) NOT NULL REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+				user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				redirect_uri text,
+				scopes varchar(255),
+				code_challenge varchar(128),
+				expires_at bigint NOT NULL,
+				used_at bigint DEFAULT 0,
+				created_at bigint
+			);`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS oauth_codes;",
+			"DROP TABLE IF EXISTS oauth_clients;",
+		},
+	},
+	{
+		Version: 11,
+		Name:    "add_profiles",
+		Up: []string{
+			`
+			CREATE TABLE profiles (
+				user_id bigint PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+				display_name varchar(64),
+				bio text,
+				website varchar(255),
+				location varchar(64),
+				social_links text,
+				avatar_updated_at bigint DEFAULT 0,
+				updated_at bigint
+			);`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS profiles;",
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add_erasures",
+		Up: []string{
+			`
+			CREATE TABLE erasures (
+				id integer PRIMARY KEY AUTOINCREMENT,
+				user_id bigint NOT NULL,
+				keep_posts boolean DEFAULT false,
+				requested_at bigint,
+				confirmed_at bigint DEFAULT 0,
+				erase_after bigint DEFAULT 0,
+				erased_at bigint DEFAULT 0
+			);`,
+
+			"CREATE INDEX erasures_user_id ON erasures (user_id);",
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS erasures;",
+		},
+	},
+	{
+		Version: 13,
+		Name:    "add_audit_log",
+		Up: []string{
+			`
+			CREATE TABLE audit_log (
+				id integer PRIMARY KEY AUTOINCREMENT,
+				actor_id bigint DEFAULT 0,
+				action varchar(64) NOT NULL,
+				target_type varchar(32),
+				target_id varchar(64),
+				diff text,
+				ip varchar(45),
+				request_id varchar(64),
+				created_at bigint,
+				prev_hash char(64),
+				hash char(64) NOT NULL
+			);`,
+
+			"CREATE INDEX audit_log_actor_id ON audit_log (actor_id);",
+			"CREATE INDEX audit_log_action ON audit_log (action);",
+			"CREATE INDEX audit_log_target ON audit_log (target_type, target_id);",
+			"CREATE INDEX audit_log_created_at ON audit_log (created_at);",
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS audit_log;",
+		},
+	},
+}
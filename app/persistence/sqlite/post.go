@@ -0,0 +1,207 @@
+package sqlite
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/persistence/sql"
+)
+
+var (
+	errEmpty        = app.NewError(app.Validation, "error: Post is required")
+	errNotInserted  = app.NewError(app.Internal, "error: Not inserted")
+	errNoID         = app.NewError(app.Validation, "error: ID is required")
+	errPostDelete   = app.NewError(app.Internal, "error: Post deletion")
+	errPostUpdate   = app.NewError(app.Internal, "error: Post update")
+	errPostNotFound = app.NewError(app.NotFound, "error: Post not found")
+	errPostChanged  = app.NewError(app.PreconditionFailed, "error: Post was changed since it was read")
+)
+
+// PostService implements the app.PostService
+type PostService interface {
+	app.PostService
+}
+
+// Post implements the PostService interface
+type Post struct {
+	DB *sqlx.DB
+}
+
+// NewPostSQLiteService returns the interface that implements the app.PostService
+func NewPostSQLiteService(db *sqlx.DB) PostService {
+	return &Post{
+		DB: db,
+	}
+}
+
+// CreatePost ...
+func (p *Post) CreatePost(post *app.Post) error {
+	return p.CreatePostContext(context.Background(), post)
+}
+
+// CreatePostContext ...
+func (p *Post) CreatePostContext(ctx context.Context, post *app.Post) error {
+	if post == nil {
+		return errEmpty
+	}
+
+	post.CreatedAt = time.Now().Unix()
+	post.Version = 1
+
+	ctx, cancel := sql.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := sql.Transact(ctx, p.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "INSERT INTO posts (creator_id, post_title, post_body, created_at, deleted_at, updated_at) VALUES(?, ?, ?, ?, ?, ?);", post.CreatorID, post.PostTitle, post.PostBody, post.CreatedAt, post.DeletedAt, post.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		post.ID, err = res.LastInsertId()
+		return err
+	})
+
+	if err != nil {
+		return sql.TxError(err, errNotInserted)
+	}
+
+	return nil
+}
+
+// Post ...
+func (p *Post) Post(id int64) (*app.Post, error) {
+	return p.PostContext(context.Background(), id)
+}
+
+// PostContext ...
+func (p *Post) PostContext(ctx context.Context, id int64) (*app.Post, error) {
+	if id <= 0 {
+		return nil, errNoID
+	}
+
+	post := new(app.Post)
+
+	ctx, cancel := sql.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := sql.Conn(ctx, p.DB).GetContext(ctx, post, "SELECT * FROM posts WHERE id = ?;", id)
+
+	if err != nil {
+		return nil, sql.QueryError(err, errPostNotFound)
+	}
+
+	return post, nil
+}
+
+// Posts ...
+func (p *Post) Posts() ([]*app.Post, error) {
+	return p.PostsContext(context.Background())
+}
+
+// PostsContext ...
+func (p *Post) PostsContext(ctx context.Context) ([]*app.Post, error) {
+	posts := []*app.Post{}
+
+	ctx, cancel := sql.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := sql.Conn(ctx, p.DB).SelectContext(ctx, &posts, "SELECT * FROM posts ORDER BY id DESC;")
+
+	if err != nil {
+		return nil, sql.QueryError(err, errPostNotFound)
+	}
+	return posts, nil
+}
+
+// PostsByCreator ...
+func (p *Post) PostsByCreator(creatorID, afterID int64, limit int) ([]*app.Post, error) {
+	return p.PostsByCreatorContext(context.Background(), creatorID, afterID, limit)
+}
+
+// PostsByCreatorContext ...
+func (p *Post) PostsByCreatorContext(ctx context.Context, creatorID, afterID int64, limit int) ([]*app.Post, error) {
+	posts := []*app.Post{}
+
+	ctx, cancel := sql.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := sql.Conn(ctx, p.DB).SelectContext(ctx, &posts, "SELECT * FROM posts WHERE creator_id = ? AND id > ? ORDER BY id LIMIT ?;", creatorID, afterID, limit)
+
+	if err != nil {
+		return nil, sql.QueryError(err, errPostNotFound)
+	}
+	return posts, nil
+}
+
+// CountPostsByCreator ...
+func (p *Post) CountPostsByCreator(creatorID int64) (int, error) {
+	return p.CountPostsByCreatorContext(context.Background(), creatorID)
+}
+
+// CountPostsByCreatorContext ...
+func (p *Post) CountPostsByCreatorContext(ctx context.Context, creatorID int64) (int, error) {
+	var count int
+
+	ctx, cancel := sql.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := sql.Conn(ctx, p.DB).GetContext(ctx, &count, "SELECT COUNT(*) FROM posts WHERE creator_id = ?;", creatorID)
+
+	if err != nil {
+		return 0, sql.QueryError(err, errPostNotFound)
+	}
+	return count, nil
+}
+
+// UpdatePost ...
+func (p *Post) UpdatePost(post *app.Post) error {
+	return p.UpdatePostContext(context.Background(), post)
+}
+
+// UpdatePostContext ...
+func (p *Post) UpdatePostContext(ctx context.Context, post *app.Post) error {
+	post.UpdatedAt = time.Now().Unix()
+
+	ctx, cancel := sql.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := sql.Transact(ctx, p.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE posts SET post_title = ?, post_body = ?, created_at = ?, updated_at = ?, version = version + 1 WHERE id = ? AND creator_id = ? AND version = ?;", post.PostTitle, post.PostBody, post.CreatedAt, post.UpdatedAt, post.ID, post.CreatorID, post.Version)
+		if err != nil {
+			return err
+		}
+
+		return sql.CheckVersion(ctx, tx, res, errPostNotFound, errPostChanged, "SELECT COUNT(*) FROM posts WHERE id = ? AND creator_id = ?;", post.ID, post.CreatorID)
+	})
+
+	if err != nil {
+		return sql.TxError(err, errPostUpdate)
+	}
+
+	post.Version++
+	return nil
+}
+
+// DeletePost ...
+func (p *Post) DeletePost(id int64) error {
+	return p.DeletePostContext(context.Background(), id)
+}
+
+// DeletePostContext ...
+func (p *Post) DeletePostContext(ctx context.Context, id int64) error {
+	ctx, cancel := sql.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := sql.Transact(ctx, p.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM posts WHERE id = ?;", id)
+		return err
+	})
+
+	if err != nil {
+		return sql.TxError(err, errPostDelete)
+	}
+
+	return nil
+}
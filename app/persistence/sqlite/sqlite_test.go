@@ -0,0 +1,213 @@
+//go:build cgo
+// +build cgo
+
+package sqlite_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/passwords"
+	"github.com/rbo13/write-it/app/persistence/sql"
+	"github.com/rbo13/write-it/app/persistence/sqlite"
+	"github.com/rbo13/write-it/app/persistence/storetest"
+)
+
+func TestStores(t *testing.T) {
+	db, cleanup := open(t)
+	defer cleanup()
+
+	hasher := passwords.New(passwords.Bcrypt{Cost: 4})
+
+	storetest.Run(t, func(t *testing.T) (app.UserService, app.PostService) {
+		for _, table := range []string{"posts", "users"} {
+			if _, err := db.Sqlx.Exec("DELETE FROM " + table + ";"); err != nil {
+				t.Fatalf("Error occurred due to: %v", err)
+			}
+		}
+
+		return sqlite.NewUserSQLiteService(db.Sqlx, nil, hasher), sqlite.NewPostSQLiteService(db.Sqlx)
+	})
+}
+
+func TestAuxiliaryStores(t *testing.T) {
+	db, cleanup := open(t)
+	defer cleanup()
+
+	hasher := passwords.New(passwords.Bcrypt{Cost: 4})
+	tables := []string{
+		"oauth_codes", "oauth_clients", "password_resets", "recovery_codes", "two_factor", "role_policies",
+		"access_tokens", "identities", "profiles", "erasures", "audit_log", "posts", "users",
+	}
+
+	storetest.RunAuxiliary(t, func(t *testing.T) storetest.Auxiliary {
+		for _, table := range tables {
+			if _, err := db.Sqlx.Exec("DELETE FROM " + table + ";"); err != nil {
+				t.Fatalf("Error occurred due to: %v", err)
+			}
+		}
+
+		return storetest.Auxiliary{
+			Users:          sqlite.NewUserSQLiteService(db.Sqlx, nil, hasher),
+			Posts:          sqlite.NewPostSQLiteService(db.Sqlx),
+			PasswordResets: sql.NewPasswordResetSQLService(db.Sqlx),
+			TwoFactor:      sql.NewTwoFactorSQLService(db.Sqlx),
+			AccessTokens:   sql.NewAccessTokenSQLService(db.Sqlx),
+			Identities:     sql.NewIdentitySQLService(db.Sqlx),
+			OAuth:          sql.NewOAuthSQLService(db.Sqlx),
+			Profiles:       sql.NewProfileSQLService(db.Sqlx),
+			Erasures:       sql.NewErasureSQLService(db.Sqlx),
+			Audit:          sql.NewAuditSQLService(db.Sqlx),
+		}
+	})
+}
+
+func TestSQLite(t *testing.T) {
+	db, cleanup := open(t)
+	defer cleanup()
+
+	t.Run("WAL", func(t *testing.T) {
+		var mode string
+		if err := db.Sqlx.Get(&mode, "PRAGMA journal_mode;"); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if mode != "wal" {
+			t.Errorf("Expecting: %v, but got: %v instead", "wal", mode)
+		}
+	})
+
+	t.Run("Redo", func(t *testing.T) {
+		migrator := sql.NewMigrator(db, sqlite.Migrations)
+
+		if _, err := migrator.Redo(); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		statuses, err := migrator.Status()
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		for _, s := range statuses {
+			if s.State != sql.MigrationApplied {
+				t.Errorf("Expecting: %v, but got: %v instead", sql.MigrationApplied, s)
+			}
+		}
+	})
+
+	t.Run("DownToBaseline", func(t *testing.T) {
+		migrator := sql.NewMigrator(db, sqlite.Migrations)
+		users := sqlite.NewUserSQLiteService(db.Sqlx, nil, passwords.New(passwords.Bcrypt{Cost: 4}))
+
+		user := &app.User{Username: "baseline", EmailAddress: "baseline@example.com", Password: "correct horse"}
+		if err := users.CreateUser(user); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		for i := len(sqlite.Migrations); i > 1; i-- {
+			if _, err := migrator.Down(); err != nil {
+				t.Fatalf("Error occurred due to: %v", err)
+			}
+		}
+
+		var count int
+		if err := db.Sqlx.Get(&count, "SELECT COUNT(*) FROM users WHERE username = 'baseline';"); err != nil || count != 1 {
+			t.Errorf("Expecting: %v, but got: %v instead", 1, count)
+		}
+
+		// Only the tables of the baseline are left, with the migrations
+		var tables []string
+		if err := db.Sqlx.Select(&tables, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name;"); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if strings.Join(tables, " ") != "posts schema_migrations users" {
+			t.Errorf("Expecting: %v, but got: %v instead", "posts schema_migrations users", tables)
+		}
+
+		if _, err := migrator.Up(); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if _, err := users.User(user.ID); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+	})
+
+	t.Run("ConcurrentWrites", func(t *testing.T) {
+		users := sqlite.NewUserSQLiteService(db.Sqlx, nil, passwords.New(passwords.Bcrypt{Cost: 4}))
+		posts := sqlite.NewPostSQLiteService(db.Sqlx)
+
+		writer := &app.User{Username: "writer", EmailAddress: "writer@example.com", Password: "correct horse"}
+		if err := users.CreateUser(writer); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		// The writers wait for each other instead of failing with SQLITE_BUSY
+		var wg sync.WaitGroup
+		errs := make(chan error, 20)
+
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- posts.CreatePost(&app.Post{CreatorID: writer.ID, PostTitle: fmt.Sprintf("Post %d", i)})
+			}(i)
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Errorf("Error occurred due to: %v", err)
+			}
+		}
+
+		count, err := posts.CountPostsByCreator(writer.ID)
+		if err != nil || count != 20 {
+			t.Errorf("Expecting: %v, but got: %v instead", 20, count)
+		}
+	})
+
+	t.Run("ForeignKeys", func(t *testing.T) {
+		posts := sqlite.NewPostSQLiteService(db.Sqlx)
+
+		if err := posts.CreatePost(&app.Post{CreatorID: 1000, PostTitle: "Orphan"}); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+	})
+}
+
+// open migrates a new SQLite file, which cleanup removes.
+func open(t *testing.T) (*sql.DB, func()) {
+	dir, err := ioutil.TempDir("", "write-it")
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	db, err := sql.New("sqlite://" + filepath.Join(dir, "write-it.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	cleanup := func() {
+		db.Sqlx.Close()
+		os.RemoveAll(dir)
+	}
+
+	if err = db.Migrate(sqlite.Migrations); err != nil {
+		cleanup()
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	return db, cleanup
+}
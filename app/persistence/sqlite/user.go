@@ -0,0 +1,464 @@
+package sqlite
+
+import (
+	"context"
+	"log"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/jwtauth"
+	"github.com/jmoiron/sqlx"
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/jwtservice"
+	"github.com/rbo13/write-it/app/passwords"
+	persistence "github.com/rbo13/write-it/app/persistence/sql"
+)
+
+var (
+	errUserNotInserted      = app.NewError(app.Internal, "Failed to insert the user")
+	errUserUpdate           = app.NewError(app.Internal, "Failed to updated the user")
+	errUserDelete           = app.NewError(app.Internal, "Failed to delete the user")
+	errUserNotFound         = app.NewError(app.NotFound, "User not found")
+	errUserChanged          = app.NewError(app.PreconditionFailed, "User was changed since it was read")
+	errEmailAlreadyTaken    = app.NewError(app.Conflict, "Email Address is already taken")
+	errUsernameAlreadyTaken = app.NewError(app.Conflict, "Username is already taken")
+	errEmailRequired        = app.NewValidationError("Email is required", map[string]string{"email": "Email is required"})
+	errUsernameRequired     = app.NewValidationError("Username is required", map[string]string{"username": "Username is required"})
+	errMissingCredentials   = app.NewError(app.Validation, "Email or Password is missing")
+	errCredentialsIncorrect = app.NewError(app.Unauthorized, "Email or Password is invalid")
+	errEmailNotVerified     = app.NewError(app.Conflict, "Email Address cannot be verified")
+	errPasswordNotHashed    = app.NewError(app.Internal, "Failed to hash the password")
+	errAuthToken            = app.NewError(app.Internal, "Failed to generate the auth token")
+)
+
+// UserService implements the app.UserService
+type UserService interface {
+	app.UserService
+}
+
+// User implements the UserService interface
+type User struct {
+	DB         *sqlx.DB
+	JWTService *jwtservice.JWT
+	Hasher     passwords.Hasher
+
+	// dummyHash is verified against when the email is unknown,
+	// so that it takes as long to answer as a wrong password.
+	dummyHash string
+}
+
+// NewUserSQLiteService returns the interface that implements the app.UserService
+func NewUserSQLiteService(db *sqlx.DB, jwtService *jwtservice.JWT, hasher passwords.Hasher) UserService {
+	dummyHash, err := hasher.Hash("write-it")
+	if err != nil {
+		log.Printf("Error hashing the dummy password: %v", err)
+	}
+
+	return &User{
+		DB:         db,
+		JWTService: jwtService,
+		Hasher:     hasher,
+		dummyHash:  dummyHash,
+	}
+}
+
+// CreateUser ...
+func (u *User) CreateUser(user *app.User) error {
+	return u.CreateUserContext(context.Background(), user)
+}
+
+// CreateUserContext ...
+func (u *User) CreateUserContext(ctx context.Context, user *app.User) error {
+	_, err := u.UserByEmailContext(ctx, user.EmailAddress)
+
+	if err == nil {
+		return errEmailAlreadyTaken
+	}
+
+	if app.KindOf(err) != app.NotFound {
+		return errUserNotInserted.Wrap(err)
+	}
+
+	_, err = u.UserByUsernameContext(ctx, user.Username)
+
+	if err == nil {
+		return errUsernameAlreadyTaken
+	}
+
+	if app.KindOf(err) != app.NotFound {
+		return errUserNotInserted.Wrap(err)
+	}
+
+	hash, err := u.Hasher.Hash(user.Password)
+	if err != nil {
+		return errPasswordNotHashed.Wrap(err)
+	}
+
+	user.CreatedAt = time.Now().Unix()
+	user.Password = hash
+	user.Version = 1
+
+	if user.UserType == "" {
+		user.UserType = "reader"
+	}
+
+	ctx, cancel := persistence.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err = persistence.Transact(ctx, u.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "INSERT INTO users (username, email, password, user_type, created_at, deleted_at, updated_at) VALUES(?, ?, ?, ?, ?, ?, ?);", user.Username, user.EmailAddress, user.Password, user.UserType, user.CreatedAt, user.DeletedAt, user.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		user.ID, err = res.LastInsertId()
+		return err
+	})
+
+	if err != nil {
+		return persistence.TxError(err, errUserNotInserted)
+	}
+
+	return nil
+}
+
+// User ...
+func (u *User) User(id int64) (*app.User, error) {
+	return u.UserContext(context.Background(), id)
+}
+
+// UserContext ...
+func (u *User) UserContext(ctx context.Context, id int64) (*app.User, error) {
+	user := new(app.User)
+
+	ctx, cancel := persistence.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := persistence.Conn(ctx, u.DB).GetContext(ctx, user, "SELECT * FROM users WHERE id = ?;", id)
+
+	if err != nil {
+		return nil, persistence.QueryError(err, errUserNotFound)
+	}
+
+	return user, nil
+}
+
+// UserByEmail ...
+func (u *User) UserByEmail(email string) (*app.User, error) {
+	return u.UserByEmailContext(context.Background(), email)
+}
+
+// UserByEmailContext ...
+func (u *User) UserByEmailContext(ctx context.Context, email string) (*app.User, error) {
+	if email == "" {
+		return nil, errEmailRequired
+	}
+
+	user := new(app.User)
+
+	ctx, cancel := persistence.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := persistence.Conn(ctx, u.DB).GetContext(ctx, user, "SELECT * FROM users WHERE email = ? ORDER BY id LIMIT 1;", email)
+
+	if err != nil {
+		return nil, persistence.QueryError(err, errUserNotFound)
+	}
+
+	return user, nil
+}
+
+// UserByUsername ...
+func (u *User) UserByUsername(username string) (*app.User, error) {
+	return u.UserByUsernameContext(context.Background(), username)
+}
+
+// UserByUsernameContext ...
+func (u *User) UserByUsernameContext(ctx context.Context, username string) (*app.User, error) {
+	if username == "" {
+		return nil, errUsernameRequired
+	}
+
+	user := new(app.User)
+
+	ctx, cancel := persistence.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := persistence.Conn(ctx, u.DB).GetContext(ctx, user, "SELECT * FROM users WHERE username = ? LIMIT 1;", username)
+
+	if err != nil {
+		return nil, persistence.QueryError(err, errUserNotFound)
+	}
+
+	return user, nil
+}
+
+// Login ...
+func (u *User) Login(email, password string) (*app.User, error) {
+	return u.LoginContext(context.Background(), email, password)
+}
+
+// LoginContext ...
+func (u *User) LoginContext(ctx context.Context, email, password string) (*app.User, error) {
+	if email == "" || password == "" {
+		return nil, errMissingCredentials
+	}
+
+	user, err := u.UserByEmailContext(ctx, email)
+
+	if app.KindOf(err) == app.NotFound {
+		u.Hasher.Verify(u.dummyHash, password)
+		return nil, errCredentialsIncorrect
+	}
+
+	if err != nil {
+		return nil, persistence.QueryError(err, errUserNotFound)
+	}
+
+	ok, err := u.Hasher.Verify(user.Password, password)
+	if err != nil {
+		log.Printf("Error verifying the password of user %d: %v", user.ID, err)
+	}
+
+	if !ok {
+		return nil, errCredentialsIncorrect
+	}
+
+	// Hashes from a previous algorithm or parameters are upgraded
+	// now that we know the password. Failing to do so can wait.
+	if u.Hasher.NeedsRehash(user.Password) {
+		u.rehashPassword(ctx, user, password)
+	}
+
+	return user, nil
+}
+
+// GenerateAuthToken ...
+func (u *User) GenerateAuthToken(user *app.User) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id":       user.ID,
+		"email":         user.EmailAddress,
+		"authenticated": true,
+		"created_at":    user.CreatedAt,
+	}
+
+	jwtauth.SetExpiryIn(claims, 1*time.Hour)
+	jwtauth.SetIssuedNow(claims)
+
+	authToken, err := u.JWTService.Encode(claims)
+	if err != nil {
+		return "", errAuthToken.Wrap(err)
+	}
+
+	return authToken, nil
+}
+
+// Users ...
+func (u *User) Users() ([]*app.User, error) {
+	return u.UsersContext(context.Background())
+}
+
+// UsersContext ...
+func (u *User) UsersContext(ctx context.Context) ([]*app.User, error) {
+	users := []*app.User{}
+
+	ctx, cancel := persistence.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := persistence.Conn(ctx, u.DB).SelectContext(ctx, &users, "SELECT * FROM users ORDER BY id DESC;")
+
+	if err != nil {
+		return nil, persistence.QueryError(err, errUserNotFound)
+	}
+	return users, nil
+}
+
+// GetUserPosts returns a slice to pointer of UserPosts.
+func (u *User) GetUserPosts(userID int64) ([]*app.UserPosts, error) {
+	return u.GetUserPostsContext(context.Background(), userID)
+}
+
+// GetUserPostsContext ...
+func (u *User) GetUserPostsContext(ctx context.Context, userID int64) ([]*app.UserPosts, error) {
+	var userPosts []*app.UserPosts
+
+	ctx, cancel := persistence.WithQueryTimeout(ctx)
+	defer cancel()
+
+	query := "SELECT po.post_title, po.post_body, po.created_at, po.updated_at, u.user_type, u.email, u.username FROM posts AS po JOIN users AS u ON po.creator_id = u.id WHERE u.id = ? ORDER BY po.id;"
+	err := persistence.Conn(ctx, u.DB).SelectContext(ctx, &userPosts, query, userID)
+
+	if err != nil {
+		return nil, persistence.QueryError(err, errUserNotFound)
+	}
+
+	return userPosts, nil
+}
+
+// UpdateUser ...
+func (u *User) UpdateUser(user *app.User) error {
+	return u.UpdateUserContext(context.Background(), user)
+}
+
+// UpdateUserContext ...
+func (u *User) UpdateUserContext(ctx context.Context, user *app.User) error {
+	user.UpdatedAt = time.Now().Unix()
+
+	ctx, cancel := persistence.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := persistence.Transact(ctx, u.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		var taken int
+		err := tx.GetContext(ctx, &taken, "SELECT COUNT(*) FROM users WHERE username = ? AND id <> ?;", user.Username, user.ID)
+		if err != nil {
+			return err
+		}
+
+		if taken > 0 {
+			return errUsernameAlreadyTaken
+		}
+
+		res, err := tx.ExecContext(ctx, "UPDATE users SET username = ?, email = ?, password = ?, user_type = ?, email_verified_at = ?, updated_at = ?, version = version + 1 WHERE id = ? AND version = ?;", user.Username, user.EmailAddress, user.Password, user.UserType, user.EmailVerifiedAt, user.UpdatedAt, user.ID, user.Version)
+		if err != nil {
+			return err
+		}
+
+		return persistence.CheckVersion(ctx, tx, res, errUserNotFound, errUserChanged, "SELECT COUNT(*) FROM users WHERE id = ?;", user.ID)
+	})
+
+	if err != nil {
+		return persistence.TxError(err, errUserUpdate)
+	}
+
+	user.Version++
+	return nil
+}
+
+// VerifyEmail marks the email address of the user as verified,
+// as long as the user did not change it in the meantime.
+func (u *User) VerifyEmail(id int64, email string) error {
+	return u.VerifyEmailContext(context.Background(), id, email)
+}
+
+// VerifyEmailContext ...
+func (u *User) VerifyEmailContext(ctx context.Context, id int64, email string) error {
+	ctx, cancel := persistence.WithQueryTimeout(ctx)
+	defer cancel()
+
+	var affected int64
+
+	err := persistence.Transact(ctx, u.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE users SET email_verified_at = ?, version = version + 1 WHERE id = ? AND email = ?;", time.Now().Unix(), id, email)
+		if err != nil {
+			return err
+		}
+
+		affected, err = res.RowsAffected()
+		return err
+	})
+
+	if err != nil {
+		return persistence.TxError(err, errUserUpdate)
+	}
+
+	if affected == 0 {
+		return errEmailNotVerified
+	}
+
+	return nil
+}
+
+// ResetPassword hashes the new password and revokes
+// every auth token issued until now.
+func (u *User) ResetPassword(id int64, password string) error {
+	return u.ResetPasswordContext(context.Background(), id, password)
+}
+
+// ResetPasswordContext ...
+func (u *User) ResetPasswordContext(ctx context.Context, id int64, password string) error {
+	now := time.Now().Unix()
+
+	hash, err := u.Hasher.Hash(password)
+	if err != nil {
+		return errPasswordNotHashed.Wrap(err)
+	}
+
+	ctx, cancel := persistence.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err = persistence.Transact(ctx, u.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE users SET password = ?, sessions_revoked_at = ?, updated_at = ?, version = version + 1 WHERE id = ?;", hash, now, now, id)
+		return err
+	})
+
+	if err != nil {
+		return persistence.TxError(err, errUserUpdate)
+	}
+
+	return nil
+}
+
+// DeleteUser deletes the user right away together with their posts,
+// the other rows referencing them are deleted in cascade.
+func (u *User) DeleteUser(id int64) error {
+	return u.DeleteUserContext(context.Background(), id)
+}
+
+// DeleteUserContext ...
+func (u *User) DeleteUserContext(ctx context.Context, id int64) error {
+	ctx, cancel := persistence.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err := persistence.Transact(ctx, u.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM posts WHERE creator_id = ?;", id); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?;", id)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			return errUserNotFound
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return persistence.TxError(err, errUserDelete)
+	}
+
+	return nil
+}
+
+// rehashPassword replaces the hash of the user, unless the
+// password changed since we read it.
+func (u *User) rehashPassword(ctx context.Context, user *app.User, password string) {
+	hash, err := u.Hasher.Hash(password)
+	if err != nil {
+		log.Printf("Error rehashing the password of user %d: %v", user.ID, err)
+		return
+	}
+
+	ctx, cancel := persistence.WithQueryTimeout(ctx)
+	defer cancel()
+
+	err = persistence.Transact(ctx, u.DB, func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ? AND password = ?;", hash, user.ID, user.Password)
+		return err
+	})
+
+	if err != nil {
+		log.Printf("Error rehashing the password of user %d: %v", user.ID, err)
+		return
+	}
+
+	user.Password = hash
+}
@@ -0,0 +1,261 @@
+package storetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rbo13/write-it/app"
+)
+
+// Auxiliary are the services of the tables beside the users and posts,
+// which every backend shares.
+type Auxiliary struct {
+	Users          app.UserService
+	Posts          app.PostService
+	PasswordResets app.PasswordResetService
+	TwoFactor      app.TwoFactorService
+	AccessTokens   app.AccessTokenService
+	Identities     app.IdentityService
+	OAuth          app.OAuthService
+	Profiles       app.ProfileService
+	Erasures       app.ErasureService
+	Audit          app.AuditService
+}
+
+// AuxiliaryStores returns the services of a backend, over empty stores.
+type AuxiliaryStores func(t *testing.T) Auxiliary
+
+// RunAuxiliary runs the conformance tests of the auxiliary services.
+// newStores is called again by every test, which starts from empty stores.
+func RunAuxiliary(t *testing.T, newStores AuxiliaryStores) {
+	t.Run("PasswordResets", func(t *testing.T) {
+		s := newStores(t)
+		user := createUser(t, s.Users, "writer")
+		expiresAt := time.Now().Add(time.Hour).Unix()
+
+		for _, hash := range []string{"first", "second"} {
+			reset := &app.PasswordReset{UserID: user.ID, TokenHash: hash, ExpiresAt: expiresAt}
+			if err := s.PasswordResets.CreatePasswordReset(reset); err != nil {
+				t.Fatalf("Error occurred due to: %v", err)
+			}
+
+			if reset.ID <= 0 {
+				t.Errorf("Expecting: %v, but got: %v instead", "an id", reset.ID)
+			}
+		}
+
+		reset, err := s.PasswordResets.UsePasswordReset("second")
+		if err != nil || reset.UserID != user.ID {
+			t.Fatalf("Expecting: %v, but got: %v instead", user.ID, err)
+		}
+
+		// Using a token uses every pending token of the user
+		for _, hash := range []string{"first", "second"} {
+			if _, err := s.PasswordResets.UsePasswordReset(hash); err == nil {
+				t.Errorf("Expecting: %v, but got: %v instead", "a used token", hash)
+			}
+		}
+	})
+
+	t.Run("TwoFactor", func(t *testing.T) {
+		s := newStores(t)
+		user := createUser(t, s.Users, "writer")
+
+		// Saving twice replaces the secret
+		for _, secret := range []string{"first", "second"} {
+			if err := s.TwoFactor.SaveTwoFactor(&app.TwoFactor{UserID: user.ID, Secret: secret}); err != nil {
+				t.Fatalf("Error occurred due to: %v", err)
+			}
+		}
+
+		twoFactor, err := s.TwoFactor.TwoFactor(user.ID)
+		if err != nil || twoFactor.Secret != "second" {
+			t.Errorf("Expecting: %v, but got: %v instead", "second", err)
+		}
+
+		if err := s.TwoFactor.UseStep(user.ID, 42); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+
+		if err := s.TwoFactor.UseStep(user.ID, 42); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "a replayed step", err)
+		}
+
+		if err := s.TwoFactor.ReplaceRecoveryCodes(user.ID, []string{"code"}); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if err := s.TwoFactor.UseRecoveryCode(user.ID, "code"); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+
+		if err := s.TwoFactor.UseRecoveryCode(user.ID, "code"); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "a used recovery code", err)
+		}
+
+		for _, required := range []bool{true, false} {
+			if err := s.TwoFactor.SetRoleRequiresTwoFactor("admin", required); err != nil {
+				t.Fatalf("Error occurred due to: %v", err)
+			}
+
+			if got, err := s.TwoFactor.RoleRequiresTwoFactor("admin"); err != nil || got != required {
+				t.Errorf("Expecting: %v, but got: %v instead", required, got)
+			}
+		}
+
+		if err := s.TwoFactor.DeleteTwoFactor(user.ID); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+	})
+
+	t.Run("AccessTokens", func(t *testing.T) {
+		s := newStores(t)
+		user := createUser(t, s.Users, "writer")
+
+		token := &app.AccessToken{UserID: user.ID, Name: "cli", TokenHash: "hash", Scopes: "posts:read"}
+		if err := s.AccessTokens.CreateAccessToken(token); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if err := s.AccessTokens.TouchAccessToken(token.ID, 42); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+
+		found, err := s.AccessTokens.AccessToken("hash")
+		if err != nil || found.ID != token.ID || found.LastUsedAt != 42 {
+			t.Errorf("Expecting: %v, but got: %v instead", token.ID, err)
+		}
+
+		if err := s.AccessTokens.DeleteAccessToken(token.ID, user.ID); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+
+		if tokens, _ := s.AccessTokens.AccessTokens(user.ID); len(tokens) != 0 {
+			t.Errorf("Expecting: %v, but got: %v instead", 0, len(tokens))
+		}
+	})
+
+	t.Run("Identities", func(t *testing.T) {
+		s := newStores(t)
+		user := createUser(t, s.Users, "writer")
+
+		identity := &app.Identity{UserID: user.ID, Provider: "example", Subject: "subject"}
+		if err := s.Identities.CreateIdentity(identity); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		found, err := s.Identities.Identity("example", "subject")
+		if err != nil || found.ID != identity.ID || found.UserID != user.ID {
+			t.Errorf("Expecting: %v, but got: %v instead", identity.ID, err)
+		}
+	})
+
+	t.Run("OAuth", func(t *testing.T) {
+		s := newStores(t)
+		user := createUser(t, s.Users, "writer")
+
+		client := &app.OAuthClient{ClientID: "client", Name: "app", OwnerID: user.ID, RedirectURIs: "https://app.test/callback"}
+		if err := s.OAuth.CreateOAuthClient(client); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		code := &app.OAuthCode{CodeHash: "hash", ClientID: "client", UserID: user.ID, ExpiresAt: time.Now().Add(time.Minute).Unix()}
+		if err := s.OAuth.CreateOAuthCode(code); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if found, err := s.OAuth.OAuthCode("hash"); err != nil || found.ID != code.ID {
+			t.Errorf("Expecting: %v, but got: %v instead", code.ID, err)
+		}
+
+		if used, err := s.OAuth.UseOAuthCode("hash"); err != nil || used.ID != code.ID {
+			t.Errorf("Expecting: %v, but got: %v instead", code.ID, err)
+		}
+
+		if _, err := s.OAuth.UseOAuthCode("hash"); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "a used code", err)
+		}
+
+		if _, err := s.OAuth.OAuthCode("hash"); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "a used code", err)
+		}
+
+		if err := s.OAuth.DeleteOAuthClient("client", user.ID); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+
+		if _, err := s.OAuth.OAuthClient("client"); app.KindOf(err) != app.NotFound {
+			t.Errorf("Expecting: %v, but got: %v instead", app.NotFound, err)
+		}
+	})
+
+	t.Run("Profiles", func(t *testing.T) {
+		s := newStores(t)
+		user := createUser(t, s.Users, "writer")
+
+		for _, bio := range []string{"first", "second"} {
+			profile := &app.Profile{UserID: user.ID, Bio: bio, SocialLinks: app.SocialLinks{"github": bio}}
+			if err := s.Profiles.SaveProfile(profile); err != nil {
+				t.Fatalf("Error occurred due to: %v", err)
+			}
+		}
+
+		profile, err := s.Profiles.Profile(user.ID)
+		if err != nil || profile.Bio != "second" || profile.SocialLinks["github"] != "second" {
+			t.Errorf("Expecting: %v, but got: %v instead", "second", profile)
+		}
+	})
+
+	t.Run("Erasures", func(t *testing.T) {
+		s := newStores(t)
+		user := createUser(t, s.Users, "writer")
+		post := createPost(t, s.Posts, user.ID, "Kept")
+
+		erasure := &app.Erasure{UserID: user.ID, KeepPosts: true, ConfirmedAt: 1, EraseAfter: 1}
+		if err := s.Erasures.SaveErasure(erasure); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		due, err := s.Erasures.DueErasures(time.Now().Unix())
+		if err != nil || len(due) != 1 || due[0].ID != erasure.ID {
+			t.Fatalf("Expecting: %v, but got: %v instead", erasure.ID, due)
+		}
+
+		if err := s.Erasures.EraseUser(due[0]); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if _, err := s.Users.User(user.ID); app.KindOf(err) != app.NotFound {
+			t.Errorf("Expecting: %v, but got: %v instead", app.NotFound, err)
+		}
+
+		kept, err := s.Posts.Post(post.ID)
+		if err != nil || kept.CreatorID == user.ID {
+			t.Errorf("Expecting: %v, but got: %v instead", "a post of the former member", err)
+		}
+	})
+
+	t.Run("Audit", func(t *testing.T) {
+		s := newStores(t)
+
+		entries := []*app.AuditEntry{
+			{ActorID: 1, Action: "user.update", TargetType: "user", TargetID: "1", Diff: app.AuditDiff{}},
+			{ActorID: 2, Action: "post.delete", TargetType: "post", TargetID: "1", Diff: app.AuditDiff{}},
+		}
+
+		for _, entry := range entries {
+			if err := s.Audit.AppendAuditEntry(entry); err != nil {
+				t.Fatalf("Error occurred due to: %v", err)
+			}
+		}
+
+		if entries[1].ID <= entries[0].ID || entries[1].PrevHash != entries[0].Hash {
+			t.Errorf("Expecting: %v, but got: %v instead", entries[0].Hash, entries[1].PrevHash)
+		}
+
+		found, err := s.Audit.AuditEntries(app.AuditFilter{Action: "post.delete"})
+		if err != nil || len(found) != 1 || found[0].ID != entries[1].ID {
+			t.Errorf("Expecting: %v, but got: %v instead", entries[1].ID, found)
+		}
+	})
+}
@@ -0,0 +1,639 @@
+// Package storetest is the conformance suite of app.UserService and
+// app.PostService. Every implementation, in memory or SQL, runs it
+// to prove that it behaves like the others.
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/rbo13/write-it/app"
+)
+
+// Stores returns the user and post services of an implementation, over empty stores.
+type Stores func(t *testing.T) (app.UserService, app.PostService)
+
+// Run runs the conformance tests against the implementation. newStores
+// is called again by every test, which starts from empty stores.
+func Run(t *testing.T, newStores Stores) {
+	t.Run("Users", func(t *testing.T) {
+		testUsers(t, newStores)
+	})
+
+	t.Run("Posts", func(t *testing.T) {
+		testPosts(t, newStores)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		testCanceled(t, newStores)
+	})
+}
+
+func testUsers(t *testing.T, newStores Stores) {
+	t.Run("CreateUser", func(t *testing.T) {
+		users, _ := newStores(t)
+		user := createUser(t, users, "writer")
+
+		if user.ID <= 0 || user.CreatedAt <= 0 {
+			t.Errorf("Expecting: %v, but got: %v instead", "an id and a creation time", user)
+		}
+
+		if user.Password == "correct horse writer" || user.UserType != "reader" {
+			t.Errorf("Expecting: %v, but got: %v instead", "a hashed password and the reader type", user)
+		}
+
+		duplicate := &app.User{Username: "other", EmailAddress: "writer@example.com", Password: "correct horse other"}
+		if err := users.CreateUser(duplicate); app.KindOf(err) != app.Conflict {
+			t.Errorf("Expecting: %v, but got: %v instead", "the email address to be taken", err)
+		}
+
+		all, err := users.Users()
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if len(all) != 1 {
+			t.Errorf("Expecting: %v, but got: %v instead", 1, len(all))
+		}
+	})
+
+	t.Run("Lookup", func(t *testing.T) {
+		users, _ := newStores(t)
+		user := createUser(t, users, "writer")
+
+		byID, err := users.User(user.ID)
+		if err != nil || byID.EmailAddress != "writer@example.com" {
+			t.Errorf("Expecting: %v, but got: %v instead", "writer@example.com", err)
+		}
+
+		byEmail, err := users.UserByEmail("writer@example.com")
+		if err != nil || byEmail.ID != user.ID {
+			t.Errorf("Expecting: %v, but got: %v instead", user.ID, err)
+		}
+
+		byUsername, err := users.UserByUsername("writer")
+		if err != nil || byUsername.ID != user.ID {
+			t.Errorf("Expecting: %v, but got: %v instead", user.ID, err)
+		}
+
+		if _, err := users.User(user.ID + 1000); app.KindOf(err) != app.NotFound {
+			t.Errorf("Expecting: %v, but got: %v instead", app.NotFound, err)
+		}
+
+		if _, err := users.UserByEmail(""); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+
+		if _, err := users.UserByUsername("unknown"); app.KindOf(err) != app.NotFound {
+			t.Errorf("Expecting: %v, but got: %v instead", app.NotFound, err)
+		}
+	})
+
+	t.Run("Login", func(t *testing.T) {
+		users, _ := newStores(t)
+		user := createUser(t, users, "writer")
+
+		loggedIn, err := users.Login("writer@example.com", "correct horse writer")
+		if err != nil || loggedIn.ID != user.ID {
+			t.Errorf("Expecting: %v, but got: %v instead", user.ID, err)
+		}
+
+		for _, credentials := range [][2]string{
+			{"writer@example.com", "wrong"},
+			{"unknown@example.com", "correct horse writer"},
+		} {
+			if _, err := users.Login(credentials[0], credentials[1]); app.KindOf(err) != app.Unauthorized {
+				t.Errorf("Expecting: %v, but got: %v instead", app.Unauthorized, err)
+			}
+		}
+
+		for _, credentials := range [][2]string{
+			{"", "correct horse writer"},
+			{"writer@example.com", ""},
+		} {
+			if _, err := users.Login(credentials[0], credentials[1]); err == nil {
+				t.Errorf("Expecting: %v, but got: %v instead", "an error", credentials)
+			}
+		}
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		users, _ := newStores(t)
+		first := createUser(t, users, "first")
+		second := createUser(t, users, "second")
+
+		all, err := users.Users()
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if len(all) != 2 || all[0].ID != second.ID || all[1].ID != first.ID {
+			t.Errorf("Expecting: %v, but got: %v instead", "the newest user first", all)
+		}
+	})
+
+	t.Run("UpdateUser", func(t *testing.T) {
+		users, _ := newStores(t)
+		user := createUser(t, users, "writer")
+
+		user.Username = "editor"
+		user.UserType = "admin"
+		if err := users.UpdateUser(user); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		updated, err := users.User(user.ID)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if updated.Username != "editor" || updated.UserType != "admin" || updated.UpdatedAt <= 0 {
+			t.Errorf("Expecting: %v, but got: %v instead", "the updated user", updated)
+		}
+
+		if _, err := users.Login("writer@example.com", "correct horse writer"); err != nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "the password to be kept", err)
+		}
+
+		if err := users.UpdateUser(&app.User{ID: user.ID + 1000, Version: 1}); app.KindOf(err) != app.NotFound {
+			t.Errorf("Expecting: %v, but got: %v instead", app.NotFound, err)
+		}
+	})
+
+	t.Run("UniqueUsername", func(t *testing.T) {
+		users, _ := newStores(t)
+		createUser(t, users, "writer")
+		editor := createUser(t, users, "editor")
+
+		duplicate := &app.User{Username: "writer", EmailAddress: "other@example.com", Password: "correct horse other"}
+		if err := users.CreateUser(duplicate); app.KindOf(err) != app.Conflict {
+			t.Errorf("Expecting: %v, but got: %v instead", "the username to be taken", err)
+		}
+
+		editor.Username = "writer"
+		if err := users.UpdateUser(editor); app.KindOf(err) != app.Conflict {
+			t.Errorf("Expecting: %v, but got: %v instead", "the username to be taken", err)
+		}
+
+		// Keeping their own username is fine
+		editor.Username = "editor"
+		if err := users.UpdateUser(editor); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+	})
+
+	t.Run("Version", func(t *testing.T) {
+		users, _ := newStores(t)
+		user := createUser(t, users, "writer")
+
+		if user.Version != 1 {
+			t.Fatalf("Expecting: %v, but got: %v instead", 1, user.Version)
+		}
+
+		// Both editors read the first version
+		stale, err := users.User(user.ID)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		user.Username = "editor"
+		if err := users.UpdateUser(user); err != nil || user.Version != 2 {
+			t.Fatalf("Expecting: %v, but got: %v instead", 2, user.Version)
+		}
+
+		stale.Username = "clobbered"
+		if err := users.UpdateUser(stale); app.KindOf(err) != app.PreconditionFailed {
+			t.Errorf("Expecting: %v, but got: %v instead", app.PreconditionFailed, err)
+		}
+
+		if err := users.VerifyEmail(user.ID, user.EmailAddress); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if err := users.ResetPassword(user.ID, "correct horse editor"); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		updated, err := users.User(user.ID)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if updated.Username != "editor" || updated.Version != 4 {
+			t.Errorf("Expecting: %v, but got: %v instead", 4, updated)
+		}
+	})
+
+	t.Run("VerifyEmail", func(t *testing.T) {
+		users, _ := newStores(t)
+		user := createUser(t, users, "writer")
+
+		if err := users.VerifyEmail(user.ID, "previous@example.com"); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+
+		if err := users.VerifyEmail(user.ID, "writer@example.com"); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		verified, err := users.User(user.ID)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if !verified.EmailVerified() {
+			t.Errorf("Expecting: %v, but got: %v instead", "a verified email address", verified.EmailVerifiedAt)
+		}
+	})
+
+	t.Run("ResetPassword", func(t *testing.T) {
+		users, _ := newStores(t)
+		user := createUser(t, users, "writer")
+
+		if err := users.ResetPassword(user.ID, "battery staple"); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if _, err := users.Login("writer@example.com", "correct horse writer"); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "the old password to be refused", err)
+		}
+
+		reset, err := users.Login("writer@example.com", "battery staple")
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if reset.SessionsRevokedAt <= 0 {
+			t.Errorf("Expecting: %v, but got: %v instead", "the sessions to be revoked", reset.SessionsRevokedAt)
+		}
+	})
+
+	t.Run("GetUserPosts", func(t *testing.T) {
+		users, posts := newStores(t)
+		user := createUser(t, users, "writer")
+		other := createUser(t, users, "other")
+
+		createPost(t, posts, user.ID, "First")
+		createPost(t, posts, user.ID, "Second")
+		createPost(t, posts, other.ID, "Third")
+
+		userPosts, err := users.GetUserPosts(user.ID)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if len(userPosts) != 2 {
+			t.Fatalf("Expecting: %v, but got: %v instead", 2, len(userPosts))
+		}
+
+		for _, p := range userPosts {
+			if p.Username != "writer" || p.Email != "writer@example.com" || p.PostTitle == "Third" {
+				t.Errorf("Expecting: %v, but got: %v instead", "the posts of the writer", p)
+			}
+		}
+	})
+
+	t.Run("Copies", func(t *testing.T) {
+		users, _ := newStores(t)
+		user := createUser(t, users, "writer")
+
+		// The caller owns what it passed or got back
+		user.Username = "changed"
+
+		found, err := users.User(user.ID)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		found.UserType = "admin"
+
+		again, err := users.UserByEmail("writer@example.com")
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if again.Username != "writer" || again.UserType != "reader" {
+			t.Errorf("Expecting: %v, but got: %v instead", "the stored user to be unchanged", again)
+		}
+	})
+
+	t.Run("DeleteUser", func(t *testing.T) {
+		users, posts := newStores(t)
+		user := createUser(t, users, "writer")
+		post := createPost(t, posts, user.ID, "First")
+
+		if err := users.DeleteUser(user.ID); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if _, err := users.User(user.ID); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "the user to be deleted", err)
+		}
+
+		if _, err := posts.Post(post.ID); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "the posts of the user to be deleted", err)
+		}
+
+		if err := users.DeleteUser(user.ID); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+
+		userPosts, err := users.GetUserPosts(user.ID)
+		if err != nil || len(userPosts) != 0 {
+			t.Errorf("Expecting: %v, but got: %v instead", "no posts", userPosts)
+		}
+	})
+}
+
+func testPosts(t *testing.T, newStores Stores) {
+	t.Run("CreatePost", func(t *testing.T) {
+		users, posts := newStores(t)
+		user := createUser(t, users, "writer")
+
+		if err := posts.CreatePost(nil); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+
+		post := createPost(t, posts, user.ID, "First")
+		if post.ID <= 0 || post.CreatedAt <= 0 {
+			t.Errorf("Expecting: %v, but got: %v instead", "an id and a creation time", post)
+		}
+
+		found, err := posts.Post(post.ID)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if found.PostTitle != "First" || found.PostBody != "Body of First" || found.CreatorID != user.ID {
+			t.Errorf("Expecting: %v, but got: %v instead", post, found)
+		}
+	})
+
+	t.Run("Post", func(t *testing.T) {
+		_, posts := newStores(t)
+
+		if _, err := posts.Post(0); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+
+		if _, err := posts.Post(1000); app.KindOf(err) != app.NotFound {
+			t.Errorf("Expecting: %v, but got: %v instead", app.NotFound, err)
+		}
+	})
+
+	t.Run("Posts", func(t *testing.T) {
+		users, posts := newStores(t)
+		user := createUser(t, users, "writer")
+		first := createPost(t, posts, user.ID, "First")
+		second := createPost(t, posts, user.ID, "Second")
+
+		all, err := posts.Posts()
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if len(all) != 2 || all[0].ID != second.ID || all[1].ID != first.ID {
+			t.Errorf("Expecting: %v, but got: %v instead", "the newest post first", all)
+		}
+	})
+
+	t.Run("PostsByCreator", func(t *testing.T) {
+		users, posts := newStores(t)
+		user := createUser(t, users, "writer")
+		other := createUser(t, users, "other")
+
+		first := createPost(t, posts, user.ID, "First")
+		createPost(t, posts, other.ID, "Other")
+		second := createPost(t, posts, user.ID, "Second")
+		third := createPost(t, posts, user.ID, "Third")
+
+		page, err := posts.PostsByCreator(user.ID, 0, 2)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if len(page) != 2 || page[0].ID != first.ID || page[1].ID != second.ID {
+			t.Errorf("Expecting: %v, but got: %v instead", []int64{first.ID, second.ID}, page)
+		}
+
+		page, err = posts.PostsByCreator(user.ID, second.ID, 2)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if len(page) != 1 || page[0].ID != third.ID {
+			t.Errorf("Expecting: %v, but got: %v instead", []int64{third.ID}, page)
+		}
+
+		count, err := posts.CountPostsByCreator(user.ID)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if count != 3 {
+			t.Errorf("Expecting: %v, but got: %v instead", 3, count)
+		}
+	})
+
+	t.Run("UpdatePost", func(t *testing.T) {
+		users, posts := newStores(t)
+		user := createUser(t, users, "writer")
+		other := createUser(t, users, "other")
+		post := createPost(t, posts, user.ID, "First")
+
+		post.PostTitle = "Edited"
+		if err := posts.UpdatePost(post); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		// Only the creator updates their post
+		hijacked := &app.Post{ID: post.ID, CreatorID: other.ID, PostTitle: "Hijacked", Version: post.Version}
+		if err := posts.UpdatePost(hijacked); app.KindOf(err) != app.NotFound {
+			t.Errorf("Expecting: %v, but got: %v instead", app.NotFound, err)
+		}
+
+		// Nor from a version they did not read
+		stale := &app.Post{ID: post.ID, CreatorID: user.ID, PostTitle: "Clobbered", Version: 1}
+		if err := posts.UpdatePost(stale); app.KindOf(err) != app.PreconditionFailed {
+			t.Errorf("Expecting: %v, but got: %v instead", app.PreconditionFailed, err)
+		}
+
+		updated, err := posts.Post(post.ID)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if updated.PostTitle != "Edited" || updated.UpdatedAt <= 0 || updated.Version != 2 || post.Version != 2 {
+			t.Errorf("Expecting: %v, but got: %v instead", "Edited", updated)
+		}
+	})
+
+	t.Run("DeletePost", func(t *testing.T) {
+		users, posts := newStores(t)
+		user := createUser(t, users, "writer")
+		post := createPost(t, posts, user.ID, "First")
+		kept := createPost(t, posts, user.ID, "Second")
+
+		if err := posts.DeletePost(post.ID); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if _, err := posts.Post(post.ID); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "the post to be deleted", err)
+		}
+
+		all, err := posts.Posts()
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if len(all) != 1 || all[0].ID != kept.ID {
+			t.Errorf("Expecting: %v, but got: %v instead", []int64{kept.ID}, all)
+		}
+	})
+
+	t.Run("Copies", func(t *testing.T) {
+		users, posts := newStores(t)
+		user := createUser(t, users, "writer")
+		post := createPost(t, posts, user.ID, "First")
+
+		post.PostTitle = "Changed"
+
+		all, err := posts.Posts()
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		all[0].PostBody = "Changed"
+
+		found, err := posts.Post(post.ID)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if found.PostTitle != "First" || found.PostBody != "Body of First" {
+			t.Errorf("Expecting: %v, but got: %v instead", "the stored post to be unchanged", found)
+		}
+	})
+
+	t.Run("ConcurrentCreate", func(t *testing.T) {
+		users, posts := newStores(t)
+		user := createUser(t, users, "writer")
+
+		var wg sync.WaitGroup
+		ids := make(chan int64, 20)
+
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				post := &app.Post{CreatorID: user.ID, PostTitle: fmt.Sprintf("Post %d", i)}
+				if err := posts.CreatePost(post); err != nil {
+					t.Errorf("Error occurred due to: %v", err)
+				}
+				ids <- post.ID
+			}(i)
+		}
+
+		wg.Wait()
+		close(ids)
+
+		unique := map[int64]bool{}
+		for id := range ids {
+			unique[id] = true
+		}
+
+		count, err := posts.CountPostsByCreator(user.ID)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if len(unique) != 20 || count != 20 {
+			t.Errorf("Expecting: %v, but got: %v instead", 20, len(unique))
+		}
+	})
+}
+
+// testCanceled checks that the Context variants give up on a done context.
+func testCanceled(t *testing.T, newStores Stores) {
+	users, posts := newStores(t)
+	writer := createUser(t, users, "writer")
+	createPost(t, posts, writer.ID, "First")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := users.UserContext(ctx, writer.ID); err == nil {
+		t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+	}
+
+	if _, err := users.UsersContext(ctx); err == nil {
+		t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+	}
+
+	if err := users.CreateUserContext(ctx, &app.User{Username: "reader", EmailAddress: "reader@example.com", Password: "correct horse"}); err == nil {
+		t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+	}
+
+	if _, err := posts.PostsContext(ctx); err == nil {
+		t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+	}
+
+	if err := posts.CreatePostContext(ctx, &app.Post{CreatorID: writer.ID, PostTitle: "Second"}); err == nil {
+		t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+	}
+
+	if err := posts.DeletePostContext(ctx, writer.ID); err == nil {
+		t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+	}
+
+	count, err := posts.CountPostsByCreator(writer.ID)
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	if count != 1 {
+		t.Errorf("Expecting: %v, but got: %v instead", 1, count)
+	}
+
+	if _, err = users.UserByEmail("reader@example.com"); err == nil {
+		t.Errorf("Expecting: %v, but got: %v instead", "no reader", err)
+	}
+}
+
+// createUser creates the user named username, with the
+// email username@example.com and password "correct horse username".
+func createUser(t *testing.T, users app.UserService, username string) *app.User {
+	t.Helper()
+
+	user := &app.User{
+		Username:     username,
+		EmailAddress: username + "@example.com",
+		Password:     "correct horse " + username,
+	}
+
+	if err := users.CreateUser(user); err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	return user
+}
+
+func createPost(t *testing.T, posts app.PostService, creatorID int64, title string) *app.Post {
+	t.Helper()
+
+	post := &app.Post{
+		CreatorID: creatorID,
+		PostTitle: title,
+		PostBody:  "Body of " + title,
+	}
+
+	if err := posts.CreatePost(post); err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	return post
+}
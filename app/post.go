@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"fmt"
 )
 
@@ -13,15 +14,30 @@ type Post struct {
 	CreatedAt int64  `json:"created_at" db:"created_at"`
 	UpdatedAt int64  `json:"updated_at" db:"updated_at"`
 	DeletedAt int64  `json:"deleted_at" db:"deleted_at"`
+	// Version is increased by every change of the post, and
+	// UpdatePost only saves the post at the version it holds.
+	Version int64 `json:"version" db:"version"`
 }
 
-// PostService defines the basic service of post
+// PostService defines the basic service of post. Like in UserService,
+// the Context variants give up once the context is done.
 type PostService interface {
 	CreatePost(*Post) error
 	Post(id int64) (*Post, error)
 	Posts() ([]*Post, error)
+	// PostsByCreator returns, by id, up to limit posts of the creator with an id above afterID.
+	PostsByCreator(creatorID, afterID int64, limit int) ([]*Post, error)
+	CountPostsByCreator(creatorID int64) (int, error)
 	UpdatePost(*Post) error
 	DeletePost(id int64) error
+
+	CreatePostContext(ctx context.Context, post *Post) error
+	PostContext(ctx context.Context, id int64) (*Post, error)
+	PostsContext(ctx context.Context) ([]*Post, error)
+	PostsByCreatorContext(ctx context.Context, creatorID, afterID int64, limit int) ([]*Post, error)
+	CountPostsByCreatorContext(ctx context.Context, creatorID int64) (int, error)
+	UpdatePostContext(ctx context.Context, post *Post) error
+	DeletePostContext(ctx context.Context, id int64) error
 }
 
 // TableName represents the table name of post
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+)
+
+// Profile represents what a user shares publicly about themselves.
+type Profile struct {
+	UserID      int64       `json:"-" db:"user_id"`
+	DisplayName string      `json:"display_name" db:"display_name"`
+	Bio         string      `json:"bio" db:"bio"`
+	Website     string      `json:"website" db:"website"`
+	Location    string      `json:"location" db:"location"`
+	SocialLinks SocialLinks `json:"social_links" db:"social_links"`
+	// AvatarUpdatedAt is zero while the user has not uploaded an avatar.
+	AvatarUpdatedAt int64 `json:"-" db:"avatar_updated_at"`
+	UpdatedAt       int64 `json:"updated_at" db:"updated_at"`
+}
+
+// SocialLinks maps a social network, e.g. "github", to the URL of the user's
+// page on it. It is stored as a JSON object.
+type SocialLinks map[string]string
+
+// ProfileService defines the basic service of profile
+type ProfileService interface {
+	// Profile returns an error of kind NotFound until the user saves a profile.
+	Profile(userID int64) (*Profile, error)
+	SaveProfile(*Profile) error
+}
+
+// Value implements the driver.Valuer interface.
+func (l SocialLinks) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (l *SocialLinks) Scan(src interface{}) error {
+	var b []byte
+
+	switch v := src.(type) {
+	case nil:
+		*l = SocialLinks{}
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return errors.New("app: cannot scan social links")
+	}
+
+	links := SocialLinks{}
+	if err := json.Unmarshal(b, &links); err != nil {
+		return err
+	}
+
+	*l = links
+	return nil
+}
+
+// TableName represents the table name of profile
+func (Profile) TableName() string {
+	return "profiles"
+}
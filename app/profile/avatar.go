@@ -0,0 +1,254 @@
+package profile
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	// The formats accepted for the uploads
+	_ "image/gif"
+	_ "image/jpeg"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/generate"
+)
+
+// MaxAvatarBytes limits the size of an uploaded avatar.
+const MaxAvatarBytes = 5 << 20
+
+// maxAvatarSide rejects the images that would take too much memory once decoded.
+const maxAvatarSide = 4096
+
+// AvatarSizes lists the sizes, in pixels, an uploaded avatar is resized to.
+var AvatarSizes = []int{32, 64, 128, 256}
+
+// DefaultAvatarSize is served when no size is asked for.
+const DefaultAvatarSize = 128
+
+var (
+	errAvatarTooLarge   = app.NewValidationError("Avatar must be at most 5MB", map[string]string{"avatar": "Avatar must be at most 5MB"})
+	errAvatarFormat     = app.NewValidationError("Avatar must be a PNG, JPEG or GIF image", map[string]string{"avatar": "Avatar must be a PNG, JPEG or GIF image"})
+	errAvatarDimensions = app.NewValidationError("Avatar must be at most 4096x4096 pixels", map[string]string{"avatar": "Avatar must be at most 4096x4096 pixels"})
+	errAvatarStorage    = app.NewError(app.Internal, "Failed to read or write the avatar")
+)
+
+// SetAvatar resizes the uploaded image to every AvatarSizes and stores them as PNG,
+// which also drops any metadata of the upload, e.g. the location of a photo.
+func (s *Service) SetAvatar(userID int64, r io.Reader) error {
+	data, err := ioutil.ReadAll(io.LimitReader(r, MaxAvatarBytes+1))
+	if err != nil {
+		return errAvatarFormat.Wrap(err)
+	}
+
+	if len(data) > MaxAvatarBytes {
+		return errAvatarTooLarge
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return errAvatarFormat
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || config.Width > maxAvatarSide || config.Height > maxAvatarSide {
+		return errAvatarDimensions
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return errAvatarFormat
+	}
+
+	profile, err := s.Profile(userID)
+	if err != nil {
+		return err
+	}
+
+	dir := s.avatarPath(userID)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return errAvatarStorage.Wrap(err)
+	}
+
+	for _, size := range AvatarSizes {
+		var buf bytes.Buffer
+		if err = png.Encode(&buf, resize(img, size)); err != nil {
+			return errAvatarStorage.Wrap(err)
+		}
+
+		if err = writeFile(filepath.Join(dir, strconv.Itoa(size)+".png"), buf.Bytes()); err != nil {
+			return errAvatarStorage.Wrap(err)
+		}
+	}
+
+	profile.AvatarUpdatedAt = time.Now().Unix()
+
+	return s.profileService.SaveProfile(profile)
+}
+
+// DeleteAvatar removes the uploaded avatar, the identicon is served instead.
+func (s *Service) DeleteAvatar(userID int64) error {
+	profile, err := s.Profile(userID)
+	if err != nil {
+		return err
+	}
+
+	if err = os.RemoveAll(s.avatarPath(userID)); err != nil {
+		return errAvatarStorage.Wrap(err)
+	}
+
+	profile.AvatarUpdatedAt = 0
+
+	return s.profileService.SaveProfile(profile)
+}
+
+// Avatar returns the avatar of the user with the given username, in the
+// smallest of AvatarSizes that is at least size. It is the uploaded image
+// as PNG, or else an identicon generated from the user id, as SVG unless
+// the format is "png".
+func (s *Service) Avatar(ctx context.Context, username string, size int, format string) (data []byte, contentType string, err error) {
+	user, err := s.userService.UserByUsernameContext(ctx, username)
+
+	if app.KindOf(err) == app.NotFound {
+		return nil, "", errUserNotFound.Wrap(err)
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	size = avatarSize(size)
+
+	profile, err := s.Profile(user.ID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if profile.AvatarUpdatedAt > 0 {
+		data, err = ioutil.ReadFile(filepath.Join(s.avatarPath(user.ID), strconv.Itoa(size)+".png"))
+		if err == nil {
+			return data, "image/png", nil
+		}
+
+		if !os.IsNotExist(err) {
+			return nil, "", errAvatarStorage.Wrap(err)
+		}
+	}
+
+	if format == "png" {
+		data, err = generate.IdenticonPNG(user.ID, size)
+		if err != nil {
+			return nil, "", errAvatarStorage.Wrap(err)
+		}
+
+		return data, "image/png", nil
+	}
+
+	return generate.Identicon(user.ID, size), "image/svg+xml", nil
+}
+
+func (s *Service) avatarPath(userID int64) string {
+	return filepath.Join(s.avatarDir, strconv.FormatInt(userID, 10))
+}
+
+func avatarSize(size int) int {
+	if size <= 0 {
+		return DefaultAvatarSize
+	}
+
+	for _, s := range AvatarSizes {
+		if s >= size {
+			return s
+		}
+	}
+
+	return AvatarSizes[len(AvatarSizes)-1]
+}
+
+// resize crops the centered square of img and scales it to size×size,
+// each pixel being the average of the source pixels it covers.
+func resize(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(src, src.Bounds(), img, image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2), draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	for y := 0; y < size; y++ {
+		y0, y1 := span(y, side, size)
+
+		for x := 0; x < size; x++ {
+			x0, x1 := span(x, side, size)
+
+			var sum [4]uint32
+			n := uint32((y1 - y0) * (x1 - x0))
+
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					i := src.PixOffset(sx, sy)
+					for c := 0; c < 4; c++ {
+						sum[c] += uint32(src.Pix[i+c])
+					}
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+
+	return dst
+}
+
+// span returns the source pixels covered by the i-th of to pixels scaled from
+// from pixels. When upscaling, each pixel covers the nearest source pixel.
+func span(i, from, to int) (start, end int) {
+	start = i * from / to
+	end = (i + 1) * from / to
+
+	if end <= start {
+		end = start + 1
+	}
+
+	return start, end
+}
+
+// writeFile replaces the file at once, so that it is never served half written.
+func writeFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".avatar")
+	if err != nil {
+		return err
+	}
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,215 @@
+// Package profile manages the public profiles of the users and their avatars.
+package profile
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rbo13/write-it/app"
+)
+
+// The maximum lengths, in characters, of the profile fields.
+const (
+	maxDisplayName = 64
+	maxBio         = 500
+	maxLocation    = 64
+	maxURL         = 255
+)
+
+// SocialNetworks lists the networks a profile can link to.
+var SocialNetworks = []string{"github", "gitlab", "twitter", "mastodon", "linkedin", "instagram", "youtube"}
+
+var (
+	errUserNotFound  = app.NewError(app.NotFound, "User not found")
+	errInvalidURL    = "Links must be absolute http or https URLs"
+	errUnknownSocial = "Unknown social network, expecting one of: " + strings.Join(SocialNetworks, ", ")
+)
+
+// Public is the profile of a user as anyone can see it,
+// it never holds the email address nor the password.
+type Public struct {
+	Username    string          `json:"username"`
+	DisplayName string          `json:"display_name"`
+	Bio         string          `json:"bio"`
+	Website     string          `json:"website"`
+	Location    string          `json:"location"`
+	SocialLinks app.SocialLinks `json:"social_links"`
+	AvatarURL   string          `json:"avatar_url"`
+	CreatedAt   int64           `json:"created_at"`
+}
+
+// Service reads and updates the profiles and the avatars.
+type Service struct {
+	profileService app.ProfileService
+	userService    app.UserService
+	baseURL        string
+	avatarDir      string
+}
+
+// New returns a profile Service. The avatar URLs point to baseURL,
+// the uploaded avatars are stored inside avatarDir.
+func New(profileService app.ProfileService, userService app.UserService, baseURL, avatarDir string) *Service {
+	return &Service{
+		profileService: profileService,
+		userService:    userService,
+		baseURL:        baseURL,
+		avatarDir:      avatarDir,
+	}
+}
+
+// Profile returns the profile of the user, which is empty until they save one.
+func (s *Service) Profile(userID int64) (*app.Profile, error) {
+	profile, err := s.profileService.Profile(userID)
+
+	if app.KindOf(err) == app.NotFound {
+		return &app.Profile{UserID: userID, SocialLinks: app.SocialLinks{}}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// Public returns the public profile of the user with the given username.
+func (s *Service) Public(ctx context.Context, username string) (*Public, error) {
+	user, err := s.userService.UserByUsernameContext(ctx, username)
+
+	if app.KindOf(err) == app.NotFound {
+		return nil, errUserNotFound.Wrap(err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := s.Profile(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Public{
+		Username:    user.Username,
+		DisplayName: profile.DisplayName,
+		Bio:         profile.Bio,
+		Website:     profile.Website,
+		Location:    profile.Location,
+		SocialLinks: profile.SocialLinks,
+		AvatarURL:   s.avatarURL(user.Username, profile),
+		CreatedAt:   user.CreatedAt,
+	}, nil
+}
+
+// Update checks and saves the profile fields of the user.
+// The avatar is changed through SetAvatar only.
+func (s *Service) Update(userID int64, fields *app.Profile) (*app.Profile, error) {
+	current, err := s.Profile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &app.Profile{
+		UserID:          userID,
+		DisplayName:     strings.TrimSpace(fields.DisplayName),
+		Bio:             strings.TrimSpace(fields.Bio),
+		Website:         strings.TrimSpace(fields.Website),
+		Location:        strings.TrimSpace(fields.Location),
+		SocialLinks:     app.SocialLinks{},
+		AvatarUpdatedAt: current.AvatarUpdatedAt,
+	}
+
+	for network, link := range fields.SocialLinks {
+		network = strings.ToLower(strings.TrimSpace(network))
+		if !contains(SocialNetworks, network) {
+			return nil, invalid("social_links."+network, errUnknownSocial)
+		}
+
+		if link = strings.TrimSpace(link); link != "" {
+			profile.SocialLinks[network] = link
+		}
+	}
+
+	if err = validate(profile); err != nil {
+		return nil, err
+	}
+
+	if err = s.profileService.SaveProfile(profile); err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// avatarURL returns the URL of the avatar, which changes with each upload
+// so that it can be cached for long.
+func (s *Service) avatarURL(username string, profile *app.Profile) string {
+	avatarURL := fmt.Sprintf("%s/api/v1/users/%s/avatar", s.baseURL, url.PathEscape(username))
+
+	if profile.AvatarUpdatedAt > 0 {
+		avatarURL += fmt.Sprintf("?v=%d", profile.AvatarUpdatedAt)
+	}
+
+	return avatarURL
+}
+
+func validate(profile *app.Profile) error {
+	fields := []struct {
+		key, name, value string
+		max              int
+	}{
+		{"display_name", "Display name", profile.DisplayName, maxDisplayName},
+		{"bio", "Bio", profile.Bio, maxBio},
+		{"location", "Location", profile.Location, maxLocation},
+	}
+
+	for _, field := range fields {
+		if utf8.RuneCountInString(field.value) > field.max {
+			return invalid(field.key, fmt.Sprintf("%s must be at most %d characters", field.name, field.max))
+		}
+	}
+
+	if profile.Website != "" && !validURL(profile.Website) {
+		return invalid("website", errInvalidURL)
+	}
+
+	for network, link := range profile.SocialLinks {
+		if !validURL(link) {
+			return invalid("social_links."+network, errInvalidURL)
+		}
+	}
+
+	return nil
+}
+
+// invalid returns the app.Validation error of a single field.
+func invalid(field, message string) error {
+	return app.NewValidationError(message, map[string]string{field: message})
+}
+
+// validURL only accepts absolute http(s) URLs, which rules out
+// e.g. `javascript:` links once the profile is rendered.
+func validURL(link string) bool {
+	if len(link) > maxURL {
+		return false
+	}
+
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	return u.Scheme == "http" || u.Scheme == "https"
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
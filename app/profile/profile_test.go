@@ -0,0 +1,185 @@
+package profile_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/profile"
+)
+
+// errNotFound is what the stores return when nothing matches.
+var errNotFound = app.NewError(app.NotFound, "Not found")
+
+type profileStore struct {
+	profiles map[int64]*app.Profile
+}
+
+func (s *profileStore) Profile(userID int64) (*app.Profile, error) {
+	p, ok := s.profiles[userID]
+	if !ok {
+		return nil, errNotFound
+	}
+	return p, nil
+}
+
+func (s *profileStore) SaveProfile(p *app.Profile) error {
+	s.profiles[p.UserID] = p
+	return nil
+}
+
+type userStore struct {
+	app.UserService
+}
+
+func (s *userStore) UserByUsernameContext(ctx context.Context, username string) (*app.User, error) {
+	switch username {
+	case "writer":
+		return &app.User{ID: 1, Username: "writer", EmailAddress: "writer@example.com", Password: "hash"}, nil
+	case "reader":
+		return &app.User{ID: 2, Username: "reader", EmailAddress: "reader@example.com", Password: "hash"}, nil
+	}
+	return nil, errNotFound
+}
+
+func TestProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avatars")
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	service := profile.New(&profileStore{profiles: map[int64]*app.Profile{}}, &userStore{}, "https://write-it.test", dir)
+
+	t.Run("Update", func(t *testing.T) {
+		_, err := service.Update(1, &app.Profile{
+			DisplayName: " Writer ",
+			Website:     "https://writer.example",
+			SocialLinks: app.SocialLinks{"GitHub": "https://github.com/writer"},
+		})
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		p, _ := service.Profile(1)
+		if p.DisplayName != "Writer" || p.SocialLinks["github"] != "https://github.com/writer" {
+			t.Errorf("Expecting: %v, but got: %v instead", "the saved profile", p)
+		}
+	})
+
+	t.Run("InvalidFields", func(t *testing.T) {
+		invalid := []*app.Profile{
+			{Website: "javascript:alert(1)"},
+			{Website: "writer.example"},
+			{SocialLinks: app.SocialLinks{"myspace": "https://myspace.com/writer"}},
+			{SocialLinks: app.SocialLinks{"github": "ftp://github.com/writer"}},
+			{Bio: strings.Repeat("a", 501)},
+		}
+
+		for _, fields := range invalid {
+			if _, err := service.Update(1, fields); err == nil {
+				t.Errorf("Expecting: %v, but got: %v instead", "an error", fields)
+			}
+		}
+	})
+
+	t.Run("PublicProfile", func(t *testing.T) {
+		public, err := service.Public(context.Background(), "writer")
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		b, _ := json.Marshal(public)
+		if bytes.Contains(b, []byte("writer@example.com")) || bytes.Contains(b, []byte("hash")) {
+			t.Errorf("Expecting: %v, but got: %v instead", "no email nor password", string(b))
+		}
+
+		if public.DisplayName != "Writer" || public.AvatarURL != "https://write-it.test/api/v1/users/writer/avatar" {
+			t.Errorf("Expecting: %v, but got: %v instead", "the profile of writer", public)
+		}
+
+		if _, err := service.Public(context.Background(), "nobody"); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+	})
+
+	t.Run("Identicon", func(t *testing.T) {
+		first, contentType, err := service.Avatar(context.Background(), "writer", 64, "")
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		again, _, _ := service.Avatar(context.Background(), "writer", 64, "")
+		other, _, _ := service.Avatar(context.Background(), "reader", 64, "")
+
+		if contentType != "image/svg+xml" || !bytes.Equal(first, again) || bytes.Equal(first, other) {
+			t.Errorf("Expecting: %v, but got: %v instead", "a deterministic identicon per user", string(first))
+		}
+
+		data, contentType, err := service.Avatar(context.Background(), "writer", 64, "png")
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if icon, err := png.Decode(bytes.NewReader(data)); err != nil || contentType != "image/png" || icon.Bounds().Dx() != 64 {
+			t.Errorf("Expecting: %v, but got: %v instead", "a 64x64 PNG", err)
+		}
+	})
+
+	t.Run("UploadAvatar", func(t *testing.T) {
+		img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+		for x := 0; x < 300; x++ {
+			for y := 0; y < 200; y++ {
+				img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+			}
+		}
+
+		var buf bytes.Buffer
+		png.Encode(&buf, img)
+
+		if err := service.SetAvatar(1, &buf); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		data, contentType, err := service.Avatar(context.Background(), "writer", 100, "")
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		avatar, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if contentType != "image/png" || avatar.Bounds().Dx() != 128 || avatar.Bounds().Dy() != 128 {
+			t.Errorf("Expecting: %v, but got: %v instead", "a 128x128 PNG", avatar.Bounds())
+		}
+
+		public, _ := service.Public(context.Background(), "writer")
+		if !strings.Contains(public.AvatarURL, "?v=") {
+			t.Errorf("Expecting: %v, but got: %v instead", "a versioned avatar URL", public.AvatarURL)
+		}
+
+		if err = service.DeleteAvatar(1); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if _, contentType, _ = service.Avatar(context.Background(), "writer", 100, ""); contentType != "image/svg+xml" {
+			t.Errorf("Expecting: %v, but got: %v instead", "image/svg+xml", contentType)
+		}
+	})
+
+	t.Run("InvalidAvatar", func(t *testing.T) {
+		if err := service.SetAvatar(1, strings.NewReader("<svg></svg>")); err == nil {
+			t.Errorf("Expecting: %v, but got: %v instead", "an error", err)
+		}
+	})
+}
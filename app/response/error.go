@@ -0,0 +1,73 @@
+package response
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/rbo13/write-it/app"
+)
+
+// errInternal is sent instead of the message of the errors that are not an *app.Error.
+const errInternal = "Internal server error"
+
+// statuses maps the kinds of app.Error to the status code they are answered with.
+var statuses = map[app.ErrorKind]uint{
+	app.Internal:           http.StatusInternalServerError,
+	app.NotFound:           http.StatusNotFound,
+	app.Conflict:           http.StatusConflict,
+	app.Validation:         http.StatusUnprocessableEntity,
+	app.Unauthorized:       http.StatusUnauthorized,
+	app.Forbidden:          http.StatusForbidden,
+	app.RateLimited:        http.StatusTooManyRequests,
+	app.PreconditionFailed: http.StatusPreconditionFailed,
+}
+
+// codes maps the kinds of app.Error to the `code` of the error responses.
+// Clients rely on them rather than on the messages, they must never change.
+var codes = map[app.ErrorKind]string{
+	app.Internal:           "internal_error",
+	app.NotFound:           "not_found",
+	app.Conflict:           "conflict",
+	app.Validation:         "validation_failed",
+	app.Unauthorized:       "unauthorized",
+	app.Forbidden:          "forbidden",
+	app.RateLimited:        "rate_limited",
+	app.PreconditionFailed: "precondition_failed",
+}
+
+// Error sends err to the client with the status code and the code of its
+// kind. Only the message and the fields of an *app.Error are sent: its cause,
+// and any other error, is logged, as it may hold e.g. a message of the driver.
+func Error(w http.ResponseWriter, r *http.Request, err error) {
+	e := app.ErrorOf(err)
+	if e == nil {
+		e = app.NewError(app.Internal, errInternal)
+	}
+
+	if e.Kind == app.Internal {
+		log.Printf("Error on %s %s: %v", r.Method, r.URL.Path, err)
+	}
+
+	JSONError(w, r, Config{
+		Message:    e.Message,
+		StatusCode: statuses[e.Kind],
+		Code:       codes[e.Kind],
+		Fields:     e.Fields,
+	})
+}
+
+// codeOf returns the code of the errors answered with status. The
+// statuses that no kind is answered with are bad requests.
+func codeOf(status uint) string {
+	for kind, s := range statuses {
+		if s == status {
+			return codes[kind]
+		}
+	}
+
+	if status >= http.StatusInternalServerError {
+		return codes[app.Internal]
+	}
+
+	return "bad_request"
+}
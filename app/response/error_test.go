@@ -0,0 +1,65 @@
+package response_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/response"
+)
+
+func TestError(t *testing.T) {
+	errTaken := app.NewError(app.Conflict, "Email address already taken")
+	errInvalid := app.NewValidationError("Invalid profile", map[string]string{"website": "Must be a valid URL"})
+
+	cases := []struct {
+		name    string
+		err     error
+		status  uint
+		code    string
+		message string
+	}{
+		{"Conflict", errTaken.Wrap(errors.New("duplicate key")), http.StatusConflict, "conflict", "Email address already taken"},
+		{"Validation", errInvalid, http.StatusUnprocessableEntity, "validation_failed", "Invalid profile"},
+		{"Internal", app.WrapError(app.Internal, "Failed to query the database", errors.New("driver: bad connection")), http.StatusInternalServerError, "internal_error", "Failed to query the database"},
+		{"Plain", errors.New("driver: bad connection"), http.StatusInternalServerError, "internal_error", "Internal server error"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			response.Error(w, httptest.NewRequest(http.MethodGet, "/users/1", nil), c.err)
+
+			var res response.JSONResponse
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatalf("Error occurred due to: %v", err)
+			}
+
+			if uint(w.Code) != c.status || res.StatusCode != c.status || res.Code != c.code {
+				t.Errorf("Expecting: %v, but got: %v instead", c.code, res.Code)
+			}
+
+			// The cause is only logged, never sent
+			if res.Message != c.message {
+				t.Errorf("Expecting: %v, but got: %v instead", c.message, res.Message)
+			}
+		})
+	}
+
+	t.Run("Fields", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		response.Error(w, httptest.NewRequest(http.MethodPut, "/profile", nil), errInvalid)
+
+		var res response.JSONResponse
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if res.Fields["website"] != "Must be a valid URL" {
+			t.Errorf("Expecting: %v, but got: %v instead", errInvalid.Fields, res.Fields)
+		}
+	})
+}
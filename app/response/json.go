@@ -12,6 +12,10 @@ type JSONResponse struct {
 	Message    string      `json:"message"`
 	Success    bool        `json:"success"`
 	Data       interface{} `json:"data"`
+	// Code is the machine-readable code of an error, see Error.
+	Code string `json:"code,omitempty"`
+	// Fields maps the invalid fields of a request to what is wrong with them.
+	Fields map[string]string `json:"fields,omitempty"`
 }
 
 // Config sets the different response configuration when returning a JSON responses.
@@ -19,6 +23,12 @@ type Config struct {
 	Message    string
 	StatusCode uint
 	Data       interface{}
+	// View decides which fields of Data are sent, it defaults to ViewPublic.
+	View View
+	// Code and Fields are only sent with errors. Code defaults to
+	// the code of the error kind answered with StatusCode.
+	Code   string
+	Fields map[string]string
 }
 
 // Configure configures the response by a given message, statusCode, data.
@@ -30,29 +40,57 @@ func Configure(message string, statusCode uint, data interface{}) Config {
 	}
 }
 
-// JSONOK sends an http.StatusOK as the response together with the custom response `JSONResponse`.
+// For returns the configuration with the data sent for the given view.
+func (con Config) For(view View) Config {
+	con.View = view
+	return con
+}
+
+// JSONOK sends the success configured by con with its status code, an
+// http.StatusOK when none is set, together with the custom response `JSONResponse`.
 func JSONOK(w http.ResponseWriter, r *http.Request, con Config) {
 	if con.StatusCode <= 0 {
 		con.StatusCode = http.StatusOK
 	}
 
+	render.Status(r, int(con.StatusCode))
 	render.JSON(w, r, &JSONResponse{
 		StatusCode: con.StatusCode,
 		Message:    con.Message,
 		Success:    true,
-		Data:       con.Data,
+		Data:       Redact(con.Data, con.View),
 	})
 
 	return
 }
 
-// JSONError handles the response for the client.
+// JSONError sends the error configured by con with its status code, an
+// http.StatusInternalServerError when it is not one of an error. The clients
+// preferring problem details get them from JSONProblem instead.
 func JSONError(w http.ResponseWriter, r *http.Request, con Config) {
+	w.Header().Add("Vary", "Accept")
+
+	if WantsProblem(r) {
+		JSONProblem(w, r, con)
+		return
+	}
+
+	if con.StatusCode < http.StatusBadRequest {
+		con.StatusCode = http.StatusInternalServerError
+	}
+
+	if con.Code == "" {
+		con.Code = codeOf(con.StatusCode)
+	}
+
+	render.Status(r, int(con.StatusCode))
 	render.JSON(w, r, &JSONResponse{
 		StatusCode: con.StatusCode,
 		Message:    con.Message,
 		Success:    false,
-		Data:       con.Data,
+		Data:       Redact(con.Data, con.View),
+		Code:       con.Code,
+		Fields:     con.Fields,
 	})
 
 	return
@@ -0,0 +1,97 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProblemContentType is the media type of the errors sent as RFC 7807 problem details.
+const ProblemContentType = "application/problem+json"
+
+// problemType prefixes the code of an error to make the type of its problem.
+const problemType = "urn:write-it:problem:"
+
+// Problem is an error response as described by RFC 7807, sent to
+// the clients accepting ProblemContentType over application/json.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Code is the code of the error, as sent in the JSONResponse.
+	Code string `json:"code,omitempty"`
+	// Errors lists the invalid fields of a request, sorted by field.
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError tells what is wrong with a field of a request.
+type FieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// JSONProblem sends the error configured by con as problem details,
+// with its status code. The Data of con is not sent.
+func JSONProblem(w http.ResponseWriter, r *http.Request, con Config) {
+	if con.StatusCode < http.StatusBadRequest {
+		con.StatusCode = http.StatusInternalServerError
+	}
+
+	if con.Code == "" {
+		con.Code = codeOf(con.StatusCode)
+	}
+
+	problem := &Problem{
+		Type:     problemType + con.Code,
+		Title:    http.StatusText(int(con.StatusCode)),
+		Status:   int(con.StatusCode),
+		Detail:   con.Message,
+		Instance: r.URL.RequestURI(),
+		Code:     con.Code,
+	}
+
+	for field, detail := range con.Fields {
+		problem.Errors = append(problem.Errors, FieldError{Field: field, Detail: detail})
+	}
+
+	sort.Slice(problem.Errors, func(i, j int) bool {
+		return problem.Errors[i].Field < problem.Errors[j].Field
+	})
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// WantsProblem tells whether the Accept header of r prefers ProblemContentType
+// to application/json. The clients accepting anything get the JSONResponse.
+func WantsProblem(r *http.Request) bool {
+	problem, envelope := 0.0, 0.0
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		params := strings.Split(accepted, ";")
+		quality := 1.0
+
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case ProblemContentType:
+			problem = quality
+		case "application/json":
+			envelope = quality
+		}
+	}
+
+	return problem > 0 && problem >= envelope
+}
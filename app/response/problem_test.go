@@ -0,0 +1,108 @@
+package response_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/response"
+)
+
+func TestWantsProblem(t *testing.T) {
+	accepts := []struct {
+		accept   string
+		expected bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"application/problem+json", true},
+		{"application/problem+json, application/json", true},
+		{"application/json, application/problem+json;q=0.5", false},
+		{"application/json;q=0.8, Application/Problem+JSON", true},
+		{"application/problem+json;q=0", false},
+	}
+
+	for _, a := range accepts {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", a.accept)
+
+		if response.WantsProblem(r) != a.expected {
+			t.Errorf("Expecting: %v, but got: %v instead", a.expected, a.accept)
+		}
+	}
+}
+
+func TestJSONProblem(t *testing.T) {
+	t.Run("Validation", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPut, "/api/v1/users/1/profile?draft=1", nil)
+		r.Header.Set("Accept", response.ProblemContentType)
+		w := httptest.NewRecorder()
+
+		response.Error(w, r, app.NewValidationError("Invalid profile", map[string]string{
+			"website": "Must be a valid URL",
+			"bio":     "Must be at most 300 characters",
+		}))
+
+		if w.Code != http.StatusUnprocessableEntity || w.Header().Get("Content-Type") != response.ProblemContentType {
+			t.Errorf("Expecting: %v, but got: %v instead", http.StatusUnprocessableEntity, w.Code)
+		}
+
+		var problem response.Problem
+		if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		expected := response.Problem{
+			Type:     "urn:write-it:problem:validation_failed",
+			Title:    "Unprocessable Entity",
+			Status:   http.StatusUnprocessableEntity,
+			Detail:   "Invalid profile",
+			Instance: "/api/v1/users/1/profile?draft=1",
+			Code:     "validation_failed",
+		}
+
+		if problem.Type != expected.Type || problem.Title != expected.Title || problem.Status != expected.Status ||
+			problem.Detail != expected.Detail || problem.Instance != expected.Instance || problem.Code != expected.Code {
+			t.Errorf("Expecting: %v, but got: %v instead", expected, problem)
+		}
+
+		if len(problem.Errors) != 2 || problem.Errors[0].Field != "bio" || problem.Errors[1].Detail != "Must be a valid URL" {
+			t.Errorf("Expecting: %v, but got: %v instead", "the bio and website errors", problem.Errors)
+		}
+	})
+
+	t.Run("Legacy", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/posts/1", nil)
+		r.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+
+		response.JSONError(w, r, response.Configure("Post not found", http.StatusNotFound, nil))
+
+		var res response.JSONResponse
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if w.Code != http.StatusNotFound || res.StatusCode != http.StatusNotFound || res.Code != "not_found" {
+			t.Errorf("Expecting: %v, but got: %v instead", http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("NotAnError", func(t *testing.T) {
+		for _, accept := range []string{"application/json", response.ProblemContentType} {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", accept)
+			w := httptest.NewRecorder()
+
+			// An error is never sent as a success
+			response.JSONError(w, r, response.Configure("Failed", http.StatusOK, nil))
+
+			if w.Code != http.StatusInternalServerError {
+				t.Errorf("Expecting: %v, but got: %v instead", http.StatusInternalServerError, w.Code)
+			}
+		}
+	})
+}
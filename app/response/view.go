@@ -0,0 +1,178 @@
+package response
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// View is who a response is written for, it decides which fields of the data are serialised.
+type View string
+
+// The views of a response. The fields of a struct choose the views they are
+// part of with their `view` tag: a field without the tag is part of every
+// view, `view:"-"` of none, and `view:"self,admin"` of the listed views only.
+const (
+	// ViewPublic is for anyone and is the default.
+	ViewPublic View = "public"
+	// ViewSelf is for the user the data belongs to.
+	ViewSelf View = "self"
+	// ViewAdmin is for the administrators.
+	ViewAdmin View = "admin"
+)
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// Redact returns a copy of data without the struct fields that are not part
+// of the view. The copy encodes to the same JSON as data, minus those fields.
+func Redact(data interface{}, view View) interface{} {
+	if view == "" {
+		view = ViewPublic
+	}
+
+	return redact(reflect.ValueOf(data), view)
+}
+
+func redact(v reflect.Value, view View) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	// Values encoding themselves are kept as they are
+	if v.Type().Implements(marshalerType) {
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return redact(v.Elem(), view)
+
+	case reflect.Struct:
+		fields := map[string]interface{}{}
+		redactStruct(v, view, fields)
+		return fields
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+
+		// Bytes are encoded as base64
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = redact(v.Index(i), view)
+		}
+		return items
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+
+		items := make(map[string]interface{}, v.Len())
+		for _, key := range v.MapKeys() {
+			items[fmt.Sprint(key.Interface())] = redact(v.MapIndex(key), view)
+		}
+		return items
+	}
+
+	return v.Interface()
+}
+
+// redactStruct adds the fields of the struct that are part of the view,
+// under their JSON names. The fields of embedded structs are added inline.
+func redactStruct(v reflect.Value, view View, fields map[string]interface{}) {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		name, options := tag[0], tag[1:]
+
+		if name == "-" && len(options) == 0 || !inView(field.Tag.Get("view"), view) {
+			continue
+		}
+
+		value := v.Field(i)
+
+		if field.Anonymous && name == "" {
+			if value.Kind() == reflect.Ptr {
+				if value.IsNil() {
+					continue
+				}
+				value = value.Elem()
+			}
+
+			if value.Kind() == reflect.Struct {
+				redactStruct(value, view, fields)
+				continue
+			}
+		}
+
+		// Unexported fields are never encoded
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		if hasOption(options, "omitempty") && isEmpty(value) {
+			continue
+		}
+
+		fields[name] = redact(value, view)
+	}
+}
+
+func inView(tag string, view View) bool {
+	if tag == "" {
+		return true
+	}
+
+	for _, v := range strings.Split(tag, ",") {
+		if View(strings.TrimSpace(v)) == view {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmpty follows the `omitempty` rules of encoding/json.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package response_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rbo13/write-it/app/response"
+)
+
+type base struct {
+	ID int64 `json:"id"`
+}
+
+type account struct {
+	base
+	Name     string            `json:"name"`
+	Email    string            `json:"email" view:"self,admin"`
+	Password string            `json:"password" view:"-"`
+	Note     string            `json:"note,omitempty" view:"admin"`
+	Secret   string            `json:"-"`
+	Friends  []*account        `json:"friends"`
+	Links    map[string]string `json:"links"`
+	hidden   string
+}
+
+func TestRedact(t *testing.T) {
+	friend := &account{base: base{ID: 2}, Name: "friend", Email: "friend@example.com", Password: "hash"}
+	data := map[string]interface{}{
+		"account": &account{
+			base:     base{ID: 1},
+			Name:     "writer",
+			Email:    "writer@example.com",
+			Password: "hash",
+			Secret:   "secret",
+			Friends:  []*account{friend},
+			Links:    map[string]string{"github": "https://github.com/writer"},
+			hidden:   "hidden",
+		},
+	}
+
+	views := []struct {
+		view     response.View
+		expected string
+	}{
+		{response.ViewPublic, `{"account":{"friends":[{"friends":null,"id":2,"links":null,"name":"friend"}],"id":1,"links":{"github":"https://github.com/writer"},"name":"writer"}}`},
+		{"", `{"account":{"friends":[{"friends":null,"id":2,"links":null,"name":"friend"}],"id":1,"links":{"github":"https://github.com/writer"},"name":"writer"}}`},
+		{response.ViewSelf, `{"account":{"email":"writer@example.com","friends":[{"email":"friend@example.com","friends":null,"id":2,"links":null,"name":"friend"}],"id":1,"links":{"github":"https://github.com/writer"},"name":"writer"}}`},
+	}
+
+	for _, v := range views {
+		t.Run(string(v.view), func(t *testing.T) {
+			b, err := json.Marshal(response.Redact(data, v.view))
+			if err != nil {
+				t.Fatalf("Error occurred due to: %v", err)
+			}
+
+			if string(b) != v.expected {
+				t.Errorf("Expecting: %v, but got: %v instead", v.expected, string(b))
+			}
+		})
+	}
+
+	t.Run("Values", func(t *testing.T) {
+		b, _ := json.Marshal(response.Redact([]interface{}{1, "a", nil, []byte("b")}, response.ViewPublic))
+		if string(b) != `[1,"a",null,"Yg=="]` {
+			t.Errorf("Expecting: %v, but got: %v instead", `[1,"a",null,"Yg=="]`, string(b))
+		}
+	})
+}
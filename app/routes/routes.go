@@ -1,36 +1,56 @@
 package routes
 
 import (
+	"net/http"
+
 	"github.com/go-chi/chi"
 	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/accesstoken"
 )
 
-// User sets the user related routes
-func User(r chi.Router, handler app.UserHandler) chi.Router {
-	r.Get("/", handler.Get)
+var (
+	usersRead  = accesstoken.RequireScope(accesstoken.ScopeUsersRead)
+	usersWrite = accesstoken.RequireScope(accesstoken.ScopeUsersWrite)
+	postsRead  = accesstoken.RequireScope(accesstoken.ScopePostsRead)
+	postsWrite = accesstoken.RequireScope(accesstoken.ScopePostsWrite)
+)
+
+// User sets the user related routes. The public profile, avatar, export download
+// and erasure confirmation routes are set outside of the authenticated routes.
+func User(r chi.Router, handler app.UserHandler, profileHandler app.ProfileHandler, exportHandler app.ExportHandler, erasureHandler app.ErasureHandler) chi.Router {
+	r.With(usersRead).Get("/", handler.Get)
 	// r.Get("/{id}", handler.GetByID)
 	// r.Get("/{id}/posts", handler.GetUserPosts)
 	// r.Put("/{id}", handler.Update)
 	// r.Delete("/{id}", handler.Delete)
 
 	r.Route("/{id}", func(r chi.Router) {
-		r.Get("/", handler.GetByID)
-		r.Get("/posts", handler.GetUserPosts)
-		r.Put("/", handler.Update)
-		r.Delete("/", handler.Delete)
+		r.With(usersRead).Get("/", handler.GetByID)
+		r.With(usersRead).Get("/posts", handler.GetUserPosts)
+		// The account itself, its credentials included, is only changed interactively
+		r.With(accesstoken.InteractiveOnly).Put("/", handler.Update)
+		r.With(accesstoken.InteractiveOnly).Delete("/", handler.Delete)
+		r.With(usersWrite).Put("/profile", profileHandler.Update)
+		r.With(usersWrite).Put("/avatar", profileHandler.UploadAvatar)
+		r.With(usersWrite).Delete("/avatar", profileHandler.DeleteAvatar)
+		r.With(accesstoken.InteractiveOnly).Post("/export", exportHandler.Start)
+		r.With(accesstoken.InteractiveOnly).Get("/erasure", erasureHandler.Status)
+		r.With(accesstoken.InteractiveOnly).Delete("/erasure", erasureHandler.Cancel)
 	})
 
 	return r
 }
 
-// Post sets the post related routes
-func Post(r chi.Router, handler app.Handler) chi.Router {
+// Post sets the post related routes. The given middlewares
+// only guard the routes that write a post.
+func Post(r chi.Router, handler app.Handler, writeMiddlewares ...func(http.Handler) http.Handler) chi.Router {
+	write := append([]func(http.Handler) http.Handler{postsWrite}, writeMiddlewares...)
 
-	r.Post("/create", handler.Create)
-	r.Get("/", handler.Get)
-	r.Get("/{id}", handler.GetByID)
-	r.Put("/{id}", handler.Update)
-	r.Delete("/{id}", handler.Delete)
+	r.With(write...).Post("/create", handler.Create)
+	r.With(postsRead).Get("/", handler.Get)
+	r.With(postsRead).Get("/{id}", handler.GetByID)
+	r.With(write...).Put("/{id}", handler.Update)
+	r.With(postsWrite).Delete("/{id}", handler.Delete)
 
 	// r.Route("/{id}", func(r chi.Router) {
 	//  r.Get("/", handler.GetByID)
@@ -39,3 +59,51 @@ func Post(r chi.Router, handler app.Handler) chi.Router {
 	// })
 	return r
 }
+
+// TwoFactor sets the two-factor authentication related routes
+func TwoFactor(r chi.Router, handler app.TwoFactorHandler) chi.Router {
+	r.Use(accesstoken.InteractiveOnly)
+
+	r.Post("/enrol", handler.Enrol)
+	r.Get("/qrcode", handler.QRCode)
+	r.Post("/confirm", handler.Confirm)
+	r.Delete("/", handler.Disable)
+
+	return r
+}
+
+// AccessToken sets the personal access token related routes
+func AccessToken(r chi.Router, handler app.AccessTokenHandler) chi.Router {
+	r.Use(accesstoken.InteractiveOnly)
+
+	r.Get("/", handler.Get)
+	r.Post("/", handler.Create)
+	r.Delete("/{id}", handler.Delete)
+
+	return r
+}
+
+// OAuth sets the OAuth2 client registration and consent routes
+func OAuth(r chi.Router, handler app.OAuthHandler) chi.Router {
+	r.Use(accesstoken.InteractiveOnly)
+
+	r.Get("/clients", handler.Clients)
+	r.Post("/clients", handler.RegisterClient)
+	r.Delete("/clients/{client_id}", handler.DeleteClient)
+	r.Get("/authorize", handler.Consent)
+	r.Post("/authorize", handler.Authorize)
+
+	return r
+}
+
+// Admin sets the admin related routes
+func Admin(r chi.Router, userHandler app.UserHandler, twoFactorHandler app.TwoFactorHandler, auditHandler app.AuditHandler) chi.Router {
+	r.Use(accesstoken.InteractiveOnly)
+
+	r.Put("/users/{id}/user-type", userHandler.SetUserType)
+	r.Put("/roles/{role}/two-factor", twoFactorHandler.SetRoleRequirement)
+	r.Get("/audit", auditHandler.Get)
+	r.Get("/audit/verify", auditHandler.Verify)
+
+	return r
+}
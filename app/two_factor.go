@@ -0,0 +1,36 @@
+package app
+
+// TwoFactor represents the TOTP enrolment of a user.
+// It only protects the account once EnabledAt is set.
+type TwoFactor struct {
+	UserID    int64  `json:"user_id" db:"user_id"`
+	Secret    string `json:"-" db:"secret"`
+	EnabledAt int64  `json:"enabled_at" db:"enabled_at"`
+	// LastStep is the last accepted TOTP time step, codes cannot be replayed.
+	LastStep  int64 `json:"-" db:"last_step"`
+	CreatedAt int64 `json:"created_at" db:"created_at"`
+}
+
+// TwoFactorService defines the basic service of two-factor authentication
+type TwoFactorService interface {
+	TwoFactor(userID int64) (*TwoFactor, error)
+	SaveTwoFactor(*TwoFactor) error
+	DeleteTwoFactor(userID int64) error
+	// UseStep records step as used, failing when it is not newer than the last one.
+	UseStep(userID, step int64) error
+	// ReplaceRecoveryCodes drops the previous recovery codes of the user.
+	ReplaceRecoveryCodes(userID int64, codeHashes []string) error
+	UseRecoveryCode(userID int64, codeHash string) error
+	RoleRequiresTwoFactor(userType string) (bool, error)
+	SetRoleRequiresTwoFactor(userType string, required bool) error
+}
+
+// Enabled returns true once the user confirmed the enrolment.
+func (t *TwoFactor) Enabled() bool {
+	return t != nil && t.EnabledAt > 0
+}
+
+// TableName represents the table name of two factor
+func (TwoFactor) TableName() string {
+	return "two_factor"
+}
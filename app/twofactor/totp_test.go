@@ -0,0 +1,57 @@
+package twofactor_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rbo13/write-it/app/twofactor"
+)
+
+// secret is the base32 encoding of the RFC 6238 SHA1 test key "12345678901234567890".
+const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTP(t *testing.T) {
+	t.Run("TestCode", func(t *testing.T) {
+		// The RFC test vectors use 8 digits, we keep the last 6.
+		vectors := map[int64]string{
+			59:         "287082",
+			1111111109: "081804",
+			1234567890: "005924",
+			2000000000: "279037",
+		}
+
+		for unix, want := range vectors {
+			got, err := twofactor.Code(secret, twofactor.Step(time.Unix(unix, 0)))
+			if err != nil {
+				t.Fatalf("Error occurred due to: %v", err)
+			}
+
+			if got != want {
+				t.Errorf("Expecting: %v, but got: %v instead", want, got)
+			}
+		}
+	})
+
+	t.Run("TestValidateSkew", func(t *testing.T) {
+		now := time.Unix(1234567890, 0)
+		previous, _ := twofactor.Code(secret, twofactor.Step(now)-1)
+		tooOld, _ := twofactor.Code(secret, twofactor.Step(now)-2)
+
+		if step, ok := twofactor.Validate(secret, previous, now); !ok || step != twofactor.Step(now)-1 {
+			t.Errorf("Expecting the previous code to be accepted, but got: %v %v instead", step, ok)
+		}
+
+		if _, ok := twofactor.Validate(secret, tooOld, now); ok {
+			t.Error("Expecting a code two periods old to be refused")
+		}
+	})
+
+	t.Run("TestURI", func(t *testing.T) {
+		uri := twofactor.URI("write-it", "writer@example.com", secret)
+
+		if !strings.HasPrefix(uri, "otpauth://totp/write-it:writer@example.com?") || !strings.Contains(uri, "secret="+secret) {
+			t.Errorf("Unexpected URI: %v", uri)
+		}
+	})
+}
@@ -0,0 +1,267 @@
+// Package twofactor adds TOTP based two-factor authentication to the login.
+package twofactor
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/generate"
+	"github.com/rbo13/write-it/app/jwtservice"
+)
+
+const (
+	challengePurpose  = "two_factor_challenge"
+	recoveryCodeCount = 10
+	qrCodeScale       = 6
+)
+
+var (
+	errAlreadyEnabled   = app.NewError(app.Conflict, "Two-factor authentication is already enabled")
+	errNotEnrolled      = app.NewError(app.NotFound, "Two-factor authentication is not enrolled")
+	errInvalidCode      = app.NewError(app.Unauthorized, "Authentication code is invalid")
+	errInvalidChallenge = app.NewError(app.Unauthorized, "Two-factor challenge is invalid or expired")
+	errRequiredByRole   = app.NewError(app.Forbidden, "Two-factor authentication is required for your account")
+)
+
+// Enrolment is what the user needs to add the account to an authenticator app.
+type Enrolment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// Service enrols users and checks their codes.
+type Service struct {
+	twoFactorService app.TwoFactorService
+	jwtService       *jwtservice.JWT
+	issuer           string
+	challengeTTL     time.Duration
+}
+
+// New returns a two-factor Service. The issuer is the name shown inside
+// the authenticator apps, and challenge tokens expire after challengeTTL.
+func New(twoFactorService app.TwoFactorService, jwtService *jwtservice.JWT, issuer string, challengeTTL time.Duration) *Service {
+	return &Service{
+		twoFactorService: twoFactorService,
+		jwtService:       jwtService,
+		issuer:           issuer,
+		challengeTTL:     challengeTTL,
+	}
+}
+
+// Enabled reports whether the user confirmed an enrolment.
+func (s *Service) Enabled(userID int64) bool {
+	twoFactor, err := s.twoFactorService.TwoFactor(userID)
+	return err == nil && twoFactor.Enabled()
+}
+
+// Required reports whether the role of the user must use two-factor authentication.
+func (s *Service) Required(user *app.User) (bool, error) {
+	return s.twoFactorService.RoleRequiresTwoFactor(user.UserType)
+}
+
+// SetRequired sets whether the users of a role must use two-factor authentication.
+func (s *Service) SetRequired(userType string, required bool) error {
+	return s.twoFactorService.SetRoleRequiresTwoFactor(userType, required)
+}
+
+// Enrol generates a new secret for the user. It replaces any
+// unconfirmed enrolment, but not an enabled one.
+func (s *Service) Enrol(user *app.User) (*Enrolment, error) {
+	if s.Enabled(user.ID) {
+		return nil, errAlreadyEnabled
+	}
+
+	secret, err := NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.twoFactorService.SaveTwoFactor(&app.TwoFactor{
+		UserID: user.ID,
+		Secret: secret,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Enrolment{
+		Secret: secret,
+		URI:    URI(s.issuer, user.EmailAddress, secret),
+	}, nil
+}
+
+// QRCode returns the otpauth:// URI of a pending enrolment as a PNG image.
+func (s *Service) QRCode(user *app.User) ([]byte, error) {
+	twoFactor, err := s.twoFactor(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if twoFactor.Enabled() {
+		return nil, errAlreadyEnabled
+	}
+
+	code, err := generate.NewQRCode(URI(s.issuer, user.EmailAddress, twoFactor.Secret))
+	if err != nil {
+		return nil, err
+	}
+
+	return code.PNG(qrCodeScale)
+}
+
+// Confirm enables the pending enrolment with the first code
+// from the authenticator app and returns the recovery codes,
+// which are only shown this time.
+func (s *Service) Confirm(user *app.User, code string) ([]string, error) {
+	twoFactor, err := s.twoFactor(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if twoFactor.Enabled() {
+		return nil, errAlreadyEnabled
+	}
+
+	step, ok := Validate(twoFactor.Secret, code, time.Now())
+	if !ok {
+		return nil, errInvalidCode
+	}
+
+	twoFactor.EnabledAt = time.Now().Unix()
+	twoFactor.LastStep = step
+
+	if err = s.twoFactorService.SaveTwoFactor(twoFactor); err != nil {
+		return nil, err
+	}
+
+	return s.newRecoveryCodes(user.ID)
+}
+
+// Disable removes the two-factor authentication of the user,
+// unless the role of the user requires it.
+func (s *Service) Disable(user *app.User, code string) error {
+	required, err := s.Required(user)
+	if err != nil {
+		return err
+	}
+
+	if required {
+		return errRequiredByRole
+	}
+
+	if err = s.check(user.ID, code); err != nil {
+		return err
+	}
+
+	return s.twoFactorService.DeleteTwoFactor(user.ID)
+}
+
+// Challenge returns the short-lived token exchanged for
+// the auth token once the second factor is checked.
+func (s *Service) Challenge(user *app.User) (string, error) {
+	return s.jwtService.EncodePurpose(challengePurpose, jwt.MapClaims{
+		"sub": user.ID,
+	}, s.challengeTTL)
+}
+
+// ChallengeUser returns the id of the user the challenge token was issued to,
+// so that the attempts can be limited per user before checking the code.
+func (s *Service) ChallengeUser(challenge string) (int64, error) {
+	claims, err := s.jwtService.DecodePurpose(challengePurpose, challenge)
+	if err != nil {
+		return 0, errInvalidChallenge.Wrap(err)
+	}
+
+	userID, ok := claims["sub"].(float64)
+	if !ok {
+		return 0, errInvalidChallenge
+	}
+
+	return int64(userID), nil
+}
+
+// VerifyChallenge checks the challenge token together with a TOTP
+// or recovery code, and returns the id of the authenticated user.
+func (s *Service) VerifyChallenge(challenge, code string) (int64, error) {
+	userID, err := s.ChallengeUser(challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = s.check(userID, code); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// check accepts a TOTP code that was not used yet, or an unused recovery code.
+func (s *Service) check(userID int64, code string) error {
+	twoFactor, err := s.twoFactor(userID)
+	if err != nil {
+		return err
+	}
+
+	if !twoFactor.Enabled() {
+		return errNotEnrolled
+	}
+
+	if step, ok := Validate(twoFactor.Secret, code, time.Now()); ok {
+		if err := s.twoFactorService.UseStep(userID, step); err != nil {
+			return errInvalidCode
+		}
+		return nil
+	}
+
+	if err := s.twoFactorService.UseRecoveryCode(userID, hashRecoveryCode(code)); err != nil {
+		return errInvalidCode
+	}
+
+	return nil
+}
+
+// twoFactor returns the enrolment of the user, errNotEnrolled when there is none.
+func (s *Service) twoFactor(userID int64) (*app.TwoFactor, error) {
+	twoFactor, err := s.twoFactorService.TwoFactor(userID)
+	if app.KindOf(err) == app.NotFound {
+		return nil, errNotEnrolled.Wrap(err)
+	}
+
+	return twoFactor, err
+}
+
+func (s *Service) newRecoveryCodes(userID int64) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	if err := s.twoFactorService.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// hashRecoveryCode ignores the case and the dashes typed by the user.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,15 @@
+package app
+
+import (
+	"context"
+)
+
+// UnitOfWork runs several operations of the user and post services
+// atomically: either all of them are saved, or none is.
+type UnitOfWork interface {
+	// Do runs fn in a transaction, committed when fn returns nil and rolled
+	// back otherwise. The services join it when fn calls their Context
+	// methods with the context it is given. fn may run again when the
+	// transaction is aborted by concurrent ones, and must not have other effects.
+	Do(ctx context.Context, fn func(ctx context.Context) error) error
+}
@@ -0,0 +1,115 @@
+package usecase
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/jwtauth"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/accesstoken"
+	"github.com/rbo13/write-it/app/response"
+)
+
+type accessTokenUsecase struct {
+	accessTokens *accesstoken.Service
+}
+
+type createAccessTokenRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt int64    `json:"expires_at"`
+}
+
+// NewAccessToken ...
+func NewAccessToken(accessTokens *accesstoken.Service) app.AccessTokenHandler {
+	return &accessTokenUsecase{
+		accessTokens,
+	}
+}
+
+func (a *accessTokenUsecase) Create(w http.ResponseWriter, r *http.Request) {
+	var req createAccessTokenRequest
+
+	_, claims, err := jwtauth.FromContext(r.Context())
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusForbidden, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	userID := int64(claims["user_id"].(float64))
+	token, plaintext, err := a.accessTokens.Create(userID, req.Name, req.Scopes, req.ExpiresAt)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("Access token created, copy it now as it will not be shown again", http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"token":        plaintext,
+	})
+	response.JSONOK(w, r, config)
+}
+
+func (a *accessTokenUsecase) Get(w http.ResponseWriter, r *http.Request) {
+	_, claims, err := jwtauth.FromContext(r.Context())
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusForbidden, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	tokens, err := a.accessTokens.List(int64(claims["user_id"].(float64)))
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("Access tokens successfully retrieved", http.StatusOK, map[string]interface{}{
+		"access_tokens": tokens,
+	})
+	response.JSONOK(w, r, config)
+}
+
+func (a *accessTokenUsecase) Delete(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusBadRequest, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	_, claims, err := jwtauth.FromContext(r.Context())
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusForbidden, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	err = a.accessTokens.Revoke(tokenID, int64(claims["user_id"].(float64)))
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("Access token successfully revoked", http.StatusOK, nil)
+	response.JSONOK(w, r, config)
+}
@@ -0,0 +1,138 @@
+package usecase
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/audit"
+	"github.com/rbo13/write-it/app/response"
+)
+
+type auditUsecase struct {
+	auditor *audit.Service
+}
+
+// NewAudit ...
+func NewAudit(auditor *audit.Service) app.AuditHandler {
+	return &auditUsecase{
+		auditor,
+	}
+}
+
+// Get lists the entries matching the `actor_id`, `action`, `target_type`,
+// `target_id`, `from` and `to` filters, the dates being RFC 3339. The
+// entries are paged with `after_id` and `limit`, or all sent with `format=csv`.
+func (a *auditUsecase) Get(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+
+		if err = a.auditor.WriteCSV(w, filter); err != nil {
+			log.Printf("Error exporting the audit log: %v", err)
+		}
+		return
+	}
+
+	entries, err := a.auditor.Entries(filter)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"entries": entries,
+	}
+
+	if len(entries) > 0 {
+		data["next_after_id"] = entries[len(entries)-1].ID
+	}
+
+	config := response.Configure("Audit entries successfully retrieved", http.StatusOK, data)
+	response.JSONOK(w, r, config)
+}
+
+func (a *auditUsecase) Verify(w http.ResponseWriter, r *http.Request) {
+	verification, err := a.auditor.Verify()
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	message := "Audit log is intact"
+	if !verification.Valid {
+		message = "Audit log was tampered with"
+	}
+
+	config := response.Configure(message, http.StatusOK, verification)
+	response.JSONOK(w, r, config)
+}
+
+// auditLogin records that the user logged in with the given method.
+func auditLogin(r *http.Request, user *app.User, method string) {
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionLogin,
+		ActorID:    user.ID,
+		TargetType: "user",
+		TargetID:   strconv.FormatInt(user.ID, 10),
+		After:      map[string]interface{}{"method": method},
+	})
+}
+
+// auditLoginFailed records a failed login, with the email when it is known.
+func auditLoginFailed(r *http.Request, email, method string) {
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionLoginFailed,
+		TargetType: "user",
+		After:      map[string]interface{}{"email": email, "method": method},
+	})
+}
+
+func auditFilter(r *http.Request) (app.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := app.AuditFilter{
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		TargetID:   query.Get("target_id"),
+	}
+
+	var err error
+
+	for name, dest := range map[string]*int64{"actor_id": &filter.ActorID, "after_id": &filter.AfterID} {
+		if val := query.Get(name); val != "" {
+			if *dest, err = strconv.ParseInt(val, 10, 64); err != nil {
+				return filter, err
+			}
+		}
+	}
+
+	for name, dest := range map[string]*int64{"from": &filter.From, "to": &filter.To} {
+		if val := query.Get(name); val != "" {
+			t, err := time.Parse(time.RFC3339, val)
+			if err != nil {
+				return filter, err
+			}
+			*dest = t.Unix()
+		}
+	}
+
+	if val := query.Get("limit"); val != "" {
+		if filter.Limit, err = strconv.Atoi(val); err != nil {
+			return filter, err
+		}
+	}
+
+	return filter, nil
+}
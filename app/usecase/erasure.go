@@ -0,0 +1,93 @@
+package usecase
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/erasure"
+	"github.com/rbo13/write-it/app/persistence/cache"
+	"github.com/rbo13/write-it/app/response"
+)
+
+type erasureUsecase struct {
+	eraser *erasure.Service
+}
+
+// NewErasure ...
+func NewErasure(eraser *erasure.Service) app.ErasureHandler {
+	return &erasureUsecase{
+		eraser,
+	}
+}
+
+func (e *erasureUsecase) Status(w http.ResponseWriter, r *http.Request) {
+	userID, ok := ownUserID(w, r)
+	if !ok {
+		return
+	}
+
+	pending, err := e.eraser.Pending(userID)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("Erasure successfully retrieved", http.StatusOK, map[string]interface{}{
+		"erasure": pending,
+	})
+	response.JSONOK(w, r, config)
+}
+
+func (e *erasureUsecase) Cancel(w http.ResponseWriter, r *http.Request) {
+	userID, ok := ownUserID(w, r)
+	if !ok {
+		return
+	}
+
+	err := e.eraser.Cancel(userID)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("Erasure successfully cancelled", http.StatusOK, nil)
+	response.JSONOK(w, r, config)
+}
+
+func (e *erasureUsecase) Confirm(w http.ResponseWriter, r *http.Request) {
+	confirmed, err := e.eraser.Confirm(r.URL.Query().Get("token"))
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("Erasure confirmed, it can be cancelled until `erase_after`", http.StatusOK, map[string]interface{}{
+		"erasure": confirmed,
+	})
+	response.JSONOK(w, r, config)
+}
+
+type cachePurger struct {
+	cache cache.Cacher
+}
+
+// NewCachePurger returns the erasure.Purger removing
+// the cached users and posts from c.
+func NewCachePurger(c cache.Cacher) erasure.Purger {
+	return &cachePurger{
+		c,
+	}
+}
+
+func (p *cachePurger) Purge(userID int64, postIDs []int64) {
+	forgetUser(p.cache, userID)
+
+	cache.Delete(p.cache, allPostsCacheKey)
+	for _, id := range postIDs {
+		cache.Delete(p.cache, strconv.FormatInt(id, 10))
+	}
+}
@@ -0,0 +1,66 @@
+package usecase
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/response"
+)
+
+const errPreconditionRequired = "The If-Match header is required, with the ETag of the version read"
+
+// errChanged is sent when If-Match does not hold the current version.
+var errChanged = app.NewError(app.PreconditionFailed, "It was changed since it was read, read it again")
+
+// etag returns the ETag of the version of a user or a post.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// notModified sets the ETag of the version read, and answers 304 when the
+// If-None-Match header of r lists it, in which case it returns true.
+func notModified(w http.ResponseWriter, r *http.Request, version int64) bool {
+	tag := etag(version)
+	w.Header().Set("ETag", tag)
+
+	for _, t := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		// The weak comparison of RFC 7232 ignores the W/ prefix
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+
+		if t == tag || t == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+
+	return false
+}
+
+// ifMatch tells whether the If-Match header of r lists the current version
+// of what r updates or deletes. It answers 428 when the header is missing,
+// and 412 when the client did not read the current version.
+func ifMatch(w http.ResponseWriter, r *http.Request, version int64) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		response.JSONError(w, r, response.Config{
+			Message:    errPreconditionRequired,
+			StatusCode: http.StatusPreconditionRequired,
+			Code:       "precondition_required",
+		})
+		return false
+	}
+
+	tag := etag(version)
+
+	// Unlike If-None-Match, the weak ETags never match
+	for _, t := range strings.Split(header, ",") {
+		if t = strings.TrimSpace(t); t == tag || t == "*" {
+			return true
+		}
+	}
+
+	response.Error(w, r, errChanged)
+	return false
+}
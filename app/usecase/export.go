@@ -0,0 +1,61 @@
+package usecase
+
+import (
+	"net/http"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/export"
+	"github.com/rbo13/write-it/app/response"
+)
+
+type exportUsecase struct {
+	exporter *export.Service
+}
+
+// NewExport ...
+func NewExport(exporter *export.Service) app.ExportHandler {
+	return &exportUsecase{
+		exporter,
+	}
+}
+
+func (e *exportUsecase) Start(w http.ResponseWriter, r *http.Request) {
+	userID, ok := ownUserID(w, r)
+	if !ok {
+		return
+	}
+
+	jobID, err := e.exporter.Start(userID)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("Export started, its progress is sent over the websocket", http.StatusAccepted, map[string]interface{}{
+		"job_id": jobID,
+	})
+	response.JSONOK(w, r, config)
+}
+
+func (e *exportUsecase) Download(w http.ResponseWriter, r *http.Request) {
+	file, err := e.exporter.Open(r.URL.Query().Get("token"))
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="write-it-export.zip"`)
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
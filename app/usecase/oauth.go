@@ -0,0 +1,261 @@
+package usecase
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/jwtauth"
+	"github.com/go-chi/render"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/oauth"
+	"github.com/rbo13/write-it/app/response"
+)
+
+// errApproveFailed is answered instead of the error of the store, which is only logged.
+const errApproveFailed = "Failed to grant the authorization"
+
+type oauthUsecase struct {
+	oauth *oauth.Service
+}
+
+type registerClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	Public       bool     `json:"public"`
+}
+
+type authorizeRequest struct {
+	Approved bool `json:"approved"`
+}
+
+type consentScope struct {
+	Scope       string `json:"scope"`
+	Description string `json:"description"`
+}
+
+// NewOAuth ...
+func NewOAuth(oauthService *oauth.Service) app.OAuthHandler {
+	return &oauthUsecase{
+		oauthService,
+	}
+}
+
+func (o *oauthUsecase) RegisterClient(w http.ResponseWriter, r *http.Request) {
+	var req registerClientRequest
+
+	_, claims, err := jwtauth.FromContext(r.Context())
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusForbidden, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	ownerID := int64(claims["user_id"].(float64))
+	client, secret, err := o.oauth.RegisterClient(ownerID, req.Name, req.RedirectURIs, req.Scopes, req.Public)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("OAuth client registered, copy the secret now as it will not be shown again", http.StatusOK, map[string]interface{}{
+		"client":        client,
+		"client_secret": secret,
+	})
+	response.JSONOK(w, r, config)
+}
+
+func (o *oauthUsecase) Clients(w http.ResponseWriter, r *http.Request) {
+	_, claims, err := jwtauth.FromContext(r.Context())
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusForbidden, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	clients, err := o.oauth.Clients(int64(claims["user_id"].(float64)))
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("OAuth clients successfully retrieved", http.StatusOK, map[string]interface{}{
+		"clients": clients,
+	})
+	response.JSONOK(w, r, config)
+}
+
+func (o *oauthUsecase) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	_, claims, err := jwtauth.FromContext(r.Context())
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusForbidden, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	err = o.oauth.DeleteClient(chi.URLParam(r, "client_id"), int64(claims["user_id"].(float64)))
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("OAuth client successfully deleted", http.StatusOK, nil)
+	response.JSONOK(w, r, config)
+}
+
+// Consent describes the authorization request so that the user can approve it.
+func (o *oauthUsecase) Consent(w http.ResponseWriter, r *http.Request) {
+	req, err := o.oauth.ParseAuthorization(r.URL.Query())
+
+	if req == nil {
+		config := response.Configure(err.Error(), http.StatusBadRequest, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusBadRequest, map[string]interface{}{
+			"redirect_to": req.ErrorRedirect(err),
+		})
+		response.JSONError(w, r, config)
+		return
+	}
+
+	scopes := make([]consentScope, len(req.Scopes))
+	for i, scope := range req.Scopes {
+		scopes[i] = consentScope{scope, oauth.ScopeDescriptions[scope]}
+	}
+
+	config := response.Configure(req.Client.Name+" wants to access your account", http.StatusOK, map[string]interface{}{
+		"client_id":    req.Client.ClientID,
+		"client_name":  req.Client.Name,
+		"redirect_uri": req.RedirectURI,
+		"scopes":       scopes,
+	})
+	response.JSONOK(w, r, config)
+}
+
+// Authorize records the decision of the user and returns where to send the user agent.
+func (o *oauthUsecase) Authorize(w http.ResponseWriter, r *http.Request) {
+	var decision authorizeRequest
+
+	req, err := o.oauth.ParseAuthorization(r.URL.Query())
+
+	if req == nil {
+		config := response.Configure(err.Error(), http.StatusBadRequest, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	if err == nil {
+		err = json.NewDecoder(r.Body).Decode(&decision)
+	}
+
+	if err == nil && !decision.Approved {
+		err = oauth.AccessDenied
+	}
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusBadRequest, map[string]interface{}{
+			"redirect_to": req.ErrorRedirect(err),
+		})
+		response.JSONError(w, r, config)
+		return
+	}
+
+	_, claims, _ := jwtauth.FromContext(r.Context())
+
+	redirectTo, err := o.oauth.Approve(req, int64(claims["user_id"].(float64)))
+
+	if err != nil {
+		log.Printf("Error approving the authorization of %s: %v", req.Client.ClientID, err)
+
+		config := response.Configure(errApproveFailed, http.StatusInternalServerError, map[string]interface{}{
+			"redirect_to": req.ErrorRedirect(err),
+		})
+		response.JSONError(w, r, config)
+		return
+	}
+
+	config := response.Configure("Authorization granted", http.StatusOK, map[string]interface{}{
+		"redirect_to": redirectTo,
+	})
+	response.JSONOK(w, r, config)
+}
+
+// Token is the token endpoint, it answers in the format of RFC 6749.
+func (o *oauthUsecase) Token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		oauthError(w, r, &oauth.Error{Code: "unsupported_grant_type", Description: "Only the authorization_code grant is supported", Status: http.StatusBadRequest})
+		return
+	}
+
+	clientID, clientSecret := clientCredentials(r)
+
+	token, err := o.oauth.Exchange(clientID, clientSecret, r.PostFormValue("code"), r.PostFormValue("redirect_uri"), r.PostFormValue("code_verifier"))
+
+	if err != nil {
+		oauthError(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, token)
+}
+
+// Introspect is the token introspection endpoint of RFC 7662.
+func (o *oauthUsecase) Introspect(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret := clientCredentials(r)
+
+	introspection, err := o.oauth.Introspect(r.Context(), clientID, clientSecret, r.PostFormValue("token"))
+
+	if err != nil {
+		oauthError(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, introspection)
+}
+
+// clientCredentials reads the client credentials from the basic
+// authentication, or from the form for the clients that cannot use it.
+func clientCredentials(r *http.Request) (clientID, clientSecret string) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		return id, secret
+	}
+
+	return r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+}
+
+func oauthError(w http.ResponseWriter, r *http.Request, err error) {
+	e, ok := err.(*oauth.Error)
+	if !ok {
+		e = &oauth.Error{Code: "server_error", Status: http.StatusInternalServerError}
+	}
+
+	if e.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="write-it"`)
+	}
+
+	render.Status(r, e.Status)
+	render.JSON(w, r, e)
+}
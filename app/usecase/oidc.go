@@ -0,0 +1,127 @@
+package usecase
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/oidc"
+	"github.com/rbo13/write-it/app/response"
+	"github.com/rbo13/write-it/app/twofactor"
+)
+
+// oidcCookie keeps the signed session of a sign in until the provider redirects back.
+const oidcCookie = "oidc_session"
+
+type oidcUsecase struct {
+	oidc        *oidc.Service
+	userService app.UserService
+	twoFactor   *twofactor.Service
+	secure      bool
+}
+
+// NewOIDC returns the handler of the sign in with the OpenID Connect providers.
+// The session cookie is only sent over HTTPS when secure is true.
+func NewOIDC(oidcService *oidc.Service, userService app.UserService, twoFactor *twofactor.Service, secure bool) app.OIDCHandler {
+	return &oidcUsecase{
+		oidcService,
+		userService,
+		twoFactor,
+		secure,
+	}
+}
+
+func (o *oidcUsecase) Providers(w http.ResponseWriter, r *http.Request) {
+	config := response.Configure("Identity providers successfully retrieved", http.StatusOK, map[string]interface{}{
+		"providers": o.oidc.Providers(),
+	})
+	response.JSONOK(w, r, config)
+}
+
+func (o *oidcUsecase) Begin(w http.ResponseWriter, r *http.Request) {
+	provider := chi.URLParam(r, "provider")
+
+	authURL, session, err := o.oidc.Begin(provider)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    session,
+		Path:     "/login/oidc/" + provider,
+		HttpOnly: true,
+		Secure:   o.secure,
+		// Lax, so that the cookie comes back with the redirect of the provider.
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (o *oidcUsecase) Callback(w http.ResponseWriter, r *http.Request) {
+	provider := chi.URLParam(r, "provider")
+	query := r.URL.Query()
+
+	// The session is single use, whatever the outcome
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Path:     "/login/oidc/" + provider,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   o.secure,
+	})
+
+	if reason := query.Get("error"); reason != "" {
+		config := response.Configure("The identity provider refused the sign in: "+reason, http.StatusUnauthorized, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	session := ""
+	if cookie, err := r.Cookie(oidcCookie); err == nil {
+		session = cookie.Value
+	}
+
+	user, err := o.oidc.Finish(r.Context(), provider, session, query.Get("state"), query.Get("code"))
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	// Enrolled users exchange the challenge and a code for the auth token
+	if o.twoFactor.Enabled(user.ID) {
+		challenge, err := o.twoFactor.Challenge(user)
+
+		if err != nil {
+			response.Error(w, r, err)
+			return
+		}
+
+		config := response.Configure("Two-factor authentication required", http.StatusOK, map[string]interface{}{
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+		})
+		response.JSONOK(w, r, config)
+		return
+	}
+
+	authToken, err := o.userService.GenerateAuthToken(user)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	auditLogin(r, user, "oidc:"+chi.URLParam(r, "provider"))
+
+	config := response.Configure("Logged in sucessfully", http.StatusOK, map[string]interface{}{
+		"user":       user,
+		"auth_token": authToken,
+	}).For(response.ViewSelf)
+	response.JSONOK(w, r, config)
+}
@@ -0,0 +1,97 @@
+package usecase
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/lockout"
+	"github.com/rbo13/write-it/app/passwordreset"
+	"github.com/rbo13/write-it/app/response"
+)
+
+const (
+	forgotPasswordMessage = "If the Email Address belongs to an account, a password reset link has been sent to it"
+	tooManyResetsMessage  = "Too many password reset requests, retry later"
+)
+
+type passwordUsecase struct {
+	resetter *passwordreset.Service
+	guard    *lockout.Guard
+}
+
+type forgotPasswordRequest struct {
+	EmailAddress string `json:"email_address"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// NewPassword returns the password handler. The reset emails are counted
+// per email address and IP address by the guard, apart from the failed
+// logins, which slows them down and stops them once too many were sent.
+func NewPassword(resetter *passwordreset.Service, guard *lockout.Guard) app.PasswordHandler {
+	return &passwordUsecase{
+		resetter,
+		guard.Scoped("password_reset").Silent(),
+	}
+}
+
+func (p *passwordUsecase) Forgot(w http.ResponseWriter, r *http.Request) {
+	var req forgotPasswordRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	ip := clientIP(r)
+
+	// Every address is throttled alike, whether it belongs to an account or not
+	if err = p.guard.Check(req.EmailAddress, ip); err != nil {
+		if locked, ok := err.(*lockout.LockedError); ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(locked.RetryAfter.Seconds()+0.5)))
+		}
+
+		config := response.Configure(tooManyResetsMessage, http.StatusTooManyRequests, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	p.guard.Fail(req.EmailAddress, ip)
+
+	// The lookup and the email are done in the background so that the
+	// response time does not tell whether the account exists.
+	go p.resetter.Forgot(req.EmailAddress)
+
+	config := response.Configure(forgotPasswordMessage, http.StatusOK, nil)
+	response.JSONOK(w, r, config)
+}
+
+func (p *passwordUsecase) Reset(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	err = p.resetter.Reset(r.Context(), req.Token, req.Password)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("Password successfully reset", http.StatusOK, nil)
+	response.JSONOK(w, r, config)
+}
@@ -9,12 +9,15 @@ import (
 	"github.com/go-chi/jwtauth"
 
 	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/audit"
 	"github.com/rbo13/write-it/app/persistence/cache"
 	"github.com/rbo13/write-it/app/response"
+	"github.com/rbo13/write-it/app/validation"
 )
 
 type postUsecase struct {
 	postService app.PostService
+	cache       cache.Cacher
 }
 
 type postResponse struct {
@@ -24,15 +27,23 @@ type postResponse struct {
 	Data       interface{} `json:"data"`
 }
 
+// postRequest holds the fields of a post a client may set, the others
+// are owned by the server and rejected as unknown.
+type postRequest struct {
+	PostTitle string `json:"post_title"`
+	PostBody  string `json:"post_body"`
+}
+
 // NewPost ...
-func NewPost(postService app.PostService) app.Handler {
+func NewPost(postService app.PostService, cacher cache.Cacher) app.Handler {
 	return &postUsecase{
 		postService,
+		cacher,
 	}
 }
 
 func (p *postUsecase) Create(w http.ResponseWriter, r *http.Request) {
-	var post app.Post
+	var req postRequest
 
 	_, claims, err := jwtauth.FromContext(r.Context())
 
@@ -42,24 +53,35 @@ func (p *postUsecase) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	post.CreatorID = int64(claims["user_id"].(float64))
-
-	err = json.NewDecoder(r.Body).Decode(&post)
+	errs, err := validation.Decode(r.Body, &req)
+	post := app.Post{
+		CreatorID: int64(claims["user_id"].(float64)),
+		PostTitle: req.PostTitle,
+		PostBody:  req.PostBody,
+	}
+	if err == nil {
+		err = validation.Post(&post, errs)
+	}
 
 	if err != nil {
-		config := response.Configure(err.Error(), http.StatusBadRequest, nil)
-		response.JSONError(w, r, config)
+		response.Error(w, r, err)
 		return
 	}
 
-	err = p.postService.CreatePost(&post)
+	err = p.postService.CreatePostContext(r.Context(), &post)
 
 	if err != nil {
-		config := response.Configure(err.Error(), http.StatusBadRequest, nil)
-		response.JSONError(w, r, config)
+		response.Error(w, r, err)
 		return
 	}
 
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionPostCreate,
+		TargetType: "post",
+		TargetID:   strconv.FormatInt(post.ID, 10),
+		After:      post,
+	})
+
 	config := response.Configure("Post created successfully", http.StatusOK, post)
 	response.JSONOK(w, r, config)
 	return
@@ -69,10 +91,8 @@ func (p *postUsecase) Get(w http.ResponseWriter, r *http.Request) {
 
 	// get from cache first
 	var posts []*app.Post
-	cacheKey = "getAllPosts"
-	mem := BootMemcached()
 
-	err := cache.Get(mem, cacheKey, &posts)
+	err := cache.Get(p.cache, allPostsCacheKey, &posts)
 	if err == nil {
 		config := response.Configure("Posts successfully retrieved", http.StatusOK, map[string]interface{}{
 			"posts":  posts,
@@ -82,20 +102,15 @@ func (p *postUsecase) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	posts, err = p.postService.Posts()
+	posts, err = p.postService.PostsContext(r.Context())
 
 	if err != nil {
-		config := response.Configure(err.Error(), http.StatusInternalServerError, nil)
-		response.JSONError(w, r, config)
+		response.Error(w, r, err)
 		return
 	}
 
-	ok, err := cache.Set(mem, cacheKey, posts)
-	if err != nil && !ok {
-		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
-		response.JSONError(w, r, config)
-		return
-	}
+	// The cache only spares the next queries, failing to fill it fails nothing
+	cache.Set(p.cache, allPostsCacheKey, posts)
 
 	config := response.Configure("Posts successfully retrieved", http.StatusOK, map[string]interface{}{
 		"posts":  posts,
@@ -116,11 +131,14 @@ func (p *postUsecase) GetByID(w http.ResponseWriter, r *http.Request) {
 
 	// get from cache
 	var post *app.Post
-	cacheKey = chi.URLParam(r, "id")
-	mem := BootMemcached()
+	cacheKey := chi.URLParam(r, "id")
 
-	err = cache.Get(mem, cacheKey, &post)
+	err = cache.Get(p.cache, cacheKey, &post)
 	if err == nil {
+		if notModified(w, r, post.Version) {
+			return
+		}
+
 		config := response.Configure("Post successfully retrieved", http.StatusOK, map[string]interface{}{
 			"post":   post,
 			"cached": true,
@@ -129,18 +147,16 @@ func (p *postUsecase) GetByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	post, err = p.postService.Post(postID)
+	post, err = p.postService.PostContext(r.Context(), postID)
 
 	if err != nil {
-		config := response.Configure(err.Error(), http.StatusNotFound, nil)
-		response.JSONError(w, r, config)
+		response.Error(w, r, err)
 		return
 	}
 
-	ok, err := cache.Set(mem, cacheKey, post)
-	if err != nil && !ok {
-		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
-		response.JSONError(w, r, config)
+	cache.Set(p.cache, cacheKey, post)
+
+	if notModified(w, r, post.Version) {
 		return
 	}
 
@@ -152,16 +168,27 @@ func (p *postUsecase) GetByID(w http.ResponseWriter, r *http.Request) {
 }
 
 func (p *postUsecase) Update(w http.ResponseWriter, r *http.Request) {
-	var post app.Post
+	var req postRequest
 	postID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
-	check(err, w, r)
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusBadRequest, nil)
+		response.JSONError(w, r, config)
+		return
+	}
 
 	_, claims, err := jwtauth.FromContext(r.Context())
-	check(err, w, r)
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
 
 	// find a user by the given id
-	postFetchRes, err := p.postService.Post(postID)
-	check(err, w, r)
+	postFetchRes, err := p.postService.PostContext(r.Context(), postID)
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
 
 	userID := int64(claims["user_id"].(float64))
 	if postFetchRes.CreatorID != userID {
@@ -170,18 +197,41 @@ func (p *postUsecase) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	post.ID = postFetchRes.ID
-	post.CreatorID = int64(claims["user_id"].(float64))
-	post.CreatedAt = postFetchRes.CreatedAt
+	if !ifMatch(w, r, postFetchRes.Version) {
+		return
+	}
 
-	err = json.NewDecoder(r.Body).Decode(&post)
+	// The stored post keeps its id, creator, creation time and the
+	// version it is saved at, only its title and body change.
+	errs, err := validation.Decode(r.Body, &req)
+	post := *postFetchRes
+	post.PostTitle, post.PostBody = req.PostTitle, req.PostBody
+	if err == nil {
+		err = validation.Post(&post, errs)
+	}
 
-	check(err, w, r)
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
 
-	err = p.postService.UpdatePost(&post)
+	err = p.postService.UpdatePostContext(r.Context(), &post)
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
 
-	check(err, w, r)
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionPostUpdate,
+		TargetType: "post",
+		TargetID:   strconv.FormatInt(post.ID, 10),
+		Before:     postFetchRes,
+		After:      post,
+	})
+
+	p.forget(chi.URLParam(r, "id"))
 
+	w.Header().Set("ETag", etag(post.Version))
 	config := response.Configure("Post Successfully Updated", http.StatusOK, post)
 	response.JSONOK(w, r, config)
 }
@@ -196,12 +246,14 @@ func (p *postUsecase) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	_, claims, err := jwtauth.FromContext(r.Context())
-	check(err, w, r)
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
 
-	postResp, err := p.postService.Post(postID)
+	postResp, err := p.postService.PostContext(r.Context(), postID)
 	if err != nil {
-		config := response.Configure(err.Error(), http.StatusNotFound, nil)
-		response.JSONError(w, r, config)
+		response.Error(w, r, err)
 		return
 	}
 
@@ -212,34 +264,35 @@ func (p *postUsecase) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = p.postService.DeletePost(postID)
+	if !ifMatch(w, r, postResp.Version) {
+		return
+	}
+
+	err = p.postService.DeletePostContext(r.Context(), postID)
 
 	if err != nil {
-		config := response.Configure(err.Error(), http.StatusBadRequest, nil)
-		response.JSONError(w, r, config)
+		response.Error(w, r, err)
 		return
 	}
-	mem := BootMemcached()
-	allPostsKey := "getAllPosts"
-	singlePostKey := chi.URLParam(r, "id")
-
-	_, err = cache.Delete(mem, allPostsKey)
-	check(err, w, r)
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionPostDelete,
+		TargetType: "post",
+		TargetID:   strconv.FormatInt(postID, 10),
+		Before:     postResp,
+	})
 
-	_, err = cache.Delete(mem, singlePostKey)
-	check(err, w, r)
+	p.forget(chi.URLParam(r, "id"))
 
 	config := response.Configure("Post Successfully Deleted", http.StatusOK, nil)
 	response.JSONOK(w, r, config)
 }
 
-func check(err error, w http.ResponseWriter, r *http.Request) {
-	if err != nil {
-		config := response.Configure(err.Error(), http.StatusBadRequest, nil)
-		response.JSONError(w, r, config)
-		return
+// forget removes the cached copies of the post, so that
+// its readers get the ETag of the version just saved.
+func (p *postUsecase) forget(id string) {
+	for _, key := range []string{allPostsCacheKey, id} {
+		cache.Delete(p.cache, key)
 	}
-	return
 }
 
 // Unmarshaler handles the unmarshaling of data
@@ -0,0 +1,165 @@
+package usecase
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/jwtauth"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/profile"
+	"github.com/rbo13/write-it/app/response"
+)
+
+const (
+	errOtherProfile  = "Cannot update the profile of other User"
+	errAvatarMissing = "An image is required in the `avatar` field"
+)
+
+type profileUsecase struct {
+	profiles *profile.Service
+}
+
+// NewProfile ...
+func NewProfile(profiles *profile.Service) app.ProfileHandler {
+	return &profileUsecase{
+		profiles,
+	}
+}
+
+func (p *profileUsecase) Get(w http.ResponseWriter, r *http.Request) {
+	public, err := p.profiles.Public(r.Context(), chi.URLParam(r, "username"))
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("Profile successfully retrieved", http.StatusOK, map[string]interface{}{
+		"profile": public,
+	})
+	response.JSONOK(w, r, config)
+}
+
+func (p *profileUsecase) Update(w http.ResponseWriter, r *http.Request) {
+	userID, ok := ownUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var fields app.Profile
+
+	err := json.NewDecoder(r.Body).Decode(&fields)
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	updated, err := p.profiles.Update(userID, &fields)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("Profile successfully updated", http.StatusOK, map[string]interface{}{
+		"profile": updated,
+	})
+	response.JSONOK(w, r, config)
+}
+
+func (p *profileUsecase) Avatar(w http.ResponseWriter, r *http.Request) {
+	size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+
+	data, contentType, err := p.profiles.Avatar(r.Context(), chi.URLParam(r, "username"), size, r.URL.Query().Get("format"))
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(data)
+}
+
+func (p *profileUsecase) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	userID, ok := ownUserID(w, r)
+	if !ok {
+		return
+	}
+
+	// Leaves room for the multipart envelope around the image
+	r.Body = http.MaxBytesReader(w, r.Body, profile.MaxAvatarBytes+64<<10)
+
+	file, _, err := r.FormFile("avatar")
+
+	if err != nil {
+		config := response.Configure(errAvatarMissing, http.StatusUnprocessableEntity, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+	defer file.Close()
+
+	err = p.profiles.SetAvatar(userID, file)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("Avatar successfully uploaded", http.StatusOK, nil)
+	response.JSONOK(w, r, config)
+}
+
+func (p *profileUsecase) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	userID, ok := ownUserID(w, r)
+	if !ok {
+		return
+	}
+
+	err := p.profiles.DeleteAvatar(userID)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("Avatar successfully deleted", http.StatusOK, nil)
+	response.JSONOK(w, r, config)
+}
+
+// ownUserID returns the `id` of the URL when it is the authenticated user,
+// otherwise it writes the error response.
+func ownUserID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+
+	if err != nil || userID <= 0 {
+		config := response.Configure("User id is invalid", http.StatusUnprocessableEntity, nil)
+		response.JSONError(w, r, config)
+		return 0, false
+	}
+
+	_, claims, err := jwtauth.FromContext(r.Context())
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusForbidden, nil)
+		response.JSONError(w, r, config)
+		return 0, false
+	}
+
+	authID, _ := claims["user_id"].(float64)
+	if userID != int64(authID) {
+		config := response.Configure(errOtherProfile, http.StatusForbidden, nil)
+		response.JSONError(w, r, config)
+		return 0, false
+	}
+
+	return userID, true
+}
@@ -0,0 +1,139 @@
+package usecase
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/jwtauth"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/response"
+	"github.com/rbo13/write-it/app/twofactor"
+)
+
+const (
+	errSessionRevoked     = "Session has been revoked, please login again"
+	errForbiddenUserType  = "You are not allowed to access this resource"
+	errTwoFactorEnrolment = "Two-factor authentication is required for your account, please enrol first"
+)
+
+type contextKey string
+
+const userCtxKey contextKey = "user"
+
+// ActiveSession is a middleware that rejects the auth tokens issued before
+// the sessions of their user were revoked, e.g. by a password reset.
+// It must run after the JWT verifier, and stores the authenticated user
+// inside the request context.
+func ActiveSession(userService app.UserService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, claims, err := jwtauth.FromContext(r.Context())
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			userID, _ := claims["user_id"].(float64)
+			issuedAt, _ := claims["iat"].(float64)
+
+			user, err := userService.UserContext(r.Context(), int64(userID))
+			if err != nil && app.KindOf(err) == app.Internal {
+				response.Error(w, r, err)
+				return
+			}
+
+			// The users deleted since are signed out too
+			if err != nil || int64(issuedAt) <= user.SessionsRevokedAt {
+				config := response.Configure(errSessionRevoked, http.StatusUnauthorized, nil)
+				response.JSONError(w, r, config)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userCtxKey, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// userFromContext returns the user stored by ActiveSession.
+func userFromContext(ctx context.Context) (*app.User, bool) {
+	user, ok := ctx.Value(userCtxKey).(*app.User)
+	return user, ok
+}
+
+// viewOf returns the representation of the data of the user
+// userID that the authenticated user is allowed to see.
+func viewOf(r *http.Request, userID int64) response.View {
+	user, ok := userFromContext(r.Context())
+
+	switch {
+	case ok && user.UserType == "admin":
+		return response.ViewAdmin
+	case ok && user.ID == userID:
+		return response.ViewSelf
+	}
+
+	return response.ViewPublic
+}
+
+// RequireUserType is a middleware that only lets the given types of user through.
+// It must run after ActiveSession.
+func RequireUserType(userTypes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := userFromContext(r.Context())
+			if ok {
+				for _, userType := range userTypes {
+					if user.UserType == userType {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+
+			config := response.Configure(errForbiddenUserType, http.StatusForbidden, nil)
+			response.JSONError(w, r, config)
+		})
+	}
+}
+
+// RequireTwoFactor is a middleware that only lets the users whose role requires
+// two-factor authentication reach the enrolment routes until they are enrolled.
+// It must run after ActiveSession.
+func RequireTwoFactor(twoFactor *twofactor.Service, enrolmentPrefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := userFromContext(r.Context())
+			if !ok || strings.HasPrefix(r.URL.Path, enrolmentPrefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			required, err := twoFactor.Required(user)
+			if err != nil {
+				response.Error(w, r, err)
+				return
+			}
+
+			if required && !twoFactor.Enabled(user.ID) {
+				config := response.Configure(errTwoFactorEnrolment, http.StatusForbidden, nil)
+				response.JSONError(w, r, config)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientIP returns the address of the client without its port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
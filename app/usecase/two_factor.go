@@ -0,0 +1,223 @@
+package usecase
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/audit"
+	"github.com/rbo13/write-it/app/lockout"
+	"github.com/rbo13/write-it/app/response"
+	"github.com/rbo13/write-it/app/twofactor"
+)
+
+type twoFactorUsecase struct {
+	userService app.UserService
+	twoFactor   *twofactor.Service
+	guard       *lockout.Guard
+}
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type twoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+type twoFactorRoleRequest struct {
+	Required bool `json:"required"`
+}
+
+// NewTwoFactor returns the two-factor handler. The failed codes are counted
+// per user by the guard, apart from the failed passwords, so that neither a
+// new challenge nor a successful password gives more attempts.
+func NewTwoFactor(userService app.UserService, twoFactor *twofactor.Service, guard *lockout.Guard) app.TwoFactorHandler {
+	return &twoFactorUsecase{
+		userService,
+		twoFactor,
+		guard.Scoped("two_factor"),
+	}
+}
+
+func (t *twoFactorUsecase) Enrol(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	enrolment, err := t.twoFactor.Enrol(user)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("Scan the QR code or enter the secret in your authenticator app, then confirm with its first code", http.StatusOK, enrolment)
+	response.JSONOK(w, r, config)
+}
+
+func (t *twoFactorUsecase) QRCode(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+
+	png, err := t.twoFactor.QRCode(user)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(png)
+}
+
+func (t *twoFactorUsecase) Confirm(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorCodeRequest
+	user, _ := userFromContext(r.Context())
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	recoveryCodes, err := t.twoFactor.Confirm(user, req.Code)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("Two-factor authentication enabled, store the recovery codes somewhere safe", http.StatusOK, map[string]interface{}{
+		"recovery_codes": recoveryCodes,
+	})
+	response.JSONOK(w, r, config)
+}
+
+func (t *twoFactorUsecase) Disable(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorCodeRequest
+	user, _ := userFromContext(r.Context())
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	err = t.twoFactor.Disable(user, req.Code)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("Two-factor authentication disabled", http.StatusOK, nil)
+	response.JSONOK(w, r, config)
+}
+
+func (t *twoFactorUsecase) Login(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorLoginRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	userID, err := t.twoFactor.ChallengeUser(req.ChallengeToken)
+
+	if err != nil {
+		auditLoginFailed(r, "", "two_factor")
+		response.Error(w, r, err)
+		return
+	}
+
+	user, err := t.userService.UserContext(r.Context(), userID)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	ip := clientIP(r)
+
+	if err = t.guard.Check(user.EmailAddress, ip); err != nil {
+		if locked, ok := err.(*lockout.LockedError); ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(locked.RetryAfter.Seconds()+0.5)))
+		}
+
+		config := response.Configure(err.Error(), http.StatusTooManyRequests, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	if _, err = t.twoFactor.VerifyChallenge(req.ChallengeToken, req.Code); err != nil {
+		if app.KindOf(err) != app.Internal {
+			t.guard.Fail(user.EmailAddress, ip)
+		} else {
+			t.guard.Release(user.EmailAddress)
+		}
+
+		auditLoginFailed(r, user.EmailAddress, "two_factor")
+		response.Error(w, r, err)
+		return
+	}
+
+	t.guard.Succeed(user.EmailAddress)
+
+	authToken, err := t.userService.GenerateAuthToken(user)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	auditLogin(r, user, "two_factor")
+
+	config := response.Configure("Logged in sucessfully", http.StatusOK, map[string]interface{}{
+		"user":       user,
+		"auth_token": authToken,
+	}).For(response.ViewSelf)
+	response.JSONOK(w, r, config)
+}
+
+func (t *twoFactorUsecase) SetRoleRequirement(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorRoleRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	userType := chi.URLParam(r, "role")
+	err = t.twoFactor.SetRequired(userType, req.Required)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionAdminTwoFactor,
+		TargetType: "role",
+		TargetID:   userType,
+		After:      map[string]interface{}{"require_two_factor": req.Required},
+	})
+
+	config := response.Configure("Role successfully updated", http.StatusOK, map[string]interface{}{
+		"user_type":          userType,
+		"require_two_factor": req.Required,
+	})
+	response.JSONOK(w, r, config)
+}
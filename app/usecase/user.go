@@ -1,30 +1,50 @@
 package usecase
 
 import (
+	"context"
 	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/jwtauth"
-	"github.com/go-chi/render"
 
 	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/audit"
+	"github.com/rbo13/write-it/app/erasure"
+	"github.com/rbo13/write-it/app/lockout"
+	"github.com/rbo13/write-it/app/passwords"
 	"github.com/rbo13/write-it/app/persistence/cache"
-	"github.com/rbo13/write-it/app/persistence/cache/memcached"
 	"github.com/rbo13/write-it/app/response"
+	"github.com/rbo13/write-it/app/twofactor"
+	"github.com/rbo13/write-it/app/validation"
+	"github.com/rbo13/write-it/app/verification"
 )
 
-var (
-	cacheKey = ""
+// The users are cached in their admin representation, which has no password hash.
+const (
+	usersCacheKey     = "users"
+	userCacheKey      = "user."
+	userPostsCacheKey = "user.posts."
+	allPostsCacheKey  = "getAllPosts"
 )
 
 const (
-	errCacheMiss = "memcache: cache miss"
+	errCacheMiss       = "memcache: cache miss"
+	errEmailUnverified = "Email Address is not verified yet"
+	errLoginFailed     = "Email or Password is invalid"
 )
 
 type userUsecase struct {
 	userService app.UserService
+	unitOfWork  app.UnitOfWork
+	verifier    *verification.Service
+	twoFactor   *twofactor.Service
+	guard       *lockout.Guard
+	policy      *passwords.Policy
+	cache       cache.Cacher
+	eraser      *erasure.Service
 }
 
 // UserResponse represents a user response
@@ -35,43 +55,68 @@ type UserResponse struct {
 	Data       interface{} `json:"data"`
 }
 
+// userUpdateRequest holds what users change of their account. The other
+// fields of the user are kept by the server, and rejected as unknown.
+type userUpdateRequest struct {
+	Username     string `json:"username"`
+	EmailAddress string `json:"email_address"`
+	// Password is left empty to keep the current one
+	Password string `json:"password"`
+}
+
 type loginResponse struct {
 	UserResponse UserResponse `json:"user_response"`
 	AuthToken    string       `json:"auth_token"`
 }
 
-// BootMemcached returns the pointer to memcached.Memcached to spin up the caching layer.
-func BootMemcached() *memcached.Memcached {
-	return memcached.New("localhost", "11211", "localhost:11211")
-}
-
 // NewUser ...
-func NewUser(userService app.UserService) app.UserHandler {
+func NewUser(userService app.UserService, unitOfWork app.UnitOfWork, verifier *verification.Service, twoFactor *twofactor.Service, guard *lockout.Guard, policy *passwords.Policy, cacher cache.Cacher, eraser *erasure.Service) app.UserHandler {
 	return &userUsecase{
 		userService,
+		unitOfWork,
+		verifier,
+		twoFactor,
+		guard,
+		policy,
+		cacher,
+		eraser,
 	}
 }
 
 func (u *userUsecase) Create(w http.ResponseWriter, r *http.Request) {
 	var user app.User
 
-	err := json.NewDecoder(r.Body).Decode(&user)
+	errs, err := validation.Decode(r.Body, &user)
+	if err == nil {
+		err = validation.User(&user, errs)
+	}
 
 	if err != nil {
-		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
-		response.JSONError(w, r, config)
+		response.Error(w, r, err)
+		return
+	}
+
+	if err = u.policy.Validate(user.Password, &user); err != nil {
+		response.Error(w, r, err)
 		return
 	}
 
-	err = u.userService.CreateUser(&user)
+	// Only an admin gives another type, through SetUserType
+	user.UserType = "reader"
+
+	err = u.userService.CreateUserContext(r.Context(), &user)
 
 	if err != nil {
-		config := response.Configure(err.Error(), http.StatusBadRequest, nil)
-		response.JSONError(w, r, config)
+		response.Error(w, r, err)
 		return
 	}
 
-	config := response.Configure("User successfully registered", http.StatusOK, user)
+	// The account is created either way, the user can ask for a new link later.
+	if err = u.verifier.Send(&user); err != nil {
+		log.Printf("Error sending the verification email: %v", err)
+	}
+
+	config := response.Configure("User successfully registered", http.StatusOK, user).For(response.ViewSelf)
 	response.JSONOK(w, r, config)
 	return
 }
@@ -91,178 +136,225 @@ func (u *userUsecase) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userResp, err := u.userService.Login(user.EmailAddress, user.Password)
+	ip := clientIP(r)
+
+	if err = u.guard.Check(user.EmailAddress, ip); err != nil {
+		if locked, ok := err.(*lockout.LockedError); ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(locked.RetryAfter.Seconds()+0.5)))
+		}
 
-	if err != nil {
 		loginResp := loginResponse{
-			UserResponse: errorResponse(http.StatusNotFound, err.Error()),
+			UserResponse: errorResponse(http.StatusTooManyRequests, err.Error()),
 			AuthToken:    "",
 		}
 
-		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, &loginResp)
+		config := response.Configure(err.Error(), http.StatusTooManyRequests, &loginResp)
 		response.JSONError(w, r, config)
 		return
 	}
 
-	authToken, err := u.userService.GenerateAuthToken(userResp)
+	userResp, err := u.userService.LoginContext(r.Context(), user.EmailAddress, user.Password)
+
+	// The failures of the store are not the user's
+	if err != nil && app.KindOf(err) == app.Internal {
+		u.guard.Release(user.EmailAddress)
+		response.Error(w, r, err)
+		return
+	}
 
 	if err != nil {
+		// Every failure answers the same, so that the
+		// response does not tell which emails are registered.
+		log.Printf("Login failed: %v", err)
+		u.guard.Fail(user.EmailAddress, ip)
+		auditLoginFailed(r, user.EmailAddress, "password")
+
 		loginResp := loginResponse{
-			UserResponse: errorResponse(http.StatusBadRequest, err.Error()),
+			UserResponse: errorResponse(http.StatusUnauthorized, errLoginFailed),
 			AuthToken:    "",
 		}
 
-		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, &loginResp)
+		config := response.Configure(errLoginFailed, http.StatusUnauthorized, &loginResp)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	u.guard.Succeed(user.EmailAddress)
+
+	if !u.verifier.CanLogin(userResp) {
+		loginResp := loginResponse{
+			UserResponse: errorResponse(http.StatusForbidden, errEmailUnverified),
+			AuthToken:    "",
+		}
+
+		config := response.Configure(errEmailUnverified, http.StatusForbidden, &loginResp)
 		response.JSONError(w, r, config)
 		return
 	}
 
+	// Enrolled users exchange the challenge and a code for the auth token
+	if u.twoFactor.Enabled(userResp.ID) {
+		challenge, err := u.twoFactor.Challenge(userResp)
+
+		if err != nil {
+			response.Error(w, r, err)
+			return
+		}
+
+		config := response.Configure("Two-factor authentication required", http.StatusOK, map[string]interface{}{
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+		})
+		response.JSONOK(w, r, config)
+		return
+	}
+
+	authToken, err := u.userService.GenerateAuthToken(userResp)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	auditLogin(r, userResp, "password")
+
 	loginResp := map[string]interface{}{
 		"user":       userResp,
 		"auth_token": authToken,
 	}
 
-	config := response.Configure("Logged in sucessfully", http.StatusOK, loginResp)
+	config := response.Configure("Logged in sucessfully", http.StatusOK, loginResp).For(response.ViewSelf)
 	response.JSONOK(w, r, config)
 }
 
 func (u *userUsecase) GetUserPosts(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil || userID <= 0 {
-		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
+		config := response.Configure("User id is invalid", http.StatusUnprocessableEntity, nil)
 		response.JSONError(w, r, config)
 		return
 	}
 
-	mem := BootMemcached()
-	cacheKey := chi.URLParam(r, "id")
+	cacheKey := userPostsCacheKey + chi.URLParam(r, "id")
 	var userPosts []*app.UserPosts
 
-	err = cache.Get(mem, cacheKey, &userPosts)
+	err = cache.Get(u.cache, cacheKey, &userPosts)
 	if err == nil {
 
 		config := response.Configure("User Posts successfully retrieved", http.StatusOK, map[string]interface{}{
 			"user_posts": userPosts,
 			"cached":     true,
-		})
+		}).For(viewOf(r, userID))
 		response.JSONOK(w, r, config)
 		return
 	}
 
-	userPosts, err = u.userService.GetUserPosts(userID)
+	userPosts, err = u.userService.GetUserPostsContext(r.Context(), userID)
 
-	if err != nil || userPosts == nil {
-		config := response.Configure(err.Error(), http.StatusNotFound, userPosts)
-		response.JSONError(w, r, config)
+	if err != nil {
+		response.Error(w, r, err)
 		return
 	}
 
 	if len(userPosts) > 0 {
-		ok, err := cache.Set(mem, cacheKey, userPosts)
-
-		if err != nil && !ok {
-			config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
-			response.JSONError(w, r, config)
-			return
-		}
+		cache.Set(u.cache, cacheKey, response.Redact(userPosts, response.ViewAdmin))
 	}
 
 	config := response.Configure("User Posts successfully retrieved", http.StatusOK, map[string]interface{}{
 		"user_posts": userPosts,
 		"cached":     false,
-	})
+	}).For(viewOf(r, userID))
 	response.JSONOK(w, r, config)
 }
 
 func (u *userUsecase) Get(w http.ResponseWriter, r *http.Request) {
-	mem := BootMemcached()
-	cacheKey = "getAllUsers"
 	var usrs []app.User
 
-	err := cache.Get(mem, cacheKey, &usrs)
+	err := cache.Get(u.cache, usersCacheKey, &usrs)
 	if err == nil {
 		config := response.Configure("Users successfully retrieved", http.StatusOK, map[string]interface{}{
 			"users":  usrs,
 			"cached": true,
-		})
+		}).For(viewOf(r, 0))
 		response.JSONOK(w, r, config)
 		return
 	}
 
-	users, err := u.userService.Users()
-	if err != nil || users == nil {
-		config := response.Configure(err.Error(), http.StatusNotFound, users)
-		response.JSONError(w, r, config)
+	users, err := u.userService.UsersContext(r.Context())
+	if err != nil {
+		response.Error(w, r, err)
 		return
 	}
 
 	if len(users) > 0 {
-		ok, err := cache.Set(mem, cacheKey, users)
-
-		if err != nil && !ok {
-			config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
-			response.JSONError(w, r, config)
-			return
-		}
+		cache.Set(u.cache, usersCacheKey, response.Redact(users, response.ViewAdmin))
 	}
 
 	config := response.Configure("Users successfully retrieved", http.StatusOK, map[string]interface{}{
 		"users":  users,
 		"cached": false,
-	})
+	}).For(viewOf(r, 0))
 	response.JSONOK(w, r, config)
 }
 
 func (u *userUsecase) GetByID(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	if err != nil || userID <= 0 {
-		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
+		config := response.Configure("User id is invalid", http.StatusUnprocessableEntity, nil)
 		response.JSONError(w, r, config)
 		return
 	}
 
 	var user *app.User
-	cacheKey = chi.URLParam(r, "id")
-	mem := BootMemcached()
+	cacheKey := userCacheKey + chi.URLParam(r, "id")
 
-	err = cache.Get(mem, cacheKey, &user)
+	err = cache.Get(u.cache, cacheKey, &user)
 	if err == nil {
+		if notModified(w, r, user.Version) {
+			return
+		}
+
 		config := response.Configure("User successfully retrieved", http.StatusOK, map[string]interface{}{
 			"user":   user,
 			"cached": true,
-		})
+		}).For(viewOf(r, userID))
 		response.JSONOK(w, r, config)
 		return
 	}
 
-	user, err = u.userService.User(userID)
+	user, err = u.userService.UserContext(r.Context(), userID)
 	if err != nil {
-		config := response.Configure(err.Error(), http.StatusNotFound, nil)
-		response.JSONError(w, r, config)
+		response.Error(w, r, err)
 		return
 	}
 
-	ok, err := cache.Set(mem, cacheKey, user)
-	if err != nil && !ok {
-		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
-		response.JSONError(w, r, config)
+	cache.Set(u.cache, cacheKey, response.Redact(user, response.ViewAdmin))
+
+	if notModified(w, r, user.Version) {
 		return
 	}
 
 	config := response.Configure("User successfully retrieved", http.StatusOK, map[string]interface{}{
 		"user":   user,
 		"cached": false,
-	})
+	}).For(viewOf(r, userID))
 	response.JSONOK(w, r, config)
 }
 
 func (u *userUsecase) Update(w http.ResponseWriter, r *http.Request) {
-	var user app.User
+	var req userUpdateRequest
 	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
-	check(err, w, r)
+	if err != nil || userID <= 0 {
+		config := response.Configure("User id is invalid", http.StatusUnprocessableEntity, nil)
+		response.JSONError(w, r, config)
+		return
+	}
 
 	_, claims, err := jwtauth.FromContext(r.Context())
-	check(err, w, r)
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
 
 	authorID := int64(claims["user_id"].(float64))
 	if userID != authorID {
@@ -272,70 +364,231 @@ func (u *userUsecase) Update(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Find a user by the given id
-	userResp, err := u.userService.User(userID)
+	userResp, err := u.userService.UserContext(r.Context(), userID)
 
 	if err != nil {
-		config := response.Configure(err.Error(), http.StatusNotFound, nil)
-		response.JSONError(w, r, config)
+		response.Error(w, r, err)
+		return
+	}
+
+	if !ifMatch(w, r, userResp.Version) {
 		return
 	}
 
-	// fill the necessary fields
-	// that doesnt need to be updated
-	user.ID = userResp.ID
-	user.Password = userResp.Password
-	user.CreatedAt = userResp.CreatedAt
+	// Only the fields of the request change, the others
+	// are the ones of the stored user, e.g. its id and type.
+	user := *userResp
+	errs, err := validation.Decode(r.Body, &req)
+	user.Username, user.EmailAddress = req.Username, req.EmailAddress
 
-	err = json.NewDecoder(r.Body).Decode(&user)
+	if err == nil {
+		err = validation.User(&user, errs)
+	}
 
 	if err != nil {
-		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
-		response.JSONError(w, r, config)
+		response.Error(w, r, err)
 		return
 	}
 
-	err = u.userService.UpdateUser(&user)
+	// A new password is checked, then hashed by ResetPassword,
+	// which signs out every session of the user.
+	newPassword := req.Password
+	if newPassword != "" {
+		if err = u.policy.Validate(newPassword, &user); err != nil {
+			response.Error(w, r, err)
+			return
+		}
+	}
+
+	// A new email address needs to be verified again
+	emailChanged := user.EmailAddress != userResp.EmailAddress
+	if emailChanged {
+		user.EmailVerifiedAt = 0
+	}
+
+	// The user is saved together with their new password, if any
+	err = u.unitOfWork.Do(r.Context(), func(ctx context.Context) error {
+		if err := u.userService.UpdateUserContext(ctx, &user); err != nil {
+			return err
+		}
+
+		if newPassword == "" {
+			return nil
+		}
+
+		if err := u.userService.ResetPasswordContext(ctx, user.ID, newPassword); err != nil {
+			return err
+		}
+
+		// The new password changed the version too
+		saved, err := u.userService.UserContext(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		user.Version, user.UpdatedAt = saved.Version, saved.UpdatedAt
+		return nil
+	})
 
 	if err != nil {
-		config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
-		response.JSONError(w, r, config)
+		response.Error(w, r, err)
 		return
 	}
 
-	config := response.Configure("User successfully updated", http.StatusOK, user)
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionUserUpdate,
+		TargetType: "user",
+		TargetID:   strconv.FormatInt(user.ID, 10),
+		Before:     userResp,
+		After:      &user,
+	})
+
+	if emailChanged {
+		if err = u.verifier.Send(&user); err != nil {
+			log.Printf("Error sending the verification email: %v", err)
+		}
+	}
+
+	u.forget(user.ID)
+
+	w.Header().Set("ETag", etag(user.Version))
+	config := response.Configure("User successfully updated", http.StatusOK, user).For(response.ViewSelf)
 	response.JSONOK(w, r, config)
 }
 
-func (u *userUsecase) Delete(w http.ResponseWriter, r *http.Request) {
+// SetUserType changes the type of a user, e.g. to make them an admin.
+// It is only routed for the admins, the users cannot change their own type.
+func (u *userUsecase) SetUserType(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || userID <= 0 {
+		config := response.Configure("User id is invalid", http.StatusUnprocessableEntity, nil)
+		response.JSONError(w, r, config)
+		return
+	}
+
+	var req struct {
+		UserType string `json:"user_type"`
+	}
+
+	errs, err := validation.Decode(r.Body, &req)
+	if err == nil {
+		err = validation.UserType(req.UserType, errs)
+	}
 
 	if err != nil {
-		render.JSON(w, r, userID)
+		response.Error(w, r, err)
 		return
 	}
 
-	_, claims, err := jwtauth.FromContext(r.Context())
-	check(err, w, r)
+	before, err := u.userService.UserContext(r.Context(), userID)
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
 
-	authID := int64(claims["user_id"].(float64))
-	if userID != authID {
-		config := response.Configure("Cannot Delete other User", http.StatusForbidden, nil)
-		response.JSONError(w, r, config)
+	if !ifMatch(w, r, before.Version) {
+		return
+	}
+
+	user := *before
+	user.UserType = req.UserType
+
+	if err = u.userService.UpdateUserContext(r.Context(), &user); err != nil {
+		response.Error(w, r, err)
 		return
 	}
 
-	err = u.userService.DeleteUser(userID)
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionRoleChange,
+		TargetType: "user",
+		TargetID:   strconv.FormatInt(user.ID, 10),
+		Before:     before,
+		After:      &user,
+	})
+
+	u.forget(user.ID)
+
+	w.Header().Set("ETag", etag(user.Version))
+	config := response.Configure("User type successfully updated", http.StatusOK, user).For(response.ViewAdmin)
+	response.JSONOK(w, r, config)
+}
+
+func (u *userUsecase) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	err := u.verifier.Verify(r.URL.Query().Get("token"))
 
 	if err != nil {
-		config := response.Configure(err.Error(), http.StatusNotFound, nil)
-		response.JSONError(w, r, config)
+		response.Error(w, r, err)
+		return
+	}
+
+	config := response.Configure("Email Address successfully verified", http.StatusOK, nil)
+	response.JSONOK(w, r, config)
+}
+
+// Delete starts the erasure of the account, which is only carried out once
+// confirmed by email and after the cooling-off period. The posts are
+// deleted unless `keep_posts` is set.
+func (u *userUsecase) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, ok := ownUserID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := u.userService.UserContext(r.Context(), userID)
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	if !ifMatch(w, r, user.Version) {
 		return
 	}
 
-	config := response.Configure("User successfully deleted", http.StatusNoContent, nil)
+	var options struct {
+		KeepPosts bool `json:"keep_posts"`
+	}
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&options); err != nil {
+			config := response.Configure(err.Error(), http.StatusUnprocessableEntity, nil)
+			response.JSONError(w, r, config)
+			return
+		}
+	}
+
+	erasure, err := u.eraser.Request(userID, options.KeepPosts)
+
+	if err != nil {
+		response.Error(w, r, err)
+		return
+	}
+
+	audit.Record(r, audit.Event{
+		Action:     audit.ActionErasureRequest,
+		TargetType: "user",
+		TargetID:   strconv.FormatInt(userID, 10),
+		After:      erasure,
+	})
+
+	config := response.Configure("Check your email to confirm the erasure of your account", http.StatusAccepted, map[string]interface{}{
+		"erasure": erasure,
+	})
 	response.JSONOK(w, r, config)
 }
 
+// forget removes the cached copies of the user.
+func (u *userUsecase) forget(userID int64) {
+	forgetUser(u.cache, userID)
+}
+
+func forgetUser(c cache.Cacher, userID int64) {
+	id := strconv.FormatInt(userID, 10)
+
+	for _, key := range []string{usersCacheKey, userCacheKey + id, userPostsCacheKey + id} {
+		cache.Delete(c, key)
+	}
+}
+
 func errorResponse(statusCode uint, message string) (errResponse UserResponse) {
 	errResponse = UserResponse{
 		StatusCode: statusCode,
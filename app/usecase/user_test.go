@@ -0,0 +1,816 @@
+package usecase_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/jwtauth"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/accesstoken"
+	"github.com/rbo13/write-it/app/audit"
+	"github.com/rbo13/write-it/app/erasure"
+	"github.com/rbo13/write-it/app/export"
+	"github.com/rbo13/write-it/app/jwtservice"
+	"github.com/rbo13/write-it/app/lockout"
+	"github.com/rbo13/write-it/app/mailer"
+	"github.com/rbo13/write-it/app/oauth"
+	"github.com/rbo13/write-it/app/passwords"
+	"github.com/rbo13/write-it/app/persistence/cache/memcached"
+	"github.com/rbo13/write-it/app/persistence/inmemory"
+	"github.com/rbo13/write-it/app/profile"
+	"github.com/rbo13/write-it/app/response"
+	"github.com/rbo13/write-it/app/routes"
+	"github.com/rbo13/write-it/app/twofactor"
+	"github.com/rbo13/write-it/app/usecase"
+	"github.com/rbo13/write-it/app/verification"
+)
+
+// errNotFound is what the stores return when nothing matches.
+var errNotFound = app.NewError(app.NotFound, "Not found")
+
+// passwordHash is what the userStore saves in place of every password.
+const passwordHash = "$argon2id$v=19$m=65536,t=3,p=4$c2FsdA$aGFzaA"
+
+type memoryCache map[string]string
+
+func (c memoryCache) Set(key, val string) (bool, error) {
+	c[key] = val
+	return true, nil
+}
+
+func (c memoryCache) Get(key string) (string, error) {
+	val, ok := c[key]
+	if !ok {
+		return "", errors.New("cache miss")
+	}
+	return val, nil
+}
+
+func (c memoryCache) Delete(key string) (bool, error) {
+	delete(c, key)
+	return true, nil
+}
+
+type userStore struct {
+	users      []*app.User
+	jwtService *jwtservice.JWT
+}
+
+func (s *userStore) CreateUser(user *app.User) error {
+	user.ID = int64(len(s.users) + 1)
+	user.Password = passwordHash
+	user.CreatedAt = time.Now().Unix()
+	user.Version = 1
+	s.users = append(s.users, user)
+	return nil
+}
+
+func (s *userStore) User(id int64) (*app.User, error) {
+	for _, user := range s.users {
+		if user.ID == id {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (s *userStore) UserByEmail(email string) (*app.User, error) {
+	for _, user := range s.users {
+		if user.EmailAddress == email {
+			return s.User(user.ID)
+		}
+	}
+	return nil, errNotFound
+}
+
+func (s *userStore) UserByUsername(username string) (*app.User, error) {
+	for _, user := range s.users {
+		if user.Username == username {
+			return s.User(user.ID)
+		}
+	}
+	return nil, errNotFound
+}
+
+func (s *userStore) Login(email, password string) (*app.User, error) {
+	return s.UserByEmail(email)
+}
+
+func (s *userStore) Users() ([]*app.User, error) {
+	return s.users, nil
+}
+
+func (s *userStore) UpdateUser(user *app.User) error {
+	for i, u := range s.users {
+		if u.ID != user.ID {
+			continue
+		}
+
+		if u.Version != user.Version {
+			return app.NewError(app.PreconditionFailed, "Changed")
+		}
+
+		user.Version++
+		updated := *user
+		s.users[i] = &updated
+		return nil
+	}
+	return errNotFound
+}
+
+func (s *userStore) DeleteUser(id int64) error {
+	return nil
+}
+
+func (s *userStore) GetUserPosts(userID int64) ([]*app.UserPosts, error) {
+	user, err := s.User(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*app.UserPosts{{PostTitle: "Hello", Email: user.EmailAddress, Username: user.Username, UserType: user.UserType}}, nil
+}
+
+func (s *userStore) VerifyEmail(id int64, email string) error {
+	return nil
+}
+
+func (s *userStore) ResetPassword(id int64, password string) error {
+	return nil
+}
+
+func (s *userStore) GenerateAuthToken(user *app.User) (string, error) {
+	claims := jwt.MapClaims{"user_id": user.ID}
+	jwtauth.SetIssuedNow(claims)
+	jwtauth.SetExpiryIn(claims, time.Hour)
+	return s.jwtService.Encode(claims)
+}
+
+func (s *userStore) CreateUserContext(ctx context.Context, user *app.User) error {
+	return s.CreateUser(user)
+}
+
+func (s *userStore) UserContext(ctx context.Context, id int64) (*app.User, error) {
+	return s.User(id)
+}
+
+func (s *userStore) UserByEmailContext(ctx context.Context, email string) (*app.User, error) {
+	return s.UserByEmail(email)
+}
+
+func (s *userStore) UserByUsernameContext(ctx context.Context, username string) (*app.User, error) {
+	return s.UserByUsername(username)
+}
+
+func (s *userStore) LoginContext(ctx context.Context, email, password string) (*app.User, error) {
+	return s.Login(email, password)
+}
+
+func (s *userStore) UsersContext(ctx context.Context) ([]*app.User, error) {
+	return s.Users()
+}
+
+func (s *userStore) UpdateUserContext(ctx context.Context, user *app.User) error {
+	return s.UpdateUser(user)
+}
+
+func (s *userStore) DeleteUserContext(ctx context.Context, id int64) error {
+	return s.DeleteUser(id)
+}
+
+func (s *userStore) GetUserPostsContext(ctx context.Context, userID int64) ([]*app.UserPosts, error) {
+	return s.GetUserPosts(userID)
+}
+
+func (s *userStore) VerifyEmailContext(ctx context.Context, id int64, email string) error {
+	return s.VerifyEmail(id, email)
+}
+
+func (s *userStore) ResetPasswordContext(ctx context.Context, id int64, password string) error {
+	return s.ResetPassword(id, password)
+}
+
+// unitOfWork runs the operations one after the other, the userStore has no transactions.
+type unitOfWork struct{}
+
+func (unitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+type twoFactorStore struct {
+	app.TwoFactorService
+	enrolled map[int64]*app.TwoFactor
+}
+
+func (s *twoFactorStore) TwoFactor(userID int64) (*app.TwoFactor, error) {
+	twoFactor, ok := s.enrolled[userID]
+	if !ok {
+		return nil, errNotFound
+	}
+	return twoFactor, nil
+}
+
+func (s *twoFactorStore) UseStep(userID, step int64) error {
+	twoFactor := s.enrolled[userID]
+	if step <= twoFactor.LastStep {
+		return errors.New("Step already used")
+	}
+	twoFactor.LastStep = step
+	return nil
+}
+
+func (s *twoFactorStore) UseRecoveryCode(userID int64, codeHash string) error {
+	return errNotFound
+}
+
+type profileStore struct {
+	app.ProfileService
+}
+
+func (s *profileStore) Profile(userID int64) (*app.Profile, error) {
+	return nil, errNotFound
+}
+
+type auditStore struct {
+	entries []*app.AuditEntry
+}
+
+func (s *auditStore) AppendAuditEntry(entry *app.AuditEntry) error {
+	entry.ID = int64(len(s.entries) + 1)
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *auditStore) AuditEntries(filter app.AuditFilter) ([]*app.AuditEntry, error) {
+	return s.entries, nil
+}
+
+type exportNotifier struct {
+	progress chan export.Progress
+}
+
+func (n *exportNotifier) Notify(userID int64, message interface{}) {
+	n.progress <- message.(export.Progress)
+}
+
+// tokenStore and oauthStore hold no tokens and no apps,
+// for the routes looking them up to fail.
+type tokenStore struct {
+	app.AccessTokenService
+}
+
+func (tokenStore) DeleteAccessToken(id, userID int64) error {
+	return errNotFound
+}
+
+type oauthStore struct {
+	app.OAuthService
+}
+
+func (oauthStore) OAuthClient(clientID string) (*app.OAuthClient, error) {
+	return nil, errNotFound
+}
+
+func (oauthStore) DeleteOAuthClient(clientID string, ownerID int64) error {
+	return errNotFound
+}
+
+// erasureStore holds no pending erasure, and keeps none either.
+type erasureStore struct {
+	app.ErasureService
+}
+
+func (erasureStore) PendingErasure(userID int64) (*app.Erasure, error) {
+	return nil, errNotFound
+}
+
+func (erasureStore) CancelErasure(userID int64) error {
+	return nil
+}
+
+func (erasureStore) SaveErasure(e *app.Erasure) error {
+	e.ID = 1
+	return nil
+}
+
+func TestUserRepresentations(t *testing.T) {
+	jwtService, err := jwtservice.New(jwtservice.Config{Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "avatars")
+	if err != nil {
+		t.Fatalf("Error occurred due to: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &userStore{jwtService: jwtService}
+	mail := mailer.NewLog(ioutil.Discard)
+	cacher := memoryCache{}
+
+	twoFactors := &twoFactorStore{enrolled: map[int64]*app.TwoFactor{}}
+	twoFactor := twofactor.New(twoFactors, jwtService, "write-it", time.Minute)
+	guard := lockout.New(lockout.DefaultConfig, cacher, mail, store)
+	profileService := profile.New(&profileStore{}, store, "https://write-it.test", dir)
+	posts := inmemory.NewInMemoryPostService()
+	eraser := erasure.New(erasureStore{}, store, posts, profileService, jwtService, mail, usecase.NewCachePurger(cacher), "https://write-it.test", time.Hour)
+	users := usecase.NewUser(
+		store,
+		unitOfWork{},
+		verification.New(jwtService, mail, store, "https://write-it.test", time.Hour, verification.PolicyNone),
+		twoFactor,
+		guard,
+		passwords.NewPolicy(10, 128, nil),
+		cacher,
+		eraser,
+	)
+	profiles := usecase.NewProfile(profileService)
+
+	twoFactorHandler := usecase.NewTwoFactor(store, twoFactor, guard)
+
+	auditor := audit.New(&auditStore{})
+	notifier := &exportNotifier{progress: make(chan export.Progress, 10)}
+	exporter := export.New(store, profileService, posts, jwtService, notifier, "https://write-it.test", dir, time.Hour)
+	exports := usecase.NewExport(exporter)
+
+	router := chi.NewRouter()
+	router.Use(auditor.Middleware)
+	router.Post("/register", users.Create)
+	router.Post("/login", users.Login)
+	router.Post("/login/2fa", twoFactorHandler.Login)
+	router.Get("/api/v1/users/{username}/profile", profiles.Get)
+	router.Get("/exports/download", exports.Download)
+	router.Group(func(r chi.Router) {
+		r.Use(jwtService.Verifier)
+		r.Use(jwtauth.Authenticator)
+		r.Use(usecase.ActiveSession(store))
+		r.Mount("/api/v1/users", routes.User(chi.NewRouter(), users, profiles, exports, usecase.NewErasure(nil)))
+		r.Mount("/api/v1/posts", routes.Post(chi.NewRouter(), usecase.NewPost(posts, cacher)))
+		r.Mount("/api/v1/tokens", routes.AccessToken(chi.NewRouter(), usecase.NewAccessToken(accesstoken.New(tokenStore{}))))
+		r.Mount("/api/v1/2fa", routes.TwoFactor(chi.NewRouter(), twoFactorHandler))
+		r.Mount("/api/v1/oauth", routes.OAuth(chi.NewRouter(), usecase.NewOAuth(oauth.New(oauthStore{}, jwtService, store, time.Minute, time.Hour))))
+		r.With(usecase.RequireUserType("admin")).Mount("/api/admin", routes.Admin(chi.NewRouter(), users, twoFactorHandler, usecase.NewAudit(auditor)))
+	})
+
+	// serve returns the response, which must never hold a password
+	serve := func(method, path, token, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+
+		if strings.Contains(res.Body.String(), `"password":`) || strings.Contains(res.Body.String(), passwordHash) {
+			t.Errorf("Expecting: %v, but got: %v instead", "no password", res.Body.String())
+		}
+
+		return res
+	}
+
+	request := func(method, path, token, body string) string {
+		return serve(method, path, token, body, nil).Body.String()
+	}
+
+	login := func(email string) string {
+		var body struct {
+			Data struct {
+				AuthToken string `json:"auth_token"`
+			} `json:"data"`
+		}
+
+		res := request(http.MethodPost, "/login", "", `{"email_address": "`+email+`", "password": "correct horse battery"}`)
+		if err := json.Unmarshal([]byte(res), &body); err != nil || body.Data.AuthToken == "" {
+			t.Fatalf("Expecting: %v, but got: %v instead", "an auth token", res)
+		}
+
+		return body.Data.AuthToken
+	}
+
+	t.Run("Register", func(t *testing.T) {
+		for _, user := range []string{"writer", "reader", "admin"} {
+			res := request(http.MethodPost, "/register", "", `{"username": "`+user+`", "email_address": "`+user+`@example.com", "password": "correct horse battery"}`)
+			if !strings.Contains(res, user+"@example.com") {
+				t.Errorf("Expecting: %v, but got: %v instead", "the email of the new user", res)
+			}
+		}
+
+		store.users[2].UserType = "admin"
+	})
+
+	writer := login("writer@example.com")
+	reader := login("reader@example.com")
+	admin := login("admin@example.com")
+
+	request(http.MethodPost, "/api/v1/posts/create", writer, `{"post_title": "Hello", "post_body": "World"}`)
+
+	paths := []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, "/api/v1/users", ""},
+		{http.MethodGet, "/api/v1/users/1", ""},
+		{http.MethodGet, "/api/v1/users/1/posts", ""},
+		{http.MethodPut, "/api/v1/users/1", `{"username": "writer", "email_address": "writer@example.com"}`},
+		{http.MethodGet, "/api/v1/users/writer/profile", ""},
+		{http.MethodGet, "/api/v1/posts", ""},
+		{http.MethodGet, "/api/v1/posts/1", ""},
+	}
+
+	t.Run("NoPassword", func(t *testing.T) {
+		for _, token := range []string{writer, reader, admin, ""} {
+			for _, route := range paths {
+				// Twice, to read the cached copies too
+				request(route.method, route.path, token, route.body)
+				request(route.method, route.path, token, route.body)
+			}
+		}
+	})
+
+	t.Run("CacheDown", func(t *testing.T) {
+		// Nothing listens on the port, the requests are served from the stores
+		down := memcached.New("127.0.0.1", "1", "127.0.0.1:1")
+		uncached := usecase.NewUser(store, unitOfWork{}, nil, twoFactor, guard, passwords.NewPolicy(10, 128, nil), down, nil)
+
+		router := chi.NewRouter()
+		router.Use(jwtService.Verifier)
+		router.Use(jwtauth.Authenticator)
+		router.Mount("/api/v1/users", routes.User(chi.NewRouter(), uncached, profiles, exports, usecase.NewErasure(nil)))
+		router.Mount("/api/v1/posts", routes.Post(chi.NewRouter(), usecase.NewPost(posts, down)))
+
+		for _, path := range []string{"/api/v1/users", "/api/v1/users/1", "/api/v1/users/1/posts", "/api/v1/posts", "/api/v1/posts/1"} {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("Authorization", "Bearer "+admin)
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+
+			if res.Code != http.StatusOK {
+				t.Errorf("Expecting: %d, but got: %d instead for %v", http.StatusOK, res.Code, path)
+			}
+		}
+	})
+
+	t.Run("Views", func(t *testing.T) {
+		views := []struct {
+			token, path string
+			email       bool
+		}{
+			{writer, "/api/v1/users/1", true},
+			{reader, "/api/v1/users/1", false},
+			{admin, "/api/v1/users/1", true},
+			{writer, "/api/v1/users/1/posts", true},
+			{reader, "/api/v1/users/1/posts", false},
+			{reader, "/api/v1/users", false},
+			{admin, "/api/v1/users", true},
+			{"", "/api/v1/users/writer/profile", false},
+		}
+
+		for _, view := range views {
+			res := request(http.MethodGet, view.path, view.token, "")
+			if strings.Contains(res, "writer@example.com") != view.email || !strings.Contains(res, "writer") {
+				t.Errorf("Expecting: %v, but got: %v instead", view.email, res)
+			}
+		}
+	})
+	t.Run("ErrorStatus", func(t *testing.T) {
+		failures := []struct {
+			method, path, token, body string
+		}{
+			{http.MethodPost, "/register", "", `{"username": `},
+			{http.MethodPost, "/login", "", `{"email_address": `},
+			{http.MethodPost, "/login", "", `{"email_address": "unknown@example.com", "password": "correct horse battery"}`},
+			{http.MethodGet, "/api/v1/users", "", ""},
+			{http.MethodGet, "/api/v1/users/1000", admin, ""},
+			{http.MethodGet, "/api/v1/users/writer", admin, ""},
+			{http.MethodPut, "/api/v1/users/1", writer, `{"username": `},
+			{http.MethodPut, "/api/v1/users/2", writer, `{"username": "reader", "email_address": "reader@example.com"}`},
+			{http.MethodGet, "/api/v1/users/unknown/profile", "", ""},
+			{http.MethodGet, "/api/v1/users/0", admin, ""},
+			{http.MethodGet, "/api/v1/users/0/posts", admin, ""},
+			{http.MethodPut, "/api/v1/users/first", writer, `{"username": "writer", "email_address": "writer@example.com"}`},
+			{http.MethodPost, "/api/v1/users/1/export", reader, ""},
+			{http.MethodGet, "/exports/download?token=invalid", "", ""},
+			{http.MethodPost, "/api/v1/posts/create", writer, `{"post_title": `},
+			{http.MethodGet, "/api/v1/posts/1000", writer, ""},
+			{http.MethodPut, "/api/v1/posts/1000", writer, `{"post_title": "Missing", "post_body": "Post"}`},
+			{http.MethodPut, "/api/v1/posts/first", writer, `{"post_title": "Missing", "post_body": "Post"}`},
+			{http.MethodPut, "/api/v1/posts/1", reader, `{"post_title": "Other", "post_body": "Post"}`},
+			{http.MethodDelete, "/api/v1/posts/1000", writer, ""},
+			{http.MethodPost, "/login/2fa", "", `{"token": `},
+			{http.MethodPost, "/api/v1/2fa/confirm", writer, `{"code": `},
+			{http.MethodPost, "/api/v1/tokens", writer, `{"name": `},
+			{http.MethodDelete, "/api/v1/tokens/1000", writer, ""},
+			{http.MethodPost, "/api/v1/oauth/clients", writer, `{"name": `},
+			{http.MethodDelete, "/api/v1/oauth/clients/unknown", writer, ""},
+			{http.MethodGet, "/api/v1/oauth/authorize?client_id=unknown", writer, ""},
+		}
+
+		for _, failure := range failures {
+			for _, accept := range []string{"", "application/json", response.ProblemContentType} {
+				res := serve(failure.method, failure.path, failure.token, failure.body, map[string]string{"Accept": accept})
+				if res.Code < http.StatusBadRequest {
+					t.Errorf("Expecting: %v, but got: %v instead", "an error status", failure)
+					continue
+				}
+
+				// The body tells the same status, whichever the format
+				var body struct {
+					StatusCode int `json:"status_code"`
+					Status     int `json:"status"`
+				}
+
+				switch res.Header().Get("Content-Type") {
+				case response.ProblemContentType:
+					json.NewDecoder(res.Body).Decode(&body)
+					body.StatusCode = body.Status
+				case "application/json; charset=utf-8":
+					json.NewDecoder(res.Body).Decode(&body)
+				default:
+					body.StatusCode = res.Code
+				}
+
+				if body.StatusCode != res.Code {
+					t.Errorf("Expecting: %v, but got: %v instead", res.Code, body.StatusCode)
+				}
+			}
+		}
+	})
+	t.Run("ETag", func(t *testing.T) {
+		res := serve(http.MethodGet, "/api/v1/users/1", writer, "", nil)
+		read := res.Header().Get("ETag")
+		if res.Code != http.StatusOK || read == "" {
+			t.Fatalf("Expecting: %v, but got: %v instead", "an ETag", res.Header())
+		}
+
+		// Polling clients are told nothing changed
+		res = serve(http.MethodGet, "/api/v1/users/1", writer, "", map[string]string{"If-None-Match": read})
+		if res.Code != http.StatusNotModified || res.Body.Len() != 0 {
+			t.Errorf("Expecting: %v, but got: %v instead", http.StatusNotModified, res.Code)
+		}
+
+		update := `{"username": "writer", "email_address": "writer@example.com"}`
+		preconditions := []struct {
+			ifMatch string
+			status  int
+		}{
+			{"", http.StatusPreconditionRequired},
+			{`"1000"`, http.StatusPreconditionFailed},
+			{"W/" + read, http.StatusPreconditionFailed},
+			{read, http.StatusOK},
+			// The other editor read the previous version too
+			{read, http.StatusPreconditionFailed},
+		}
+
+		for _, p := range preconditions {
+			res = serve(http.MethodPut, "/api/v1/users/1", writer, update, map[string]string{"If-Match": p.ifMatch})
+			if res.Code != p.status {
+				t.Errorf("Expecting: %v, but got: %v instead", p.status, res.Code)
+			}
+		}
+
+		res = serve(http.MethodGet, "/api/v1/users/1", writer, "", map[string]string{"If-None-Match": read})
+		if res.Code != http.StatusOK || res.Header().Get("ETag") == read {
+			t.Errorf("Expecting: %v, but got: %v instead", "a new ETag", res.Header().Get("ETag"))
+		}
+
+		res = serve(http.MethodDelete, "/api/v1/users/1", writer, "", map[string]string{"If-Match": read})
+		if res.Code != http.StatusPreconditionFailed {
+			t.Errorf("Expecting: %v, but got: %v instead", http.StatusPreconditionFailed, res.Code)
+		}
+
+		// The erasure is only requested, until the link of the email confirms it
+		current := serve(http.MethodGet, "/api/v1/users/1", writer, "", nil).Header().Get("ETag")
+		res = serve(http.MethodDelete, "/api/v1/users/1", writer, "", map[string]string{"If-Match": current})
+		if res.Code != http.StatusAccepted || !strings.Contains(res.Body.String(), `"status_code":202`) {
+			t.Errorf("Expecting: %v, but got: %v instead", http.StatusAccepted, res.Code)
+		}
+	})
+	t.Run("UserType", func(t *testing.T) {
+		res := request(http.MethodPost, "/register", "", `{"username": "climber", "email_address": "climber@example.com", "password": "correct horse battery", "user_type": "admin"}`)
+		if !strings.Contains(res, `"user_type":"reader"`) {
+			t.Errorf("Expecting: %v, but got: %v instead", "a reader", res)
+		}
+
+		// etagOf returns the current ETag of the user
+		etagOf := func(path string) map[string]string {
+			return map[string]string{"If-Match": serve(http.MethodGet, path, admin, "", nil).Header().Get("ETag")}
+		}
+
+		// The fields owned by the server are rejected, and the stored user is left as it is
+		stored := *store.users[0]
+		for _, update := range []string{
+			`{"username": "writer", "email_address": "writer@example.com", "user_type": "admin"}`,
+			`{"id": 2, "username": "taken", "email_address": "taken@example.com"}`,
+		} {
+			if res := serve(http.MethodPut, "/api/v1/users/1", writer, update, etagOf("/api/v1/users/1")); res.Code != http.StatusUnprocessableEntity {
+				t.Errorf("Expecting: %v, but got: %v instead", http.StatusUnprocessableEntity, res.Code)
+			}
+		}
+
+		if *store.users[0] != stored || store.users[1].EmailAddress == "taken@example.com" {
+			t.Errorf("Expecting: %v, but got: %v instead", stored, *store.users[0])
+		}
+
+		changes := []struct {
+			token, body string
+			status      int
+		}{
+			{writer, `{"user_type": "admin"}`, http.StatusForbidden},
+			{admin, `{"user_type": "former_member"}`, http.StatusUnprocessableEntity},
+			{admin, `{"user_type": "admin"}`, http.StatusOK},
+		}
+
+		for _, change := range changes {
+			res := serve(http.MethodPut, "/api/admin/users/2/user-type", change.token, change.body, etagOf("/api/v1/users/2"))
+			if res.Code != change.status {
+				t.Errorf("Expecting: %v, but got: %v instead", change.status, res.Code)
+			}
+		}
+
+		if store.users[1].UserType != "admin" {
+			t.Errorf("Expecting: %v, but got: %v instead", "admin", store.users[1].UserType)
+		}
+	})
+	t.Run("PostOwnedFields", func(t *testing.T) {
+		for _, create := range []string{
+			`{"post_title": "Hello", "post_body": "World", "creator_id": 2}`,
+			`{"post_title": "Hello", "post_body": "World", "id": 1}`,
+		} {
+			if res := serve(http.MethodPost, "/api/v1/posts/create", writer, create, nil); res.Code != http.StatusUnprocessableEntity {
+				t.Errorf("Expecting: %v, but got: %v instead", http.StatusUnprocessableEntity, res.Code)
+			}
+		}
+
+		stored, err := posts.Post(1)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+		before := *stored
+
+		for _, update := range []string{
+			`{"post_title": "Taken", "post_body": "Post", "creator_id": 2}`,
+			`{"post_title": "Taken", "post_body": "Post", "id": 2}`,
+			`{"post_title": "Taken", "post_body": "Post", "created_at": 1}`,
+		} {
+			current := serve(http.MethodGet, "/api/v1/posts/1", writer, "", nil).Header().Get("ETag")
+			if res := serve(http.MethodPut, "/api/v1/posts/1", writer, update, map[string]string{"If-Match": current}); res.Code != http.StatusUnprocessableEntity {
+				t.Errorf("Expecting: %v, but got: %v instead", http.StatusUnprocessableEntity, res.Code)
+			}
+		}
+
+		if stored, _ = posts.Post(1); *stored != before {
+			t.Errorf("Expecting: %v, but got: %v instead", before, *stored)
+		}
+	})
+	t.Run("TwoFactorLogin", func(t *testing.T) {
+		request(http.MethodPost, "/register", "", `{"username": "guarded", "email_address": "guarded@example.com", "password": "correct horse battery"}`)
+
+		secret, err := twofactor.NewSecret()
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		user, _ := store.UserByEmail("guarded@example.com")
+		twoFactors.enrolled[user.ID] = &app.TwoFactor{UserID: user.ID, Secret: secret, EnabledAt: 1}
+
+		// challenge signs in with the password, which asks for the second factor
+		challenge := func() string {
+			var body struct {
+				Data struct {
+					ChallengeToken string `json:"challenge_token"`
+				} `json:"data"`
+			}
+
+			res := request(http.MethodPost, "/login", "", `{"email_address": "guarded@example.com", "password": "correct horse battery"}`)
+			if err := json.Unmarshal([]byte(res), &body); err != nil || body.Data.ChallengeToken == "" {
+				t.Fatalf("Expecting: %v, but got: %v instead", "a challenge token", res)
+			}
+			return body.Data.ChallengeToken
+		}
+
+		code, _ := twofactor.Code(secret, twofactor.Step(time.Now()))
+		res := serve(http.MethodPost, "/login/2fa", "", `{"challenge_token": "`+challenge()+`", "code": "`+code+`"}`, nil)
+		if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), "auth_token") {
+			t.Errorf("Expecting: %v, but got: %v instead", "an auth token", res.Body.String())
+		}
+
+		token := challenge()
+		attempts := []int{http.StatusUnauthorized, http.StatusTooManyRequests}
+		for _, status := range attempts {
+			res := serve(http.MethodPost, "/login/2fa", "", `{"challenge_token": "`+token+`", "code": "000000"}`, nil)
+			if res.Code != status {
+				t.Errorf("Expecting: %v, but got: %v instead", status, res.Code)
+			}
+		}
+
+		// Neither a new challenge nor the password give more attempts
+		res = serve(http.MethodPost, "/login/2fa", "", `{"challenge_token": "`+challenge()+`", "code": "000000"}`, nil)
+		if res.Code != http.StatusTooManyRequests || res.Header().Get("Retry-After") == "" {
+			t.Errorf("Expecting: %v, but got: %v instead", http.StatusTooManyRequests, res.Code)
+		}
+	})
+	t.Run("DelegatedToken", func(t *testing.T) {
+		claims := jwt.MapClaims{"user_id": 1, "scope": "users:read users:write"}
+		jwtauth.SetIssuedNow(claims)
+		delegated, err := jwtService.Encode(claims)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if res := serve(http.MethodGet, "/api/v1/users/1", delegated, "", nil); res.Code != http.StatusOK {
+			t.Errorf("Expecting: %v, but got: %v instead", http.StatusOK, res.Code)
+		}
+
+		// The credentials are only changed with an interactive login
+		update := `{"username": "writer", "email_address": "stolen@example.com", "password": "stolen horse battery"}`
+		if res := serve(http.MethodPut, "/api/v1/users/1", delegated, update, map[string]string{"If-Match": `"1000"`}); res.Code != http.StatusForbidden {
+			t.Errorf("Expecting: %v, but got: %v instead", http.StatusForbidden, res.Code)
+		}
+	})
+	t.Run("Audit", func(t *testing.T) {
+		// The new password is recorded as a change of the user, without its hash
+		update := `{"username": "writer", "email_address": "writer@example.com", "password": "another horse battery"}`
+		read := serve(http.MethodGet, "/api/v1/users/1", writer, "", nil).Header().Get("ETag")
+		if res := serve(http.MethodPut, "/api/v1/users/1", writer, update, map[string]string{"If-Match": read}); res.Code != http.StatusOK {
+			t.Fatalf("Expecting: %v, but got: %v instead", http.StatusOK, res.Code)
+		}
+
+		writer = login("writer@example.com")
+
+		for _, path := range []string{"/api/admin/audit", "/api/admin/audit?format=csv"} {
+			res := serve(http.MethodGet, path, admin, "", nil)
+			if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), audit.ActionUserUpdate) {
+				t.Errorf("Expecting: %v, but got: %v instead", "the update of the user", res.Body.String())
+			}
+		}
+	})
+	t.Run("Export", func(t *testing.T) {
+		if res := serve(http.MethodPost, "/api/v1/users/1/export", writer, "", nil); !strings.Contains(res.Body.String(), "job_id") {
+			t.Fatalf("Expecting: %v, but got: %v instead", "an export job", res.Body.String())
+		}
+
+		var last export.Progress
+		for last.Status != export.StatusDone {
+			select {
+			case last = <-notifier.progress:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Expecting: %v, but got: %v instead", export.StatusDone, last)
+			}
+
+			if last.Status == export.StatusFailed {
+				t.Fatalf("Expecting: %v, but got: %v instead", export.StatusDone, last)
+			}
+		}
+
+		link, err := url.Parse(last.DownloadURL)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		res := serve(http.MethodGet, "/exports/download?"+link.RawQuery, "", "", nil)
+		archive, err := zip.NewReader(bytes.NewReader(res.Body.Bytes()), int64(res.Body.Len()))
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		found := false
+		for _, f := range archive.File {
+			if f.Name != "profile.json" {
+				continue
+			}
+
+			rc, _ := f.Open()
+			content, _ := ioutil.ReadAll(rc)
+			rc.Close()
+
+			found = true
+			if strings.Contains(string(content), `"password":`) || strings.Contains(string(content), passwordHash) {
+				t.Errorf("Expecting: %v, but got: %v instead", "no password", string(content))
+			}
+		}
+
+		if !found {
+			t.Errorf("Expecting: %v, but got: %v instead", "profile.json", archive.File)
+		}
+	})
+}
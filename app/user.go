@@ -1,42 +1,79 @@
 package app
 
-// User represents the user of our application
+import (
+	"context"
+)
+
+// User represents the user of our application. Its `view` tags
+// decide what the public, self and admin representations hold,
+// the password hash is part of none of them.
 type User struct {
-  ID           int64  `json:"id" db:"id"`
-  Username     string `json:"username" db:"username"`
-  EmailAddress string `json:"email_address" db:"email"`
-  Password     string `json:"password" db:"password"`
-  UserType     string `json:"user_type" db:"user_type"`
-  CreatedAt    int64  `json:"created_at" db:"created_at"`
-  UpdatedAt    int64  `json:"updated_at" db:"updated_at"`
-  DeletedAt    int64  `json:"deleted_at" db:"deleted_at"`
+	ID           int64  `json:"id" db:"id"`
+	Username     string `json:"username" db:"username"`
+	EmailAddress string `json:"email_address" db:"email" view:"self,admin"`
+	Password     string `json:"password" db:"password" view:"-"`
+	UserType     string `json:"user_type" db:"user_type" view:"self,admin"`
+	// EmailVerifiedAt is zero until the user opens the verification link.
+	EmailVerifiedAt int64 `json:"email_verified_at" db:"email_verified_at" view:"self,admin"`
+	// SessionsRevokedAt invalidates every auth token issued before it, or
+	// within the same second, as the iat of the tokens has no finer precision.
+	SessionsRevokedAt int64 `json:"-" db:"sessions_revoked_at"`
+	CreatedAt         int64 `json:"created_at" db:"created_at"`
+	UpdatedAt         int64 `json:"updated_at" db:"updated_at" view:"self,admin"`
+	DeletedAt         int64 `json:"deleted_at" db:"deleted_at" view:"admin"`
+	// Version is increased by every change of the user, and
+	// UpdateUser only saves the user at the version it holds.
+	Version int64 `json:"version" db:"version"`
 }
 
 // UserPosts represent the posts made by the user.
 type UserPosts struct {
-  PostTitle string `json:"post_title" db:"post_title"`
-  PostBody  string `json:"post_body" db:"post_body"`
-  UserType  string `json:"user_type" db:"user_type"`
-  Email     string `json:"email" db:"email"`
-  Username  string `json:"username" db:"username"`
-  CreatedAt int64  `json:"created_at" db:"created_at"`
-  UpdatedAt int64  `json:"updated_at" db:"updated_at"`
+	PostTitle string `json:"post_title" db:"post_title"`
+	PostBody  string `json:"post_body" db:"post_body"`
+	UserType  string `json:"user_type" db:"user_type" view:"self,admin"`
+	Email     string `json:"email" db:"email" view:"self,admin"`
+	Username  string `json:"username" db:"username"`
+	CreatedAt int64  `json:"created_at" db:"created_at"`
+	UpdatedAt int64  `json:"updated_at" db:"updated_at"`
 }
 
-// UserService defines the basic service of user
+// UserService defines the basic service of user. Every method reading or
+// writing the store has a Context variant, which gives up once the context
+// is done. The other methods run with context.Background().
 type UserService interface {
-  CreateUser(*User) error
-  User(id int64) (*User, error)
-  UserByEmail(email string) (*User, error)
-  Login(email, password string) (*User, error)
-  Users() ([]*User, error)
-  UpdateUser(*User) error
-  DeleteUser(id int64) error
-  GetUserPosts(userID int64) ([]*UserPosts, error)
-  GenerateAuthToken(*User) (string, error)
+	CreateUser(*User) error
+	User(id int64) (*User, error)
+	UserByEmail(email string) (*User, error)
+	UserByUsername(username string) (*User, error)
+	Login(email, password string) (*User, error)
+	Users() ([]*User, error)
+	UpdateUser(*User) error
+	DeleteUser(id int64) error
+	GetUserPosts(userID int64) ([]*UserPosts, error)
+	VerifyEmail(id int64, email string) error
+	// ResetPassword sets a new password and revokes the existing sessions.
+	ResetPassword(id int64, password string) error
+	GenerateAuthToken(*User) (string, error)
+
+	CreateUserContext(ctx context.Context, user *User) error
+	UserContext(ctx context.Context, id int64) (*User, error)
+	UserByEmailContext(ctx context.Context, email string) (*User, error)
+	UserByUsernameContext(ctx context.Context, username string) (*User, error)
+	LoginContext(ctx context.Context, email, password string) (*User, error)
+	UsersContext(ctx context.Context) ([]*User, error)
+	UpdateUserContext(ctx context.Context, user *User) error
+	DeleteUserContext(ctx context.Context, id int64) error
+	GetUserPostsContext(ctx context.Context, userID int64) ([]*UserPosts, error)
+	VerifyEmailContext(ctx context.Context, id int64, email string) error
+	ResetPasswordContext(ctx context.Context, id int64, password string) error
+}
+
+// EmailVerified returns true once the user verified the email address.
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt > 0
 }
 
 // TableName represents the table name of user
 func (User) TableName() string {
-  return "users"
+	return "users"
 }
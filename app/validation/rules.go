@@ -0,0 +1,53 @@
+package validation
+
+import "github.com/rbo13/write-it/app"
+
+// The maximum lengths of the fields, those of their columns. A varchar counts
+// characters, while a text column holds 65,535 bytes whatever the characters.
+const (
+	maxUsername     = 16
+	maxEmailAddress = 151
+	maxPostTitle    = 255
+	maxPostBody     = 65535
+)
+
+// userTypes are the types of user an admin can give. The erasure gives
+// its own type to the account keeping the posts of the erased users.
+var userTypes = []string{"reader", "admin"}
+
+// User checks the user sent by a client, along with the violations found
+// so far, e.g. by Decode, if any. The password is left to passwords.Policy.
+func User(user *app.User, errs Errors) error {
+	if errs == nil {
+		errs = Errors{}
+	}
+
+	errs.Check("username", "Username", user.Username, Required(), MaxLength(maxUsername))
+	errs.Check("email_address", "Email address", user.EmailAddress, Required(), MaxLength(maxEmailAddress), Email())
+
+	return errs.Err()
+}
+
+// UserType checks the type of user an admin gives, like User does.
+func UserType(userType string, errs Errors) error {
+	if errs == nil {
+		errs = Errors{}
+	}
+
+	errs.Check("user_type", "User type", userType, Required(), OneOf(userTypes...))
+
+	return errs.Err()
+}
+
+// Post checks the post sent by a client like User does. Its title is
+// kept shorter than its text column, as it is shown on a single line.
+func Post(post *app.Post, errs Errors) error {
+	if errs == nil {
+		errs = Errors{}
+	}
+
+	errs.Check("post_title", "Post title", post.PostTitle, Required(), MaxLength(maxPostTitle))
+	errs.Check("post_body", "Post body", post.PostBody, Required(), MaxBytes(maxPostBody))
+
+	return errs.Err()
+}
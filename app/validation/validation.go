@@ -0,0 +1,195 @@
+// Package validation checks the users and the posts sent by the clients, so
+// that every entry point accepts the same ones and reports every violation at once.
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/mail"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rbo13/write-it/app"
+)
+
+// MaxBodySize is the size, in bytes, of the largest request body Decode reads.
+const MaxBodySize = 1 << 20
+
+var (
+	errMalformed = app.NewError(app.Validation, "The request body must be a JSON object")
+	errTooLarge  = app.NewError(app.Validation, fmt.Sprintf("The request body must be at most %d bytes", MaxBodySize))
+)
+
+// errInvalid is the message of the errors returned by Errors.Err.
+const errInvalid = "The request has invalid fields"
+
+// Errors maps the invalid fields of a request to what is wrong with them.
+type Errors map[string]string
+
+// Add records that field is invalid, unless it already is.
+func (e Errors) Add(field, message string) {
+	if _, ok := e[field]; !ok {
+		e[field] = message
+	}
+}
+
+// Check applies the rules to the value of field, named label in
+// the messages, and records the violation of the first one failing.
+func (e Errors) Check(field, label, value string, rules ...Rule) {
+	for _, rule := range rules {
+		if message := rule(label, value); message != "" {
+			e.Add(field, message)
+			return
+		}
+	}
+}
+
+// Err returns an app.Validation error holding every violation, or nil when there is none.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return app.NewValidationError(errInvalid, e)
+}
+
+// Rule returns what is wrong with the value of a field named label, or "" when nothing is.
+type Rule func(label, value string) string
+
+// Required rejects the empty values, and the ones made of spaces only.
+func Required() Rule {
+	return func(label, value string) string {
+		if strings.TrimSpace(value) == "" {
+			return label + " is required"
+		}
+		return ""
+	}
+}
+
+// MaxLength rejects the values longer than max characters, as
+// counted by the varchar columns, and the ones that are not UTF-8.
+func MaxLength(max int) Rule {
+	return func(label, value string) string {
+		if !utf8.ValidString(value) {
+			return label + " must be valid UTF-8"
+		}
+
+		if utf8.RuneCountInString(value) > max {
+			return fmt.Sprintf("%s must be at most %d characters", label, max)
+		}
+		return ""
+	}
+}
+
+// MaxBytes rejects the values longer than max bytes once encoded, as
+// counted by the text columns, and the ones that are not UTF-8.
+func MaxBytes(max int) Rule {
+	return func(label, value string) string {
+		if !utf8.ValidString(value) {
+			return label + " must be valid UTF-8"
+		}
+
+		if len(value) > max {
+			return fmt.Sprintf("%s must be at most %d bytes", label, max)
+		}
+		return ""
+	}
+}
+
+// Email rejects the values that are not a bare email address, without a display name.
+func Email() Rule {
+	return func(label, value string) string {
+		address, err := mail.ParseAddress(value)
+		if err != nil || address.Address != value || !strings.Contains(value[strings.LastIndex(value, "@"):], ".") {
+			return label + " must be a valid email address"
+		}
+		return ""
+	}
+}
+
+// OneOf rejects the values that are not one of the given ones.
+func OneOf(values ...string) Rule {
+	return func(label, value string) string {
+		for _, v := range values {
+			if value == v {
+				return ""
+			}
+		}
+		return fmt.Sprintf("%s must be one of %s", label, strings.Join(values, ", "))
+	}
+}
+
+// Decode reads the JSON object of body into v, a pointer to a struct. The
+// fields of the object that v does not have, and the ones of the wrong type,
+// are returned as violations, so that they are reported along with the ones
+// of the rules. An error is returned when the body is not a JSON object.
+func Decode(body io.Reader, v interface{}) (Errors, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(body, MaxBodySize+1))
+	if err != nil {
+		return nil, errMalformed.Wrap(err)
+	}
+
+	if len(data) > MaxBodySize {
+		return nil, errTooLarge
+	}
+
+	var object map[string]json.RawMessage
+	if err = json.Unmarshal(data, &object); err != nil || object == nil {
+		return nil, errMalformed.Wrap(err)
+	}
+
+	errs := Errors{}
+	known := fieldsOf(reflect.TypeOf(v).Elem())
+
+	for field := range object {
+		if !known[strings.ToLower(field)] {
+			errs.Add(field, "Unknown field")
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err = decoder.Decode(v); err != nil {
+		e, ok := err.(*json.UnmarshalTypeError)
+		if !ok || e.Field == "" {
+			return nil, errMalformed.Wrap(err)
+		}
+
+		errs.Add(e.Field, fmt.Sprintf("Must be a JSON %s", e.Value))
+	}
+
+	return errs, nil
+}
+
+// fieldsOf returns the lowercased JSON names of the fields of the struct t,
+// as encoding/json matches them regardless of the case.
+func fieldsOf(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for name := range fieldsOf(field.Type) {
+				fields[name] = true
+			}
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		fields[strings.ToLower(name)] = true
+	}
+
+	return fields
+}
@@ -0,0 +1,128 @@
+package validation_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/validation"
+)
+
+func TestDecode(t *testing.T) {
+	t.Run("UnknownFields", func(t *testing.T) {
+		var post app.Post
+
+		errs, err := validation.Decode(strings.NewReader(`{"post_title": "Hello", "Post_Body": "World", "titel": "Hello", "draft": true}`), &post)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if post.PostTitle != "Hello" || post.PostBody != "World" {
+			t.Errorf("Expecting: %v, but got: %v instead", "the decoded post", post)
+		}
+
+		if len(errs) != 2 || errs["titel"] == "" || errs["draft"] == "" {
+			t.Errorf("Expecting: %v, but got: %v instead", "titel and draft to be unknown", errs)
+		}
+	})
+
+	t.Run("WrongType", func(t *testing.T) {
+		var user app.User
+
+		errs, err := validation.Decode(strings.NewReader(`{"username": 42, "email_address": "writer@example.com"}`), &user)
+		if err != nil {
+			t.Fatalf("Error occurred due to: %v", err)
+		}
+
+		if errs["username"] == "" || user.EmailAddress != "writer@example.com" {
+			t.Errorf("Expecting: %v, but got: %v instead", "username to be of the wrong type", errs)
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		for _, body := range []string{``, `{"username": `, `[]`, `null`, strings.Repeat(" ", validation.MaxBodySize) + `{}`} {
+			var user app.User
+
+			if _, err := validation.Decode(strings.NewReader(body), &user); app.KindOf(err) != app.Validation {
+				t.Errorf("Expecting: %v, but got: %v instead", app.Validation, err)
+			}
+		}
+	})
+}
+
+func TestUser(t *testing.T) {
+	users := []struct {
+		user    app.User
+		invalid []string
+	}{
+		{app.User{Username: "writer", EmailAddress: "writer@example.com"}, nil},
+		// 16 characters, but 32 bytes
+		{app.User{Username: "éééééééééééééééé", EmailAddress: "writer@example.com"}, nil},
+		{app.User{Username: "ééééééééééééééééé", EmailAddress: "writer@example.com"}, []string{"username"}},
+		{app.User{Username: " ", EmailAddress: ""}, []string{"username", "email_address"}},
+		{app.User{Username: "writer\xff", EmailAddress: "Writer <writer@example.com>"}, []string{"username", "email_address"}},
+		{app.User{Username: "writer", EmailAddress: "writer@localhost"}, []string{"email_address"}},
+		{app.User{Username: "writer", EmailAddress: strings.Repeat("w", 140) + "@example.com"}, []string{"email_address"}},
+	}
+
+	for _, u := range users {
+		err := validation.User(&u.user, nil)
+
+		e := app.ErrorOf(err)
+		if len(u.invalid) == 0 {
+			if err != nil {
+				t.Errorf("Error occurred due to: %v", err)
+			}
+			continue
+		}
+
+		if e == nil || e.Kind != app.Validation || len(e.Fields) != len(u.invalid) {
+			t.Errorf("Expecting: %v, but got: %v instead", u.invalid, err)
+			continue
+		}
+
+		for _, field := range u.invalid {
+			if e.Fields[field] == "" {
+				t.Errorf("Expecting: %v, but got: %v instead", field, e.Fields)
+			}
+		}
+	}
+}
+
+func TestUserType(t *testing.T) {
+	if err := validation.UserType("admin", nil); err != nil {
+		t.Errorf("Error occurred due to: %v", err)
+	}
+
+	for _, userType := range []string{"", "former_member", "Admin"} {
+		e := app.ErrorOf(validation.UserType(userType, nil))
+		if e == nil || e.Fields["user_type"] == "" {
+			t.Errorf("Expecting: %v, but got: %v instead", "a user_type violation", e)
+		}
+	}
+}
+
+func TestPost(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		post := app.Post{PostTitle: "Hello", PostBody: strings.Repeat("a", 65535)}
+		if err := validation.Post(&post, nil); err != nil {
+			t.Errorf("Error occurred due to: %v", err)
+		}
+	})
+
+	t.Run("EveryViolation", func(t *testing.T) {
+		// 21,846 characters of 3 bytes are over the 65,535 bytes of the column
+		post := app.Post{PostTitle: "", PostBody: strings.Repeat("€", 21846)}
+
+		err := validation.Post(&post, validation.Errors{"draft": "Unknown field"})
+
+		e := app.ErrorOf(err)
+		if e == nil || len(e.Fields) != 3 {
+			t.Fatalf("Expecting: %v, but got: %v instead", "3 violations", err)
+		}
+
+		if e.Fields["post_title"] != "Post title is required" || e.Fields["post_body"] != "Post body must be at most 65535 bytes" {
+			t.Errorf("Expecting: %v, but got: %v instead", "the title and body violations", e.Fields)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+// Package verification verifies the email address of newly registered users.
+package verification
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/jwtauth"
+
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/jwtservice"
+	"github.com/rbo13/write-it/app/mailer"
+	"github.com/rbo13/write-it/app/response"
+)
+
+const purpose = "email_verification"
+
+var (
+	errInvalidToken    = app.NewError(app.Validation, "Verification token is invalid or expired")
+	errEmailUnverified = app.NewError(app.Forbidden, "Email Address is not verified yet")
+)
+
+// Policy sets what an unverified user cannot do.
+type Policy string
+
+const (
+	// PolicyNone lets unverified users do everything.
+	PolicyNone Policy = "none"
+	// PolicyPosting prevents unverified users from creating or updating posts.
+	PolicyPosting Policy = "posting"
+	// PolicyLogin prevents unverified users from logging in.
+	PolicyLogin Policy = "login"
+)
+
+// ParsePolicy returns the Policy named s, defaulting to PolicyNone.
+func ParsePolicy(s string) Policy {
+	switch Policy(s) {
+	case PolicyPosting, PolicyLogin:
+		return Policy(s)
+	}
+	return PolicyNone
+}
+
+// Service sends the verification emails and checks their tokens.
+type Service struct {
+	Policy Policy
+
+	jwtService  *jwtservice.JWT
+	mailer      mailer.Mailer
+	userService app.UserService
+	baseURL     string
+	ttl         time.Duration
+}
+
+// New returns a verification Service. The links inside the emails
+// point to baseURL and expire after ttl.
+func New(jwtService *jwtservice.JWT, m mailer.Mailer, userService app.UserService, baseURL string, ttl time.Duration, policy Policy) *Service {
+	return &Service{
+		Policy:      policy,
+		jwtService:  jwtService,
+		mailer:      m,
+		userService: userService,
+		baseURL:     baseURL,
+		ttl:         ttl,
+	}
+}
+
+// Send emails a verification link to the user.
+func (s *Service) Send(user *app.User) error {
+	token, err := s.jwtService.EncodePurpose(purpose, jwt.MapClaims{
+		"sub":   user.ID,
+		"email": user.EmailAddress,
+	}, s.ttl)
+	if err != nil {
+		return err
+	}
+
+	link := s.baseURL + "/verify?token=" + url.QueryEscape(token)
+
+	return s.mailer.Send(mailer.Message{
+		To:      user.EmailAddress,
+		Subject: "Verify your email address",
+		Body:    fmt.Sprintf("Hi %s,\n\nPlease verify your email address by opening the link below:\n\n%s\n\nThe link expires in %s.\n", user.Username, link, s.ttl),
+	})
+}
+
+// Verify checks the token and marks the email address of its user as verified.
+func (s *Service) Verify(token string) error {
+	claims, err := s.jwtService.DecodePurpose(purpose, token)
+	if err != nil {
+		return errInvalidToken.Wrap(err)
+	}
+
+	userID, ok := claims["sub"].(float64)
+	email, _ := claims["email"].(string)
+	if !ok || email == "" {
+		return errInvalidToken
+	}
+
+	return s.userService.VerifyEmail(int64(userID), email)
+}
+
+// CanLogin reports whether the policy allows the user to log in.
+func (s *Service) CanLogin(user *app.User) bool {
+	return s.Policy != PolicyLogin || user.EmailVerified()
+}
+
+// RequireVerified is a middleware that prevents unverified users from posting
+// when the policy asks for it. It must run after the JWT verifier.
+func (s *Service) RequireVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Policy == PolicyNone {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		_, claims, err := jwtauth.FromContext(r.Context())
+		if err != nil {
+			config := response.Configure(err.Error(), http.StatusUnauthorized, nil)
+			response.JSONError(w, r, config)
+			return
+		}
+
+		userID, _ := claims["user_id"].(float64)
+		user, err := s.userService.User(int64(userID))
+		if err != nil {
+			response.Error(w, r, err)
+			return
+		}
+
+		if !user.EmailVerified() {
+			response.Error(w, r, errEmailUnverified)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,6 +1,10 @@
 package websocket
 
 import (
+	"math/rand"
+	"net/http"
+
+	"github.com/go-chi/jwtauth"
 	"github.com/gofrs/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/rbo13/write-it/app/generate"
@@ -10,6 +14,7 @@ import (
 // for client socket connection
 type Client struct {
 	id       string
+	userID   int64
 	hub      *Hub
 	color    string
 	socket   *websocket.Conn
@@ -21,19 +26,40 @@ type Client struct {
 var u1 = uuid.Must(uuid.NewV4())
 
 // NewClient is our constructor
-// that returns an instance of Client
-func NewClient(hub *Hub, socket *websocket.Conn) *Client {
+// that returns an instance of Client.
+// The color of a user is the same on every connection,
+// userID is zero for anonymous clients.
+func NewClient(hub *Hub, socket *websocket.Conn, userID int64) *Client {
 	uuID, _ := uuid.NewV4()
 	uuIDStr := uuID.String()
+
+	seed := userID
+	if seed == 0 {
+		seed = rand.Int63()
+	}
+
 	return &Client{
 		id:       uuIDStr,
-		color:    generate.Color(),
+		userID:   userID,
+		color:    generate.ColorFor(seed),
 		hub:      hub,
 		socket:   socket,
 		outbound: make(chan []byte),
 	}
 }
 
+// clientUserID returns the id of the authenticated user, or zero.
+func clientUserID(r *http.Request) int64 {
+	_, claims, err := jwtauth.FromContext(r.Context())
+	if err == nil {
+		if userID, ok := claims["user_id"].(float64); ok {
+			return int64(userID)
+		}
+	}
+
+	return 0
+}
+
 func (client *Client) read() {
 	defer func() {
 		client.hub.unregister <- client
@@ -14,6 +14,13 @@ type Hub struct {
 	clients    []*Client
 	register   chan *Client
 	unregister chan *Client
+	notify     chan notification
+}
+
+// notification is a message for every client of a user
+type notification struct {
+	userID  int64
+	message interface{}
 }
 
 // NewHub is our constructor that
@@ -23,6 +30,7 @@ func NewHub() *Hub {
 		clients:    make([]*Client, 0),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		notify:     make(chan notification),
 	}
 
 }
@@ -35,6 +43,8 @@ func (hub *Hub) Run() {
 			hub.onConnect(client)
 		case client := <-hub.unregister:
 			hub.onDisconnect(client)
+		case n := <-hub.notify:
+			hub.onNotify(n)
 		}
 	}
 }
@@ -52,12 +62,17 @@ func (hub *Hub) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "could not upgrade", http.StatusInternalServerError)
 		return
 	}
-	client := NewClient(hub, socket)
+	client := NewClient(hub, socket, clientUserID(r))
 	hub.clients = append(hub.clients, client)
 	hub.register <- client
 	client.run()
 }
 
+// Notify sends the message to every client of the user.
+func (hub *Hub) Notify(userID int64, message interface{}) {
+	hub.notify <- notification{userID, message}
+}
+
 func (hub *Hub) send(message interface{}, client *Client) {
 	data, _ := json.Marshal(message)
 	client.outbound <- data
@@ -72,6 +87,14 @@ func (hub *Hub) broadcast(message interface{}, ignore *Client) {
 	}
 }
 
+func (hub *Hub) onNotify(n notification) {
+	for _, c := range hub.clients {
+		if c.userID == n.userID {
+			hub.send(n.message, c)
+		}
+	}
+}
+
 func (hub *Hub) onConnect(client *Client) {
 	log.Println("client connected: ", client.socket.RemoteAddr())
 	// TODO:: implement properly onConnect
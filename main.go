@@ -2,10 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -15,10 +22,29 @@ import (
 	"github.com/go-chi/jwtauth"
 	"github.com/go-chi/render"
 
+	"github.com/rbo13/write-it/app"
+	"github.com/rbo13/write-it/app/accesstoken"
+	"github.com/rbo13/write-it/app/audit"
+	"github.com/rbo13/write-it/app/erasure"
+	"github.com/rbo13/write-it/app/export"
 	"github.com/rbo13/write-it/app/jwtservice"
+	"github.com/rbo13/write-it/app/lockout"
+	"github.com/rbo13/write-it/app/mailer"
+	"github.com/rbo13/write-it/app/oauth"
+	"github.com/rbo13/write-it/app/oidc"
+	"github.com/rbo13/write-it/app/passwordreset"
+	"github.com/rbo13/write-it/app/passwords"
+	"github.com/rbo13/write-it/app/persistence/cache"
+	"github.com/rbo13/write-it/app/persistence/cache/memcached"
+	"github.com/rbo13/write-it/app/persistence/cache/memory"
+	"github.com/rbo13/write-it/app/persistence/postgres"
 	"github.com/rbo13/write-it/app/persistence/sql"
+	"github.com/rbo13/write-it/app/persistence/sqlite"
+	"github.com/rbo13/write-it/app/profile"
 	"github.com/rbo13/write-it/app/routes"
+	"github.com/rbo13/write-it/app/twofactor"
 	"github.com/rbo13/write-it/app/usecase"
+	"github.com/rbo13/write-it/app/verification"
 	"github.com/rbo13/write-it/app/websocket"
 	"github.com/rbo13/write-it/server"
 )
@@ -33,42 +59,206 @@ func main() {
 	// Setup different middlwares here
 	router.Use(
 		render.SetContentType(render.ContentTypeJSON),
+		mw.RequestID,
 		mw.Logger,
 		mw.DefaultCompress,
 		mw.Recoverer,
 		mw.RedirectSlashes,
 	)
 
-	db, err := sql.New(dsn)
-
-	check(err)
+	db, err := sql.New(getEnv("DATABASE_URL", dsn))
+	if err != nil {
+		log.Fatalf("Cannot connect to the database: %v", err)
+	}
 
 	defer db.Sqlx.Close()
 
-	db.Create(dbName)
-	db.Use(dbName)
-	db.Migrate()
+	sql.QueryTimeout = getDuration("DB_QUERY_TIMEOUT", sql.QueryTimeout)
+
+	migrations := sql.Migrations
+	switch {
+	case db.Postgres():
+		migrations = postgres.Migrations
+	case db.SQLite():
+		migrations = sqlite.Migrations
+	default:
+		if err = db.Create(dbName); err != nil {
+			log.Fatalf("Cannot create the database: %v", err)
+		}
+
+		if err = db.Use(dbName); err != nil {
+			log.Fatalf("Cannot use the database: %v", err)
+		}
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err = migrate(db, migrations, os.Args[2:]); err != nil {
+			log.Fatalf("Cannot migrate the database: %v", err)
+		}
+		return
+	}
+
+	if err = db.Migrate(migrations); err != nil {
+		log.Fatalf("Cannot migrate the database: %v", err)
+	}
+
+	auditor := audit.New(sql.NewAuditSQLService(db.Sqlx))
+	router.Use(auditor.Middleware)
+	auditUsecase := usecase.NewAudit(auditor)
+
+	jwtService, err := jwtservice.New(jwtConfig())
+	if err != nil {
+		log.Fatalf("Cannot configure JWT signing: %v", err)
+	}
 
-	jwtService := jwtservice.New()
-	userSQLSrvc := sql.NewUserSQLService(db.Sqlx, jwtService)
-	postSQLSrvc := sql.NewPostSQLService(db.Sqlx)
+	rotationDone := make(chan struct{})
+	defer close(rotationDone)
+	go jwtService.StartRotation(rotationDone)
+	userSQLSrvc, postSQLSrvc := stores(db, jwtService)
+
+	mail := newMailer()
+	policy := passwordPolicy()
+	baseURL := getEnv("BASE_URL", "https://localhost:1333")
+
+	verifier := verification.New(
+		jwtService,
+		mail,
+		userSQLSrvc,
+		baseURL,
+		getDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+		verification.ParsePolicy(os.Getenv("EMAIL_VERIFICATION_POLICY")),
+	)
+
+	unitOfWork := sql.NewUnitOfWork(db.Sqlx)
+
+	resetter := passwordreset.New(
+		userSQLSrvc,
+		sql.NewPasswordResetSQLService(db.Sqlx),
+		unitOfWork,
+		policy,
+		mail,
+		baseURL,
+		getDuration("PASSWORD_RESET_TTL", 30*time.Minute),
+	)
+
+	twoFactor := twofactor.New(
+		sql.NewTwoFactorSQLService(db.Sqlx),
+		jwtService,
+		getEnv("TOTP_ISSUER", "write-it"),
+		5*time.Minute,
+	)
+
+	lockoutConfig := lockout.DefaultConfig
+	lockoutConfig.LockDuration = getDuration("LOGIN_LOCK_DURATION", lockoutConfig.LockDuration)
+	cacher := newCache()
+	guard := lockout.New(lockoutConfig, cacher, mail, userSQLSrvc)
+
+	profiles := profile.New(
+		sql.NewProfileSQLService(db.Sqlx),
+		userSQLSrvc,
+		baseURL,
+		getEnv("AVATAR_DIR", "avatars"),
+	)
+
+	eraser := erasure.New(
+		sql.NewErasureSQLService(db.Sqlx),
+		userSQLSrvc,
+		postSQLSrvc,
+		profiles,
+		jwtService,
+		mail,
+		usecase.NewCachePurger(cacher),
+		baseURL,
+		getDuration("ERASURE_COOLING_OFF", 14*24*time.Hour),
+	)
 
-	userUsecase := usecase.NewUser(userSQLSrvc)
-	postUsecase := usecase.NewPost(postSQLSrvc)
+	erasureDone := make(chan struct{})
+	defer close(erasureDone)
+	go eraser.StartWorker(erasureDone)
+	erasureUsecase := usecase.NewErasure(eraser)
 
+	userUsecase := usecase.NewUser(userSQLSrvc, unitOfWork, verifier, twoFactor, guard, policy, cacher, eraser)
+	twoFactorUsecase := usecase.NewTwoFactor(userSQLSrvc, twoFactor, guard)
+
+	accessTokens := accesstoken.New(sql.NewAccessTokenSQLService(db.Sqlx))
+	accessTokenUsecase := usecase.NewAccessToken(accessTokens)
+	passwordUsecase := usecase.NewPassword(resetter, guard)
+
+	oidcService := oidc.New(
+		jwtService,
+		sql.NewIdentitySQLService(db.Sqlx),
+		userSQLSrvc,
+		10*time.Minute,
+		oidcProviders(baseURL)...,
+	)
+	oidcUsecase := usecase.NewOIDC(oidcService, userSQLSrvc, twoFactor, strings.HasPrefix(baseURL, "https://"))
+	postUsecase := usecase.NewPost(postSQLSrvc, cacher)
+
+	profileUsecase := usecase.NewProfile(profiles)
+
+	hub := websocket.NewHub()
+	go hub.Run()
+
+	exporter := export.New(
+		userSQLSrvc,
+		profiles,
+		postSQLSrvc,
+		jwtService,
+		hub,
+		baseURL,
+		getEnv("EXPORT_DIR", "exports"),
+		getDuration("EXPORT_LINK_TTL", 24*time.Hour),
+	)
+
+	cleanupDone := make(chan struct{})
+	defer close(cleanupDone)
+	go exporter.StartCleanup(cleanupDone)
+	exportUsecase := usecase.NewExport(exporter)
+
+	oauthServer := oauth.New(
+		sql.NewOAuthSQLService(db.Sqlx),
+		jwtService,
+		userSQLSrvc,
+		time.Minute,
+		getDuration("OAUTH_TOKEN_TTL", time.Hour),
+	)
+	oauthUsecase := usecase.NewOAuth(oauthServer)
+
+	router.Get("/.well-known/jwks.json", jwtService.JWKS)
 	router.Post("/register", userUsecase.Create)
 	router.Post("/login", userUsecase.Login)
+	router.Get("/verify", userUsecase.VerifyEmail)
+	router.Post("/login/2fa", twoFactorUsecase.Login)
+	router.Get("/login/oidc", oidcUsecase.Providers)
+	router.Get("/login/oidc/{provider}", oidcUsecase.Begin)
+	router.Get("/login/oidc/{provider}/callback", oidcUsecase.Callback)
+	router.Post("/password/forgot", passwordUsecase.Forgot)
+	router.Post("/password/reset", passwordUsecase.Reset)
+	router.Post("/oauth/token", oauthUsecase.Token)
+	router.Post("/oauth/introspect", oauthUsecase.Introspect)
+	router.Get("/api/v1/users/{username}/profile", profileUsecase.Get)
+	router.Get("/api/v1/users/{username}/avatar", profileUsecase.Avatar)
+	router.Get("/exports/download", exportUsecase.Download)
+	router.Get("/erasure/confirm", erasureUsecase.Confirm)
 
 	// Protected routes (API Group)
 	router.Group(func(r chi.Router) {
 		// Boot up JWT middleware
-		r.Use(jwtauth.Verifier(jwtService.TokenAuth))
+		r.Use(jwtService.Verifier)
+		r.Use(accessTokens.Authenticator)
 		r.Use(jwtauth.Authenticator)
+		r.Use(oauthServer.ActiveClient)
+		r.Use(usecase.ActiveSession(userSQLSrvc))
+		r.Use(usecase.RequireTwoFactor(twoFactor, "/api/v1/2fa"))
 
 		// API GROUP
 		r.Route("/api", func(rt chi.Router) {
-			rt.Mount("/v1/users", routes.User(rt, userUsecase))
-			rt.Mount("/v1/posts", routes.Post(r, postUsecase))
+			rt.Mount("/v1/users", routes.User(rt, userUsecase, profileUsecase, exportUsecase, erasureUsecase))
+			rt.Mount("/v1/posts", routes.Post(r, postUsecase, verifier.RequireVerified))
+			rt.Mount("/v1/tokens", routes.AccessToken(chi.NewRouter(), accessTokenUsecase))
+			rt.Mount("/v1/2fa", routes.TwoFactor(chi.NewRouter(), twoFactorUsecase))
+			rt.Mount("/v1/oauth", routes.OAuth(chi.NewRouter(), oauthUsecase))
+			rt.With(usecase.RequireUserType("admin")).Mount("/admin", routes.Admin(chi.NewRouter(), userUsecase, twoFactorUsecase, auditUsecase))
 		})
 
 		// r.Get("/dummy", func(w http.ResponseWriter, r *http.Request) {
@@ -77,10 +267,9 @@ func main() {
 		// })
 	})
 
-	hub := websocket.NewHub()
-	go hub.Run()
-
-	router.HandleFunc("/ws", hub.HandleWebsocket)
+	// The token is optional, it keeps the color of the user across
+	// connections and receives the progress of the exports
+	router.With(jwtService.Verifier).HandleFunc("/ws", hub.HandleWebsocket)
 
 	s := server.New(":1333", router)
 	go func() {
@@ -90,13 +279,213 @@ func main() {
 	gracefulShutdown(s.HTTPServer)
 }
 
-func check(err error) error {
-	if err != nil {
-		log.Printf("Error occured due to: %v\n\n", err)
+// stores returns the user and post services of the database, PostgreSQL,
+// SQLite or MySQL as chosen by the scheme of DATABASE_URL.
+func stores(db *sql.DB, jwtService *jwtservice.JWT) (app.UserService, app.PostService) {
+	switch {
+	case db.Postgres():
+		return postgres.NewUserPostgresService(db.Sqlx, jwtService, passwordHasher()), postgres.NewPostPostgresService(db.Sqlx)
+	case db.SQLite():
+		return sqlite.NewUserSQLiteService(db.Sqlx, jwtService, passwordHasher()), sqlite.NewPostSQLiteService(db.Sqlx)
+	}
+
+	return sql.NewUserSQLService(db.Sqlx, jwtService, passwordHasher()), sql.NewPostSQLService(db.Sqlx)
+}
+
+// migrate runs `write-it migrate up|down|status|redo`, status being the default.
+func migrate(db *sql.DB, migrations []sql.Migration, args []string) error {
+	migrator := sql.NewMigrator(db, migrations)
+
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		_, err := migrator.Up()
+		return err
+	case "down":
+		_, err := migrator.Down()
+		return err
+	case "redo":
+		_, err := migrator.Redo()
 		return err
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "-"
+			if s.AppliedAt > 0 {
+				appliedAt = time.Unix(s.AppliedAt, 0).UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Name, s.State, appliedAt)
+		}
+		w.Flush()
+
+		return migrator.Check()
+	}
+
+	return fmt.Errorf("unknown command %q, expecting up, down, status or redo", command)
+}
+
+// jwtConfig reads the token signing configuration from the environment.
+// Without JWT_KEY_DIR tokens are signed with HS256 using JWT_SECRET,
+// or with a random secret that only lives as long as the process.
+func jwtConfig() jwtservice.Config {
+	config := jwtservice.Config{
+		Secret:           os.Getenv("JWT_SECRET"),
+		KeyDir:           os.Getenv("JWT_KEY_DIR"),
+		RotationInterval: getDuration("JWT_ROTATION_INTERVAL", 0),
+		GracePeriod:      getDuration("JWT_GRACE_PERIOD", 24*time.Hour),
+	}
+
+	if config.KeyDir == "" && config.Secret == "" {
+		log.Println("JWT_SECRET is not set, using a random development secret")
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		config.Secret = hex.EncodeToString(secret)
+	}
+
+	return config
+}
+
+// newMailer returns an SMTP mailer when SMTP_HOST is set,
+// otherwise the emails are only written to the standard output.
+func newMailer() mailer.Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return mailer.NewLog(os.Stdout)
+	}
+
+	return mailer.NewSMTP(
+		host,
+		getEnv("SMTP_PORT", "587"),
+		os.Getenv("SMTP_USERNAME"),
+		os.Getenv("SMTP_PASSWORD"),
+		getEnv("SMTP_FROM", "no-reply@write-it.local"),
+	)
+}
+
+// newCache returns the memcached of MEMCACHED_SERVER, e.g. "localhost:11211",
+// otherwise the users and posts are cached by the process itself, which only
+// suits a single instance.
+func newCache() cache.Cacher {
+	server := os.Getenv("MEMCACHED_SERVER")
+	if server == "" {
+		return memory.New(getInt("CACHE_ITEMS", 10000))
+	}
+
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		log.Fatalf("Invalid MEMCACHED_SERVER: %v", err)
+	}
+
+	return memcached.New(host, port, server)
+}
+
+// passwordHasher hashes with argon2id, and still verifies
+// the bcrypt hashes until their users login again.
+func passwordHasher() passwords.Hasher {
+	argon2id := passwords.DefaultArgon2id
+	argon2id.Time = uint32(getInt("ARGON2_TIME", int(argon2id.Time)))
+	argon2id.Memory = uint32(getInt("ARGON2_MEMORY", int(argon2id.Memory)))
+	argon2id.Threads = uint8(getInt("ARGON2_THREADS", int(argon2id.Threads)))
+
+	return passwords.New(argon2id, passwords.Bcrypt{Cost: 10})
+}
+
+// passwordPolicy refuses the passwords of the BREACHED_PASSWORDS_FILE
+// on top of the common ones.
+func passwordPolicy() *passwords.Policy {
+	var breached []string
+
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		list, err := passwords.LoadBreached(path)
+		if err != nil {
+			log.Fatalf("Cannot load the breached passwords: %v", err)
+		}
+		breached = list
+	}
+
+	return passwords.NewPolicy(getInt("PASSWORD_MIN_LENGTH", 10), 128, breached)
+}
+
+// oidcProviders discovers the providers listed in OIDC_PROVIDERS, e.g. "google,gitlab",
+// each configured by OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID and OIDC_<NAME>_CLIENT_SECRET.
+// A provider that cannot be discovered is skipped.
+func oidcProviders(baseURL string) []*oidc.Provider {
+	var providers []*oidc.Provider
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider, err := oidc.NewProvider(oidc.Config{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  strings.TrimSuffix(baseURL, "/") + "/login/oidc/" + name + "/callback",
+		}, &http.Client{Timeout: 10 * time.Second})
+		if err != nil {
+			log.Printf("Cannot configure the identity provider %s: %v", name, err)
+			continue
+		}
+
+		providers = append(providers, provider)
+	}
+
+	return providers
+}
+
+// getEnv reads a value from the environment, returning fallback when it is not set.
+func getEnv(key, fallback string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+
+	return fallback
+}
+
+// getDuration reads a time.Duration like "720h" from the environment.
+func getDuration(key string, fallback time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Printf("Invalid duration for %s: %v", key, err)
+		return fallback
+	}
+
+	return d
+}
+
+// getInt reads an integer from the environment.
+func getInt(key string, fallback int) int {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+
+	i, err := strconv.Atoi(val)
+	if err != nil || i <= 0 {
+		log.Printf("Invalid number for %s: %v", key, val)
+		return fallback
 	}
 
-	return nil
+	return i
 }
 
 func gracefulShutdown(srv *http.Server) {